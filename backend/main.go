@@ -1,17 +1,54 @@
 package main
 
 import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/whyxn/easynas/backend/pkg/alerts"
+	"github.com/whyxn/easynas/backend/pkg/auth"
+	"github.com/whyxn/easynas/backend/pkg/config"
 	"github.com/whyxn/easynas/backend/pkg/db"
+	"github.com/whyxn/easynas/backend/pkg/disks"
+	"github.com/whyxn/easynas/backend/pkg/events"
+	"github.com/whyxn/easynas/backend/pkg/history"
+	"github.com/whyxn/easynas/backend/pkg/jobs"
 	"github.com/whyxn/easynas/backend/pkg/log"
+	"github.com/whyxn/easynas/backend/pkg/monitor"
 	"github.com/whyxn/easynas/backend/pkg/server"
+	"github.com/whyxn/easynas/backend/pkg/settings"
+	"github.com/whyxn/easynas/backend/pkg/tlscert"
+	"github.com/whyxn/easynas/backend/pkg/util"
+	"os"
+	"time"
 )
 
 func main() {
+	// Load Configuration from file, environment and flags
+	cfg, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	} else if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
 	// Initialize Zap logger
-	log.InitializeLogger()
+	log.InitializeLogger(cfg.Log.Level)
+
+	if cfg.File != "" {
+		log.Logger.Infow("Loaded configuration", "file", cfg.File)
+	}
+
+	if cfg.Jwt.Secret == "" {
+		log.Logger.Warn("No JWT secret configured, generating one. Access tokens will not survive a restart.")
+		if cfg.Jwt.Secret, err = util.GenerateRandomToken(32); err != nil {
+			log.Logger.Fatal("Failed to generate JWT secret: ", err)
+		}
+	}
+	config.Set(cfg)
 
 	// Initialize DB Connection
-	err := db.Connect("easynas.db")
+	err = db.Connect(cfg.Database.Path)
 	if err != nil {
 		log.Logger.Fatal("Failed to connect to database: ", err)
 	}
@@ -22,6 +59,73 @@ func main() {
 		log.Logger.Fatal("Failed to run migrations: ", err)
 	}
 
-	// Start Http Server
-	server.Start()
+	// Load runtime settings
+	err = settings.Load()
+	if err != nil {
+		log.Logger.Fatal("Failed to load settings: ", err)
+	}
+
+	// Start background jobs, recovering the ones interrupted by the last stop
+	err = jobs.Start(cfg.Jobs.Workers, time.Duration(cfg.Jobs.Retention))
+	if err != nil {
+		log.Logger.Fatal("Failed to start background jobs: ", err)
+	}
+
+	// Watch pools and system metrics for event stream clients
+	events.SetHistorySize(cfg.Events.History)
+	monitor.Start(time.Duration(cfg.Events.PoolInterval), time.Duration(cfg.Events.MetricsInterval))
+
+	// Record metrics history for graphs
+	if cfg.History.Enabled {
+		history.Start(cfg.History)
+	}
+
+	// Check alert rules and send notifications
+	if cfg.Alerts.Enabled {
+		if err = alerts.Start(cfg.Alerts); err != nil {
+			log.Logger.Fatal("Failed to start alerts: ", err)
+		}
+	}
+
+	// Read SMART data and run self-tests of the disks
+	if cfg.Disks.Enabled {
+		disks.Start(cfg.Disks)
+	}
+
+	// Setup Authentication Providers
+	providers := []auth.Provider{auth.NewLocalProvider()}
+	if cfg.Ldap.URL != "" {
+		log.Logger.Infow("LDAP authentication enabled", "url", cfg.Ldap.URL)
+		providers = append(providers, auth.NewLdapProvider(cfg.Ldap))
+	}
+	auth.Configure(providers...)
+
+	if cfg.Oidc.IssuerURL != "" {
+		log.Logger.Infow("OpenID Connect single sign-on enabled", "issuer", cfg.Oidc.IssuerURL)
+		auth.ConfigureOidc(auth.NewOidcProvider(cfg.Oidc))
+	}
+
+	// Load the HTTPS certificate, generating a self-signed one on first start
+	if tlsConfig := cfg.Server.TLS; tlsConfig.Enabled {
+		manager, err := tlscert.NewManager(tlsConfig.CertFile, tlsConfig.KeyFile, tlsConfig.Hosts)
+		if err != nil {
+			log.Logger.Fatal("Failed to load TLS certificate: ", err)
+		}
+		manager.Watch(time.Duration(tlsConfig.ReloadInterval))
+		tlscert.Configure(manager)
+	}
+
+	// Start Http Server, it returns once shutdown has drained
+	err = server.Start()
+	if err != nil {
+		log.Logger.Errorw("Failed to start Web Server", "err", err.Error())
+	}
+
+	if closeErr := db.Close(); closeErr != nil {
+		log.Logger.Errorw("Failed to close database", "err", closeErr.Error())
+	}
+	log.Logger.Sync()
+	if err != nil {
+		os.Exit(1)
+	}
 }
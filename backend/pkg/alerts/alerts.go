@@ -0,0 +1,321 @@
+package alerts
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/whyxn/easynas/backend/pkg/config"
+	"github.com/whyxn/easynas/backend/pkg/db"
+	"github.com/whyxn/easynas/backend/pkg/db/model"
+	"github.com/whyxn/easynas/backend/pkg/lifecycle"
+	"github.com/whyxn/easynas/backend/pkg/log"
+	"github.com/whyxn/easynas/backend/pkg/nas"
+	"gorm.io/gorm"
+	"sync"
+	"time"
+)
+
+const pruneInterval = time.Hour
+
+var (
+	ErrNotFiring   = errors.New("alert is not firing")
+	ErrBuiltinRule = errors.New("built-in rules can not be deleted")
+)
+
+// mu keeps rule checks and acknowledgements from overlapping
+var mu sync.Mutex
+
+// Start creates the built-in rules and checks all rules at the configured
+// interval.
+func Start(cfg config.AlertsConfig) error {
+	if err := createBuiltinRules(); err != nil {
+		return err
+	}
+
+	lifecycle.Go(func(ctx context.Context) {
+		evaluate()
+		prune(time.Duration(cfg.Retention))
+		lastPrune := time.Now()
+
+		ticker := time.NewTicker(time.Duration(cfg.Interval))
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				evaluate()
+				if time.Since(lastPrune) >= pruneInterval {
+					prune(time.Duration(cfg.Retention))
+					lastPrune = time.Now()
+				}
+			case <-lifecycle.Done():
+				return
+			}
+		}
+	})
+	return nil
+}
+
+func createBuiltinRules() error {
+	for _, builtin := range builtinRules {
+		existing, err := db.Get[model.AlertRule](db.GetDb(), map[string]interface{}{"key": builtin.Key})
+		if err == nil && existing != nil {
+			continue
+		} else if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		rule := builtin
+		rule.Enabled = true
+		rule.NotifyResolved = true
+		if rule.Params, err = ParseRuleParams(rule.Type, nil); err != nil {
+			return err
+		}
+		if err = db.GetDb().Insert(&rule); err != nil {
+			return err
+		}
+		log.Logger.Infow("Created built-in alert rule", "key", rule.Key)
+	}
+	return nil
+}
+
+// evaluate checks every rule and fires, repeats or resolves its alerts
+func evaluate() {
+	mu.Lock()
+	defer mu.Unlock()
+
+	rules, err := db.GetList[model.AlertRule](db.GetDb(), map[string]interface{}{})
+	if err != nil {
+		log.Logger.Warnw("Failed to load alert rules", "err", err)
+		return
+	}
+
+	e := &evaluation{now: time.Now(), datasets: map[string][]nas.DatasetStat{}}
+	for i := range rules {
+		rule := &rules[i]
+		if !rule.Enabled {
+			// nobody is told about alerts of disabled rules
+			resolveAll(rule, nil, false)
+			continue
+		}
+
+		c, err := loadRule(rule)
+		if err != nil {
+			log.Logger.Warnw("Invalid alert rule", "rule", rule.Name, "err", err)
+			continue
+		}
+		findings, err := c.check(e)
+		if err != nil {
+			// keep the alerts as they are until the rule can be checked again
+			log.Logger.Warnw("Failed to check alert rule", "rule", rule.Name, "err", err)
+			continue
+		}
+		apply(rule, findings, e.now)
+	}
+}
+
+func fingerprint(rule *model.AlertRule, subject string) string {
+	return fmt.Sprintf("%d/%s", rule.ID, subject)
+}
+
+// apply fires new alerts, repeats the notifications of persisting ones and
+// resolves the alerts the rule no longer finds
+func apply(rule *model.AlertRule, findings []finding, now time.Time) {
+	seen := map[string]bool{}
+	for _, f := range findings {
+		print := fingerprint(rule, f.subject)
+		if seen[print] {
+			continue
+		}
+		seen[print] = true
+
+		alert, err := db.Get[model.Alert](db.GetDb(), map[string]interface{}{"fingerprint": print, "state": model.AlertFiring})
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Logger.Warnw("Failed to load alert", "rule", rule.Name, "err", err)
+			continue
+		}
+
+		if alert == nil || alert.ID == 0 {
+			alert = &model.Alert{
+				RuleId:      rule.ID,
+				RuleName:    rule.Name,
+				Fingerprint: print,
+				Subject:     f.subject,
+				Severity:    rule.Severity,
+				State:       model.AlertFiring,
+				Message:     f.message,
+				FiredAt:     now,
+				LastSeenAt:  now,
+			}
+			if err = db.GetDb().Insert(alert); err != nil {
+				log.Logger.Warnw("Failed to store alert", "rule", rule.Name, "err", err)
+				continue
+			}
+			log.Logger.Warnw("Alert fired", "rule", rule.Name, "subject", f.subject, "message", f.message)
+			notify(rule, alert, model.AlertFiring)
+			continue
+		}
+
+		updates := map[string]interface{}{"last_seen_at": now, "message": f.message, "severity": rule.Severity, "rule_name": rule.Name}
+		if err = db.GetDb().Update(alert, updates); err != nil {
+			log.Logger.Warnw("Failed to update alert", "id", alert.ID, "err", err)
+		}
+		if alert.AcknowledgedAt != nil {
+			continue
+		}
+		// alerts no channel took yet are retried every check
+		if alert.LastNotifiedAt == nil || (rule.RenotifyMinutes > 0 &&
+			now.Sub(*alert.LastNotifiedAt) >= time.Duration(rule.RenotifyMinutes)*time.Minute) {
+			notify(rule, alert, model.AlertFiring)
+		}
+	}
+	resolveAll(rule, seen, rule.NotifyResolved)
+}
+
+// resolveAll resolves the firing alerts of the rule not in keep
+func resolveAll(rule *model.AlertRule, keep map[string]bool, notifyResolved bool) {
+	var firing []model.Alert
+	err := db.GetDb().Client().Where("rule_id = ? AND state = ?", rule.ID, model.AlertFiring).Find(&firing).Error
+	if err != nil {
+		log.Logger.Warnw("Failed to load firing alerts", "rule", rule.Name, "err", err)
+		return
+	}
+
+	for i := range firing {
+		alert := &firing[i]
+		if keep[alert.Fingerprint] {
+			continue
+		}
+		now := time.Now()
+		if err = db.GetDb().Update(alert, map[string]interface{}{"state": model.AlertResolved, "resolved_at": now}); err != nil {
+			log.Logger.Warnw("Failed to resolve alert", "id", alert.ID, "err", err)
+			continue
+		}
+		log.Logger.Infow("Alert resolved", "rule", rule.Name, "subject", alert.Subject)
+		// only tell the ones that were told it fired
+		if notifyResolved && alert.Notifications > 0 {
+			notify(rule, alert, model.AlertResolved)
+		}
+	}
+}
+
+// notify sends the alert to the channels of the rule
+func notify(rule *model.AlertRule, alert *model.Alert, state string) {
+	channels, err := ruleChannels(rule)
+	if err != nil {
+		log.Logger.Warnw("Failed to load alert channels", "rule", rule.Name, "err", err)
+		return
+	}
+
+	n := Notification{Alert: alert, State: state, Time: time.Now()}
+	sent := false
+	for i := range channels {
+		channel := &channels[i]
+		if severityOrder[alert.Severity] < severityOrder[channel.MinSeverity] {
+			continue
+		}
+		if send(channel, n) == nil {
+			sent = true
+		}
+	}
+
+	if sent && state == model.AlertFiring {
+		now := time.Now()
+		err = db.GetDb().Update(alert, map[string]interface{}{"last_notified_at": now, "notifications": alert.Notifications + 1})
+		if err != nil {
+			log.Logger.Warnw("Failed to update alert", "id", alert.ID, "err", err)
+		}
+	}
+}
+
+func ruleChannels(rule *model.AlertRule) ([]model.AlertChannel, error) {
+	var ids []uint
+	if rule.Channels != "" {
+		if err := json.Unmarshal([]byte(rule.Channels), &ids); err != nil {
+			return nil, err
+		}
+	}
+
+	query := db.GetDb().Client().Where("enabled = ?", true)
+	if len(ids) > 0 {
+		query = query.Where("id IN ?", ids)
+	}
+	var channels []model.AlertChannel
+	return channels, query.Find(&channels).Error
+}
+
+// send delivers a notification through a channel and records the outcome
+// on it
+func send(channel *model.AlertChannel, n Notification) error {
+	err := SendTo(channel, n)
+	updates := map[string]interface{}{"last_error": ""}
+	if err != nil {
+		log.Logger.Warnw("Failed to send alert notification", "channel", channel.Name, "type", channel.Type, "err", err)
+		updates["last_error"] = err.Error()
+	} else {
+		updates["last_sent_at"] = time.Now()
+	}
+	if updateErr := db.GetDb().Update(channel, updates); updateErr != nil {
+		log.Logger.Warnw("Failed to update alert channel", "id", channel.ID, "err", updateErr)
+	}
+	return err
+}
+
+// SendTo delivers a notification through a channel within the configured
+// timeout.
+func SendTo(channel *model.AlertChannel, n Notification) error {
+	settings, err := loadChannel(channel)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(config.Get().Alerts.SendTimeout))
+	defer cancel()
+	return settings.Send(ctx, n)
+}
+
+// Test sends a test notification through a channel and records the outcome.
+func Test(channel *model.AlertChannel, requester *model.User) error {
+	now := time.Now()
+	return send(channel, Notification{
+		Alert: &model.Alert{
+			RuleName: "Test",
+			Subject:  "test",
+			Severity: model.SeverityInfo,
+			State:    model.AlertFiring,
+			Message:  fmt.Sprintf("Test notification requested by %s", requester.Email),
+			FiredAt:  now,
+		},
+		State: "test",
+		Time:  now,
+	})
+}
+
+// Acknowledge stops the repeated notifications of a firing alert.
+func Acknowledge(alert *model.Alert, requester *model.User) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if alert.State != model.AlertFiring {
+		return ErrNotFiring
+	}
+	return db.GetDb().Update(alert, map[string]interface{}{"acknowledged_at": time.Now(), "acknowledged_by": requester.ID})
+}
+
+// ResolveRule resolves the firing alerts of a rule that was deleted,
+// without notifications.
+func ResolveRule(rule *model.AlertRule) {
+	mu.Lock()
+	defer mu.Unlock()
+	resolveAll(rule, nil, false)
+}
+
+func prune(retention time.Duration) {
+	result := db.GetDb().Client().
+		Where("state = ? AND resolved_at < ?", model.AlertResolved, time.Now().Add(-retention)).
+		Delete(&model.Alert{})
+	if result.Error != nil {
+		log.Logger.Warnw("Failed to prune resolved alerts", "err", result.Error)
+	} else if result.RowsAffected > 0 {
+		log.Logger.Infow("Pruned resolved alerts", "count", result.RowsAffected)
+	}
+}
@@ -0,0 +1,199 @@
+package alerts
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/whyxn/easynas/backend/pkg/db/model"
+	"os"
+	"strings"
+	"time"
+)
+
+// Channel types
+const (
+	ChannelSmtp    = "smtp"
+	ChannelWebhook = "webhook"
+	ChannelSlack   = "slack"
+	ChannelSyslog  = "syslog"
+)
+
+// redacted replaces secrets in channel settings shown to clients. Sending
+// it back keeps the stored secret.
+const redacted = "[redacted]"
+
+var ErrInvalidChannel = errors.New("invalid channel")
+
+// Notification is sent to channels when an alert fires, repeats or is
+// resolved.
+type Notification struct {
+	Alert *model.Alert
+	// State is firing or resolved, or test for test notifications
+	State string
+	Time  time.Time
+}
+
+// Title is a one line summary of the notification.
+func (n Notification) Title() string {
+	if n.State == model.AlertResolved {
+		return fmt.Sprintf("[easynas] resolved: %s", n.Alert.Message)
+	}
+	return fmt.Sprintf("[easynas] %s: %s", strings.ToUpper(n.Alert.Severity), n.Alert.Message)
+}
+
+// Body describes the alert in plain text.
+func (n Notification) Body() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n\n", n.Alert.Message)
+	fmt.Fprintf(&b, "State:    %s\n", n.State)
+	fmt.Fprintf(&b, "Severity: %s\n", n.Alert.Severity)
+	fmt.Fprintf(&b, "Rule:     %s\n", n.Alert.RuleName)
+	fmt.Fprintf(&b, "Subject:  %s\n", n.Alert.Subject)
+	fmt.Fprintf(&b, "Host:     %s\n", hostname())
+	fmt.Fprintf(&b, "Fired:    %s\n", n.Alert.FiredAt.Format(time.RFC1123Z))
+	if n.Alert.ResolvedAt != nil {
+		fmt.Fprintf(&b, "Resolved: %s\n", n.Alert.ResolvedAt.Format(time.RFC1123Z))
+	}
+	return b.String()
+}
+
+// Notifier sends notifications through one channel.
+type Notifier interface {
+	Send(ctx context.Context, n Notification) error
+}
+
+// channelSettings are the settings of a channel type
+type channelSettings interface {
+	Notifier
+	validate() error
+	// secrets returns pointers to the fields hidden from clients
+	secrets() []*string
+}
+
+// secretMaps is implemented by channel settings with maps whose values are
+// all hidden from clients, like the headers of a webhook
+type secretMaps interface {
+	secretMaps() []map[string]string
+}
+
+var channelTypes = map[string]func() channelSettings{
+	ChannelSmtp:    func() channelSettings { return &smtpChannel{Port: 587, Security: smtpStartTLS} },
+	ChannelWebhook: func() channelSettings { return &webhookChannel{} },
+	ChannelSlack:   func() channelSettings { return &slackChannel{} },
+	ChannelSyslog:  func() channelSettings { return &syslogChannel{Facility: "daemon", Tag: "easynas"} },
+}
+
+// ChannelTypes lists the supported channel types
+func ChannelTypes() []string {
+	return []string{ChannelSmtp, ChannelWebhook, ChannelSlack, ChannelSyslog}
+}
+
+// ParseChannelConfig validates the settings of a channel type and returns
+// them encoded for storage. Redacted secrets are taken from previous, the
+// stored settings of the channel being updated.
+func ParseChannelConfig(channelType string, raw json.RawMessage, previous string) (string, error) {
+	newSettings, ok := channelTypes[channelType]
+	if !ok {
+		return "", fmt.Errorf("%w: unknown type '%s'", ErrInvalidChannel, channelType)
+	}
+
+	settings := newSettings()
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(settings); err != nil {
+		return "", fmt.Errorf("%w: %s", ErrInvalidChannel, err.Error())
+	}
+
+	if previous != "" {
+		old := newSettings()
+		if err := json.Unmarshal([]byte(previous), old); err == nil {
+			restoreSecrets(settings, old)
+		}
+	}
+
+	if err := settings.validate(); err != nil {
+		return "", fmt.Errorf("%w: %s", ErrInvalidChannel, err.Error())
+	}
+	encoded, err := json.Marshal(settings)
+	return string(encoded), err
+}
+
+// RedactedChannelConfig returns the settings of a channel with its secrets
+// hidden.
+func RedactedChannelConfig(channel *model.AlertChannel) interface{} {
+	settings, err := loadChannel(channel)
+	if err != nil {
+		return map[string]interface{}{}
+	}
+	redactSecrets(settings)
+	return settings
+}
+
+func redactSecrets(settings channelSettings) {
+	for _, secret := range settings.secrets() {
+		if *secret != "" {
+			*secret = redacted
+		}
+	}
+	if maps, ok := settings.(secretMaps); ok {
+		for _, m := range maps.secretMaps() {
+			for key, value := range m {
+				if value != "" {
+					m[key] = redacted
+				}
+			}
+		}
+	}
+}
+
+// restoreSecrets puts the secrets of old back where settings has them
+// redacted. Map values are matched by key, a redacted value of a new key
+// is kept as it is.
+func restoreSecrets(settings, old channelSettings) {
+	oldSecrets := old.secrets()
+	for i, secret := range settings.secrets() {
+		if *secret == redacted {
+			*secret = *oldSecrets[i]
+		}
+	}
+	if maps, ok := settings.(secretMaps); ok {
+		oldMaps := old.(secretMaps).secretMaps()
+		for i, m := range maps.secretMaps() {
+			for key, value := range m {
+				if oldValue, ok := oldMaps[i][key]; ok && value == redacted {
+					m[key] = oldValue
+				}
+			}
+		}
+	}
+}
+
+func loadChannel(channel *model.AlertChannel) (channelSettings, error) {
+	newSettings, ok := channelTypes[channel.Type]
+	if !ok {
+		return nil, fmt.Errorf("unknown channel type '%s'", channel.Type)
+	}
+	settings := newSettings()
+	if err := json.Unmarshal([]byte(channel.Config), settings); err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+var severityOrder = map[string]int{model.SeverityInfo: 0, model.SeverityWarning: 1, model.SeverityCritical: 2}
+
+// ValidSeverity tells whether the severity is info, warning or critical
+func ValidSeverity(severity string) bool {
+	_, ok := severityOrder[severity]
+	return ok
+}
+
+func hostname() string {
+	name, err := os.Hostname()
+	if err != nil {
+		return "easynas"
+	}
+	return name
+}
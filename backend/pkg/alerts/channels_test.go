@@ -0,0 +1,398 @@
+package alerts
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/whyxn/easynas/backend/pkg/config"
+	"github.com/whyxn/easynas/backend/pkg/db/model"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testNotification(state, severity string) Notification {
+	fired := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	return Notification{
+		Alert: &model.Alert{
+			ID:       7,
+			RuleName: "Pool health",
+			Subject:  "naspool",
+			Severity: severity,
+			State:    model.AlertFiring,
+			Message:  "Pool naspool is DEGRADED",
+			FiredAt:  fired,
+		},
+		State: state,
+		Time:  fired.Add(time.Minute),
+	}
+}
+
+// smtpSession is what the stub SMTP server received
+type smtpSession struct {
+	auth string
+	from string
+	to   []string
+	data string
+}
+
+// serveSmtp accepts one connection on a local listener and answers like an
+// SMTP server offering AUTH PLAIN without TLS.
+func serveSmtp(t *testing.T) (host string, port int, session <-chan smtpSession) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	result := make(chan smtpSession, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+		text := textproto.NewConn(conn)
+		var s smtpSession
+		text.PrintfLine("220 stub ESMTP")
+		for {
+			line, err := text.ReadLine()
+			if err != nil {
+				return
+			}
+			verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+			switch verb {
+			case "EHLO":
+				text.PrintfLine("250-stub")
+				text.PrintfLine("250 AUTH PLAIN")
+			case "AUTH":
+				s.auth = strings.TrimPrefix(line, "AUTH PLAIN ")
+				text.PrintfLine("235 2.7.0 Authentication successful")
+			case "MAIL":
+				s.from = line
+				text.PrintfLine("250 OK")
+			case "RCPT":
+				s.to = append(s.to, line)
+				text.PrintfLine("250 OK")
+			case "DATA":
+				text.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+				data, err := text.ReadDotBytes()
+				if err != nil {
+					return
+				}
+				s.data = string(data)
+				text.PrintfLine("250 OK")
+			case "QUIT":
+				text.PrintfLine("221 Bye")
+				result <- s
+				return
+			default:
+				text.PrintfLine("502 Command not implemented")
+			}
+		}
+	}()
+
+	addr := listener.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port, result
+}
+
+func TestSmtpChannelSend(t *testing.T) {
+	host, port, sessions := serveSmtp(t)
+	channel := &smtpChannel{
+		Host:     host,
+		Port:     port,
+		Security: smtpNone,
+		Username: "nas",
+		Password: "secret",
+		From:     "EasyNAS <nas@example.com>",
+		To:       []string{"admin@example.com", "Ops <ops@example.com>"},
+	}
+	if err := channel.validate(); err != nil {
+		t.Fatalf("validate() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := channel.Send(ctx, testNotification(model.AlertFiring, model.SeverityCritical)); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+
+	var s smtpSession
+	select {
+	case s = <-sessions:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stub server did not receive the message")
+	}
+
+	credentials, err := base64.StdEncoding.DecodeString(s.auth)
+	if err != nil || string(credentials) != "\x00nas\x00secret" {
+		t.Errorf("AUTH PLAIN credentials = %q, want nas/secret", credentials)
+	}
+	if s.from != "MAIL FROM:<nas@example.com>" {
+		t.Errorf("envelope sender = %q", s.from)
+	}
+	wantTo := []string{"RCPT TO:<admin@example.com>", "RCPT TO:<ops@example.com>"}
+	if strings.Join(s.to, "|") != strings.Join(wantTo, "|") {
+		t.Errorf("envelope recipients = %q, want %q", s.to, wantTo)
+	}
+
+	headers, body, found := strings.Cut(s.data, "\n\n")
+	if !found {
+		t.Fatalf("message has no body: %q", s.data)
+	}
+	for _, header := range []string{
+		`From: "EasyNAS" <nas@example.com>`,
+		`To: <admin@example.com>, "Ops" <ops@example.com>`,
+		"Subject: [easynas] CRITICAL: Pool naspool is DEGRADED",
+		"Date: Sun, 18 Oct 2026 12:01:00 +0000",
+		"Content-Type: text/plain; charset=utf-8",
+		"Auto-Submitted: auto-generated",
+	} {
+		if !strings.Contains(headers+"\n", header+"\n") {
+			t.Errorf("headers lack %q:\n%s", header, headers)
+		}
+	}
+	for _, line := range []string{"State:    firing", "Rule:     Pool health", "Subject:  naspool"} {
+		if !strings.Contains(body, line) {
+			t.Errorf("body lacks %q:\n%s", line, body)
+		}
+	}
+}
+
+func TestSmtpChannelRequiresStartTLS(t *testing.T) {
+	host, port, _ := serveSmtp(t)
+	channel := &smtpChannel{
+		Host:     host,
+		Port:     port,
+		Security: smtpStartTLS,
+		From:     "nas@example.com",
+		To:       []string{"admin@example.com"},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := channel.Send(ctx, testNotification(model.AlertFiring, model.SeverityWarning))
+	if err == nil || !strings.Contains(err.Error(), "starttls") {
+		t.Errorf("Send() error = %v, want a starttls error from a server without it", err)
+	}
+}
+
+func TestWebhookChannelSend(t *testing.T) {
+	var header http.Header
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer srv.Close()
+
+	channel := &webhookChannel{
+		URL:     srv.URL + "/hook",
+		Headers: map[string]string{"X-Team": "storage"},
+		Secret:  "s3cret",
+	}
+	if err := channel.Send(context.Background(), testNotification(model.AlertFiring, model.SeverityWarning)); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+
+	if header.Get("Content-Type") != "application/json" {
+		t.Errorf("Content-Type = %q", header.Get("Content-Type"))
+	}
+	if header.Get("X-Team") != "storage" {
+		t.Errorf("custom header X-Team = %q", header.Get("X-Team"))
+	}
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write(body)
+	if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); header.Get(signatureHeader) != want {
+		t.Errorf("%s = %q, want %q", signatureHeader, header.Get(signatureHeader), want)
+	}
+
+	var payload map[string]interface{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		t.Fatalf("body is not JSON: %v", err)
+	}
+	want := map[string]interface{}{
+		"alertId":  float64(7),
+		"state":    "firing",
+		"severity": "warning",
+		"rule":     "Pool health",
+		"subject":  "naspool",
+		"message":  "Pool naspool is DEGRADED",
+		"firedAt":  "2026-10-18T12:00:00Z",
+		"time":     "2026-10-18T12:01:00Z",
+	}
+	for key, value := range want {
+		if payload[key] != value {
+			t.Errorf("payload[%q] = %v, want %v", key, payload[key], value)
+		}
+	}
+	if _, ok := payload["host"]; !ok {
+		t.Error("payload lacks host")
+	}
+	if _, ok := payload["resolvedAt"]; ok {
+		t.Error("payload of a firing alert has resolvedAt")
+	}
+}
+
+func TestWebhookChannelHidesHeaders(t *testing.T) {
+	stored, err := ParseChannelConfig(ChannelWebhook, json.RawMessage(`{
+		"url": "https://hooks.example.com/alerts",
+		"headers": {"Authorization": "Bearer abc123", "X-Team": "storage"},
+		"secret": "s3cret"
+	}`), "")
+	if err != nil {
+		t.Fatalf("ParseChannelConfig() error = %v", err)
+	}
+
+	shown, _ := json.Marshal(RedactedChannelConfig(&model.AlertChannel{Type: ChannelWebhook, Config: stored}))
+	for _, secret := range []string{"abc123", "storage", "s3cret"} {
+		if strings.Contains(string(shown), secret) {
+			t.Errorf("redacted config %s shows %q", shown, secret)
+		}
+	}
+
+	// the redacted config sent back keeps the stored values, and a changed or
+	// new header is taken as it is
+	updated := strings.Replace(string(shown), `"X-Team":"[redacted]"`, `"X-Team":"backup","X-New":"[redacted]"`, 1)
+	stored, err = ParseChannelConfig(ChannelWebhook, json.RawMessage(updated), stored)
+	if err != nil {
+		t.Fatalf("ParseChannelConfig() of the update error = %v", err)
+	}
+	var channel webhookChannel
+	if err = json.Unmarshal([]byte(stored), &channel); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"Authorization": "Bearer abc123", "X-Team": "backup", "X-New": "[redacted]"}
+	if !reflect.DeepEqual(channel.Headers, want) || channel.Secret != "s3cret" {
+		t.Errorf("stored headers = %v, secret = %q, want %v and the old secret", channel.Headers, channel.Secret, want)
+	}
+}
+
+func TestWebhookChannelErrorStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no such hook", http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	channel := &webhookChannel{URL: srv.URL}
+	err := channel.Send(context.Background(), testNotification(model.AlertFiring, model.SeverityInfo))
+	if err == nil || !strings.Contains(err.Error(), "404") || !strings.Contains(err.Error(), "no such hook") {
+		t.Errorf("Send() error = %v, want the status and message", err)
+	}
+}
+
+func TestSlackChannelSend(t *testing.T) {
+	var payload map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&payload)
+	}))
+	defer srv.Close()
+
+	channel := &slackChannel{URL: srv.URL, Channel: "#nas"}
+	if err := channel.Send(context.Background(), testNotification(model.AlertResolved, model.SeverityCritical)); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+
+	if len(payload) != 2 || payload["channel"] != "#nas" {
+		t.Errorf("payload = %v, want text and channel", payload)
+	}
+	text := payload["text"]
+	if !strings.HasPrefix(text, ":white_check_mark: *[easynas] resolved: Pool naspool is DEGRADED* on ") {
+		t.Errorf("text = %q", text)
+	}
+	if !strings.HasSuffix(text, "\nnaspool") {
+		t.Errorf("text = %q, want the subject on the second line", text)
+	}
+}
+
+func TestSendToTimeout(t *testing.T) {
+	previous := config.Get()
+	cfg := config.Default()
+	cfg.Alerts.SendTimeout = config.Duration(100 * time.Millisecond)
+	config.Set(cfg)
+	defer config.Set(previous)
+
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	settings, _ := json.Marshal(webhookChannel{URL: srv.URL})
+	channel := &model.AlertChannel{Type: ChannelWebhook, Config: string(settings)}
+	start := time.Now()
+	err := SendTo(channel, testNotification(model.AlertFiring, model.SeverityCritical))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("SendTo() error = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("SendTo() took %s despite a timeout of 100ms", elapsed)
+	}
+}
+
+func TestSyslogChannelSend(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	channel := &syslogChannel{Network: "udp", Address: conn.LocalAddr().String(), Facility: "local0", Tag: "easynas"}
+	if err := channel.validate(); err != nil {
+		t.Fatalf("validate() error = %v", err)
+	}
+
+	tests := []struct {
+		state    string
+		severity string
+		priority int
+		message  string
+	}{
+		// local0 is facility 16, its priorities start at 128
+		{model.AlertFiring, model.SeverityCritical, 128 + 2, "CRITICAL: Pool naspool is DEGRADED"},
+		{model.AlertFiring, model.SeverityWarning, 128 + 4, "WARNING: Pool naspool is DEGRADED"},
+		{model.AlertFiring, model.SeverityInfo, 128 + 6, "INFO: Pool naspool is DEGRADED"},
+		{model.AlertResolved, model.SeverityCritical, 128 + 5, "resolved: Pool naspool is DEGRADED"},
+	}
+	buf := make([]byte, 2048)
+	for _, tt := range tests {
+		if err := channel.Send(context.Background(), testNotification(tt.state, tt.severity)); err != nil {
+			t.Fatalf("Send() error = %v", err)
+		}
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("no syslog message received: %v", err)
+		}
+		line, err := bufio.NewReader(strings.NewReader(string(buf[:n]))).ReadString('\n')
+		if err != nil {
+			t.Fatalf("syslog message %q is not terminated", buf[:n])
+		}
+
+		if prefix := "<" + strconv.Itoa(tt.priority) + ">"; !strings.HasPrefix(line, prefix) {
+			t.Errorf("%s %s: message %q, want priority %s", tt.state, tt.severity, line, prefix)
+		}
+		if !strings.Contains(line, " easynas[") {
+			t.Errorf("message %q lacks the tag", line)
+		}
+		want := tt.message + ` (rule "Pool health", subject "naspool")`
+		if !strings.HasSuffix(line, ": "+want+"\n") {
+			t.Errorf("message %q, want it to end with %q", line, want)
+		}
+	}
+}
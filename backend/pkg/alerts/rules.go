@@ -0,0 +1,498 @@
+package alerts
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/whyxn/easynas/backend/pkg/config"
+	"github.com/whyxn/easynas/backend/pkg/db"
+	"github.com/whyxn/easynas/backend/pkg/db/model"
+	"github.com/whyxn/easynas/backend/pkg/forecast"
+	"github.com/whyxn/easynas/backend/pkg/history"
+	"github.com/whyxn/easynas/backend/pkg/nas"
+	"github.com/whyxn/easynas/backend/pkg/util"
+	"strings"
+	"time"
+)
+
+// Rule types
+const (
+	RulePoolHealth       = "pool_health"
+	RuleScrubErrors      = "scrub_errors"
+	RulePoolCapacity     = "pool_capacity"
+	RuleDatasetQuota     = "dataset_quota"
+	RuleCapacityForecast = "capacity_forecast"
+	RuleJobFailed        = "job_failed"
+	RuleMetric           = "metric"
+	RuleDiskHealth       = "disk_health"
+)
+
+var ErrInvalidRule = errors.New("invalid rule")
+
+// finding is a problem a rule found with a subject
+type finding struct {
+	subject string
+	message string
+}
+
+// checker is the parameters of a rule type and checks them
+type checker interface {
+	validate() error
+	check(e *evaluation) ([]finding, error)
+}
+
+var ruleTypes = map[string]func() checker{
+	RulePoolHealth:       func() checker { return &poolHealthRule{} },
+	RuleScrubErrors:      func() checker { return &scrubErrorsRule{} },
+	RulePoolCapacity:     func() checker { return &poolCapacityRule{Percent: 85} },
+	RuleDatasetQuota:     func() checker { return &datasetQuotaRule{Percent: 95} },
+	RuleCapacityForecast: func() checker { return &capacityForecastRule{Threshold: 100, Days: 14} },
+	RuleJobFailed:        func() checker { return &jobFailedRule{Types: []string{}} },
+	RuleMetric:           func() checker { return &metricRule{Window: "5m"} },
+	RuleDiskHealth:       func() checker { return &diskHealthRule{MaxTemperature: 55} },
+}
+
+// RuleTypes lists the supported rule types
+func RuleTypes() []string {
+	return []string{RulePoolHealth, RuleScrubErrors, RulePoolCapacity, RuleDatasetQuota, RuleCapacityForecast, RuleJobFailed, RuleMetric, RuleDiskHealth}
+}
+
+// builtinRules are created on first start and can be changed, but not
+// deleted
+var builtinRules = []model.AlertRule{
+	{Key: "pool-health", Name: "Pool is not healthy", Type: RulePoolHealth, Severity: model.SeverityCritical, RenotifyMinutes: 240},
+	{Key: "scrub-errors", Name: "Scrub found errors", Type: RuleScrubErrors, Severity: model.SeverityCritical, RenotifyMinutes: 1440},
+	{Key: "pool-capacity", Name: "Pool is almost full", Type: RulePoolCapacity, Severity: model.SeverityWarning, RenotifyMinutes: 1440},
+	{Key: "dataset-quota", Name: "Dataset is almost at its quota", Type: RuleDatasetQuota, Severity: model.SeverityWarning, RenotifyMinutes: 1440},
+	{Key: "capacity-forecast", Name: "Pool or dataset fills up soon", Type: RuleCapacityForecast, Severity: model.SeverityWarning, RenotifyMinutes: 1440},
+	{Key: "job-failed", Name: "Background job failed", Type: RuleJobFailed, Severity: model.SeverityWarning},
+	{Key: "disk-health", Name: "Disk is deteriorating", Type: RuleDiskHealth, Severity: model.SeverityCritical, RenotifyMinutes: 1440},
+}
+
+// ParseRuleParams validates the parameters of a rule type and returns them
+// encoded for storage, with defaults filled in.
+func ParseRuleParams(ruleType string, raw json.RawMessage) (string, error) {
+	newChecker, ok := ruleTypes[ruleType]
+	if !ok {
+		return "", fmt.Errorf("%w: unknown type '%s'", ErrInvalidRule, ruleType)
+	}
+
+	params := newChecker()
+	if len(raw) > 0 && string(raw) != "null" {
+		decoder := json.NewDecoder(bytes.NewReader(raw))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(params); err != nil {
+			return "", fmt.Errorf("%w: %s", ErrInvalidRule, err.Error())
+		}
+	}
+	if err := params.validate(); err != nil {
+		return "", fmt.Errorf("%w: %s", ErrInvalidRule, err.Error())
+	}
+	encoded, err := json.Marshal(params)
+	return string(encoded), err
+}
+
+// RuleParams returns the decoded parameters of a rule for clients.
+func RuleParams(rule *model.AlertRule) interface{} {
+	params, err := loadRule(rule)
+	if err != nil {
+		return map[string]interface{}{}
+	}
+	return params
+}
+
+func loadRule(rule *model.AlertRule) (checker, error) {
+	newChecker, ok := ruleTypes[rule.Type]
+	if !ok {
+		return nil, fmt.Errorf("unknown rule type '%s'", rule.Type)
+	}
+	params := newChecker()
+	if rule.Params != "" {
+		if err := json.Unmarshal([]byte(rule.Params), params); err != nil {
+			return nil, err
+		}
+	}
+	return params, nil
+}
+
+// evaluation caches what the rules of one check read
+type evaluation struct {
+	now      time.Time
+	pools    []nas.PoolStat
+	datasets map[string][]nas.DatasetStat
+}
+
+func (e *evaluation) managedPools() ([]nas.PoolStat, error) {
+	if e.pools == nil {
+		stats, err := nas.ListPoolStats()
+		if err != nil {
+			return nil, err
+		}
+		e.pools = []nas.PoolStat{}
+		for _, pool := range stats {
+			if util.Contains(config.Get().Nas.Pools, pool.Name) {
+				e.pools = append(e.pools, pool)
+			}
+		}
+	}
+	return e.pools, nil
+}
+
+func (e *evaluation) poolDatasets(pool string) ([]nas.DatasetStat, error) {
+	if datasets, ok := e.datasets[pool]; ok {
+		return datasets, nil
+	}
+	datasets, err := nas.ListDatasetStats(pool)
+	if err != nil {
+		return nil, err
+	}
+	e.datasets[pool] = datasets
+	return datasets, nil
+}
+
+type poolHealthRule struct{}
+
+func (r *poolHealthRule) validate() error { return nil }
+
+func (r *poolHealthRule) check(e *evaluation) ([]finding, error) {
+	pools, err := e.managedPools()
+	if err != nil {
+		return nil, err
+	}
+	var findings []finding
+	for _, pool := range pools {
+		if pool.Health != "ONLINE" {
+			findings = append(findings, finding{subject: pool.Name, message: fmt.Sprintf("Pool %s is %s", pool.Name, pool.Health)})
+		}
+	}
+	return findings, nil
+}
+
+type scrubErrorsRule struct{}
+
+func (r *scrubErrorsRule) validate() error { return nil }
+
+func (r *scrubErrorsRule) check(e *evaluation) ([]finding, error) {
+	pools, err := e.managedPools()
+	if err != nil {
+		return nil, err
+	}
+	var findings []finding
+	for _, pool := range pools {
+		scan, err := nas.PoolScanStatus(pool.Name)
+		if err != nil {
+			return nil, err
+		}
+		if scan.Errors > 0 {
+			findings = append(findings, finding{
+				subject: pool.Name,
+				message: fmt.Sprintf("Last %s of pool %s found %d errors", scan.Function, pool.Name, scan.Errors),
+			})
+		}
+	}
+	return findings, nil
+}
+
+type poolCapacityRule struct {
+	Percent float64 `json:"percent"`
+}
+
+func (r *poolCapacityRule) validate() error {
+	return validatePercent(r.Percent)
+}
+
+func (r *poolCapacityRule) check(e *evaluation) ([]finding, error) {
+	pools, err := e.managedPools()
+	if err != nil {
+		return nil, err
+	}
+	var findings []finding
+	for _, pool := range pools {
+		if pool.Size == 0 {
+			continue
+		}
+		used := float64(pool.Allocated) / float64(pool.Size) * 100
+		if used >= r.Percent {
+			findings = append(findings, finding{
+				subject: pool.Name,
+				message: fmt.Sprintf("Pool %s is %.0f%% full (%s of %s)", pool.Name, used, formatBytes(pool.Allocated), formatBytes(pool.Size)),
+			})
+		}
+	}
+	return findings, nil
+}
+
+type datasetQuotaRule struct {
+	Percent float64 `json:"percent"`
+}
+
+func (r *datasetQuotaRule) validate() error {
+	return validatePercent(r.Percent)
+}
+
+func (r *datasetQuotaRule) check(e *evaluation) ([]finding, error) {
+	pools, err := e.managedPools()
+	if err != nil {
+		return nil, err
+	}
+	var findings []finding
+	for _, pool := range pools {
+		datasets, err := e.poolDatasets(pool.Name)
+		if err != nil {
+			return nil, err
+		}
+		for _, dataset := range datasets {
+			if dataset.Quota == 0 {
+				continue
+			}
+			used := float64(dataset.Used) / float64(dataset.Quota) * 100
+			if used >= r.Percent {
+				findings = append(findings, finding{
+					subject: dataset.Name,
+					message: fmt.Sprintf("Dataset %s uses %.0f%% of its %s quota", dataset.Name, used, formatBytes(dataset.Quota)),
+				})
+			}
+		}
+	}
+	return findings, nil
+}
+
+var kindNames = map[string]string{forecast.KindPool: "Pool", forecast.KindDataset: "Dataset"}
+
+type capacityForecastRule struct {
+	// Threshold is the usage percentage the forecast is made for
+	Threshold int `json:"threshold"`
+	// Days fires the alert when the threshold is projected to be reached sooner
+	Days float64 `json:"days"`
+	// Kind limits the rule to pools or datasets, both if empty
+	Kind string `json:"kind"`
+}
+
+func (r *capacityForecastRule) validate() error {
+	if r.Threshold < 1 || r.Threshold > 100 {
+		return errors.New("threshold must be a percentage between 1 and 100")
+	}
+	if r.Days <= 0 {
+		return errors.New("days must be positive")
+	}
+	if r.Kind != "" && r.Kind != forecast.KindPool && r.Kind != forecast.KindDataset {
+		return errors.New("kind must be pool or dataset")
+	}
+	return nil
+}
+
+func (r *capacityForecastRule) check(e *evaluation) ([]finding, error) {
+	forecasts, err := forecast.Compute(forecast.Options{Thresholds: []int{r.Threshold}})
+	if err != nil {
+		return nil, err
+	}
+	var findings []finding
+	for _, f := range forecasts {
+		if r.Kind != "" && f.Kind != r.Kind {
+			continue
+		}
+		// reaching it already is for the capacity and quota rules
+		t := f.Thresholds[0]
+		if t.Reached || t.Days == nil || *t.Days > r.Days {
+			continue
+		}
+		findings = append(findings, finding{
+			subject: f.Name,
+			message: fmt.Sprintf("%s %s reaches %d%% of its capacity in about %.0f days", kindNames[f.Kind], f.Name, r.Threshold, *t.Days),
+		})
+	}
+	return findings, nil
+}
+
+type jobFailedRule struct {
+	// Types limits the rule to some job types, all if empty
+	Types []string `json:"types"`
+}
+
+func (r *jobFailedRule) validate() error { return nil }
+
+// check fires for every job type and target whose latest run failed, so a
+// successful retry resolves the alert
+func (r *jobFailedRule) check(e *evaluation) ([]finding, error) {
+	var latest []model.Job
+	query := db.GetDb().Client().
+		Where("id IN (?)", db.GetDb().Client().Model(&model.Job{}).
+			Select("MAX(id)").
+			Where("state IN ?", []string{model.JobSucceeded, model.JobFailed}).
+			Group("type, target"))
+	if len(r.Types) > 0 {
+		query = query.Where("type IN ?", r.Types)
+	}
+	if err := query.Find(&latest).Error; err != nil {
+		return nil, err
+	}
+
+	var findings []finding
+	for _, job := range latest {
+		if job.State != model.JobFailed {
+			continue
+		}
+		subject := job.Type
+		if job.Target != "" {
+			subject += " " + job.Target
+		}
+		findings = append(findings, finding{
+			subject: subject,
+			message: fmt.Sprintf("Job %d (%s) failed: %s", job.ID, subject, job.Error),
+		})
+	}
+	return findings, nil
+}
+
+type metricRule struct {
+	Metric string `json:"metric"`
+	// Series limits the rule to one pool or dataset, all if empty
+	Series   string  `json:"series"`
+	Operator string  `json:"operator"`
+	Value    float64 `json:"value"`
+	// Window is the duration the metric is averaged over
+	Window string `json:"window"`
+}
+
+var operators = map[string]func(a, b float64) bool{
+	">":  func(a, b float64) bool { return a > b },
+	">=": func(a, b float64) bool { return a >= b },
+	"<":  func(a, b float64) bool { return a < b },
+	"<=": func(a, b float64) bool { return a <= b },
+}
+
+func (r *metricRule) validate() error {
+	if !util.Contains(history.Metrics, r.Metric) {
+		return fmt.Errorf("unknown metric '%s'", r.Metric)
+	}
+	if _, ok := operators[r.Operator]; !ok {
+		return errors.New("operator must be >, >=, < or <=")
+	}
+	if window, err := time.ParseDuration(r.Window); err != nil || window < time.Minute {
+		return errors.New("window must be a duration of at least 1m")
+	}
+	return nil
+}
+
+func (r *metricRule) check(e *evaluation) ([]finding, error) {
+	window, _ := time.ParseDuration(r.Window)
+	query := history.Query{Metrics: []string{r.Metric}, From: e.now.Add(-window), To: e.now, Step: window}
+	if r.Series != "" {
+		query.Series = []string{r.Series}
+	}
+	result, err := query.Run()
+	if err != nil {
+		return nil, err
+	}
+
+	var findings []finding
+	for _, series := range result.Series {
+		if r.Series != "" && series.Series != r.Series && series.Series != "" {
+			continue
+		}
+		// the window may span two steps
+		var sum float64
+		for _, p := range series.Points {
+			sum += p.Average
+		}
+		average := sum / float64(len(series.Points))
+		if !operators[r.Operator](average, r.Value) {
+			continue
+		}
+		subject := series.Series
+		if subject == "" {
+			subject = "host"
+		}
+		findings = append(findings, finding{
+			subject: subject,
+			message: fmt.Sprintf("%s of %s is %.4g (%s %.4g) over %s", r.Metric, subject, average, r.Operator, r.Value, r.Window),
+		})
+	}
+	return findings, nil
+}
+
+// diskStaleAfter is how long a disk that is no longer checked, because it
+// was removed, keeps its alerts
+const diskStaleAfter = 7 * 24 * time.Hour
+
+type diskHealthRule struct {
+	// MaxTemperature in degrees Celsius, 0 to not check the temperature
+	MaxTemperature int `json:"maxTemperature"`
+}
+
+func (r *diskHealthRule) validate() error {
+	if r.MaxTemperature < 0 {
+		return errors.New("maxTemperature must not be negative")
+	}
+	return nil
+}
+
+func (r *diskHealthRule) check(e *evaluation) ([]finding, error) {
+	disks, err := db.GetList[model.DiskHealth](db.GetDb(), map[string]interface{}{})
+	if err != nil {
+		return nil, err
+	}
+
+	var findings []finding
+	for _, disk := range disks {
+		if disk.LastCheckedAt == nil || e.now.Sub(*disk.LastCheckedAt) > diskStaleAfter {
+			continue
+		}
+
+		var problems []string
+		if disk.Healthy != nil && !*disk.Healthy {
+			problems = append(problems, "SMART health check failed")
+		}
+		problems = appendGrowth(problems, "reallocated sectors", disk.BaselineReallocated, disk.ReallocatedSectors)
+		problems = appendGrowth(problems, "pending sectors", disk.BaselinePending, disk.PendingSectors)
+		problems = appendGrowth(problems, "uncorrectable sectors", disk.BaselineUncorrectable, disk.Uncorrectable)
+		problems = appendGrowth(problems, "media errors", disk.BaselineMediaErrors, disk.MediaErrors)
+		if disk.FailingAttributes != "" {
+			problems = append(problems, "failing attributes "+disk.FailingAttributes)
+		}
+		if r.MaxTemperature > 0 && disk.Temperature != nil && *disk.Temperature >= r.MaxTemperature {
+			problems = append(problems, fmt.Sprintf("temperature %d°C", *disk.Temperature))
+		}
+		if disk.LastSelfTestPassed != nil && !*disk.LastSelfTestPassed {
+			problems = append(problems, fmt.Sprintf("%s self-test failed: %s", disk.LastSelfTest, disk.LastSelfTestResult))
+		}
+		if len(problems) == 0 {
+			continue
+		}
+
+		findings = append(findings, finding{
+			subject: disk.Key,
+			message: fmt.Sprintf("Disk %s (%s, %s): %s", disk.Device, disk.Model, disk.Key, strings.Join(problems, ", ")),
+		})
+	}
+	return findings, nil
+}
+
+// appendGrowth reports an error counter that grew past its baseline
+func appendGrowth(problems []string, name string, baseline, current *int64) []string {
+	if baseline == nil || current == nil || *current <= *baseline {
+		return problems
+	}
+	return append(problems, fmt.Sprintf("%d %s (was %d)", *current, name, *baseline))
+}
+
+func validatePercent(percent float64) error {
+	if percent <= 0 || percent > 100 {
+		return errors.New("percent must be between 0 and 100")
+	}
+	return nil
+}
+
+func formatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%c", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,154 @@
+package alerts
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Security modes of SMTP connections
+const (
+	smtpNone     = "none"
+	smtpStartTLS = "starttls"
+	smtpTLS      = "tls"
+)
+
+// smtpChannel sends notifications by mail. Credentials are only sent over
+// TLS, or to a server on localhost.
+type smtpChannel struct {
+	Host string `json:"host"`
+	Port int    `json:"port"`
+	// Security is none, starttls or tls
+	Security           string   `json:"security"`
+	InsecureSkipVerify bool     `json:"insecureSkipVerify"`
+	Username           string   `json:"username"`
+	Password           string   `json:"password"`
+	From               string   `json:"from"`
+	To                 []string `json:"to"`
+}
+
+func (c *smtpChannel) validate() error {
+	if c.Host == "" {
+		return errors.New("host is required")
+	}
+	if c.Port < 1 || c.Port > 65535 {
+		return errors.New("port must be between 1 and 65535")
+	}
+	switch c.Security {
+	case smtpNone, smtpStartTLS, smtpTLS:
+	default:
+		return errors.New("security must be none, starttls or tls")
+	}
+	if _, err := mail.ParseAddress(c.From); err != nil {
+		return fmt.Errorf("from: %s", err.Error())
+	}
+	if len(c.To) == 0 {
+		return errors.New("at least one recipient is required")
+	}
+	for _, to := range c.To {
+		if _, err := mail.ParseAddress(to); err != nil {
+			return fmt.Errorf("to: %s", err.Error())
+		}
+	}
+	return nil
+}
+
+func (c *smtpChannel) secrets() []*string {
+	return []*string{&c.Password}
+}
+
+func (c *smtpChannel) Send(ctx context.Context, n Notification) error {
+	address := net.JoinHostPort(c.Host, strconv.Itoa(c.Port))
+	tlsConfig := &tls.Config{ServerName: c.Host, InsecureSkipVerify: c.InsecureSkipVerify}
+
+	dialer := &net.Dialer{}
+	var conn net.Conn
+	var err error
+	if c.Security == smtpTLS {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: tlsConfig}).DialContext(ctx, "tcp", address)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", address)
+	}
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	client, err := smtp.NewClient(conn, c.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if err = client.Hello(hostname()); err != nil {
+		return err
+	}
+	if c.Security == smtpStartTLS {
+		if err = client.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("starttls: %w", err)
+		}
+	}
+	if c.Username != "" {
+		// PlainAuth refuses to send the password without TLS except to localhost
+		if err = client.Auth(smtp.PlainAuth("", c.Username, c.Password, c.Host)); err != nil {
+			return fmt.Errorf("authentication: %w", err)
+		}
+	}
+
+	from, _ := mail.ParseAddress(c.From)
+	if err = client.Mail(from.Address); err != nil {
+		return err
+	}
+	recipients := make([]string, len(c.To))
+	for i, to := range c.To {
+		address, _ := mail.ParseAddress(to)
+		recipients[i] = address.String()
+		if err = client.Rcpt(address.Address); err != nil {
+			return err
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	headers := []string{
+		"From: " + from.String(),
+		"To: " + strings.Join(recipients, ", "),
+		"Subject: " + mimeHeader(n.Title()),
+		"Date: " + n.Time.Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=utf-8",
+		"Content-Transfer-Encoding: 8bit",
+		"Auto-Submitted: auto-generated",
+	}
+	message := strings.Join(headers, "\r\n") + "\r\n\r\n" + strings.ReplaceAll(n.Body(), "\n", "\r\n")
+	if _, err = w.Write([]byte(message)); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// mimeHeader encodes header values that are not plain ASCII
+func mimeHeader(value string) string {
+	for _, r := range value {
+		if r > 127 {
+			return mime.QEncoding.Encode("utf-8", value)
+		}
+	}
+	return value
+}
@@ -0,0 +1,71 @@
+package alerts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/whyxn/easynas/backend/pkg/db/model"
+	"log/syslog"
+	"strings"
+)
+
+var syslogFacilities = map[string]syslog.Priority{
+	"kern": syslog.LOG_KERN, "user": syslog.LOG_USER, "mail": syslog.LOG_MAIL,
+	"daemon": syslog.LOG_DAEMON, "auth": syslog.LOG_AUTH, "syslog": syslog.LOG_SYSLOG,
+	"local0": syslog.LOG_LOCAL0, "local1": syslog.LOG_LOCAL1, "local2": syslog.LOG_LOCAL2,
+	"local3": syslog.LOG_LOCAL3, "local4": syslog.LOG_LOCAL4, "local5": syslog.LOG_LOCAL5,
+	"local6": syslog.LOG_LOCAL6, "local7": syslog.LOG_LOCAL7,
+}
+
+// syslogChannel writes notifications to the local syslog daemon, or a
+// remote one when Network and Address are set.
+type syslogChannel struct {
+	// Network is udp, tcp or unix, the local daemon is used if empty
+	Network  string `json:"network"`
+	Address  string `json:"address"`
+	Facility string `json:"facility"`
+	Tag      string `json:"tag"`
+}
+
+func (c *syslogChannel) validate() error {
+	switch c.Network {
+	case "":
+		if c.Address != "" {
+			return errors.New("network is required with an address")
+		}
+	case "udp", "tcp", "unix":
+		if c.Address == "" {
+			return errors.New("address is required with a network")
+		}
+	default:
+		return errors.New("network must be udp, tcp or unix")
+	}
+	if _, ok := syslogFacilities[c.Facility]; !ok {
+		return fmt.Errorf("unknown facility '%s'", c.Facility)
+	}
+	return nil
+}
+
+func (c *syslogChannel) secrets() []*string {
+	return nil
+}
+
+func (c *syslogChannel) Send(ctx context.Context, n Notification) error {
+	w, err := syslog.Dial(c.Network, c.Address, syslogFacilities[c.Facility]|syslog.LOG_INFO, c.Tag)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	message := fmt.Sprintf("%s (rule %q, subject %q)", strings.TrimPrefix(n.Title(), "[easynas] "), n.Alert.RuleName, n.Alert.Subject)
+	if n.State == model.AlertResolved {
+		return w.Notice(message)
+	}
+	switch n.Alert.Severity {
+	case model.SeverityCritical:
+		return w.Crit(message)
+	case model.SeverityWarning:
+		return w.Warning(message)
+	}
+	return w.Info(message)
+}
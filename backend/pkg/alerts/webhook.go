@@ -0,0 +1,155 @@
+package alerts
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/whyxn/easynas/backend/pkg/db/model"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// signatureHeader carries the HMAC-SHA256 of the body when a secret is set
+const signatureHeader = "X-Easynas-Signature"
+
+// webhookChannel posts the alert as JSON to a URL.
+type webhookChannel struct {
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers,omitempty"`
+	// Secret signs the body, the signature is sent as "sha256=<hex>"
+	Secret string `json:"secret"`
+}
+
+type webhookPayload struct {
+	AlertId    uint       `json:"alertId"`
+	State      string     `json:"state"`
+	Severity   string     `json:"severity"`
+	Rule       string     `json:"rule"`
+	Subject    string     `json:"subject"`
+	Message    string     `json:"message"`
+	Host       string     `json:"host"`
+	FiredAt    time.Time  `json:"firedAt"`
+	ResolvedAt *time.Time `json:"resolvedAt,omitempty"`
+	Time       time.Time  `json:"time"`
+}
+
+func (c *webhookChannel) validate() error {
+	return validateWebhookURL(c.URL)
+}
+
+func (c *webhookChannel) secrets() []*string {
+	return []*string{&c.Secret}
+}
+
+func (c *webhookChannel) secretMaps() []map[string]string {
+	// headers usually carry a credential, like Authorization
+	return []map[string]string{c.Headers}
+}
+
+func (c *webhookChannel) Send(ctx context.Context, n Notification) error {
+	body, err := json.Marshal(webhookPayload{
+		AlertId:    n.Alert.ID,
+		State:      n.State,
+		Severity:   n.Alert.Severity,
+		Rule:       n.Alert.RuleName,
+		Subject:    n.Alert.Subject,
+		Message:    n.Alert.Message,
+		Host:       hostname(),
+		FiredAt:    n.Alert.FiredAt,
+		ResolvedAt: n.Alert.ResolvedAt,
+		Time:       n.Time,
+	})
+	if err != nil {
+		return err
+	}
+
+	headers := map[string]string{}
+	for name, value := range c.Headers {
+		headers[name] = value
+	}
+	if c.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(c.Secret))
+		mac.Write(body)
+		headers[signatureHeader] = "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	}
+	return postJSON(ctx, c.URL, body, headers)
+}
+
+// slackChannel posts to a Slack compatible incoming webhook, which
+// Mattermost, Rocket.Chat and others accept as well.
+type slackChannel struct {
+	URL string `json:"url"`
+	// Channel overrides the channel of the webhook if the server allows it
+	Channel string `json:"channel,omitempty"`
+}
+
+var slackIcons = map[string]string{
+	model.SeverityCritical: ":red_circle:",
+	model.SeverityWarning:  ":warning:",
+	model.SeverityInfo:     ":information_source:",
+}
+
+func (c *slackChannel) validate() error {
+	return validateWebhookURL(c.URL)
+}
+
+func (c *slackChannel) secrets() []*string {
+	// the webhook URL is the credential
+	return []*string{&c.URL}
+}
+
+func (c *slackChannel) Send(ctx context.Context, n Notification) error {
+	icon := slackIcons[n.Alert.Severity]
+	if n.State == model.AlertResolved {
+		icon = ":white_check_mark:"
+	}
+	payload := map[string]string{
+		"text": fmt.Sprintf("%s *%s* on %s\n%s", icon, n.Title(), hostname(), n.Alert.Subject),
+	}
+	if c.Channel != "" {
+		payload["channel"] = c.Channel
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return postJSON(ctx, c.URL, body, nil)
+}
+
+func validateWebhookURL(value string) error {
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("url must be an http:// or https:// URL")
+	}
+	return nil
+}
+
+func postJSON(ctx context.Context, url string, body []byte, headers map[string]string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "easynas")
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("webhook answered %s: %s", resp.Status, bytes.TrimSpace(message))
+	}
+	return nil
+}
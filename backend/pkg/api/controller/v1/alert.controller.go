@@ -0,0 +1,563 @@
+package v1
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/whyxn/easynas/backend/pkg/alerts"
+	"github.com/whyxn/easynas/backend/pkg/context"
+	"github.com/whyxn/easynas/backend/pkg/db"
+	"github.com/whyxn/easynas/backend/pkg/db/model"
+	"github.com/whyxn/easynas/backend/pkg/dto"
+	"github.com/whyxn/easynas/backend/pkg/log"
+	"net/http"
+	"strings"
+)
+
+// maxAlerts limits the alerts returned by GetList
+const maxAlerts = 500
+
+type AlertControllerInterface interface {
+	GetList(c *gin.Context)
+	Acknowledge(c *gin.Context)
+	GetRuleList(c *gin.Context)
+	CreateRule(c *gin.Context)
+	UpdateRule(c *gin.Context)
+	DeleteRule(c *gin.Context)
+	GetChannelList(c *gin.Context)
+	CreateChannel(c *gin.Context)
+	UpdateChannel(c *gin.Context)
+	DeleteChannel(c *gin.Context)
+	TestChannel(c *gin.Context)
+}
+
+type alertController struct{}
+
+var alc alertController
+
+func AlertController() *alertController {
+	return &alc
+}
+
+func alertRuleResponse(rule *model.AlertRule) gin.H {
+	channels := []uint{}
+	if rule.Channels != "" {
+		_ = json.Unmarshal([]byte(rule.Channels), &channels)
+	}
+	return gin.H{
+		"id":              rule.ID,
+		"key":             rule.Key,
+		"builtin":         rule.Key != "",
+		"name":            rule.Name,
+		"type":            rule.Type,
+		"params":          alerts.RuleParams(rule),
+		"severity":        rule.Severity,
+		"enabled":         rule.Enabled,
+		"channels":        channels,
+		"renotifyMinutes": rule.RenotifyMinutes,
+		"notifyResolved":  rule.NotifyResolved,
+		"createdAt":       rule.CreatedAt,
+		"updatedAt":       rule.UpdatedAt,
+	}
+}
+
+func alertChannelResponse(channel *model.AlertChannel) gin.H {
+	return gin.H{
+		"id":          channel.ID,
+		"name":        channel.Name,
+		"type":        channel.Type,
+		"config":      alerts.RedactedChannelConfig(channel),
+		"enabled":     channel.Enabled,
+		"minSeverity": channel.MinSeverity,
+		"lastSentAt":  channel.LastSentAt,
+		"lastError":   channel.LastError,
+		"createdAt":   channel.CreatedAt,
+		"updatedAt":   channel.UpdatedAt,
+	}
+}
+
+// GetList returns the alerts, newest first, optionally only the firing or
+// resolved ones
+func (ctrl *alertController) GetList(ctx *gin.Context) {
+	requester := context.GetRequesterFromContext(ctx)
+	if requester == nil {
+		returnErrorResponse(ctx, "unauthorized request", http.StatusUnauthorized)
+		return
+	} else if !isAdmin(requester) {
+		returnErrorResponse(ctx, "permission denied", http.StatusUnauthorized)
+		return
+	}
+
+	query := db.GetDb().Client().Order("fired_at DESC").Limit(maxAlerts)
+	switch state := ctx.Query("state"); state {
+	case "":
+	case model.AlertFiring, model.AlertResolved:
+		query = query.Where("state = ?", state)
+	default:
+		returnErrorResponse(ctx, "state must be firing or resolved", http.StatusBadRequest)
+		return
+	}
+
+	list := []model.Alert{}
+	if err := query.Find(&list).Error; err != nil {
+		log.Logger.Errorw("Failed to fetch alerts", "err", err)
+		returnErrorResponse(ctx, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"data":   list,
+	})
+}
+
+// Acknowledge stops the repeated notifications of a firing alert
+func (ctrl *alertController) Acknowledge(ctx *gin.Context) {
+	requester := context.GetRequesterFromContext(ctx)
+	if requester == nil {
+		returnErrorResponse(ctx, "unauthorized request", http.StatusUnauthorized)
+		return
+	} else if !isAdmin(requester) {
+		returnErrorResponse(ctx, "permission denied", http.StatusUnauthorized)
+		return
+	}
+
+	alert, _ := db.Get[model.Alert](db.GetDb(), map[string]interface{}{"ID": ctx.Param("id")})
+	if alert == nil {
+		returnErrorResponse(ctx, "alert not found", http.StatusNotFound)
+		return
+	}
+
+	err := alerts.Acknowledge(alert, requester)
+	if errors.Is(err, alerts.ErrNotFiring) {
+		returnErrorResponse(ctx, err.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
+		log.Logger.Errorw("Failed to acknowledge alert", "id", alert.ID, "err", err)
+		returnErrorResponse(ctx, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	alert, _ = db.Get[model.Alert](db.GetDb(), map[string]interface{}{"ID": alert.ID})
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"data":   alert,
+	})
+}
+
+// GetRuleList returns the alert rules with the supported rule types
+func (ctrl *alertController) GetRuleList(ctx *gin.Context) {
+	requester := context.GetRequesterFromContext(ctx)
+	if requester == nil {
+		returnErrorResponse(ctx, "unauthorized request", http.StatusUnauthorized)
+		return
+	} else if !isAdmin(requester) {
+		returnErrorResponse(ctx, "permission denied", http.StatusUnauthorized)
+		return
+	}
+
+	rules, err := db.GetList[model.AlertRule](db.GetDb(), map[string]interface{}{})
+	if err != nil {
+		log.Logger.Errorw("Failed to fetch alert rules", "err", err)
+		returnErrorResponse(ctx, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := []gin.H{}
+	for i := range rules {
+		data = append(data, alertRuleResponse(&rules[i]))
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"data":   data,
+		"types":  alerts.RuleTypes(),
+	})
+}
+
+// applyAlertRuleInput validates the input and applies it to rule, fields
+// left out of the input keep their value
+func applyAlertRuleInput(input *dto.AlertRuleInputDTO, rule *model.AlertRule) error {
+	if name := strings.TrimSpace(input.Name); name != "" {
+		rule.Name = name
+	} else if rule.Name == "" {
+		return errors.New("rule name is required")
+	}
+
+	ruleType := input.Type
+	if ruleType == "" {
+		ruleType = rule.Type
+	}
+	if rule.Key != "" && ruleType != rule.Type {
+		return errors.New("the type of built-in rules can not be changed")
+	}
+	if rule.ID == 0 || input.Params != nil || ruleType != rule.Type {
+		params, err := alerts.ParseRuleParams(ruleType, input.Params)
+		if err != nil {
+			return err
+		}
+		rule.Type = ruleType
+		rule.Params = params
+	}
+
+	if input.Severity != "" {
+		if !alerts.ValidSeverity(input.Severity) {
+			return errors.New("severity must be info, warning or critical")
+		}
+		rule.Severity = input.Severity
+	}
+	if input.Enabled != nil {
+		rule.Enabled = *input.Enabled
+	}
+	if input.NotifyResolved != nil {
+		rule.NotifyResolved = *input.NotifyResolved
+	}
+	if input.RenotifyMinutes != nil {
+		if *input.RenotifyMinutes < 0 {
+			return errors.New("renotifyMinutes must not be negative")
+		}
+		rule.RenotifyMinutes = *input.RenotifyMinutes
+	}
+
+	if input.Channels != nil {
+		for _, id := range input.Channels {
+			if channel, _ := db.Get[model.AlertChannel](db.GetDb(), map[string]interface{}{"ID": id}); channel == nil {
+				return fmt.Errorf("alert channel %d not found", id)
+			}
+		}
+		rule.Channels = ""
+		if len(input.Channels) > 0 {
+			encoded, _ := json.Marshal(input.Channels)
+			rule.Channels = string(encoded)
+		}
+	}
+	return nil
+}
+
+// CreateRule adds an alert rule
+func (ctrl *alertController) CreateRule(ctx *gin.Context) {
+	requester := context.GetRequesterFromContext(ctx)
+	if requester == nil {
+		returnErrorResponse(ctx, "unauthorized request", http.StatusUnauthorized)
+		return
+	} else if !isAdmin(requester) {
+		returnErrorResponse(ctx, "permission denied", http.StatusUnauthorized)
+		return
+	}
+
+	var input dto.AlertRuleInputDTO
+	if err := ctx.BindJSON(&input); err != nil {
+		log.Logger.Errorw("Failed to bind JSON", "err", err)
+		returnErrorResponse(ctx, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rule := &model.AlertRule{Severity: model.SeverityWarning, Enabled: true, NotifyResolved: true}
+	if err := applyAlertRuleInput(&input, rule); err != nil {
+		returnErrorResponse(ctx, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := db.GetDb().Insert(rule); err != nil {
+		log.Logger.Errorw("Failed to create alert rule", "err", err)
+		returnErrorResponse(ctx, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"data":   alertRuleResponse(rule),
+	})
+}
+
+// UpdateRule changes an alert rule, including the built-in ones
+func (ctrl *alertController) UpdateRule(ctx *gin.Context) {
+	requester := context.GetRequesterFromContext(ctx)
+	if requester == nil {
+		returnErrorResponse(ctx, "unauthorized request", http.StatusUnauthorized)
+		return
+	} else if !isAdmin(requester) {
+		returnErrorResponse(ctx, "permission denied", http.StatusUnauthorized)
+		return
+	}
+
+	rule, _ := db.Get[model.AlertRule](db.GetDb(), map[string]interface{}{"ID": ctx.Param("id")})
+	if rule == nil {
+		returnErrorResponse(ctx, "alert rule not found", http.StatusNotFound)
+		return
+	}
+
+	var input dto.AlertRuleInputDTO
+	if err := ctx.BindJSON(&input); err != nil {
+		log.Logger.Errorw("Failed to bind JSON", "err", err)
+		returnErrorResponse(ctx, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := applyAlertRuleInput(&input, rule); err != nil {
+		returnErrorResponse(ctx, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err := db.GetDb().Update(rule, map[string]interface{}{
+		"name":             rule.Name,
+		"type":             rule.Type,
+		"params":           rule.Params,
+		"severity":         rule.Severity,
+		"enabled":          rule.Enabled,
+		"channels":         rule.Channels,
+		"renotify_minutes": rule.RenotifyMinutes,
+		"notify_resolved":  rule.NotifyResolved,
+	})
+	if err != nil {
+		log.Logger.Errorw("Failed to update alert rule", "id", rule.ID, "err", err)
+		returnErrorResponse(ctx, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"data":   alertRuleResponse(rule),
+	})
+}
+
+// DeleteRule removes a user defined alert rule and resolves its alerts
+func (ctrl *alertController) DeleteRule(ctx *gin.Context) {
+	requester := context.GetRequesterFromContext(ctx)
+	if requester == nil {
+		returnErrorResponse(ctx, "unauthorized request", http.StatusUnauthorized)
+		return
+	} else if !isAdmin(requester) {
+		returnErrorResponse(ctx, "permission denied", http.StatusUnauthorized)
+		return
+	}
+
+	rule, _ := db.Get[model.AlertRule](db.GetDb(), map[string]interface{}{"ID": ctx.Param("id")})
+	if rule == nil {
+		returnErrorResponse(ctx, "alert rule not found", http.StatusNotFound)
+		return
+	} else if rule.Key != "" {
+		returnErrorResponse(ctx, alerts.ErrBuiltinRule.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := db.GetDb().Delete(&model.AlertRule{}, map[string]interface{}{"ID": rule.ID}); err != nil {
+		returnErrorResponse(ctx, err.Error(), http.StatusBadRequest)
+		return
+	}
+	alerts.ResolveRule(rule)
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+	})
+}
+
+// GetChannelList returns the notification channels, secrets redacted, with
+// the supported channel types
+func (ctrl *alertController) GetChannelList(ctx *gin.Context) {
+	requester := context.GetRequesterFromContext(ctx)
+	if requester == nil {
+		returnErrorResponse(ctx, "unauthorized request", http.StatusUnauthorized)
+		return
+	} else if !isAdmin(requester) {
+		returnErrorResponse(ctx, "permission denied", http.StatusUnauthorized)
+		return
+	}
+
+	channels, err := db.GetList[model.AlertChannel](db.GetDb(), map[string]interface{}{})
+	if err != nil {
+		log.Logger.Errorw("Failed to fetch alert channels", "err", err)
+		returnErrorResponse(ctx, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := []gin.H{}
+	for i := range channels {
+		data = append(data, alertChannelResponse(&channels[i]))
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"data":   data,
+		"types":  alerts.ChannelTypes(),
+	})
+}
+
+// applyAlertChannelInput validates the input and applies it to channel,
+// fields left out of the input keep their value
+func applyAlertChannelInput(input *dto.AlertChannelInputDTO, channel *model.AlertChannel) error {
+	if name := strings.TrimSpace(input.Name); name != "" {
+		channel.Name = name
+	} else if channel.Name == "" {
+		return errors.New("channel name is required")
+	}
+
+	channelType := input.Type
+	if channelType == "" {
+		channelType = channel.Type
+	}
+	if channel.ID == 0 || input.Config != nil || channelType != channel.Type {
+		previous := ""
+		if channelType == channel.Type {
+			previous = channel.Config
+		}
+		config, err := alerts.ParseChannelConfig(channelType, input.Config, previous)
+		if err != nil {
+			return err
+		}
+		channel.Type = channelType
+		channel.Config = config
+	}
+
+	if input.MinSeverity != "" {
+		if !alerts.ValidSeverity(input.MinSeverity) {
+			return errors.New("minSeverity must be info, warning or critical")
+		}
+		channel.MinSeverity = input.MinSeverity
+	}
+	if input.Enabled != nil {
+		channel.Enabled = *input.Enabled
+	}
+	return nil
+}
+
+// CreateChannel adds a notification channel
+func (ctrl *alertController) CreateChannel(ctx *gin.Context) {
+	requester := context.GetRequesterFromContext(ctx)
+	if requester == nil {
+		returnErrorResponse(ctx, "unauthorized request", http.StatusUnauthorized)
+		return
+	} else if !isAdmin(requester) {
+		returnErrorResponse(ctx, "permission denied", http.StatusUnauthorized)
+		return
+	}
+
+	var input dto.AlertChannelInputDTO
+	if err := ctx.BindJSON(&input); err != nil {
+		log.Logger.Errorw("Failed to bind JSON", "err", err)
+		returnErrorResponse(ctx, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	channel := &model.AlertChannel{Enabled: true, MinSeverity: model.SeverityInfo}
+	if err := applyAlertChannelInput(&input, channel); err != nil {
+		returnErrorResponse(ctx, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := db.GetDb().Insert(channel); err != nil {
+		log.Logger.Errorw("Failed to create alert channel", "err", err)
+		returnErrorResponse(ctx, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"data":   alertChannelResponse(channel),
+	})
+}
+
+// UpdateChannel changes a notification channel, secrets sent back redacted
+// keep their stored value
+func (ctrl *alertController) UpdateChannel(ctx *gin.Context) {
+	requester := context.GetRequesterFromContext(ctx)
+	if requester == nil {
+		returnErrorResponse(ctx, "unauthorized request", http.StatusUnauthorized)
+		return
+	} else if !isAdmin(requester) {
+		returnErrorResponse(ctx, "permission denied", http.StatusUnauthorized)
+		return
+	}
+
+	channel, _ := db.Get[model.AlertChannel](db.GetDb(), map[string]interface{}{"ID": ctx.Param("id")})
+	if channel == nil {
+		returnErrorResponse(ctx, "alert channel not found", http.StatusNotFound)
+		return
+	}
+
+	var input dto.AlertChannelInputDTO
+	if err := ctx.BindJSON(&input); err != nil {
+		log.Logger.Errorw("Failed to bind JSON", "err", err)
+		returnErrorResponse(ctx, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := applyAlertChannelInput(&input, channel); err != nil {
+		returnErrorResponse(ctx, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err := db.GetDb().Update(channel, map[string]interface{}{
+		"name":         channel.Name,
+		"type":         channel.Type,
+		"config":       channel.Config,
+		"enabled":      channel.Enabled,
+		"min_severity": channel.MinSeverity,
+	})
+	if err != nil {
+		log.Logger.Errorw("Failed to update alert channel", "id", channel.ID, "err", err)
+		returnErrorResponse(ctx, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"data":   alertChannelResponse(channel),
+	})
+}
+
+// DeleteChannel removes a notification channel
+func (ctrl *alertController) DeleteChannel(ctx *gin.Context) {
+	requester := context.GetRequesterFromContext(ctx)
+	if requester == nil {
+		returnErrorResponse(ctx, "unauthorized request", http.StatusUnauthorized)
+		return
+	} else if !isAdmin(requester) {
+		returnErrorResponse(ctx, "permission denied", http.StatusUnauthorized)
+		return
+	}
+
+	channel, _ := db.Get[model.AlertChannel](db.GetDb(), map[string]interface{}{"ID": ctx.Param("id")})
+	if channel == nil {
+		returnErrorResponse(ctx, "alert channel not found", http.StatusNotFound)
+		return
+	}
+
+	if err := db.GetDb().Delete(&model.AlertChannel{}, map[string]interface{}{"ID": channel.ID}); err != nil {
+		returnErrorResponse(ctx, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+	})
+}
+
+// TestChannel sends a test notification through a channel, even a disabled
+// one
+func (ctrl *alertController) TestChannel(ctx *gin.Context) {
+	requester := context.GetRequesterFromContext(ctx)
+	if requester == nil {
+		returnErrorResponse(ctx, "unauthorized request", http.StatusUnauthorized)
+		return
+	} else if !isAdmin(requester) {
+		returnErrorResponse(ctx, "permission denied", http.StatusUnauthorized)
+		return
+	}
+
+	channel, _ := db.Get[model.AlertChannel](db.GetDb(), map[string]interface{}{"ID": ctx.Param("id")})
+	if channel == nil {
+		returnErrorResponse(ctx, "alert channel not found", http.StatusNotFound)
+		return
+	}
+
+	if err := alerts.Test(channel, requester); err != nil {
+		returnErrorResponse(ctx, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+	})
+}
@@ -0,0 +1,126 @@
+package v1
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/whyxn/easynas/backend/pkg/audit"
+	"github.com/whyxn/easynas/backend/pkg/context"
+	"github.com/whyxn/easynas/backend/pkg/log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type AuditControllerInterface interface {
+	GetList(c *gin.Context)
+	Export(c *gin.Context)
+}
+
+type auditController struct{}
+
+var auc auditController
+
+func AuditController() *auditController {
+	return &auc
+}
+
+// GetList returns a page of audit events matching the query filters
+func (ctrl *auditController) GetList(ctx *gin.Context) {
+	requester := context.GetRequesterFromContext(ctx)
+	if requester == nil {
+		returnErrorResponse(ctx, "unauthorized request", http.StatusUnauthorized)
+		return
+	} else if !isAdmin(requester) {
+		returnErrorResponse(ctx, "permission denied", http.StatusUnauthorized)
+		return
+	}
+
+	filter, ok := auditFilterFromQuery(ctx)
+	if !ok {
+		return
+	}
+
+	page, err := strconv.Atoi(ctx.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		returnErrorResponse(ctx, "invalid page", http.StatusBadRequest)
+		return
+	}
+	pageSize, err := strconv.Atoi(ctx.DefaultQuery("pageSize", strconv.Itoa(audit.DefaultPageSize)))
+	if err != nil || pageSize < 1 || pageSize > audit.MaxPageSize {
+		returnErrorResponse(ctx, "invalid page size", http.StatusBadRequest)
+		return
+	}
+
+	events, total, err := audit.Query(filter, page, pageSize)
+	if err != nil {
+		log.Logger.Errorw("Failed to fetch audit events", "err", err)
+		returnErrorResponse(ctx, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status":   "success",
+		"data":     events,
+		"total":    total,
+		"page":     page,
+		"pageSize": pageSize,
+	})
+}
+
+// Export streams the audit events matching the query filters as JSON lines
+func (ctrl *auditController) Export(ctx *gin.Context) {
+	requester := context.GetRequesterFromContext(ctx)
+	if requester == nil {
+		returnErrorResponse(ctx, "unauthorized request", http.StatusUnauthorized)
+		return
+	} else if !isAdmin(requester) {
+		returnErrorResponse(ctx, "permission denied", http.StatusUnauthorized)
+		return
+	}
+
+	filter, ok := auditFilterFromQuery(ctx)
+	if !ok {
+		return
+	}
+
+	ctx.Header("Content-Type", "application/x-ndjson")
+	ctx.Header("Content-Disposition", `attachment; filename="audit.jsonl"`)
+	ctx.Status(http.StatusOK)
+	if err := audit.Export(ctx.Writer, filter); err != nil {
+		log.Logger.Errorw("Failed to export audit events", "err", err)
+	}
+}
+
+// auditFilterFromQuery reads the audit filters from the query parameters.
+// Returns false if an error response has been written.
+func auditFilterFromQuery(ctx *gin.Context) (audit.Filter, bool) {
+	filter := audit.Filter{
+		Actor:   ctx.Query("actor"),
+		Action:  ctx.Query("action"),
+		Pool:    ctx.Query("pool"),
+		Dataset: ctx.Query("dataset"),
+		Result:  ctx.Query("result"),
+	}
+
+	for name, target := range map[string]*uint{"actorId": &filter.ActorId, "userId": &filter.TargetUserId, "jobId": &filter.JobId} {
+		if value := ctx.Query(name); value != "" {
+			id, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				returnErrorResponse(ctx, "invalid "+name, http.StatusBadRequest)
+				return filter, false
+			}
+			*target = uint(id)
+		}
+	}
+
+	for name, target := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+		if value := ctx.Query(name); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				returnErrorResponse(ctx, name+" must be an RFC 3339 timestamp", http.StatusBadRequest)
+				return filter, false
+			}
+			*target = t
+		}
+	}
+	return filter, true
+}
@@ -1,18 +1,41 @@
 package v1
 
 import (
+	"errors"
+	"fmt"
 	"github.com/gin-gonic/gin"
+	"github.com/whyxn/easynas/backend/pkg/auth"
+	"github.com/whyxn/easynas/backend/pkg/context"
 	"github.com/whyxn/easynas/backend/pkg/db"
 	"github.com/whyxn/easynas/backend/pkg/db/model"
 	"github.com/whyxn/easynas/backend/pkg/dto"
 	"github.com/whyxn/easynas/backend/pkg/jwt"
 	"github.com/whyxn/easynas/backend/pkg/log"
-	"github.com/whyxn/easynas/backend/pkg/util"
+	"github.com/whyxn/easynas/backend/pkg/loginguard"
+	"github.com/whyxn/easynas/backend/pkg/session"
+	"github.com/whyxn/easynas/backend/pkg/twofactor"
+	"math"
 	"net/http"
+	"net/url"
+	"strconv"
+	"time"
 )
 
+// oidcStateCookie binds an OpenID Connect login to the browser that started it
+const oidcStateCookie = "easynas_oidc_state"
+
+// errInvalidCredentials is returned for every failed login, so responses do
+// not reveal whether an account exists
+const errInvalidCredentials = "invalid credentials"
+
 type AuthControllerInterface interface {
 	Login(c *gin.Context)
+	LoginTwoFactor(c *gin.Context)
+	GetProviders(c *gin.Context)
+	OidcLogin(c *gin.Context)
+	OidcCallback(c *gin.Context)
+	Refresh(c *gin.Context)
+	Logout(c *gin.Context)
 }
 
 type authController struct{}
@@ -32,22 +55,310 @@ func (ctrl *authController) Login(ctx *gin.Context) {
 		return
 	}
 
-	user, _ := db.Get[model.User](db.GetDb(), map[string]interface{}{"email": input.Username})
-	if user == nil {
+	ip, userAgent := ctx.ClientIP(), ctx.Request.UserAgent()
+
+	// the attempt counts as failed until the credentials are verified,
+	// failures and the lockout are keyed by the username as submitted
+	reservation, wait := loginguard.Reserve(input.Username, ip)
+	if wait > 0 {
+		loginguard.RecordBlocked(input.Username, ip, userAgent)
+		returnTooManyLoginAttempts(ctx, wait)
+		return
+	}
+
+	user, err := auth.Authenticate(input.Username, input.Password)
+	if err != nil {
+		if !auth.IsCredentialError(err) {
+			log.Logger.Errorw("Failed to authenticate user", "err", err.Error())
+		}
+		reservation.Fail(userAgent, err.Error())
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"error": errInvalidCredentials,
+		})
+		return
+	}
+
+	if user.TotpEnabled {
+		// Password was correct, the second factor is checked by LoginTwoFactor
+		reservation.Release()
+		mfaToken, err := jwt.GenerateMfaJWT(user.ID)
+		if err != nil {
+			log.Logger.Errorw("Failed to generate MFA token", "err", err.Error())
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		ctx.JSON(http.StatusOK, gin.H{
+			"mfaRequired": true,
+			"mfaToken":    mfaToken,
+		})
+		return
+	}
+
+	reservation.Succeed(userAgent)
+	startSession(ctx, user, input.DeviceName)
+}
+
+// LoginTwoFactor completes a login of a user with two-factor authentication enabled
+func (ctrl *authController) LoginTwoFactor(ctx *gin.Context) {
+	var input dto.LoginTwoFactorInputDTO
+
+	err := ctx.BindJSON(&input)
+	if err != nil {
+		log.Logger.Errorw("Failed to bind JSON", "err", err)
+		return
+	}
+
+	claims, err := jwt.ValidateMfaJWT(input.MfaToken)
+	if err != nil {
+		log.Logger.Warnw("Failed to validate MFA token", "err", err.Error())
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"error": "invalid or expired mfa token",
+		})
+		return
+	}
+
+	user, _ := db.Get[model.User](db.GetDb(), map[string]interface{}{"ID": claims.UserId})
+	if user == nil || !user.TotpEnabled || user.Disabled {
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"error": "invalid or expired mfa token",
+		})
+		return
+	}
+
+	ip, userAgent := ctx.ClientIP(), ctx.Request.UserAgent()
+
+	// the second factor is throttled separately, by the ID of the user, so a
+	// new login with the password does not reset its failures
+	reservation, wait := loginguard.ReserveTwoFactor(user.ID, user.Email, ip)
+	if wait > 0 {
+		loginguard.RecordBlocked(user.Email, ip, userAgent)
+		returnTooManyLoginAttempts(ctx, wait)
+		return
+	}
+
+	if input.RecoveryCode != "" {
+		err = twofactor.UseRecoveryCode(user, input.RecoveryCode)
+	} else {
+		err = twofactor.VerifyCode(user, input.Code)
+	}
+	if err != nil {
+		log.Logger.Warnw("Failed second factor verification", "user", user.ID, "err", err.Error())
+		reservation.Fail(userAgent, "invalid second factor")
 		ctx.JSON(http.StatusUnauthorized, gin.H{
-			"error": "invalid credential, user not found",
+			"error": twofactor.ErrInvalidCode.Error(),
+		})
+		return
+	}
+
+	reservation.Succeed(userAgent)
+	startSession(ctx, user, input.DeviceName)
+}
+
+func returnTooManyLoginAttempts(ctx *gin.Context, wait time.Duration) {
+	ctx.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	ctx.JSON(http.StatusTooManyRequests, gin.H{
+		"error": "too many failed login attempts, try again later",
+	})
+}
+
+// startSession creates a login session for an authenticated user and responds
+// with its access and refresh tokens
+func startSession(ctx *gin.Context, user *model.User, deviceName string) {
+	data, err := createSession(ctx, user, deviceName)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, data)
+}
+
+// createSession creates a login session for an authenticated user and returns
+// its access and refresh tokens
+func createSession(ctx *gin.Context, user *model.User, deviceName string) (gin.H, error) {
+	s, refreshToken, err := session.Create(user, deviceName, ctx.Request.UserAgent(), ctx.ClientIP())
+	if err != nil {
+		log.Logger.Errorw("Failed to create login session", "err", err.Error())
+		return nil, err
+	}
+
+	authToken, err := jwt.GenerateJWT(*user, s.ID)
+	if err != nil {
+		log.Logger.Errorw("Failed to generate JWT token", "err", err.Error())
+		return nil, err
+	}
+
+	return gin.H{
+		"token":                       authToken,
+		"refreshToken":                refreshToken,
+		"twoFactorEnrollmentRequired": twofactor.Required(user) && !user.TotpEnabled,
+		"passwordChangeRequired":      user.MustChangePassword,
+	}, nil
+}
+
+// GetProviders lists the login methods the frontend can offer
+func (ctrl *authController) GetProviders(ctx *gin.Context) {
+	var providers []string
+	for _, p := range auth.Providers() {
+		providers = append(providers, p.Name())
+	}
+
+	oidc := gin.H{"enabled": false}
+	if p := auth.Oidc(); p != nil {
+		oidc = gin.H{"enabled": true, "displayName": p.DisplayName()}
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"data": gin.H{
+			"password": providers,
+			"oidc":     oidc,
+		},
+	})
+}
+
+// OidcLogin sends the browser to the OpenID Connect identity provider
+func (ctrl *authController) OidcLogin(ctx *gin.Context) {
+	provider := auth.Oidc()
+	if provider == nil {
+		returnErrorResponse(ctx, "single sign-on is not configured", http.StatusNotFound)
+		return
+	}
+
+	authURL, state, err := provider.BeginLogin()
+	if err != nil {
+		log.Logger.Errorw("Failed to start OpenID Connect login", "err", err.Error())
+		returnErrorResponse(ctx, "identity provider is not available", http.StatusBadGateway)
+		return
+	}
+
+	ctx.SetSameSite(http.SameSiteLaxMode)
+	ctx.SetCookie(oidcStateCookie, state, int(10*time.Minute/time.Second), "/api/v1/auth/oidc", "", isSecureRequest(ctx), true)
+
+	if ctx.Query("redirect") == "false" {
+		ctx.JSON(http.StatusOK, gin.H{
+			"status": "success",
+			"data":   gin.H{"url": authURL},
+		})
+		return
+	}
+	ctx.Redirect(http.StatusFound, authURL)
+}
+
+// OidcCallback completes the OpenID Connect login and starts a session the
+// same way a password login does
+func (ctrl *authController) OidcCallback(ctx *gin.Context) {
+	provider := auth.Oidc()
+	if provider == nil {
+		returnErrorResponse(ctx, "single sign-on is not configured", http.StatusNotFound)
+		return
+	}
+
+	state := ctx.Query("state")
+	cookieState, _ := ctx.Cookie(oidcStateCookie)
+	ctx.SetCookie(oidcStateCookie, "", -1, "/api/v1/auth/oidc", "", isSecureRequest(ctx), true)
+
+	if idpError := ctx.Query("error"); idpError != "" {
+		log.Logger.Warnw("Identity provider refused login", "err", idpError, "description", ctx.Query("error_description"))
+		respondOidcLogin(ctx, provider, nil, "login was refused by the identity provider")
+		return
+	}
+
+	if state == "" || state != cookieState {
+		respondOidcLogin(ctx, provider, nil, "invalid login state")
+		return
+	}
+
+	ip, userAgent := ctx.ClientIP(), ctx.Request.UserAgent()
+
+	user, err := provider.CompleteLogin(ctx.Query("code"), state)
+	if err != nil {
+		log.Logger.Warnw("Failed to complete OpenID Connect login", "err", err.Error())
+		loginguard.RecordFailure("", ip, userAgent, err.Error())
+		respondOidcLogin(ctx, provider, nil, "single sign-on failed")
+		return
+	}
+
+	if user.TotpEnabled {
+		mfaToken, err := jwt.GenerateMfaJWT(user.ID)
+		if err != nil {
+			log.Logger.Errorw("Failed to generate MFA token", "err", err.Error())
+			respondOidcLogin(ctx, provider, nil, err.Error())
+			return
+		}
+		respondOidcLogin(ctx, provider, gin.H{"mfaRequired": true, "mfaToken": mfaToken}, "")
+		return
+	}
+
+	data, err := createSession(ctx, user, "")
+	if err != nil {
+		respondOidcLogin(ctx, provider, nil, err.Error())
+		return
+	}
+	loginguard.RecordSuccess(user.Email, ip, userAgent)
+	respondOidcLogin(ctx, provider, data, "")
+}
+
+// respondOidcLogin hands the login result to the frontend. The values are
+// put into the URL fragment so they never reach any server logs.
+func respondOidcLogin(ctx *gin.Context, provider *auth.OidcProvider, data gin.H, errMsg string) {
+	redirectURL := provider.PostLoginRedirectURL()
+	if redirectURL == "" {
+		if errMsg != "" {
+			ctx.JSON(http.StatusUnauthorized, gin.H{
+				"error": errMsg,
+			})
+			return
+		}
+		ctx.JSON(http.StatusOK, data)
+		return
+	}
+
+	fragment := url.Values{}
+	if errMsg != "" {
+		fragment.Set("error", errMsg)
+	}
+	for k, v := range data {
+		fragment.Set(k, fmt.Sprint(v))
+	}
+	ctx.Redirect(http.StatusFound, redirectURL+"#"+fragment.Encode())
+}
+
+// Refresh issues a new access token and rotates the refresh token
+func (ctrl *authController) Refresh(ctx *gin.Context) {
+	var input dto.RefreshTokenInputDTO
+
+	err := ctx.BindJSON(&input)
+	if err != nil {
+		log.Logger.Errorw("Failed to bind JSON", "err", err)
+		return
+	}
+
+	if input.RefreshToken == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "refresh token is required",
 		})
 		return
 	}
 
-	if !util.CheckPasswordHash(input.Password, user.Password) {
+	s, refreshToken, err := session.Rotate(input.RefreshToken, ctx.Request.UserAgent(), ctx.ClientIP())
+	if errors.Is(err, session.ErrTokenReused) {
+		log.Logger.Warnw("Revoked session of a reused refresh token", "clientIP", ctx.ClientIP())
+	}
+	if err != nil {
+		log.Logger.Warnw("Failed to refresh session", "err", err.Error())
 		ctx.JSON(http.StatusUnauthorized, gin.H{
-			"error": "invalid password",
+			"error": "invalid or expired refresh token",
 		})
 		return
 	}
 
-	authToken, err := jwt.GenerateJWT(*user)
+	authToken, err := jwt.GenerateJWT(s.User, s.ID)
 	if err != nil {
 		log.Logger.Errorw("Failed to generate JWT token", "err", err.Error())
 		ctx.JSON(http.StatusBadRequest, gin.H{
@@ -57,6 +368,26 @@ func (ctrl *authController) Login(ctx *gin.Context) {
 	}
 
 	ctx.JSON(http.StatusOK, gin.H{
-		"token": authToken,
+		"token":        authToken,
+		"refreshToken": refreshToken,
+	})
+}
+
+// Logout revokes the session of the current access token
+func (ctrl *authController) Logout(ctx *gin.Context) {
+	s := context.GetSessionFromContext(ctx)
+	if s == nil {
+		returnErrorResponse(ctx, "unauthorized request", http.StatusUnauthorized)
+		return
+	}
+
+	if err := session.Revoke(s.ID); err != nil {
+		log.Logger.Errorw("Failed to revoke session", "err", err.Error())
+		returnErrorResponse(ctx, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
 	})
 }
@@ -0,0 +1,139 @@
+package v1
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/whyxn/easynas/backend/pkg/context"
+	"github.com/whyxn/easynas/backend/pkg/dto"
+	"github.com/whyxn/easynas/backend/pkg/log"
+	"github.com/whyxn/easynas/backend/pkg/tlscert"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+)
+
+const (
+	// maxCertificateSize limits each uploaded PEM file
+	maxCertificateSize = 1 << 20
+	// maxCertificateUploadSize limits the whole request, which has both files
+	// and the form or JSON around them
+	maxCertificateUploadSize = 2*maxCertificateSize + 64<<10
+)
+
+type CertificateControllerInterface interface {
+	Get(c *gin.Context)
+	Upload(c *gin.Context)
+}
+
+type certificateController struct{}
+
+var crc certificateController
+
+func CertificateController() *certificateController {
+	return &crc
+}
+
+// Get describes the certificate served over HTTPS
+func (ctrl *certificateController) Get(ctx *gin.Context) {
+	requester := context.GetRequesterFromContext(ctx)
+	if requester == nil {
+		returnErrorResponse(ctx, "unauthorized request", http.StatusUnauthorized)
+		return
+	} else if !isAdmin(requester) {
+		returnErrorResponse(ctx, "permission denied", http.StatusUnauthorized)
+		return
+	}
+
+	manager := tlscert.Current()
+	if manager == nil {
+		returnErrorResponse(ctx, "https is not enabled", http.StatusNotFound)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"data":   manager.Info(),
+	})
+}
+
+// Upload replaces the served certificate. The PEM certificate chain and
+// private key are sent as JSON or as the multipart files "certificate" and
+// "privateKey".
+func (ctrl *certificateController) Upload(ctx *gin.Context) {
+	requester := context.GetRequesterFromContext(ctx)
+	if requester == nil {
+		returnErrorResponse(ctx, "unauthorized request", http.StatusUnauthorized)
+		return
+	} else if !isAdmin(requester) {
+		returnErrorResponse(ctx, "permission denied", http.StatusUnauthorized)
+		return
+	}
+
+	manager := tlscert.Current()
+	if manager == nil {
+		returnErrorResponse(ctx, "https is not enabled", http.StatusConflict)
+		return
+	}
+
+	// limit the body before it is parsed, the form parser keeps whole files
+	ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, maxCertificateUploadSize)
+
+	var input dto.CertificateInputDTO
+	if strings.HasPrefix(ctx.ContentType(), "multipart/") {
+		var err error
+		if input.Certificate, err = readFormFile(ctx, "certificate"); err != nil {
+			returnErrorResponse(ctx, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if input.PrivateKey, err = readFormFile(ctx, "privateKey"); err != nil {
+			returnErrorResponse(ctx, err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else if err := ctx.BindJSON(&input); err != nil {
+		log.Logger.Errorw("Failed to bind JSON", "err", err)
+		returnErrorResponse(ctx, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if input.Certificate == "" || input.PrivateKey == "" {
+		returnErrorResponse(ctx, "certificate and privateKey are required", http.StatusBadRequest)
+		return
+	}
+
+	if err := manager.Install([]byte(input.Certificate), []byte(input.PrivateKey)); err != nil {
+		log.Logger.Warnw("Rejected certificate upload", "err", err)
+		returnErrorResponse(ctx, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	info := manager.Info()
+	log.Logger.Infow("Installed new certificate", "subject", info.Subject, "notAfter", info.NotAfter, "by", requester.Email)
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"data":   info,
+	})
+}
+
+func readFormFile(ctx *gin.Context, name string) (string, error) {
+	header, err := ctx.FormFile(name)
+	if err != nil {
+		return "", err
+	}
+	if header.Size > maxCertificateSize {
+		return "", fmt.Errorf("%s is larger than %d bytes", name, maxCertificateSize)
+	}
+
+	var file multipart.File
+	if file, err = header.Open(); err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxCertificateSize))
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
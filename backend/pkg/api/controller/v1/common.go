@@ -3,6 +3,7 @@ package v1
 import (
 	"github.com/gin-gonic/gin"
 	"github.com/whyxn/easynas/backend/pkg/db/model"
+	"net/http"
 )
 
 func returnErrorResponse(ctx *gin.Context, msg string, statusCode int) {
@@ -12,6 +13,20 @@ func returnErrorResponse(ctx *gin.Context, msg string, statusCode int) {
 	})
 }
 
+// returnJobResponse answers a request whose work continues in a background
+// job, which the client can follow at api/v1/jobs/:id
+func returnJobResponse(ctx *gin.Context, job *model.Job) {
+	ctx.JSON(http.StatusAccepted, gin.H{
+		"status": "success",
+		"data":   job,
+	})
+}
+
+// isSecureRequest tells whether cookies may be marked Secure
+func isSecureRequest(ctx *gin.Context) bool {
+	return ctx.Request.TLS != nil
+}
+
 func isAdmin(requester *model.User) bool {
 	if requester.Role == model.RoleAdmin {
 		return true
@@ -0,0 +1,40 @@
+package v1
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/whyxn/easynas/backend/pkg/config"
+	"github.com/whyxn/easynas/backend/pkg/context"
+	"net/http"
+)
+
+type ConfigControllerInterface interface {
+	Get(c *gin.Context)
+}
+
+type configController struct{}
+
+var cfc configController
+
+func ConfigController() *configController {
+	return &cfc
+}
+
+// Get returns the effective configuration with secrets redacted
+func (ctrl *configController) Get(ctx *gin.Context) {
+	requester := context.GetRequesterFromContext(ctx)
+	if requester == nil {
+		returnErrorResponse(ctx, "unauthorized request", http.StatusUnauthorized)
+		return
+	} else if !isAdmin(requester) {
+		returnErrorResponse(ctx, "permission denied", http.StatusUnauthorized)
+		return
+	}
+
+	cfg := config.Get()
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"data":   cfg.Redacted(),
+		"file":   cfg.File,
+	})
+}
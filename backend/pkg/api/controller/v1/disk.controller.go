@@ -0,0 +1,191 @@
+package v1
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/whyxn/easynas/backend/pkg/context"
+	"github.com/whyxn/easynas/backend/pkg/db/model"
+	"github.com/whyxn/easynas/backend/pkg/disks"
+	"github.com/whyxn/easynas/backend/pkg/dto"
+	"github.com/whyxn/easynas/backend/pkg/log"
+	"net/http"
+)
+
+type DiskControllerInterface interface {
+	GetList(c *gin.Context)
+	GetSmart(c *gin.Context)
+	StartSelfTest(c *gin.Context)
+	ResetBaseline(c *gin.Context)
+}
+
+type diskController struct{}
+
+var dkc diskController
+
+func DiskController() *diskController {
+	return &dkc
+}
+
+// GetList returns the physical disks with the health recorded by the last
+// check
+func (ctrl *diskController) GetList(ctx *gin.Context) {
+	requester := context.GetRequesterFromContext(ctx)
+	if requester == nil {
+		returnErrorResponse(ctx, "unauthorized request", http.StatusUnauthorized)
+		return
+	} else if !isAdmin(requester) {
+		returnErrorResponse(ctx, "permission denied", http.StatusUnauthorized)
+		return
+	}
+
+	list, err := disks.List()
+	if err != nil {
+		log.Logger.Errorw("Failed to list disks", "err", err)
+		returnErrorResponse(ctx, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	health, err := disks.ListHealth()
+	if err != nil {
+		returnErrorResponse(ctx, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	type diskWithHealth struct {
+		disks.Disk
+		Health *model.DiskHealth `json:"health"`
+	}
+	result := []diskWithHealth{}
+	for _, disk := range list {
+		item := diskWithHealth{Disk: disk}
+		if record, ok := health[disk.Key()]; ok {
+			item.Health = &record
+		}
+		result = append(result, item)
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"data":   result,
+	})
+}
+
+// GetSmart reads the SMART data of a disk, waking it up if needed, and
+// records it as the health of the disk
+func (ctrl *diskController) GetSmart(ctx *gin.Context) {
+	requester := context.GetRequesterFromContext(ctx)
+	if requester == nil {
+		returnErrorResponse(ctx, "unauthorized request", http.StatusUnauthorized)
+		return
+	} else if !isAdmin(requester) {
+		returnErrorResponse(ctx, "permission denied", http.StatusUnauthorized)
+		return
+	}
+
+	disk, ok := getDisk(ctx)
+	if !ok {
+		return
+	}
+	info, health, err := disks.Refresh(disk, true)
+	if errors.Is(err, disks.ErrUnsupported) {
+		returnErrorResponse(ctx, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		log.Logger.Errorw("Failed to read SMART data", "disk", disk.Path, "err", err)
+		returnErrorResponse(ctx, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"data": gin.H{
+			"disk":   disk,
+			"smart":  info,
+			"health": health,
+		},
+	})
+}
+
+// StartSelfTest starts a short or long self-test of a disk
+func (ctrl *diskController) StartSelfTest(ctx *gin.Context) {
+	requester := context.GetRequesterFromContext(ctx)
+	if requester == nil {
+		returnErrorResponse(ctx, "unauthorized request", http.StatusUnauthorized)
+		return
+	} else if !isAdmin(requester) {
+		returnErrorResponse(ctx, "permission denied", http.StatusUnauthorized)
+		return
+	}
+
+	var input dto.DiskSelfTestInputDTO
+	if err := ctx.BindJSON(&input); err != nil {
+		log.Logger.Errorw("Failed to bind JSON", "err", err)
+		returnErrorResponse(ctx, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if input.Type != disks.SelfTestShort && input.Type != disks.SelfTestLong {
+		returnErrorResponse(ctx, "type must be short or long", http.StatusBadRequest)
+		return
+	}
+
+	disk, ok := getDisk(ctx)
+	if !ok {
+		return
+	}
+	if err := disks.StartSelfTest(disk.Path, input.Type); err != nil {
+		log.Logger.Errorw("Failed to start self-test", "disk", disk.Path, "err", err)
+		returnErrorResponse(ctx, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Logger.Infow("Started self-test", "disk", disk.Path, "type", input.Type, "by", requester.Email)
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"data":   "self-test started",
+	})
+}
+
+// ResetBaseline accepts the current error counters of a disk, so only new
+// errors alert again
+func (ctrl *diskController) ResetBaseline(ctx *gin.Context) {
+	requester := context.GetRequesterFromContext(ctx)
+	if requester == nil {
+		returnErrorResponse(ctx, "unauthorized request", http.StatusUnauthorized)
+		return
+	} else if !isAdmin(requester) {
+		returnErrorResponse(ctx, "permission denied", http.StatusUnauthorized)
+		return
+	}
+
+	disk, ok := getDisk(ctx)
+	if !ok {
+		return
+	}
+	health, err := disks.ResetBaseline(disk)
+	if errors.Is(err, disks.ErrNotFound) {
+		returnErrorResponse(ctx, "disk has not been checked yet", http.StatusNotFound)
+		return
+	} else if err != nil {
+		returnErrorResponse(ctx, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"data":   health,
+	})
+}
+
+// getDisk finds the disk of the :name parameter and writes the error
+// response if there is none
+func getDisk(ctx *gin.Context) (*disks.Disk, bool) {
+	disk, err := disks.Get(ctx.Param("name"))
+	if errors.Is(err, disks.ErrNotFound) {
+		returnErrorResponse(ctx, "disk not found", http.StatusNotFound)
+		return nil, false
+	} else if err != nil {
+		log.Logger.Errorw("Failed to list disks", "err", err)
+		returnErrorResponse(ctx, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	return disk, true
+}
@@ -0,0 +1,215 @@
+package v1
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/whyxn/easynas/backend/pkg/apitoken"
+	"github.com/whyxn/easynas/backend/pkg/context"
+	"github.com/whyxn/easynas/backend/pkg/db"
+	"github.com/whyxn/easynas/backend/pkg/db/model"
+	"github.com/whyxn/easynas/backend/pkg/events"
+	"github.com/whyxn/easynas/backend/pkg/lifecycle"
+	"github.com/whyxn/easynas/backend/pkg/log"
+	"github.com/whyxn/easynas/backend/pkg/session"
+	"github.com/whyxn/easynas/backend/pkg/util"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// eventHeartbeat keeps proxies from closing idle streams and rechecks
+	// that the session or API token is still valid
+	eventHeartbeat = 25 * time.Second
+	// eventRetry tells browsers how long to wait before reconnecting, in ms
+	eventRetry = 3000
+)
+
+type EventControllerInterface interface {
+	Stream(c *gin.Context)
+}
+
+type eventController struct{}
+
+var evc eventController
+
+func EventController() *eventController {
+	return &evc
+}
+
+// Stream sends events as server-sent events. ?topics=dataset,job picks the
+// topics, all by default. A reconnecting client resumes after the
+// Last-Event-ID header, or ?lastEventId for clients that cannot set it. The
+// first event is "ready"; its resync field tells the client to reload its
+// state because events were missed.
+func (ctrl *eventController) Stream(ctx *gin.Context) {
+	requester := context.GetRequesterFromContext(ctx)
+	if requester == nil {
+		returnErrorResponse(ctx, "unauthorized request", http.StatusUnauthorized)
+		return
+	}
+
+	topics := events.Topics
+	if query := ctx.Query("topics"); query != "" {
+		topics = strings.Split(query, ",")
+		for _, topic := range topics {
+			if !util.Contains(events.Topics, topic) {
+				returnErrorResponse(ctx, fmt.Sprintf("unknown topic '%s'", topic), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	var resumeID uint64
+	if lastEventID := firstNonEmptyString(ctx.GetHeader("Last-Event-ID"), ctx.Query("lastEventId")); lastEventID != "" {
+		var err error
+		if resumeID, err = strconv.ParseUint(lastEventID, 10, 64); err != nil {
+			returnErrorResponse(ctx, "invalid last event id", http.StatusBadRequest)
+			return
+		}
+	}
+
+	visibility, err := newEventVisibility(requester)
+	if err != nil {
+		log.Logger.Errorw("Failed to load nfs share permissions", "err", err)
+		returnErrorResponse(ctx, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	sub, missed, complete := events.Subscribe(topics, resumeID)
+	defer events.Unsubscribe(sub)
+
+	ctx.Header("Content-Type", "text/event-stream")
+	ctx.Header("Cache-Control", "no-cache")
+	ctx.Header("Connection", "keep-alive")
+	// nginx buffers responses otherwise
+	ctx.Header("X-Accel-Buffering", "no")
+	ctx.Status(http.StatusOK)
+
+	fmt.Fprintf(ctx.Writer, "retry: %d\n\n", eventRetry)
+	lastSent := sub.Head
+	if complete && len(missed) > 0 {
+		lastSent = resumeID
+	}
+	writeServerSentEvent(ctx, lastSent, "ready", gin.H{"resync": !complete, "topics": topics})
+	for _, event := range missed {
+		if visibility.allows(event) {
+			writeServerSentEvent(ctx, event.ID, event.Type, event)
+		}
+	}
+	ctx.Writer.Flush()
+
+	heartbeat := time.NewTicker(eventHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case event, ok := <-sub.C:
+			if !ok {
+				// fell behind, the client resumes from the history
+				return
+			}
+			if event.Type == events.ShareChanged && !isAdmin(visibility.user) {
+				if err = visibility.reload(); err != nil {
+					log.Logger.Warnw("Failed to reload nfs share permissions", "err", err)
+					return
+				}
+			}
+			if visibility.allows(event) {
+				writeServerSentEvent(ctx, event.ID, event.Type, event)
+				ctx.Writer.Flush()
+			}
+		case <-heartbeat.C:
+			user, err := streamRequester(ctx)
+			if err != nil {
+				log.Logger.Infow("Closing event stream", "user", requester.Email, "reason", err.Error())
+				return
+			}
+			visibility.user = user
+			fmt.Fprint(ctx.Writer, ": ping\n\n")
+			ctx.Writer.Flush()
+		case <-ctx.Request.Context().Done():
+			return
+		case <-lifecycle.Done():
+			return
+		}
+	}
+}
+
+func writeServerSentEvent(ctx *gin.Context, id uint64, eventType string, data interface{}) {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		log.Logger.Warnw("Failed to encode event", "type", eventType, "err", err)
+		return
+	}
+	fmt.Fprintf(ctx.Writer, "id: %d\nevent: %s\ndata: %s\n\n", id, eventType, encoded)
+}
+
+// streamRequester checks that the credentials of a long running request
+// are still valid and returns the current state of their user
+func streamRequester(ctx *gin.Context) (*model.User, error) {
+	if token := context.GetApiTokenFromContext(ctx); token != nil {
+		token, err := apitoken.Validate(token.ID)
+		if err != nil {
+			return nil, err
+		}
+		return &token.User, nil
+	}
+	if s := context.GetSessionFromContext(ctx); s != nil {
+		s, err := session.Validate(s.ID)
+		if err != nil {
+			return nil, err
+		}
+		return &s.User, nil
+	}
+	return context.GetRequesterFromContext(ctx), nil
+}
+
+// eventVisibility decides which events a user may see. Admins see every
+// event, users the events of datasets they have a permission on and of
+// their own jobs.
+type eventVisibility struct {
+	user     *model.User
+	datasets map[string]bool
+}
+
+func newEventVisibility(user *model.User) (*eventVisibility, error) {
+	v := &eventVisibility{user: user}
+	return v, v.reload()
+}
+
+func (v *eventVisibility) reload() error {
+	permissionList, err := db.GetList[model.NfsSharePermission](db.GetDb(), map[string]interface{}{"user_id": v.user.ID}, "NfsShare")
+	if err != nil {
+		return err
+	}
+	v.datasets = map[string]bool{}
+	for _, p := range permissionList {
+		v.datasets[p.NfsShare.Dataset] = true
+	}
+	return nil
+}
+
+func (v *eventVisibility) allows(event events.Event) bool {
+	if isAdmin(v.user) {
+		return true
+	}
+	if event.OwnerId != 0 && event.OwnerId != v.user.ID {
+		return false
+	}
+	if event.Dataset != "" && !v.datasets[event.Dataset] && event.OwnerId != v.user.ID {
+		return false
+	}
+	return true
+}
+
+func firstNonEmptyString(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,89 @@
+package v1
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/whyxn/easynas/backend/pkg/context"
+	"github.com/whyxn/easynas/backend/pkg/db"
+	"github.com/whyxn/easynas/backend/pkg/db/model"
+	"github.com/whyxn/easynas/backend/pkg/forecast"
+	"github.com/whyxn/easynas/backend/pkg/log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type ForecastControllerInterface interface {
+	GetForecasts(c *gin.Context)
+}
+
+type forecastController struct{}
+
+var fc forecastController
+
+func ForecastController() *forecastController {
+	return &fc
+}
+
+// GetForecasts projects when pools and datasets fill up, of every managed
+// pool or the one in the route. ?dataset limits the forecasts to one
+// dataset and ?thresholds=80,95 overrides the configured percentages. Users
+// get the forecasts of the datasets they have a permission on.
+func (ctrl *forecastController) GetForecasts(ctx *gin.Context) {
+	requester := context.GetRequesterFromContext(ctx)
+	if requester == nil {
+		returnErrorResponse(ctx, "unauthorized request", http.StatusUnauthorized)
+		return
+	}
+
+	var options forecast.Options
+	if value := ctx.Query("thresholds"); value != "" {
+		for _, item := range strings.Split(value, ",") {
+			percent, err := strconv.Atoi(strings.TrimSpace(item))
+			if err != nil || percent < 1 || percent > 100 {
+				returnErrorResponse(ctx, "thresholds must be percentages between 1 and 100", http.StatusBadRequest)
+				return
+			}
+			options.Thresholds = append(options.Thresholds, percent)
+		}
+	}
+	if ctx.Param("pool") != "" {
+		pool, ok := requestPool(ctx)
+		if !ok {
+			return
+		}
+		options.Pools = []string{pool}
+	}
+	if dataset := ctx.Query("dataset"); dataset != "" {
+		options.Datasets = []string{dataset}
+		options.SkipPools = true
+	}
+
+	if !isAdmin(requester) {
+		permissionList, err := db.GetList[model.NfsSharePermission](db.GetDb(), map[string]interface{}{"user_id": requester.ID}, "NfsShare")
+		if err != nil {
+			log.Logger.Errorw("Failed to load nfs share permissions", "err", err)
+			returnErrorResponse(ctx, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		allowed := []string{}
+		for _, p := range permissionList {
+			if options.Datasets == nil || options.Datasets[0] == p.NfsShare.Dataset {
+				allowed = append(allowed, p.NfsShare.Dataset)
+			}
+		}
+		options.Datasets = allowed
+		options.SkipPools = true
+	}
+
+	forecasts, err := forecast.Compute(options)
+	if err != nil {
+		log.Logger.Errorw("Failed to compute capacity forecasts", "err", err)
+		returnErrorResponse(ctx, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"data":   forecasts,
+	})
+}
@@ -4,12 +4,16 @@ import (
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/whyxn/easynas/backend/pkg/context"
+	"github.com/whyxn/easynas/backend/pkg/db"
+	"github.com/whyxn/easynas/backend/pkg/lifecycle"
+	"github.com/whyxn/easynas/backend/pkg/log"
 	"net/http"
 )
 
 type HealthControllerInterface interface {
 	Check(c *gin.Context)
 	SecuredCheck(c *gin.Context)
+	Ready(c *gin.Context)
 }
 
 type healthController struct{}
@@ -26,6 +30,33 @@ func (ctrl *healthController) Check(ctx *gin.Context) {
 	})
 }
 
+// Ready tells load balancers whether to send traffic. It fails as soon as
+// shutdown starts, while in-flight requests are still being served.
+func (ctrl *healthController) Ready(ctx *gin.Context) {
+	if lifecycle.ShuttingDown() {
+		ctx.JSON(http.StatusServiceUnavailable, gin.H{
+			"msg": "shutting down",
+		})
+		return
+	}
+
+	sqlDB, err := db.GetDb().Client().DB()
+	if err == nil {
+		err = sqlDB.PingContext(ctx.Request.Context())
+	}
+	if err != nil {
+		log.Logger.Warnw("Readiness check failed", "err", err)
+		ctx.JSON(http.StatusServiceUnavailable, gin.H{
+			"msg": "database is not available",
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"msg": "ready",
+	})
+}
+
 func (ctrl *healthController) SecuredCheck(ctx *gin.Context) {
 	requester := context.GetRequesterFromContext(ctx)
 	if requester == nil {
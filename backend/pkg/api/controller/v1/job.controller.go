@@ -0,0 +1,144 @@
+package v1
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/whyxn/easynas/backend/pkg/context"
+	"github.com/whyxn/easynas/backend/pkg/db/model"
+	"github.com/whyxn/easynas/backend/pkg/jobs"
+	"github.com/whyxn/easynas/backend/pkg/log"
+	"net/http"
+	"strconv"
+)
+
+type JobControllerInterface interface {
+	GetList(c *gin.Context)
+	Get(c *gin.Context)
+	Cancel(c *gin.Context)
+}
+
+type jobController struct{}
+
+var jc jobController
+
+func JobController() *jobController {
+	return &jc
+}
+
+// GetList returns a page of jobs, newest first. Users only see their own
+// jobs, admins see every job and may filter by owner.
+func (ctrl *jobController) GetList(ctx *gin.Context) {
+	requester := context.GetRequesterFromContext(ctx)
+	if requester == nil {
+		returnErrorResponse(ctx, "unauthorized request", http.StatusUnauthorized)
+		return
+	}
+
+	filter := jobs.Filter{
+		Type:   ctx.Query("type"),
+		State:  ctx.Query("state"),
+		Target: ctx.Query("target"),
+	}
+	if !isAdmin(requester) {
+		filter.OwnerId = requester.ID
+	} else if ownerId := ctx.Query("ownerId"); ownerId != "" {
+		id, err := strconv.ParseUint(ownerId, 10, 64)
+		if err != nil {
+			returnErrorResponse(ctx, "invalid ownerId", http.StatusBadRequest)
+			return
+		}
+		filter.OwnerId = uint(id)
+	}
+
+	page, err := strconv.Atoi(ctx.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		returnErrorResponse(ctx, "invalid page", http.StatusBadRequest)
+		return
+	}
+	pageSize, err := strconv.Atoi(ctx.DefaultQuery("pageSize", strconv.Itoa(jobs.DefaultPageSize)))
+	if err != nil || pageSize < 1 || pageSize > jobs.MaxPageSize {
+		returnErrorResponse(ctx, "invalid page size", http.StatusBadRequest)
+		return
+	}
+
+	list, total, err := jobs.List(filter, page, pageSize)
+	if err != nil {
+		log.Logger.Errorw("Failed to fetch jobs", "err", err)
+		returnErrorResponse(ctx, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status":   "success",
+		"data":     list,
+		"total":    total,
+		"page":     page,
+		"pageSize": pageSize,
+	})
+}
+
+// Get returns a job with its log
+func (ctrl *jobController) Get(ctx *gin.Context) {
+	requester := context.GetRequesterFromContext(ctx)
+	if requester == nil {
+		returnErrorResponse(ctx, "unauthorized request", http.StatusUnauthorized)
+		return
+	}
+
+	job, ok := requestJob(ctx, requester)
+	if !ok {
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"data":   job,
+	})
+}
+
+// Cancel stops a queued or running job of the requester, admins may cancel
+// any job
+func (ctrl *jobController) Cancel(ctx *gin.Context) {
+	requester := context.GetRequesterFromContext(ctx)
+	if requester == nil {
+		returnErrorResponse(ctx, "unauthorized request", http.StatusUnauthorized)
+		return
+	}
+
+	job, ok := requestJob(ctx, requester)
+	if !ok {
+		return
+	}
+
+	job, err := jobs.Cancel(job.ID)
+	if errors.Is(err, jobs.ErrFinished) {
+		returnErrorResponse(ctx, err.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
+		log.Logger.Errorw("Failed to cancel job", "id", ctx.Param("id"), "err", err)
+		returnErrorResponse(ctx, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	ctx.JSON(http.StatusAccepted, gin.H{
+		"status": "success",
+		"data":   job,
+	})
+}
+
+// requestJob loads the job named by the :id param. Users get 404 for jobs
+// of others.
+func requestJob(ctx *gin.Context, requester *model.User) (*model.Job, bool) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		returnErrorResponse(ctx, "invalid job id", http.StatusBadRequest)
+		return nil, false
+	}
+
+	job, _ := jobs.Get(uint(id))
+	if job == nil || (!isAdmin(requester) && job.OwnerId != requester.ID) {
+		returnErrorResponse(ctx, "job not found", http.StatusNotFound)
+		return nil, false
+	}
+	return job, true
+}
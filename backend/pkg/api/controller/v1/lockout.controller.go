@@ -0,0 +1,120 @@
+package v1
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/whyxn/easynas/backend/pkg/context"
+	"github.com/whyxn/easynas/backend/pkg/db"
+	"github.com/whyxn/easynas/backend/pkg/db/model"
+	"github.com/whyxn/easynas/backend/pkg/log"
+	"github.com/whyxn/easynas/backend/pkg/loginguard"
+	"net/http"
+	"strconv"
+)
+
+type LockoutControllerInterface interface {
+	GetList(c *gin.Context)
+	Clear(c *gin.Context)
+	GetLoginAttempts(c *gin.Context)
+}
+
+type lockoutController struct{}
+
+var lc lockoutController
+
+func LockoutController() *lockoutController {
+	return &lc
+}
+
+// GetList of accounts and addresses with failed logins
+func (ctrl *lockoutController) GetList(ctx *gin.Context) {
+	requester := context.GetRequesterFromContext(ctx)
+	if requester == nil {
+		returnErrorResponse(ctx, "unauthorized request", http.StatusUnauthorized)
+		return
+	} else if !isAdmin(requester) {
+		returnErrorResponse(ctx, "permission denied", http.StatusUnauthorized)
+		return
+	}
+
+	lockouts, err := loginguard.ListActive()
+	if err != nil {
+		log.Logger.Errorw("Failed to fetch login lockout list", "err", err)
+		returnErrorResponse(ctx, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"data":   lockouts,
+	})
+}
+
+// Clear a lockout so that logins are allowed again
+func (ctrl *lockoutController) Clear(ctx *gin.Context) {
+	requester := context.GetRequesterFromContext(ctx)
+	if requester == nil {
+		returnErrorResponse(ctx, "unauthorized request", http.StatusUnauthorized)
+		return
+	} else if !isAdmin(requester) {
+		returnErrorResponse(ctx, "permission denied", http.StatusUnauthorized)
+		return
+	}
+
+	id := ctx.Param("id")
+
+	lockout, _ := db.Get[model.LoginLockout](db.GetDb(), map[string]interface{}{"ID": id})
+	if lockout == nil {
+		returnErrorResponse(ctx, "lockout not found", http.StatusNotFound)
+		return
+	}
+
+	if err := loginguard.Clear(lockout.ID); err != nil {
+		returnErrorResponse(ctx, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+	})
+}
+
+// GetLoginAttempts returns the most recent login attempts, optionally filtered by username, ip and outcome
+func (ctrl *lockoutController) GetLoginAttempts(ctx *gin.Context) {
+	requester := context.GetRequesterFromContext(ctx)
+	if requester == nil {
+		returnErrorResponse(ctx, "unauthorized request", http.StatusUnauthorized)
+		return
+	} else if !isAdmin(requester) {
+		returnErrorResponse(ctx, "permission denied", http.StatusUnauthorized)
+		return
+	}
+
+	limit, err := strconv.Atoi(ctx.DefaultQuery("limit", "100"))
+	if err != nil || limit <= 0 || limit > 1000 {
+		returnErrorResponse(ctx, "invalid limit", http.StatusBadRequest)
+		return
+	}
+
+	tx := db.GetDb().Client().Order("created_at desc").Limit(limit)
+	if username := ctx.Query("username"); username != "" {
+		tx = tx.Where("username = ?", username)
+	}
+	if ip := ctx.Query("ip"); ip != "" {
+		tx = tx.Where("client_ip = ?", ip)
+	}
+	if success := ctx.Query("success"); success != "" {
+		tx = tx.Where("success = ?", success == "true")
+	}
+
+	var attempts []model.LoginAttempt
+	if err = tx.Find(&attempts).Error; err != nil {
+		log.Logger.Errorw("Failed to fetch login attempts", "err", err)
+		returnErrorResponse(ctx, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"data":   attempts,
+	})
+}
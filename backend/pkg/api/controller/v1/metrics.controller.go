@@ -1,15 +1,39 @@
 package v1
 
 import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
 	"github.com/gin-gonic/gin"
+	"github.com/whyxn/easynas/backend/pkg/config"
 	"github.com/whyxn/easynas/backend/pkg/context"
+	"github.com/whyxn/easynas/backend/pkg/db"
+	"github.com/whyxn/easynas/backend/pkg/db/model"
+	"github.com/whyxn/easynas/backend/pkg/history"
 	"github.com/whyxn/easynas/backend/pkg/log"
 	"github.com/whyxn/easynas/backend/pkg/metrics"
+	"github.com/whyxn/easynas/backend/pkg/util"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultHistoryRange = 24 * time.Hour
+	// defaultHistoryPoints is the number of points per series if no step is given
+	defaultHistoryPoints = 300
+	// maxIOInterval limits how long an I/O request samples
+	maxIOInterval = 10
 )
 
 type MetricsControllerInterface interface {
 	GetSystemMetrics(c *gin.Context)
+	GetIOMetrics(c *gin.Context)
+	GetArcStats(c *gin.Context)
+	Prometheus(c *gin.Context)
+	GetHistory(c *gin.Context)
 }
 
 type metricsController struct{}
@@ -39,3 +63,195 @@ func (ctrl *metricsController) GetSystemMetrics(ctx *gin.Context) {
 		"data":   metrics,
 	})
 }
+
+// GetIOMetrics samples per-disk, per-vdev, network and CPU activity over
+// ?interval seconds, 1 by default
+func (ctrl *metricsController) GetIOMetrics(ctx *gin.Context) {
+	requester := context.GetRequesterFromContext(ctx)
+	if requester == nil {
+		returnErrorResponse(ctx, "unauthorized request", http.StatusUnauthorized)
+		return
+	} else if !isAdmin(requester) {
+		returnErrorResponse(ctx, "permission denied", http.StatusUnauthorized)
+		return
+	}
+
+	interval, err := strconv.Atoi(ctx.DefaultQuery("interval", "1"))
+	if err != nil || interval < 1 || interval > maxIOInterval {
+		returnErrorResponse(ctx, fmt.Sprintf("interval must be between 1 and %d seconds", maxIOInterval), http.StatusBadRequest)
+		return
+	}
+
+	io, err := metrics.GetIOMetrics(time.Duration(interval) * time.Second)
+	if err != nil {
+		log.Logger.Errorw("Failed to sample I/O metrics", "err", err)
+		returnErrorResponse(ctx, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"data":   io,
+	})
+}
+
+// GetArcStats returns the ZFS ARC and L2ARC statistics
+func (ctrl *metricsController) GetArcStats(ctx *gin.Context) {
+	requester := context.GetRequesterFromContext(ctx)
+	if requester == nil {
+		returnErrorResponse(ctx, "unauthorized request", http.StatusUnauthorized)
+		return
+	} else if !isAdmin(requester) {
+		returnErrorResponse(ctx, "permission denied", http.StatusUnauthorized)
+		return
+	}
+
+	stats, err := metrics.GetArcStats()
+	if errors.Is(err, metrics.ErrArcUnavailable) {
+		returnErrorResponse(ctx, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Logger.Errorw("Failed to read ARC statistics", "err", err)
+		returnErrorResponse(ctx, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"data":   stats,
+	})
+}
+
+// GetHistory returns recorded metrics, ?metrics=pool.free_bytes,... over
+// ?from to ?to (RFC 3339, the last 24 hours by default) in points ?step
+// apart. ?series limits pool and dataset metrics to some pools or datasets.
+// Users see the host metrics and the datasets they have a permission on.
+func (ctrl *metricsController) GetHistory(ctx *gin.Context) {
+	requester := context.GetRequesterFromContext(ctx)
+	if requester == nil {
+		returnErrorResponse(ctx, "unauthorized request", http.StatusUnauthorized)
+		return
+	}
+
+	q := history.Query{To: time.Now()}
+	if value := ctx.Query("metrics"); value != "" {
+		q.Metrics = strings.Split(value, ",")
+	}
+	if value := ctx.Query("series"); value != "" {
+		q.Series = strings.Split(value, ",")
+	}
+	for name, target := range map[string]*time.Time{"from": &q.From, "to": &q.To} {
+		if value := ctx.Query(name); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				returnErrorResponse(ctx, name+" must be an RFC 3339 timestamp", http.StatusBadRequest)
+				return
+			}
+			*target = t
+		}
+	}
+	if q.From.IsZero() {
+		q.From = q.To.Add(-defaultHistoryRange)
+	}
+	if value := ctx.Query("step"); value != "" {
+		step, err := time.ParseDuration(value)
+		if err != nil || step <= 0 {
+			returnErrorResponse(ctx, "step must be a duration like 5m", http.StatusBadRequest)
+			return
+		}
+		q.Step = step
+	} else {
+		q.Step = (q.To.Sub(q.From) / defaultHistoryPoints).Round(time.Second)
+	}
+
+	if !isAdmin(requester) {
+		for _, metric := range q.Metrics {
+			if !strings.HasPrefix(metric, "system.") && !strings.HasPrefix(metric, "dataset.") {
+				returnErrorResponse(ctx, "you are not allowed to view metric '"+metric+"'", http.StatusForbidden)
+				return
+			}
+		}
+		permissionList, err := db.GetList[model.NfsSharePermission](db.GetDb(), map[string]interface{}{"user_id": requester.ID}, "NfsShare")
+		if err != nil {
+			log.Logger.Errorw("Failed to load nfs share permissions", "err", err)
+			returnErrorResponse(ctx, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		allowed := []string{}
+		for _, p := range permissionList {
+			if q.Series == nil || util.Contains(q.Series, p.NfsShare.Dataset) {
+				allowed = append(allowed, p.NfsShare.Dataset)
+			}
+		}
+		q.Series = allowed
+	}
+
+	result, err := q.Run()
+	if err != nil {
+		if errors.Is(err, history.ErrDisabled) {
+			returnErrorResponse(ctx, err.Error(), http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, history.ErrInvalidQuery) {
+			returnErrorResponse(ctx, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Logger.Errorw("Failed to query metrics history", "err", err)
+		returnErrorResponse(ctx, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"data":   result,
+	})
+}
+
+// Prometheus serves host, ZFS and HTTP metrics in the Prometheus text
+// format. It does not use the API authentication: scrapers are admitted by
+// their address and the configured bearer token.
+func (ctrl *metricsController) Prometheus(ctx *gin.Context) {
+	cfg := config.Get().Metrics
+	if !cfg.Enabled {
+		ctx.Status(http.StatusNotFound)
+		return
+	}
+
+	// the connecting address, X-Forwarded-For could be forged
+	if len(cfg.AllowedIPs) > 0 && !addressAllowed(ctx.RemoteIP(), cfg.AllowedIPs) {
+		ctx.String(http.StatusForbidden, "forbidden\n")
+		return
+	}
+	if cfg.Token != "" {
+		token := strings.TrimPrefix(ctx.GetHeader("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(cfg.Token)) != 1 {
+			ctx.Header("WWW-Authenticate", "Bearer")
+			ctx.String(http.StatusUnauthorized, "unauthorized\n")
+			return
+		}
+	}
+
+	ctx.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	ctx.Status(http.StatusOK)
+	if err := metrics.WritePrometheus(ctx.Writer); err != nil {
+		log.Logger.Warnw("Failed to write metrics", "err", err)
+	}
+}
+
+// addressAllowed matches an address against addresses and CIDR ranges
+func addressAllowed(address string, allowed []string) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+	for _, entry := range allowed {
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			if network.Contains(ip) {
+				return true
+			}
+		} else if allowedIP := net.ParseIP(entry); allowedIP != nil && allowedIP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
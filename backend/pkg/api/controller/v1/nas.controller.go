@@ -1,16 +1,23 @@
 package v1
 
 import (
+	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
+	"github.com/whyxn/easynas/backend/pkg/config"
 	"github.com/whyxn/easynas/backend/pkg/context"
 	"github.com/whyxn/easynas/backend/pkg/db"
 	"github.com/whyxn/easynas/backend/pkg/db/model"
 	"github.com/whyxn/easynas/backend/pkg/dto"
 	"github.com/whyxn/easynas/backend/pkg/enum"
+	"github.com/whyxn/easynas/backend/pkg/events"
+	"github.com/whyxn/easynas/backend/pkg/jobs"
 	"github.com/whyxn/easynas/backend/pkg/log"
 	"github.com/whyxn/easynas/backend/pkg/nas"
 	"github.com/whyxn/easynas/backend/pkg/util"
+	"io"
+	"mime"
+	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -18,14 +25,11 @@ import (
 	"time"
 )
 
-const (
-	DefaultPool     string = "naspool"
-	DefaultClientIP string = "10.0.0.1"
-)
-
 type NasControllerInterface interface {
 	GetPool(c *gin.Context)
 	GetPoolList(c *gin.Context)
+	GetPoolSettings(c *gin.Context)
+	UpdatePoolSettings(c *gin.Context)
 	GetDataset(c *gin.Context)
 	GetDatasetList(c *gin.Context)
 	CreateDataset(c *gin.Context)
@@ -60,23 +64,29 @@ func (ctrl *nasController) GetPool(ctx *gin.Context) {
 		return
 	}
 
+	pool, ok := requestPool(ctx)
+	if !ok {
+		return
+	}
+
 	zpools, err := nas.ListZPools()
 	if err != nil {
 		log.Logger.Errorw("Failed to fetch zpool list", "err", err)
 		returnErrorResponse(ctx, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
-	if len(zpools) > 0 {
-		ctx.JSON(http.StatusOK, gin.H{
-			"status": "success",
-			"data":   zpools[0],
-		})
+	for _, zpool := range zpools {
+		if zpool.Name == pool {
+			ctx.JSON(http.StatusOK, gin.H{
+				"status": "success",
+				"data":   zpool,
+			})
+			return
+		}
 	}
 
-	ctx.JSON(http.StatusOK, gin.H{
-		"status": "success",
-		"data":   nil,
-	})
+	returnErrorResponse(ctx, "pool not found", http.StatusNotFound)
 }
 
 // GetPoolList
@@ -91,18 +101,129 @@ func (ctrl *nasController) GetPoolList(ctx *gin.Context) {
 	if err != nil {
 		log.Logger.Errorw("Failed to fetch zpool list", "err", err)
 		returnErrorResponse(ctx, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Only pools managed by easynas are listed
+	managedPools := []nas.ZPool{}
+	for _, zpool := range zpools {
+		if util.Contains(config.Get().Nas.Pools, zpool.Name) {
+			managedPools = append(managedPools, zpool)
+		}
 	}
 
 	ctx.JSON(http.StatusOK, gin.H{
 		"status": "success",
-		"data":   zpools,
+		"data":   managedPools,
 	})
 }
 
-func findDataset(dsName string) (*nas.ZFSDataset, error) {
-	nfsShare, _ := db.Get[model.NfsShare](db.GetDb(), map[string]interface{}{"name": dsName})
+// GetPoolSettings returns the defaults applied to new datasets of the pool
+func (ctrl *nasController) GetPoolSettings(ctx *gin.Context) {
+	requester := context.GetRequesterFromContext(ctx)
+	if requester == nil {
+		returnErrorResponse(ctx, "unauthorized request", http.StatusUnauthorized)
+		return
+	}
+
+	pool, ok := requestPool(ctx)
+	if !ok {
+		return
+	}
+
+	poolSettings, _ := db.Get[model.PoolSettings](db.GetDb(), map[string]interface{}{"pool": pool})
+	if poolSettings == nil {
+		poolSettings = &model.PoolSettings{Pool: pool}
+	}
 
-	datasets, err := nas.ListZFSDatasets()
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"data":   poolSettings,
+	})
+}
+
+// UpdatePoolSettings replaces the defaults applied to new datasets of the pool
+func (ctrl *nasController) UpdatePoolSettings(ctx *gin.Context) {
+	requester := context.GetRequesterFromContext(ctx)
+	if requester == nil {
+		returnErrorResponse(ctx, "unauthorized request", http.StatusUnauthorized)
+		return
+	} else if !isAdmin(requester) {
+		returnErrorResponse(ctx, "permission denied", http.StatusUnauthorized)
+		return
+	}
+
+	pool, ok := requestPool(ctx)
+	if !ok {
+		return
+	}
+
+	var input dto.PoolSettingsInputDTO
+
+	err := ctx.BindJSON(&input)
+	if err != nil {
+		log.Logger.Errorw("Failed to bind JSON", "err", err)
+		returnErrorResponse(ctx, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if input.DefaultQuota != "" && !nas.ValidQuota(input.DefaultQuota) {
+		returnErrorResponse(ctx, "invalid default quota", http.StatusBadRequest)
+		return
+	}
+	if input.Compression != "" && !nas.ValidCompression(input.Compression) {
+		returnErrorResponse(ctx, "invalid compression", http.StatusBadRequest)
+		return
+	}
+	if input.RecordSize != "" && !nas.ValidRecordSize(input.RecordSize) {
+		returnErrorResponse(ctx, "invalid record size", http.StatusBadRequest)
+		return
+	}
+
+	poolSettings := &model.PoolSettings{
+		Pool:         pool,
+		DefaultQuota: input.DefaultQuota,
+		Compression:  input.Compression,
+		RecordSize:   input.RecordSize,
+	}
+	if err = db.GetDb().Client().Save(poolSettings).Error; err != nil {
+		log.Logger.Errorw("Failed to save pool settings", "err", err)
+		returnErrorResponse(ctx, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"data":   poolSettings,
+	})
+}
+
+// requestPool returns the pool named in the route, or the default pool for
+// routes without one. Writes an error response and returns false if the
+// pool is not managed by easynas.
+func requestPool(ctx *gin.Context) (string, bool) {
+	pool := ctx.Param("pool")
+	if pool == "" {
+		pool = config.Get().Nas.DefaultPool
+	}
+
+	if !util.Contains(config.Get().Nas.Pools, pool) {
+		returnErrorResponse(ctx, "pool is not managed by easynas", http.StatusNotFound)
+		return "", false
+	}
+	return pool, true
+}
+
+// findDataset returns the dataset of the pool with the given full name, or
+// nil if the pool has no such dataset.
+func findDataset(pool, dsName string) (*nas.ZFSDataset, error) {
+	if !strings.HasPrefix(dsName, pool+"/") {
+		return nil, nil
+	}
+
+	nfsShare, _ := db.Get[model.NfsShare](db.GetDb(), map[string]interface{}{"dataset": dsName})
+
+	datasets, err := nas.ListPoolDatasets(pool)
 	if err != nil {
 		log.Logger.Errorw("Failed to fetch zfs datasets list", "err", err)
 		return nil, err
@@ -121,6 +242,75 @@ func findDataset(dsName string) (*nas.ZFSDataset, error) {
 	return dataset, nil
 }
 
+// defaultReadWriteClients returns the addresses granted read-write access to every NFS share
+func defaultReadWriteClients() []string {
+	if ip := config.Get().Nas.DefaultClientIP; ip != "" {
+		return []string{ip}
+	}
+	return nil
+}
+
+// applyNfsSharePermissions renders the NFS export of a share from the
+// permissions stored in the db. Disabled users and users without a NAS
+// client IP are left out of the export.
+func applyNfsSharePermissions(nfsShare *model.NfsShare) error {
+	if !nfsShare.ShareOn {
+		return nil
+	}
+
+	// Fetch all Nfs share permissions from db
+	permissionList, err := db.GetList[model.NfsSharePermission](db.GetDb(), map[string]interface{}{"nfs_share_id": nfsShare.ID}, "NfsShare", "User")
+	if err != nil {
+		log.Logger.Errorw("Failed to fetch nfs share permission list", "err", err)
+		return err
+	}
+
+	var rPermissions []string
+	var rwPermissions = defaultReadWriteClients()
+
+	for _, p := range permissionList {
+		if p.User.Disabled || p.User.NasClientIP == "" {
+			continue
+		}
+		if p.Permission == enum.ReadOnly {
+			rPermissions = append(rPermissions, p.User.NasClientIP)
+		} else if p.Permission == enum.ReadWrite {
+			rwPermissions = append(rwPermissions, p.User.NasClientIP)
+		}
+	}
+
+	if err = nas.CreateNFSShare(nfsShare.Dataset, rwPermissions, rPermissions); err != nil {
+		return err
+	}
+	publishShareChanged(nfsShare.Pool, nfsShare.Dataset, true)
+
+	// files written by clients meanwhile are owned by them
+	_, err = jobs.Enqueue(jobs.TypeSetOwnership, 0, nfsShare.Dataset, jobs.DatasetParams{Dataset: nfsShare.Dataset})
+	return err
+}
+
+// publishShareChanged tells event stream clients that the export or the
+// permissions of a share changed
+func publishShareChanged(pool, dataset string, shareOn bool) {
+	events.Publish(events.Event{Type: events.ShareChanged, Pool: pool, Dataset: dataset, Data: gin.H{"shareOn": shareOn}})
+}
+
+// applyUserNfsSharePermissions renders the NFS exports of every share the user has a permission on
+func applyUserNfsSharePermissions(userId uint) error {
+	permissionList, err := db.GetList[model.NfsSharePermission](db.GetDb(), map[string]interface{}{"user_id": userId}, "NfsShare")
+	if err != nil {
+		return err
+	}
+
+	for _, p := range permissionList {
+		if err = applyNfsSharePermissions(&p.NfsShare); err != nil {
+			log.Logger.Errorw("Failed to re-create nfs share with update permissions", "dataset", p.NfsShare.Dataset, "err", err)
+			return err
+		}
+	}
+	return nil
+}
+
 // GetDataset
 func (ctrl *nasController) GetDataset(ctx *gin.Context) {
 	requester := context.GetRequesterFromContext(ctx)
@@ -129,6 +319,11 @@ func (ctrl *nasController) GetDataset(ctx *gin.Context) {
 		return
 	}
 
+	pool, ok := requestPool(ctx)
+	if !ok {
+		return
+	}
+
 	dsName := ctx.Param("dataset")
 	dsName = util.Base64Decode(dsName)
 	if dsName == "" {
@@ -136,7 +331,7 @@ func (ctrl *nasController) GetDataset(ctx *gin.Context) {
 		return
 	}
 
-	dataset, err := findDataset(dsName)
+	dataset, err := findDataset(pool, dsName)
 	if err != nil {
 		returnErrorResponse(ctx, err.Error(), http.StatusBadRequest)
 		return
@@ -166,7 +361,12 @@ func (ctrl *nasController) GetDatasetList(ctx *gin.Context) {
 		return
 	}
 
-	nfsShareList, err := db.GetList[model.NfsShare](db.GetDb(), map[string]interface{}{"pool": DefaultPool})
+	pool, ok := requestPool(ctx)
+	if !ok {
+		return
+	}
+
+	nfsShareList, err := db.GetList[model.NfsShare](db.GetDb(), map[string]interface{}{"pool": pool})
 	if err != nil {
 		log.Logger.Errorw("Failed to fetch nfs share list", "err", err)
 		returnErrorResponse(ctx, err.Error(), http.StatusBadRequest)
@@ -178,7 +378,7 @@ func (ctrl *nasController) GetDatasetList(ctx *gin.Context) {
 		nfsShareMap[nsl.Dataset] = nsl
 	}
 
-	datasets, err := nas.ListZFSDatasets()
+	datasets, err := nas.ListPoolDatasets(pool)
 	if err != nil {
 		log.Logger.Errorw("Failed to fetch zfs datasets list", "err", err)
 		returnErrorResponse(ctx, err.Error(), http.StatusInternalServerError)
@@ -187,7 +387,7 @@ func (ctrl *nasController) GetDatasetList(ctx *gin.Context) {
 
 	var filteredDatasets []nas.ZFSDataset
 	for _, ds := range datasets {
-		if strings.HasPrefix(ds.Name, fmt.Sprintf("%s/", DefaultPool)) {
+		if strings.HasPrefix(ds.Name, fmt.Sprintf("%s/", pool)) {
 			if share, exists := nfsShareMap[ds.Name]; exists {
 				ds.ShareEnabled = share.ShareOn
 			}
@@ -209,6 +409,11 @@ func (ctrl *nasController) GetDatasetFileSystem(ctx *gin.Context) {
 		return
 	}
 
+	pool, ok := requestPool(ctx)
+	if !ok {
+		return
+	}
+
 	dsName := ctx.Param("dataset")
 	dsName = util.Base64Decode(dsName)
 	if dsName == "" {
@@ -219,7 +424,7 @@ func (ctrl *nasController) GetDatasetFileSystem(ctx *gin.Context) {
 	path := ctx.Param("path")
 	path = util.Base64Decode(path)
 
-	dataset, err := findDataset(dsName)
+	dataset, err := findDataset(pool, dsName)
 	if err != nil {
 		returnErrorResponse(ctx, err.Error(), http.StatusBadRequest)
 		return
@@ -254,6 +459,11 @@ func (ctrl *nasController) CreateDataset(ctx *gin.Context) {
 		return
 	}
 
+	pool, ok := requestPool(ctx)
+	if !ok {
+		return
+	}
+
 	var input dto.CreateZfsDatasetInputDTO
 
 	err := ctx.BindJSON(&input)
@@ -263,11 +473,18 @@ func (ctrl *nasController) CreateDataset(ctx *gin.Context) {
 		return
 	}
 
-	if input.Pool == "" {
-		input.Pool = DefaultPool
+	if input.Pool != "" && input.Pool != pool {
+		returnErrorResponse(ctx, "pool in request body does not match the route", http.StatusBadRequest)
+		return
+	}
+
+	if input.DatasetName == "" || strings.ContainsAny(input.DatasetName, "@# ") {
+		returnErrorResponse(ctx, "invalid dataset name", http.StatusBadRequest)
+		return
 	}
 
-	dataset, err := findDataset(input.DatasetName)
+	dsName := fmt.Sprintf("%s/%s", pool, input.DatasetName)
+	dataset, err := findDataset(pool, dsName)
 	if err != nil {
 		returnErrorResponse(ctx, err.Error(), http.StatusBadRequest)
 		return
@@ -278,13 +495,30 @@ func (ctrl *nasController) CreateDataset(ctx *gin.Context) {
 		return
 	}
 
-	err = nas.CreateZFSVolume(fmt.Sprintf("%s/%s", input.Pool, input.DatasetName), input.Quota)
+	// New datasets get the pool's defaults unless the request overrides them
+	properties := map[string]string{"quota": input.Quota}
+	if poolSettings, _ := db.Get[model.PoolSettings](db.GetDb(), map[string]interface{}{"pool": pool}); poolSettings != nil {
+		if properties["quota"] == "" {
+			properties["quota"] = poolSettings.DefaultQuota
+		}
+		properties["compression"] = poolSettings.Compression
+		properties["recordsize"] = poolSettings.RecordSize
+	}
+
+	if properties["quota"] != "" && !nas.ValidQuota(properties["quota"]) {
+		returnErrorResponse(ctx, "invalid quota", http.StatusBadRequest)
+		return
+	}
+
+	err = nas.CreateZFSVolume(dsName, properties)
 	if err != nil {
 		log.Logger.Errorw("Failed create zfs dataset", "err", err)
 		returnErrorResponse(ctx, err.Error(), http.StatusBadRequest)
 		return
 	}
 
+	events.Publish(events.Event{Type: events.DatasetCreated, Pool: pool, Dataset: dsName, Data: properties})
+
 	ctx.JSON(http.StatusOK, gin.H{
 		"status": "success",
 	})
@@ -301,6 +535,11 @@ func (ctrl *nasController) DeleteDataset(ctx *gin.Context) {
 		return
 	}
 
+	pool, ok := requestPool(ctx)
+	if !ok {
+		return
+	}
+
 	dsName := ctx.Param("dataset")
 	dsName = util.Base64Decode(dsName)
 	if dsName == "" {
@@ -308,7 +547,7 @@ func (ctrl *nasController) DeleteDataset(ctx *gin.Context) {
 		return
 	}
 
-	dataset, err := findDataset(dsName)
+	dataset, err := findDataset(pool, dsName)
 	if err != nil {
 		returnErrorResponse(ctx, err.Error(), http.StatusBadRequest)
 		return
@@ -319,28 +558,15 @@ func (ctrl *nasController) DeleteDataset(ctx *gin.Context) {
 		return
 	}
 
-	err = nas.DeleteZFSVolume(dsName)
+	// destroying a large dataset takes long, the nfs share records are removed by the job
+	job, err := jobs.Enqueue(jobs.TypeDestroyDataset, requester.ID, dsName, jobs.DatasetParams{Dataset: dsName})
 	if err != nil {
-		log.Logger.Errorw("Failed delete zfs dataset", "err", err)
-		returnErrorResponse(ctx, err.Error(), http.StatusBadRequest)
+		log.Logger.Errorw("Failed to queue dataset destroy", "err", err)
+		returnErrorResponse(ctx, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	nfsShare, _ := db.Get[model.NfsShare](db.GetDb(), map[string]interface{}{"name": dsName})
-
-	if nfsShare != nil {
-		if err = db.GetDb().Delete(&model.NfsShare{}, map[string]interface{}{"dataset": dsName}); err != nil {
-			log.Logger.Warn("Failed delete nfs share record from db", "err", err)
-		}
-
-		if err = db.GetDb().Delete(&model.NfsSharePermission{}, map[string]interface{}{"nfsShareId": nfsShare.ID}); err != nil {
-			log.Logger.Warn("Failed delete nfs share permission records from db", "err", err)
-		}
-	}
-
-	ctx.JSON(http.StatusOK, gin.H{
-		"status": "success",
-	})
+	returnJobResponse(ctx, job)
 }
 
 // CreateNfsShare
@@ -354,12 +580,14 @@ func (ctrl *nasController) CreateNfsShare(ctx *gin.Context) {
 		return
 	}
 
+	pool, ok := requestPool(ctx)
+	if !ok {
+		return
+	}
+
 	var input dto.CreateNfsShareInputDTO
 
-	input.Pool = ctx.Param("pool")
-	if input.Pool == "" {
-		input.Pool = DefaultPool
-	}
+	input.Pool = pool
 
 	input.DatasetName = ctx.Param("dataset")
 	input.DatasetName = util.Base64Decode(input.DatasetName)
@@ -368,7 +596,7 @@ func (ctrl *nasController) CreateNfsShare(ctx *gin.Context) {
 		return
 	}
 
-	dataset, err := findDataset(input.DatasetName)
+	dataset, err := findDataset(pool, input.DatasetName)
 	if err != nil {
 		returnErrorResponse(ctx, err.Error(), http.StatusBadRequest)
 		return
@@ -379,13 +607,21 @@ func (ctrl *nasController) CreateNfsShare(ctx *gin.Context) {
 		return
 	}
 
-	err = nas.CreateNFSShare(input.DatasetName, []string{DefaultClientIP}, []string{})
+	err = nas.CreateNFSShare(input.DatasetName, defaultReadWriteClients(), []string{})
 	if err != nil {
 		log.Logger.Errorw("Failed to create nfs share", "err", err)
 		returnErrorResponse(ctx, err.Error(), http.StatusBadRequest)
 		return
 	}
 
+	// changing the ownership of every file takes long on large datasets
+	job, err := jobs.Enqueue(jobs.TypeSetOwnership, requester.ID, input.DatasetName, jobs.DatasetParams{Dataset: input.DatasetName})
+	if err != nil {
+		log.Logger.Errorw("Failed to queue ownership change", "err", err)
+		returnErrorResponse(ctx, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	nfsShare, _ := db.Get[model.NfsShare](db.GetDb(), map[string]interface{}{"dataset": input.DatasetName})
 	if nfsShare == nil {
 		nfsShare := model.NfsShare{
@@ -406,9 +642,9 @@ func (ctrl *nasController) CreateNfsShare(ctx *gin.Context) {
 		}
 	}
 
-	ctx.JSON(http.StatusOK, gin.H{
-		"status": "success",
-	})
+	publishShareChanged(pool, input.DatasetName, true)
+
+	returnJobResponse(ctx, job)
 }
 
 // DeleteNfsShare
@@ -422,12 +658,14 @@ func (ctrl *nasController) DeleteNfsShare(ctx *gin.Context) {
 		return
 	}
 
+	pool, ok := requestPool(ctx)
+	if !ok {
+		return
+	}
+
 	var input dto.DeleteNfsShareInputDTO
 
-	input.Pool = ctx.Param("pool")
-	if input.Pool == "" {
-		input.Pool = DefaultPool
-	}
+	input.Pool = pool
 
 	input.DatasetName = ctx.Param("dataset")
 	input.DatasetName = util.Base64Decode(input.DatasetName)
@@ -436,7 +674,7 @@ func (ctrl *nasController) DeleteNfsShare(ctx *gin.Context) {
 		return
 	}
 
-	dataset, err := findDataset(input.DatasetName)
+	dataset, err := findDataset(pool, input.DatasetName)
 	if err != nil {
 		returnErrorResponse(ctx, err.Error(), http.StatusBadRequest)
 		return
@@ -466,6 +704,8 @@ func (ctrl *nasController) DeleteNfsShare(ctx *gin.Context) {
 		return
 	}
 
+	publishShareChanged(pool, input.DatasetName, false)
+
 	ctx.JSON(http.StatusOK, gin.H{
 		"status": "success",
 	})
@@ -482,6 +722,11 @@ func (ctrl *nasController) AddUserPermissionToNfsShare(ctx *gin.Context) {
 		return
 	}
 
+	pool, ok := requestPool(ctx)
+	if !ok {
+		return
+	}
+
 	var input dto.AddUserPermissionToNfsShareInputDTO
 	err := ctx.BindJSON(&input)
 	if err != nil {
@@ -497,7 +742,7 @@ func (ctrl *nasController) AddUserPermissionToNfsShare(ctx *gin.Context) {
 		return
 	}
 
-	dataset, err := findDataset(input.DatasetName)
+	dataset, err := findDataset(pool, input.DatasetName)
 	if err != nil {
 		returnErrorResponse(ctx, err.Error(), http.StatusBadRequest)
 		return
@@ -526,27 +771,8 @@ func (ctrl *nasController) AddUserPermissionToNfsShare(ctx *gin.Context) {
 		return
 	}
 
-	// Fetch all Nfs share permissions from db
-	permissionList, err := db.GetList[model.NfsSharePermission](db.GetDb(), map[string]interface{}{"nfs_share_id": nfsShare.ID}, "NfsShare", "User")
-	if err != nil {
-		log.Logger.Errorw("Failed to fetch nfs share permission list", "err", err)
-		returnErrorResponse(ctx, err.Error(), http.StatusBadRequest)
-		return
-	}
-
-	var rPermissions []string
-	var rwPermissions = []string{DefaultClientIP}
-
-	for _, p := range permissionList {
-		if p.Permission == enum.ReadOnly {
-			rPermissions = append(rPermissions, p.User.NasClientIP)
-		} else if p.Permission == enum.ReadWrite {
-			rwPermissions = append(rwPermissions, p.User.NasClientIP)
-		}
-	}
-
 	// Recreate NFS Share with updated permission
-	err = nas.CreateNFSShare(input.DatasetName, rwPermissions, rPermissions)
+	err = applyNfsSharePermissions(nfsShare)
 	if err != nil {
 		log.Logger.Errorw("Failed to re-create nfs share with update permissions", "err", err)
 		returnErrorResponse(ctx, err.Error(), http.StatusBadRequest)
@@ -569,6 +795,11 @@ func (ctrl *nasController) RemoveUserPermissionFromNfsShare(ctx *gin.Context) {
 		return
 	}
 
+	pool, ok := requestPool(ctx)
+	if !ok {
+		return
+	}
+
 	permissionId := ctx.Param("id")
 
 	nfsSharePermission, _ := db.Get[model.NfsSharePermission](db.GetDb(), map[string]interface{}{"ID": permissionId}, "NfsShare", "User")
@@ -577,7 +808,7 @@ func (ctrl *nasController) RemoveUserPermissionFromNfsShare(ctx *gin.Context) {
 		return
 	}
 
-	dataset, err := findDataset(nfsSharePermission.NfsShare.Dataset)
+	dataset, err := findDataset(pool, nfsSharePermission.NfsShare.Dataset)
 	if err != nil {
 		returnErrorResponse(ctx, err.Error(), http.StatusBadRequest)
 		return
@@ -594,27 +825,8 @@ func (ctrl *nasController) RemoveUserPermissionFromNfsShare(ctx *gin.Context) {
 		return
 	}
 
-	// Fetch all Nfs share permissions from db
-	permissionList, err := db.GetList[model.NfsSharePermission](db.GetDb(), map[string]interface{}{"nfs_share_id": nfsSharePermission.NfsShare.ID}, "NfsShare", "User")
-	if err != nil {
-		log.Logger.Errorw("Failed to fetch nfs share permission list", "err", err)
-		returnErrorResponse(ctx, err.Error(), http.StatusBadRequest)
-		return
-	}
-
-	var rPermissions []string
-	var rwPermissions = []string{DefaultClientIP}
-
-	for _, p := range permissionList {
-		if p.Permission == enum.ReadOnly {
-			rPermissions = append(rPermissions, p.User.NasClientIP)
-		} else if p.Permission == enum.ReadWrite {
-			rwPermissions = append(rwPermissions, p.User.NasClientIP)
-		}
-	}
-
 	// Recreate NFS Share with updated permission
-	err = nas.CreateNFSShare(nfsSharePermission.NfsShare.Dataset, rwPermissions, rPermissions)
+	err = applyNfsSharePermissions(&nfsSharePermission.NfsShare)
 	if err != nil {
 		log.Logger.Errorw("Failed to re-create nfs share with update permissions", "err", err)
 		returnErrorResponse(ctx, err.Error(), http.StatusBadRequest)
@@ -637,9 +849,9 @@ func (ctrl *nasController) GetNfsShareUserPermissions(ctx *gin.Context) {
 		return
 	}
 
-	pool := ctx.Param("pool")
-	if pool == "" {
-		pool = DefaultPool
+	pool, ok := requestPool(ctx)
+	if !ok {
+		return
 	}
 
 	datasetName := ctx.Param("dataset")
@@ -649,7 +861,7 @@ func (ctrl *nasController) GetNfsShareUserPermissions(ctx *gin.Context) {
 		return
 	}
 
-	dataset, err := findDataset(datasetName)
+	dataset, err := findDataset(pool, datasetName)
 	if err != nil {
 		returnErrorResponse(ctx, err.Error(), http.StatusBadRequest)
 		return
@@ -688,6 +900,11 @@ func (ctrl *nasController) UploadFileToDataset(ctx *gin.Context) {
 		return
 	}
 
+	pool, ok := requestPool(ctx)
+	if !ok {
+		return
+	}
+
 	datasetName := ctx.Param("dataset")
 	datasetName = util.Base64Decode(datasetName)
 	if datasetName == "" {
@@ -698,7 +915,7 @@ func (ctrl *nasController) UploadFileToDataset(ctx *gin.Context) {
 	relativePath := ctx.Param("path")
 	relativePath = util.Base64Decode(relativePath)
 
-	dataset, err := findDataset(datasetName)
+	dataset, err := findDataset(pool, datasetName)
 	if err != nil {
 		returnErrorResponse(ctx, err.Error(), http.StatusBadRequest)
 		return
@@ -709,48 +926,34 @@ func (ctrl *nasController) UploadFileToDataset(ctx *gin.Context) {
 		return
 	}
 
-	if !isAdmin(requester) {
-		nfsShare, _ := db.Get[model.NfsShare](db.GetDb(), map[string]interface{}{"dataset": datasetName})
-		if nfsShare == nil {
-			returnErrorResponse(ctx, "nfs share not found", http.StatusBadRequest)
-			return
-		}
-
-		// Fetch User's Nfs share permission
-		userPermission, _ := db.Get[model.NfsSharePermission](db.GetDb(), map[string]interface{}{"nfs_share_id": nfsShare.ID, "user_id": requester.ID}, "NfsShare", "User")
-		if userPermission == nil {
-			log.Logger.Errorw("user don't have any permission on this dataset", "err", err)
-			returnErrorResponse(ctx, "you don't have any read/write permission on this dataset", http.StatusBadRequest)
-			return
-		}
-
-		if userPermission.Permission != enum.ReadWrite {
-			returnErrorResponse(ctx, "you don't have any write permission on this dataset", http.StatusBadRequest)
-			return
-		}
+	if !checkSharePermission(ctx, requester, datasetName, true) {
+		return
 	}
 
-	// Get the file from the request
-	file, err := ctx.FormFile("file")
+	// Get the file from the request. It is streamed into the dataset instead
+	// of being buffered in the temp dir and copied, which doubled the time of
+	// large uploads.
+	part, err := uploadedFilePart(ctx, "file")
 	if err != nil {
 		returnErrorResponse(ctx, "file not found in the request", http.StatusBadRequest)
 		return
 	}
+	defer part.Close()
 
 	// Specify the directory to save the uploaded file
 	uploadDir := fmt.Sprintf("/%s/%s", datasetName, relativePath)
 
 	// Create the directory if it doesn't exist
 	if err = os.MkdirAll(uploadDir, os.ModePerm); err != nil {
-		log.Logger.Errorw("failed to create upload directory", err, err.Error())
+		log.Logger.Errorw("failed to create upload directory", "err", err.Error())
 		returnErrorResponse(ctx, "Failed to create upload directory", http.StatusBadRequest)
 		return
 	}
 
 	// Save the file to the specified directory
-	filePath := filepath.Join(uploadDir, file.Filename)
-	if err = ctx.SaveUploadedFile(file, filePath); err != nil {
-		log.Logger.Errorw("failed to save file", err, err.Error())
+	filePath := filepath.Join(uploadDir, part.FileName())
+	if err = saveUpload(part, filePath); err != nil {
+		log.Logger.Errorw("failed to save file", "err", err.Error())
 		returnErrorResponse(ctx, "Failed to save file", http.StatusBadRequest)
 		return
 	}
@@ -761,14 +964,89 @@ func (ctrl *nasController) UploadFileToDataset(ctx *gin.Context) {
 	})
 }
 
-// DeleteFileFromDataset
-func (ctrl *nasController) DeleteFileFromDataset(ctx *gin.Context) {
+// checkSharePermission checks that the requester may read, or write, the
+// files of a dataset through its nfs share and writes the error response if
+// not. Admins may access every dataset.
+func checkSharePermission(ctx *gin.Context, requester *model.User, datasetName string, write bool) bool {
+	if isAdmin(requester) {
+		return true
+	}
+
+	nfsShare, _ := db.Get[model.NfsShare](db.GetDb(), map[string]interface{}{"dataset": datasetName})
+	if nfsShare == nil {
+		returnErrorResponse(ctx, "nfs share not found", http.StatusBadRequest)
+		return false
+	}
+
+	// Fetch User's Nfs share permission
+	userPermission, _ := db.Get[model.NfsSharePermission](db.GetDb(), map[string]interface{}{"nfs_share_id": nfsShare.ID, "user_id": requester.ID}, "NfsShare", "User")
+	if userPermission == nil {
+		log.Logger.Errorw("user don't have any permission on this dataset", "dataset", datasetName, "user", requester.ID)
+		returnErrorResponse(ctx, "you don't have any read/write permission on this dataset", http.StatusBadRequest)
+		return false
+	}
+
+	if write && userPermission.Permission != enum.ReadWrite {
+		returnErrorResponse(ctx, "you don't have any write permission on this dataset", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// uploadedFilePart returns the multipart part holding the named file
+func uploadedFilePart(ctx *gin.Context, name string) (*multipart.Part, error) {
+	reader, err := ctx.Request.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			return nil, err
+		}
+		if part.FormName() == name && part.FileName() != "" {
+			return part, nil
+		}
+		part.Close()
+	}
+}
+
+// saveUpload writes the upload next to its destination first, so an
+// interrupted upload never leaves a truncated file behind
+func saveUpload(src io.Reader, filePath string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(filePath), "."+filepath.Base(filePath)+".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err = tmp.Chmod(0644); err == nil {
+		_, err = io.Copy(tmp, src)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filePath)
+}
+
+// DownloadFileFromDataset streams a file. Range requests resume downloads
+// and seek in media, conditional requests revalidate cached copies. The
+// file is sent inline instead of as an attachment with ?inline=true.
+func (ctrl *nasController) DownloadFileFromDataset(ctx *gin.Context) {
 	requester := context.GetRequesterFromContext(ctx)
 	if requester == nil {
 		returnErrorResponse(ctx, "unauthorized request", http.StatusUnauthorized)
 		return
 	}
 
+	pool, ok := requestPool(ctx)
+	if !ok {
+		return
+	}
+
 	datasetName := ctx.Param("dataset")
 	datasetName = util.Base64Decode(datasetName)
 	if datasetName == "" {
@@ -779,36 +1057,221 @@ func (ctrl *nasController) DeleteFileFromDataset(ctx *gin.Context) {
 	relativePath := ctx.Param("path")
 	relativePath = util.Base64Decode(relativePath)
 
-	dataset, err := findDataset(datasetName)
+	dataset, err := findDataset(pool, datasetName)
 	if err != nil {
 		returnErrorResponse(ctx, err.Error(), http.StatusBadRequest)
 		return
 	}
 
 	if dataset == nil {
-		returnErrorResponse(ctx, "dataset not found", http.StatusBadRequest)
+		returnErrorResponse(ctx, "dataset not found", http.StatusNotFound)
+		return
+	}
+
+	if !checkSharePermission(ctx, requester, datasetName, false) {
+		return
+	}
+
+	filePath, err := nas.DatasetFilePath(datasetName, relativePath)
+	if errors.Is(err, nas.ErrOutsidePath) {
+		returnErrorResponse(ctx, "invalid path", http.StatusBadRequest)
+		return
+	}
+
+	var file *os.File
+	if err == nil {
+		file, err = os.Open(filePath)
+	}
+	if os.IsNotExist(err) {
+		returnErrorResponse(ctx, "file not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Logger.Errorw("failed to open file", "path", filePath, "err", err)
+		returnErrorResponse(ctx, "failed to open file", http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		log.Logger.Errorw("failed to stat file", "path", filePath, "err", err)
+		returnErrorResponse(ctx, "failed to open file", http.StatusInternalServerError)
+		return
+	}
+	if info.IsDir() {
+		returnErrorResponse(ctx, "path is a folder", http.StatusBadRequest)
+		return
+	}
+
+	disposition := "attachment"
+	if ctx.Query("inline") == "true" {
+		disposition = "inline"
+	}
+	ctx.Header("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": info.Name()}))
+	ctx.Header("ETag", nas.FileETag(info))
+	// clients revalidate with the ETag, the file may change any time
+	ctx.Header("Cache-Control", "private, no-cache")
+	// files shown inline must not run scripts in the origin of easynas
+	ctx.Header("Content-Security-Policy", "sandbox")
+	ctx.Header("X-Content-Type-Options", "nosniff")
+
+	// ServeContent detects the content type and answers Range, If-Range,
+	// If-None-Match and If-Modified-Since
+	http.ServeContent(ctx.Writer, ctx.Request, info.Name(), info.ModTime(), file)
+}
+
+// DownloadArchive streams files and folders of a dataset as an archive,
+// generated while it is sent. ?path, base64 encoded and repeatable, selects
+// what to archive, the whole dataset by default. ?format is zip, the
+// default, or tar.gz and ?snapshot reads the files from a snapshot of the
+// dataset instead.
+func (ctrl *nasController) DownloadArchive(ctx *gin.Context) {
+	requester := context.GetRequesterFromContext(ctx)
+	if requester == nil {
+		returnErrorResponse(ctx, "unauthorized request", http.StatusUnauthorized)
 		return
 	}
 
-	if !isAdmin(requester) {
-		nfsShare, _ := db.Get[model.NfsShare](db.GetDb(), map[string]interface{}{"dataset": datasetName})
-		if nfsShare == nil {
-			returnErrorResponse(ctx, "nfs share not found", http.StatusBadRequest)
+	pool, ok := requestPool(ctx)
+	if !ok {
+		return
+	}
+
+	datasetName := ctx.Param("dataset")
+	datasetName = util.Base64Decode(datasetName)
+	if datasetName == "" {
+		returnErrorResponse(ctx, "invalid dataset", http.StatusBadRequest)
+		return
+	}
+
+	format := ctx.DefaultQuery("format", nas.ArchiveZip)
+	contentType, ok := archiveContentTypes[format]
+	if !ok {
+		returnErrorResponse(ctx, "format must be zip or tar.gz", http.StatusBadRequest)
+		return
+	}
+
+	relativePaths := []string{}
+	for _, encoded := range ctx.QueryArray("path") {
+		relativePath := util.Base64Decode(encoded)
+		if relativePath == "" {
+			returnErrorResponse(ctx, "invalid path", http.StatusBadRequest)
 			return
 		}
+		relativePaths = append(relativePaths, relativePath)
+	}
+	if len(relativePaths) == 0 {
+		relativePaths = append(relativePaths, "/")
+	}
+
+	dataset, err := findDataset(pool, datasetName)
+	if err != nil {
+		returnErrorResponse(ctx, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if dataset == nil {
+		returnErrorResponse(ctx, "dataset not found", http.StatusNotFound)
+		return
+	}
 
-		// Fetch User's Nfs share permission
-		userPermission, _ := db.Get[model.NfsSharePermission](db.GetDb(), map[string]interface{}{"nfs_share_id": nfsShare.ID, "user_id": requester.ID}, "NfsShare", "User")
-		if userPermission == nil {
-			log.Logger.Errorw("user don't have any permission on this dataset", "err", err)
-			returnErrorResponse(ctx, "you don't have any read/write permission on this dataset", http.StatusBadRequest)
+	if !checkSharePermission(ctx, requester, datasetName, false) {
+		return
+	}
+
+	source := nas.ArchiveSource{Dataset: datasetName}
+	if snapshotName := ctx.Query("snapshot"); snapshotName != "" {
+		// the snapshot is named like in the snapshot list, dataset@name
+		if !strings.HasPrefix(snapshotName, datasetName+"@") {
+			returnErrorResponse(ctx, "snapshot does not belong to the dataset", http.StatusBadRequest)
 			return
 		}
-
-		if userPermission.Permission != enum.ReadWrite {
-			returnErrorResponse(ctx, "you don't have any write permission on this dataset", http.StatusBadRequest)
+		snapshots, err := nas.ListSnapshots(datasetName)
+		if err != nil {
+			log.Logger.Errorw("Failed to fetch snapshot list", "err", err)
+			returnErrorResponse(ctx, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		found := false
+		for _, snapshot := range snapshots {
+			found = found || snapshot.Name == snapshotName
+		}
+		if !found {
+			returnErrorResponse(ctx, "snapshot not found", http.StatusNotFound)
 			return
 		}
+		source.Snapshot = strings.TrimPrefix(snapshotName, datasetName+"@")
+	}
+
+	entries, err := source.Resolve(relativePaths)
+	if errors.Is(err, nas.ErrOutsidePath) {
+		returnErrorResponse(ctx, "invalid path", http.StatusBadRequest)
+		return
+	} else if os.IsNotExist(err) {
+		returnErrorResponse(ctx, "file not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Logger.Errorw("failed to resolve archive paths", "dataset", datasetName, "err", err)
+		returnErrorResponse(ctx, "failed to read files", http.StatusInternalServerError)
+		return
+	}
+
+	filename := nas.ArchiveName(source, entries, format)
+	ctx.Header("Content-Type", contentType)
+	ctx.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	ctx.Header("Cache-Control", "no-store")
+	ctx.Header("X-Content-Type-Options", "nosniff")
+	ctx.Status(http.StatusOK)
+
+	if err = nas.WriteArchive(ctx.Writer, format, entries); err != nil {
+		log.Logger.Errorw("failed to stream archive", "dataset", datasetName, "err", err)
+		// the archive is partly sent, aborting the response tells the client
+		// it is incomplete
+		panic(http.ErrAbortHandler)
+	}
+}
+
+var archiveContentTypes = map[string]string{
+	nas.ArchiveZip:   "application/zip",
+	nas.ArchiveTarGz: "application/gzip",
+}
+
+// DeleteFileFromDataset
+func (ctrl *nasController) DeleteFileFromDataset(ctx *gin.Context) {
+	requester := context.GetRequesterFromContext(ctx)
+	if requester == nil {
+		returnErrorResponse(ctx, "unauthorized request", http.StatusUnauthorized)
+		return
+	}
+
+	pool, ok := requestPool(ctx)
+	if !ok {
+		return
+	}
+
+	datasetName := ctx.Param("dataset")
+	datasetName = util.Base64Decode(datasetName)
+	if datasetName == "" {
+		returnErrorResponse(ctx, "invalid dataset", http.StatusBadRequest)
+		return
+	}
+
+	relativePath := ctx.Param("path")
+	relativePath = util.Base64Decode(relativePath)
+
+	dataset, err := findDataset(pool, datasetName)
+	if err != nil {
+		returnErrorResponse(ctx, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if dataset == nil {
+		returnErrorResponse(ctx, "dataset not found", http.StatusBadRequest)
+		return
+	}
+
+	if !checkSharePermission(ctx, requester, datasetName, true) {
+		return
 	}
 
 	// Construct the full file path (relative to the base directory)
@@ -845,6 +1308,11 @@ func (ctrl *nasController) CreateSnapshot(ctx *gin.Context) {
 		return
 	}
 
+	pool, ok := requestPool(ctx)
+	if !ok {
+		return
+	}
+
 	datasetName := ctx.Param("dataset")
 	datasetName = util.Base64Decode(datasetName)
 	if datasetName == "" {
@@ -852,7 +1320,7 @@ func (ctrl *nasController) CreateSnapshot(ctx *gin.Context) {
 		return
 	}
 
-	dataset, err := findDataset(datasetName)
+	dataset, err := findDataset(pool, datasetName)
 	if err != nil {
 		returnErrorResponse(ctx, err.Error(), http.StatusBadRequest)
 		return
@@ -863,13 +1331,21 @@ func (ctrl *nasController) CreateSnapshot(ctx *gin.Context) {
 		return
 	}
 
-	err = nas.CreateSnapshot(datasetName, fmt.Sprintf("snap-%d", time.Now().UTC().Unix()))
+	snapshotName := fmt.Sprintf("snap-%d", time.Now().UTC().Unix())
+	err = nas.CreateSnapshot(datasetName, snapshotName)
 	if err != nil {
 		log.Logger.Errorw("Failed create snapshot", "err", err)
 		returnErrorResponse(ctx, err.Error(), http.StatusBadRequest)
 		return
 	}
 
+	events.Publish(events.Event{
+		Type:    events.SnapshotCreated,
+		Pool:    pool,
+		Dataset: datasetName,
+		Data:    gin.H{"snapshot": datasetName + "@" + snapshotName},
+	})
+
 	ctx.JSON(http.StatusOK, gin.H{
 		"status": "success",
 	})
@@ -883,6 +1359,11 @@ func (ctrl *nasController) GetSnapshotList(ctx *gin.Context) {
 		return
 	}
 
+	pool, ok := requestPool(ctx)
+	if !ok {
+		return
+	}
+
 	datasetName := ctx.Param("dataset")
 	datasetName = util.Base64Decode(datasetName)
 	if datasetName == "" {
@@ -890,7 +1371,7 @@ func (ctrl *nasController) GetSnapshotList(ctx *gin.Context) {
 		return
 	}
 
-	dataset, err := findDataset(datasetName)
+	dataset, err := findDataset(pool, datasetName)
 	if err != nil {
 		returnErrorResponse(ctx, err.Error(), http.StatusBadRequest)
 		return
@@ -925,6 +1406,11 @@ func (ctrl *nasController) RestoreFromSnapshot(ctx *gin.Context) {
 		return
 	}
 
+	pool, ok := requestPool(ctx)
+	if !ok {
+		return
+	}
+
 	datasetName := ctx.Param("dataset")
 	datasetName = util.Base64Decode(datasetName)
 	if datasetName == "" {
@@ -932,7 +1418,7 @@ func (ctrl *nasController) RestoreFromSnapshot(ctx *gin.Context) {
 		return
 	}
 
-	dataset, err := findDataset(datasetName)
+	dataset, err := findDataset(pool, datasetName)
 	if err != nil {
 		returnErrorResponse(ctx, err.Error(), http.StatusBadRequest)
 		return
@@ -952,21 +1438,19 @@ func (ctrl *nasController) RestoreFromSnapshot(ctx *gin.Context) {
 		return
 	}
 
-	if input.SnapshotName == "" {
+	if input.SnapshotName == "" || !strings.HasPrefix(input.SnapshotName, datasetName+"@") {
 		returnErrorResponse(ctx, "invalid snapshot name", http.StatusBadRequest)
 		return
 	}
 
-	err = nas.RestoreFromSnapshot(input.SnapshotName)
+	job, err := jobs.Enqueue(jobs.TypeRollbackSnapshot, requester.ID, datasetName, jobs.SnapshotParams{Dataset: datasetName, Snapshot: input.SnapshotName})
 	if err != nil {
-		log.Logger.Errorw("Failed to restore from snapshot", "err", err)
-		returnErrorResponse(ctx, err.Error(), http.StatusBadRequest)
+		log.Logger.Errorw("Failed to queue snapshot rollback", "err", err)
+		returnErrorResponse(ctx, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	ctx.JSON(http.StatusOK, gin.H{
-		"status": "success",
-	})
+	returnJobResponse(ctx, job)
 }
 
 // DeleteSnapshot
@@ -980,6 +1464,11 @@ func (ctrl *nasController) DeleteSnapshot(ctx *gin.Context) {
 		return
 	}
 
+	pool, ok := requestPool(ctx)
+	if !ok {
+		return
+	}
+
 	datasetName := ctx.Param("dataset")
 	datasetName = util.Base64Decode(datasetName)
 	if datasetName == "" {
@@ -989,12 +1478,12 @@ func (ctrl *nasController) DeleteSnapshot(ctx *gin.Context) {
 
 	snapshotName := ctx.Param("snapshotName")
 	snapshotName = util.Base64Decode(snapshotName)
-	if snapshotName == "" {
+	if snapshotName == "" || !strings.HasPrefix(snapshotName, datasetName+"@") {
 		returnErrorResponse(ctx, "invalid snapshot name", http.StatusBadRequest)
 		return
 	}
 
-	dataset, err := findDataset(datasetName)
+	dataset, err := findDataset(pool, datasetName)
 	if err != nil {
 		returnErrorResponse(ctx, err.Error(), http.StatusBadRequest)
 		return
@@ -1012,6 +1501,8 @@ func (ctrl *nasController) DeleteSnapshot(ctx *gin.Context) {
 		return
 	}
 
+	events.Publish(events.Event{Type: events.SnapshotDeleted, Pool: pool, Dataset: datasetName, Data: gin.H{"snapshot": snapshotName}})
+
 	ctx.JSON(http.StatusOK, gin.H{
 		"status": "success",
 	})
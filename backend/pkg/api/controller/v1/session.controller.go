@@ -0,0 +1,119 @@
+package v1
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/whyxn/easynas/backend/pkg/context"
+	"github.com/whyxn/easynas/backend/pkg/db"
+	"github.com/whyxn/easynas/backend/pkg/db/model"
+	"github.com/whyxn/easynas/backend/pkg/log"
+	"github.com/whyxn/easynas/backend/pkg/session"
+	"net/http"
+)
+
+type SessionControllerInterface interface {
+	GetList(c *gin.Context)
+	Revoke(c *gin.Context)
+	RevokeAllOfUser(c *gin.Context)
+}
+
+type sessionController struct{}
+
+var sc sessionController
+
+func SessionController() *sessionController {
+	return &sc
+}
+
+// GetList of the requester's active sessions
+func (ctrl *sessionController) GetList(ctx *gin.Context) {
+	requester := context.GetRequesterFromContext(ctx)
+	if requester == nil {
+		returnErrorResponse(ctx, "unauthorized request", http.StatusUnauthorized)
+		return
+	}
+
+	sessions, err := session.ListActive(requester.ID)
+	if err != nil {
+		log.Logger.Errorw("Failed to fetch session list", "err", err)
+		returnErrorResponse(ctx, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	current := context.GetSessionFromContext(ctx)
+
+	var data []gin.H
+	for _, s := range sessions {
+		data = append(data, gin.H{
+			"id":         s.ID,
+			"device":     s.Device,
+			"userAgent":  s.UserAgent,
+			"clientIP":   s.ClientIP,
+			"createdAt":  s.CreatedAt,
+			"lastUsedAt": s.LastUsedAt,
+			"expiresAt":  s.ExpiresAt,
+			"current":    current != nil && current.ID == s.ID,
+		})
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"data":   data,
+	})
+}
+
+// Revoke one of the requester's sessions
+func (ctrl *sessionController) Revoke(ctx *gin.Context) {
+	requester := context.GetRequesterFromContext(ctx)
+	if requester == nil {
+		returnErrorResponse(ctx, "unauthorized request", http.StatusUnauthorized)
+		return
+	}
+
+	id := ctx.Param("id")
+
+	s, _ := db.Get[model.Session](db.GetDb(), map[string]interface{}{"ID": id, "user_id": requester.ID})
+	if s == nil {
+		returnErrorResponse(ctx, "session not found", http.StatusNotFound)
+		return
+	}
+
+	if err := session.Revoke(s.ID); err != nil {
+		log.Logger.Errorw("Failed to revoke session", "err", err)
+		returnErrorResponse(ctx, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+	})
+}
+
+// RevokeAllOfUser revokes every session of the given user
+func (ctrl *sessionController) RevokeAllOfUser(ctx *gin.Context) {
+	requester := context.GetRequesterFromContext(ctx)
+	if requester == nil {
+		returnErrorResponse(ctx, "unauthorized request", http.StatusUnauthorized)
+		return
+	} else if !isAdmin(requester) {
+		returnErrorResponse(ctx, "permission denied", http.StatusUnauthorized)
+		return
+	}
+
+	id := ctx.Param("id")
+
+	user, _ := db.Get[model.User](db.GetDb(), map[string]interface{}{"ID": id})
+	if user == nil {
+		returnErrorResponse(ctx, "user not found", http.StatusNotFound)
+		return
+	}
+
+	if err := session.RevokeAllForUser(user.ID, 0); err != nil {
+		log.Logger.Errorw("Failed to revoke user sessions", "err", err)
+		returnErrorResponse(ctx, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+	})
+}
@@ -0,0 +1,145 @@
+package v1
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/whyxn/easynas/backend/pkg/context"
+	"github.com/whyxn/easynas/backend/pkg/dto"
+	"github.com/whyxn/easynas/backend/pkg/log"
+	"github.com/whyxn/easynas/backend/pkg/loginguard"
+	"github.com/whyxn/easynas/backend/pkg/password"
+	"github.com/whyxn/easynas/backend/pkg/settings"
+	"net/http"
+	"time"
+)
+
+type SettingsControllerInterface interface {
+	GetSecuritySettings(c *gin.Context)
+	UpdateSecuritySettings(c *gin.Context)
+}
+
+type settingsController struct{}
+
+var stc settingsController
+
+func SettingsController() *settingsController {
+	return &stc
+}
+
+func securitySettings() gin.H {
+	policy := loginguard.CurrentPolicy()
+	passwordPolicy := password.CurrentPolicy()
+	return gin.H{
+		"requireTwoFactorForAdmins": settings.GetBool(settings.RequireTwoFactorForAdmins, false),
+		"loginFreeAttempts":         policy.FreeAttempts,
+		"loginBackoffBaseSeconds":   int(policy.BackoffBase / time.Second),
+		"loginBackoffMaxSeconds":    int(policy.BackoffMax / time.Second),
+		"loginMaxFailures":          policy.MaxFailures,
+		"loginMaxFailuresPerIP":     policy.MaxFailuresPerIP,
+		"loginLockoutMinutes":       int(policy.LockoutDuration / time.Minute),
+		"passwordMinLength":         passwordPolicy.MinLength,
+		"passwordRequireUpper":      passwordPolicy.RequireUpper,
+		"passwordRequireLower":      passwordPolicy.RequireLower,
+		"passwordRequireDigit":      passwordPolicy.RequireDigit,
+		"passwordRequireSymbol":     passwordPolicy.RequireSymbol,
+		"passwordHistoryCount":      passwordPolicy.HistoryCount,
+	}
+}
+
+// GetSecuritySettings
+func (ctrl *settingsController) GetSecuritySettings(ctx *gin.Context) {
+	requester := context.GetRequesterFromContext(ctx)
+	if requester == nil {
+		returnErrorResponse(ctx, "unauthorized request", http.StatusUnauthorized)
+		return
+	} else if !isAdmin(requester) {
+		returnErrorResponse(ctx, "permission denied", http.StatusUnauthorized)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"data":   securitySettings(),
+	})
+}
+
+// UpdateSecuritySettings updates the settings present in the request body
+func (ctrl *settingsController) UpdateSecuritySettings(ctx *gin.Context) {
+	requester := context.GetRequesterFromContext(ctx)
+	if requester == nil {
+		returnErrorResponse(ctx, "unauthorized request", http.StatusUnauthorized)
+		return
+	} else if !isAdmin(requester) {
+		returnErrorResponse(ctx, "permission denied", http.StatusUnauthorized)
+		return
+	}
+
+	var input dto.SecuritySettingsDTO
+
+	err := ctx.BindJSON(&input)
+	if err != nil {
+		log.Logger.Errorw("Failed to bind JSON", "err", err)
+		returnErrorResponse(ctx, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	intSettings := []struct {
+		key   string
+		value *int
+		min   int
+	}{
+		{settings.LoginFreeAttempts, input.LoginFreeAttempts, 0},
+		{settings.LoginBackoffBaseSeconds, input.LoginBackoffBaseSeconds, 1},
+		{settings.LoginBackoffMaxSeconds, input.LoginBackoffMaxSeconds, 1},
+		{settings.LoginMaxFailures, input.LoginMaxFailures, 1},
+		{settings.LoginMaxFailuresPerIP, input.LoginMaxFailuresPerIP, 1},
+		{settings.LoginLockoutMinutes, input.LoginLockoutMinutes, 1},
+		{settings.PasswordMinLength, input.PasswordMinLength, 1},
+		{settings.PasswordHistoryCount, input.PasswordHistoryCount, 0},
+	}
+
+	for _, setting := range intSettings {
+		if setting.value != nil && *setting.value < setting.min {
+			returnErrorResponse(ctx, fmt.Sprintf("invalid value for %s", setting.key), http.StatusBadRequest)
+			return
+		}
+	}
+
+	for _, setting := range intSettings {
+		if setting.value == nil {
+			continue
+		}
+		if err = settings.SetInt(setting.key, *setting.value); err != nil {
+			log.Logger.Errorw("Failed to update setting", "err", err)
+			returnErrorResponse(ctx, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	boolSettings := []struct {
+		key   string
+		value *bool
+	}{
+		{settings.RequireTwoFactorForAdmins, input.RequireTwoFactorForAdmins},
+		{settings.PasswordRequireUpper, input.PasswordRequireUpper},
+		{settings.PasswordRequireLower, input.PasswordRequireLower},
+		{settings.PasswordRequireDigit, input.PasswordRequireDigit},
+		{settings.PasswordRequireSymbol, input.PasswordRequireSymbol},
+	}
+
+	for _, setting := range boolSettings {
+		if setting.value == nil {
+			continue
+		}
+		if err = settings.SetBool(setting.key, *setting.value); err != nil {
+			log.Logger.Errorw("Failed to update setting", "err", err)
+			returnErrorResponse(ctx, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"data":   securitySettings(),
+	})
+}
@@ -0,0 +1,154 @@
+package v1
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/whyxn/easynas/backend/pkg/apitoken"
+	"github.com/whyxn/easynas/backend/pkg/context"
+	"github.com/whyxn/easynas/backend/pkg/db"
+	"github.com/whyxn/easynas/backend/pkg/db/model"
+	"github.com/whyxn/easynas/backend/pkg/dto"
+	"github.com/whyxn/easynas/backend/pkg/log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type ApiTokenControllerInterface interface {
+	Create(c *gin.Context)
+	GetList(c *gin.Context)
+	Delete(c *gin.Context)
+}
+
+type apiTokenController struct{}
+
+var atc apiTokenController
+
+func ApiTokenController() *apiTokenController {
+	return &atc
+}
+
+func apiTokenResponse(token *model.ApiToken) gin.H {
+	return gin.H{
+		"id":         token.ID,
+		"name":       token.Name,
+		"prefix":     token.Prefix,
+		"scopes":     apitoken.Scopes(token),
+		"expiresAt":  token.ExpiresAt,
+		"lastUsedAt": token.LastUsedAt,
+		"createdAt":  token.CreatedAt,
+	}
+}
+
+// Create API Token
+func (ctrl *apiTokenController) Create(ctx *gin.Context) {
+	requester := context.GetRequesterFromContext(ctx)
+	if requester == nil {
+		returnErrorResponse(ctx, "unauthorized request", http.StatusUnauthorized)
+		return
+	}
+
+	var input dto.CreateApiTokenInputDTO
+
+	err := ctx.BindJSON(&input)
+	if err != nil {
+		log.Logger.Errorw("Failed to bind JSON", "err", err)
+		returnErrorResponse(ctx, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	input.Name = strings.TrimSpace(input.Name)
+	if input.Name == "" {
+		returnErrorResponse(ctx, "token name is required", http.StatusBadRequest)
+		return
+	}
+
+	if len(input.Scopes) == 0 {
+		returnErrorResponse(ctx, "at least one scope is required", http.StatusBadRequest)
+		return
+	}
+
+	scopes, err := apitoken.ParseScopes(input.Scopes)
+	if err != nil {
+		returnErrorResponse(ctx, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if input.ExpiresInDays < 0 {
+		returnErrorResponse(ctx, "invalid expiry", http.StatusBadRequest)
+		return
+	}
+
+	var expiresAt *time.Time
+	if input.ExpiresInDays > 0 {
+		t := time.Now().Add(time.Duration(input.ExpiresInDays) * 24 * time.Hour)
+		expiresAt = &t
+	}
+
+	token, plain, err := apitoken.Create(requester, input.Name, scopes, expiresAt)
+	if err != nil {
+		log.Logger.Errorw("Failed to create api token", "err", err)
+		returnErrorResponse(ctx, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// The plain token is only ever shown in this response
+	data := apiTokenResponse(token)
+	data["token"] = plain
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"data":   data,
+	})
+}
+
+// GetList of the requester's API Tokens
+func (ctrl *apiTokenController) GetList(ctx *gin.Context) {
+	requester := context.GetRequesterFromContext(ctx)
+	if requester == nil {
+		returnErrorResponse(ctx, "unauthorized request", http.StatusUnauthorized)
+		return
+	}
+
+	tokens, err := db.GetList[model.ApiToken](db.GetDb(), map[string]interface{}{"user_id": requester.ID})
+	if err != nil {
+		log.Logger.Errorw("Failed to fetch api token list", "err", err)
+		returnErrorResponse(ctx, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	data := []gin.H{}
+	for i := range tokens {
+		data = append(data, apiTokenResponse(&tokens[i]))
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"data":   data,
+	})
+}
+
+// Delete revokes one of the requester's API Tokens
+func (ctrl *apiTokenController) Delete(ctx *gin.Context) {
+	requester := context.GetRequesterFromContext(ctx)
+	if requester == nil {
+		returnErrorResponse(ctx, "unauthorized request", http.StatusUnauthorized)
+		return
+	}
+
+	id := ctx.Param("id")
+
+	token, _ := db.Get[model.ApiToken](db.GetDb(), map[string]interface{}{"ID": id, "user_id": requester.ID})
+	if token == nil {
+		returnErrorResponse(ctx, "api token not found", http.StatusNotFound)
+		return
+	}
+
+	if err := db.GetDb().Delete(&model.ApiToken{}, map[string]interface{}{"ID": token.ID}); err != nil {
+		returnErrorResponse(ctx, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+	})
+}
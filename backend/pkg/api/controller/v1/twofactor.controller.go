@@ -0,0 +1,289 @@
+package v1
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/whyxn/easynas/backend/pkg/context"
+	"github.com/whyxn/easynas/backend/pkg/db"
+	"github.com/whyxn/easynas/backend/pkg/db/model"
+	"github.com/whyxn/easynas/backend/pkg/dto"
+	"github.com/whyxn/easynas/backend/pkg/log"
+	"github.com/whyxn/easynas/backend/pkg/loginguard"
+	"github.com/whyxn/easynas/backend/pkg/totp"
+	"github.com/whyxn/easynas/backend/pkg/twofactor"
+	"github.com/whyxn/easynas/backend/pkg/util"
+	"net/http"
+)
+
+type TwoFactorControllerInterface interface {
+	GetStatus(c *gin.Context)
+	Enroll(c *gin.Context)
+	Confirm(c *gin.Context)
+	Disable(c *gin.Context)
+	RegenerateRecoveryCodes(c *gin.Context)
+	ResetForUser(c *gin.Context)
+}
+
+type twoFactorController struct{}
+
+var tfc twoFactorController
+
+func TwoFactorController() *twoFactorController {
+	return &tfc
+}
+
+// GetStatus of the requester's two-factor authentication
+func (ctrl *twoFactorController) GetStatus(ctx *gin.Context) {
+	requester := context.GetRequesterFromContext(ctx)
+	if requester == nil {
+		returnErrorResponse(ctx, "unauthorized request", http.StatusUnauthorized)
+		return
+	}
+
+	remaining, err := twofactor.RemainingRecoveryCodes(requester.ID)
+	if err != nil {
+		log.Logger.Errorw("Failed to count recovery codes", "err", err)
+		returnErrorResponse(ctx, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"data": gin.H{
+			"enabled":                requester.TotpEnabled,
+			"required":               twofactor.Required(requester),
+			"recoveryCodesRemaining": remaining,
+		},
+	})
+}
+
+// Enroll generates a new TOTP secret which becomes active once confirmed
+func (ctrl *twoFactorController) Enroll(ctx *gin.Context) {
+	requester := context.GetRequesterFromContext(ctx)
+	if requester == nil {
+		returnErrorResponse(ctx, "unauthorized request", http.StatusUnauthorized)
+		return
+	}
+
+	if requester.TotpEnabled {
+		returnErrorResponse(ctx, "two-factor authentication is already enabled", http.StatusBadRequest)
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		log.Logger.Errorw("Failed to generate TOTP secret", "err", err)
+		returnErrorResponse(ctx, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err = db.GetDb().Update(requester, map[string]interface{}{"totp_pending_secret": secret}); err != nil {
+		log.Logger.Errorw("Failed to store TOTP secret", "err", err)
+		returnErrorResponse(ctx, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"data": gin.H{
+			"secret": secret,
+			"uri":    totp.URI(twofactor.Issuer, requester.Email, secret),
+		},
+	})
+}
+
+// Confirm activates the pending TOTP secret and returns recovery codes
+func (ctrl *twoFactorController) Confirm(ctx *gin.Context) {
+	requester := context.GetRequesterFromContext(ctx)
+	if requester == nil {
+		returnErrorResponse(ctx, "unauthorized request", http.StatusUnauthorized)
+		return
+	}
+
+	var input dto.TwoFactorCodeInputDTO
+
+	err := ctx.BindJSON(&input)
+	if err != nil {
+		log.Logger.Errorw("Failed to bind JSON", "err", err)
+		returnErrorResponse(ctx, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if requester.TotpEnabled {
+		returnErrorResponse(ctx, "two-factor authentication is already enabled", http.StatusBadRequest)
+		return
+	}
+
+	if requester.TotpPendingSecret == "" {
+		returnErrorResponse(ctx, "two-factor authentication enrollment has not been started", http.StatusBadRequest)
+		return
+	}
+
+	if err = twofactor.VerifyPendingCode(requester, input.Code); err != nil {
+		returnErrorResponse(ctx, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	updates := map[string]interface{}{
+		"totp_enabled":        true,
+		"totp_secret":         requester.TotpPendingSecret,
+		"totp_pending_secret": "",
+	}
+	if err = db.GetDb().Update(requester, updates); err != nil {
+		log.Logger.Errorw("Failed to enable two-factor authentication", "err", err)
+		returnErrorResponse(ctx, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	codes, err := twofactor.GenerateRecoveryCodes(requester.ID)
+	if err != nil {
+		log.Logger.Errorw("Failed to generate recovery codes", "err", err)
+		returnErrorResponse(ctx, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"data": gin.H{
+			"recoveryCodes": codes,
+		},
+	})
+}
+
+// Disable two-factor authentication of the requester
+func (ctrl *twoFactorController) Disable(ctx *gin.Context) {
+	requester := context.GetRequesterFromContext(ctx)
+	if requester == nil {
+		returnErrorResponse(ctx, "unauthorized request", http.StatusUnauthorized)
+		return
+	}
+
+	var input dto.DisableTwoFactorInputDTO
+
+	err := ctx.BindJSON(&input)
+	if err != nil {
+		log.Logger.Errorw("Failed to bind JSON", "err", err)
+		returnErrorResponse(ctx, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !requester.TotpEnabled {
+		returnErrorResponse(ctx, "two-factor authentication is not enabled", http.StatusBadRequest)
+		return
+	}
+
+	if twofactor.Required(requester) {
+		returnErrorResponse(ctx, "two-factor authentication is required for your account", http.StatusForbidden)
+		return
+	}
+
+	// guesses count against the same lockout as the second factor of a login
+	ip, userAgent := ctx.ClientIP(), ctx.Request.UserAgent()
+	reservation, wait := loginguard.ReserveTwoFactor(requester.ID, requester.Email, ip)
+	if wait > 0 {
+		loginguard.RecordBlocked(requester.Email, ip, userAgent)
+		returnTooManyLoginAttempts(ctx, wait)
+		return
+	}
+
+	// users of a directory or an identity provider have no local password,
+	// for them the second factor alone confirms it
+	if requester.AuthSource == model.AuthSourceLocal && !util.CheckPasswordHash(input.Password, requester.Password) {
+		reservation.Fail(userAgent, "invalid password")
+		returnErrorResponse(ctx, "invalid password", http.StatusBadRequest)
+		return
+	}
+
+	if input.RecoveryCode != "" {
+		err = twofactor.UseRecoveryCode(requester, input.RecoveryCode)
+	} else {
+		err = twofactor.VerifyCode(requester, input.Code)
+	}
+	if err != nil {
+		reservation.Fail(userAgent, "invalid second factor")
+		returnErrorResponse(ctx, err.Error(), http.StatusBadRequest)
+		return
+	}
+	reservation.Succeed(userAgent)
+
+	if err = twofactor.Disable(requester); err != nil {
+		log.Logger.Errorw("Failed to disable two-factor authentication", "err", err)
+		returnErrorResponse(ctx, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+	})
+}
+
+// RegenerateRecoveryCodes replaces the requester's recovery codes
+func (ctrl *twoFactorController) RegenerateRecoveryCodes(ctx *gin.Context) {
+	requester := context.GetRequesterFromContext(ctx)
+	if requester == nil {
+		returnErrorResponse(ctx, "unauthorized request", http.StatusUnauthorized)
+		return
+	}
+
+	var input dto.TwoFactorCodeInputDTO
+
+	err := ctx.BindJSON(&input)
+	if err != nil {
+		log.Logger.Errorw("Failed to bind JSON", "err", err)
+		returnErrorResponse(ctx, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !requester.TotpEnabled {
+		returnErrorResponse(ctx, "two-factor authentication is not enabled", http.StatusBadRequest)
+		return
+	}
+
+	if err = twofactor.VerifyCode(requester, input.Code); err != nil {
+		returnErrorResponse(ctx, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	codes, err := twofactor.GenerateRecoveryCodes(requester.ID)
+	if err != nil {
+		log.Logger.Errorw("Failed to generate recovery codes", "err", err)
+		returnErrorResponse(ctx, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"data": gin.H{
+			"recoveryCodes": codes,
+		},
+	})
+}
+
+// ResetForUser turns off two-factor authentication of a user who lost their device
+func (ctrl *twoFactorController) ResetForUser(ctx *gin.Context) {
+	requester := context.GetRequesterFromContext(ctx)
+	if requester == nil {
+		returnErrorResponse(ctx, "unauthorized request", http.StatusUnauthorized)
+		return
+	} else if !isAdmin(requester) {
+		returnErrorResponse(ctx, "permission denied", http.StatusUnauthorized)
+		return
+	}
+
+	id := ctx.Param("id")
+
+	user, _ := db.Get[model.User](db.GetDb(), map[string]interface{}{"ID": id})
+	if user == nil {
+		returnErrorResponse(ctx, "user not found", http.StatusNotFound)
+		return
+	}
+
+	if err := twofactor.Disable(user); err != nil {
+		log.Logger.Errorw("Failed to reset two-factor authentication", "err", err)
+		returnErrorResponse(ctx, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+	})
+}
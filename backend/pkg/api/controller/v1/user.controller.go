@@ -2,20 +2,33 @@ package v1
 
 import (
 	"github.com/gin-gonic/gin"
+	"github.com/whyxn/easynas/backend/pkg/apitoken"
 	"github.com/whyxn/easynas/backend/pkg/context"
 	"github.com/whyxn/easynas/backend/pkg/db"
 	"github.com/whyxn/easynas/backend/pkg/db/model"
 	"github.com/whyxn/easynas/backend/pkg/dto"
 	"github.com/whyxn/easynas/backend/pkg/log"
+	"github.com/whyxn/easynas/backend/pkg/loginguard"
+	"github.com/whyxn/easynas/backend/pkg/password"
+	"github.com/whyxn/easynas/backend/pkg/session"
+	"github.com/whyxn/easynas/backend/pkg/userimport"
 	"github.com/whyxn/easynas/backend/pkg/util"
+	"gorm.io/gorm"
 	"net/http"
+	"strings"
 )
 
 type UserControllerInterface interface {
 	Create(c *gin.Context)
 	GetList(c *gin.Context)
 	Get(c *gin.Context)
+	Update(c *gin.Context)
 	Delete(c *gin.Context)
+	GetMe(c *gin.Context)
+	Import(c *gin.Context)
+	Export(c *gin.Context)
+	ChangeOwnPassword(c *gin.Context)
+	ResetPassword(c *gin.Context)
 }
 
 type userController struct{}
@@ -46,6 +59,16 @@ func (ctrl *userController) Create(ctx *gin.Context) {
 		return
 	}
 
+	if input.Password != input.ConfirmPassword {
+		returnErrorResponse(ctx, "password and confirm password do not match", http.StatusBadRequest)
+		return
+	}
+
+	if err = password.Validate(input.Password); err != nil {
+		returnErrorResponse(ctx, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	user := &model.User{
 		Name:        input.Name,
 		Email:       input.Email,
@@ -54,7 +77,7 @@ func (ctrl *userController) Create(ctx *gin.Context) {
 		Role:        input.Role,
 	}
 
-	hashedPassword, err := util.HashPassword(input.Password)
+	hashedPassword, err := password.Hash(input.Password)
 	if err != nil {
 		log.Logger.Errorw("Failed to hash password", "err", err)
 		returnErrorResponse(ctx, err.Error(), http.StatusBadRequest)
@@ -64,7 +87,7 @@ func (ctrl *userController) Create(ctx *gin.Context) {
 	user.Password = hashedPassword
 
 	if err = db.GetDb().Insert(user); err != nil {
-		log.Logger.Fatalw("Failed to create user", "err", err.Error())
+		log.Logger.Errorw("Failed to create user", "err", err.Error())
 		returnErrorResponse(ctx, err.Error(), http.StatusBadRequest)
 		return
 	}
@@ -122,6 +145,128 @@ func (ctrl *userController) Get(ctx *gin.Context) {
 	})
 }
 
+// GetMe returns the requester's own profile
+func (ctrl *userController) GetMe(ctx *gin.Context) {
+	requester := context.GetRequesterFromContext(ctx)
+	if requester == nil {
+		returnErrorResponse(ctx, "unauthorized request", http.StatusUnauthorized)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"data":   requester,
+	})
+}
+
+// Update User. Only the fields present in the request are changed.
+func (ctrl *userController) Update(ctx *gin.Context) {
+	requester := context.GetRequesterFromContext(ctx)
+	if requester == nil {
+		returnErrorResponse(ctx, "unauthorized request", http.StatusUnauthorized)
+		return
+	} else if !isAdmin(requester) {
+		returnErrorResponse(ctx, "permission denied", http.StatusUnauthorized)
+		return
+	}
+
+	var input dto.UpdateUserInputDTO
+
+	err := ctx.BindJSON(&input)
+	if err != nil {
+		log.Logger.Errorw("Failed to bind JSON", "err", err)
+		returnErrorResponse(ctx, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	id := ctx.Param("id")
+
+	user, err := db.Get[model.User](db.GetDb(), map[string]interface{}{"ID": id})
+	if err != nil {
+		returnErrorResponse(ctx, "user not found", http.StatusNotFound)
+		return
+	}
+
+	updates := map[string]interface{}{}
+	if input.Name != nil {
+		if *input.Name == "" {
+			returnErrorResponse(ctx, "name must not be empty", http.StatusBadRequest)
+			return
+		}
+		updates["name"] = *input.Name
+	}
+	if input.Email != nil {
+		if *input.Email == "" {
+			returnErrorResponse(ctx, "email must not be empty", http.StatusBadRequest)
+			return
+		}
+		updates["email"] = *input.Email
+	}
+	if input.Role != nil {
+		if *input.Role != model.RoleAdmin && *input.Role != model.RoleUser {
+			returnErrorResponse(ctx, "invalid role", http.StatusBadRequest)
+			return
+		}
+		updates["role"] = *input.Role
+	}
+	if input.NasClientIP != nil {
+		if !util.IsValidNasClientIP(*input.NasClientIP) {
+			returnErrorResponse(ctx, "invalid nas client ip", http.StatusBadRequest)
+			return
+		}
+		updates["nas_client_ip"] = *input.NasClientIP
+	}
+	if input.Disabled != nil {
+		if *input.Disabled && user.ID == requester.ID {
+			returnErrorResponse(ctx, "you can not disable yourself", http.StatusBadRequest)
+			return
+		}
+		updates["disabled"] = *input.Disabled
+	}
+
+	if len(updates) == 0 {
+		returnErrorResponse(ctx, "nothing to update", http.StatusBadRequest)
+		return
+	}
+
+	losesAdmin := user.Role == model.RoleAdmin && !user.Disabled &&
+		((input.Role != nil && *input.Role != model.RoleAdmin) || (input.Disabled != nil && *input.Disabled))
+	if losesAdmin && isLastAdmin(user) {
+		returnErrorResponse(ctx, "at least one enabled admin is required", http.StatusConflict)
+		return
+	}
+
+	exportsChanged := (input.NasClientIP != nil && *input.NasClientIP != user.NasClientIP) ||
+		(input.Disabled != nil && *input.Disabled != user.Disabled)
+
+	if err = db.GetDb().Update(user, updates); err != nil {
+		log.Logger.Errorw("Failed to update user", "err", err.Error())
+		returnErrorResponse(ctx, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if user.Disabled {
+		// Disabled users are signed out everywhere
+		if err = session.RevokeAllForUser(user.ID, 0); err != nil {
+			log.Logger.Errorw("Failed to revoke user sessions", "err", err)
+			returnErrorResponse(ctx, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if exportsChanged {
+		if err = applyUserNfsSharePermissions(user.ID); err != nil {
+			returnErrorResponse(ctx, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"data":   user,
+	})
+}
+
 // Delete User
 func (ctrl *userController) Delete(ctx *gin.Context) {
 	requester := context.GetRequesterFromContext(ctx)
@@ -135,12 +280,337 @@ func (ctrl *userController) Delete(ctx *gin.Context) {
 
 	id := ctx.Param("id")
 
-	if err := db.GetDb().Delete(&model.User{}, map[string]interface{}{"ID": id}); err != nil {
+	user, err := db.Get[model.User](db.GetDb(), map[string]interface{}{"ID": id})
+	if err != nil {
+		returnErrorResponse(ctx, "user not found", http.StatusNotFound)
+		return
+	}
+
+	if user.ID == requester.ID {
+		returnErrorResponse(ctx, "you can not delete yourself", http.StatusBadRequest)
+		return
+	}
+
+	if user.Role == model.RoleAdmin && !user.Disabled && isLastAdmin(user) {
+		returnErrorResponse(ctx, "at least one enabled admin is required", http.StatusConflict)
+		return
+	}
+
+	permissionList, err := db.GetList[model.NfsSharePermission](db.GetDb(), map[string]interface{}{"user_id": user.ID}, "NfsShare")
+	if err != nil {
+		log.Logger.Errorw("Failed to fetch nfs share permission list", "err", err)
+		returnErrorResponse(ctx, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if len(permissionList) > 0 && ctx.Query("cascade") != "true" {
+		returnErrorResponse(ctx, "user has nfs share permissions, delete with cascade=true to remove them", http.StatusConflict)
+		return
+	}
+
+	err = db.GetDb().Client().Transaction(func(tx *gorm.DB) error {
+		for _, record := range []interface{}{
+			&model.NfsSharePermission{},
+			&model.Session{},
+			&model.ApiToken{},
+			&model.RecoveryCode{},
+			&model.PasswordHistory{},
+		} {
+			if err := tx.Where("user_id = ?", user.ID).Delete(record).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Delete(&model.User{}, user.ID).Error
+	})
+	if err != nil {
+		log.Logger.Errorw("Failed to delete user", "err", err)
+		returnErrorResponse(ctx, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Remove the user's address from the exports it was part of
+	for _, p := range permissionList {
+		if err = applyNfsSharePermissions(&p.NfsShare); err != nil {
+			log.Logger.Errorw("Failed to re-create nfs share with update permissions", "dataset", p.NfsShare.Dataset, "err", err)
+			returnErrorResponse(ctx, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+	})
+}
+
+// ChangeOwnPassword changes the requester's password after checking the current one
+func (ctrl *userController) ChangeOwnPassword(ctx *gin.Context) {
+	requester := context.GetRequesterFromContext(ctx)
+	if requester == nil {
+		returnErrorResponse(ctx, "unauthorized request", http.StatusUnauthorized)
+		return
+	}
+
+	var input dto.UpdateUserPasswordInputDTO
+
+	err := ctx.BindJSON(&input)
+	if err != nil {
+		log.Logger.Errorw("Failed to bind JSON", "err", err)
+		returnErrorResponse(ctx, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if requester.AuthSource != model.AuthSourceLocal {
+		returnErrorResponse(ctx, "password is managed by the identity provider", http.StatusBadRequest)
+		return
+	}
+
+	// guessing the current password with a stolen session is throttled
+	// like guessing it on login
+	ip, userAgent := ctx.ClientIP(), ctx.Request.UserAgent()
+	reservation, wait := loginguard.Reserve(requester.Email, ip)
+	if wait > 0 {
+		loginguard.RecordBlocked(requester.Email, ip, userAgent)
+		returnTooManyLoginAttempts(ctx, wait)
+		return
+	}
+
+	if !util.CheckPasswordHash(input.CurrentPassword, requester.Password) {
+		reservation.Fail(userAgent, "current password is incorrect")
+		returnErrorResponse(ctx, "current password is incorrect", http.StatusBadRequest)
+		return
+	}
+	reservation.Succeed(userAgent)
+
+	if !updatePassword(ctx, requester, input, false) {
+		return
+	}
+
+	// Sign out everywhere else, the current session stays valid
+	var currentSessionId uint
+	if s := context.GetSessionFromContext(ctx); s != nil {
+		currentSessionId = s.ID
+	}
+	if err = session.RevokeAllForUser(requester.ID, currentSessionId); err != nil {
+		log.Logger.Errorw("Failed to revoke user sessions", "err", err)
+		returnErrorResponse(ctx, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err = apitoken.RevokeAllForUser(requester.ID); err != nil {
+		log.Logger.Errorw("Failed to revoke user API tokens", "err", err)
+		returnErrorResponse(ctx, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+	})
+}
+
+// ResetPassword sets a new password for a user who has to change it on next login
+func (ctrl *userController) ResetPassword(ctx *gin.Context) {
+	requester := context.GetRequesterFromContext(ctx)
+	if requester == nil {
+		returnErrorResponse(ctx, "unauthorized request", http.StatusUnauthorized)
+		return
+	} else if !isAdmin(requester) {
+		returnErrorResponse(ctx, "permission denied", http.StatusUnauthorized)
+		return
+	}
+
+	var input dto.UpdateUserPasswordInputDTO
+
+	err := ctx.BindJSON(&input)
+	if err != nil {
+		log.Logger.Errorw("Failed to bind JSON", "err", err)
+		returnErrorResponse(ctx, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	id := ctx.Param("id")
+
+	user, err := db.Get[model.User](db.GetDb(), map[string]interface{}{"ID": id})
+	if err != nil {
+		returnErrorResponse(ctx, "user not found", http.StatusNotFound)
+		return
+	}
+
+	if user.AuthSource != model.AuthSourceLocal {
+		returnErrorResponse(ctx, "password is managed by the identity provider", http.StatusBadRequest)
+		return
+	}
+
+	if !updatePassword(ctx, user, input, true) {
+		return
+	}
+
+	if err = session.RevokeAllForUser(user.ID, 0); err != nil {
+		log.Logger.Errorw("Failed to revoke user sessions", "err", err)
+		returnErrorResponse(ctx, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err = apitoken.RevokeAllForUser(user.ID); err != nil {
+		log.Logger.Errorw("Failed to revoke user API tokens", "err", err)
+		returnErrorResponse(ctx, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+	})
+}
+
+// maxImportSize limits the request body of a user import
+const maxImportSize = 5 << 20
+
+// Import creates users in bulk from CSV or JSON. The format is taken from
+// the format query parameter or the Content-Type. With dryRun=true only the
+// validation report is returned. Nothing is created unless every row is valid.
+func (ctrl *userController) Import(ctx *gin.Context) {
+	requester := context.GetRequesterFromContext(ctx)
+	if requester == nil {
+		returnErrorResponse(ctx, "unauthorized request", http.StatusUnauthorized)
+		return
+	} else if !isAdmin(requester) {
+		returnErrorResponse(ctx, "permission denied", http.StatusUnauthorized)
+		return
+	}
+
+	body := http.MaxBytesReader(ctx.Writer, ctx.Request.Body, maxImportSize)
+
+	var records []userimport.Record
+	var err error
+	if requestFormat(ctx, "json") == "csv" {
+		records, err = userimport.ParseCSV(body)
+	} else {
+		records, err = userimport.ParseJSON(body)
+	}
+	if err != nil {
+		returnErrorResponse(ctx, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(records) == 0 {
+		returnErrorResponse(ctx, "no users to import", http.StatusBadRequest)
+		return
+	}
+
+	report, err := userimport.Validate(records)
+	if err != nil {
+		log.Logger.Errorw("Failed to validate user import", "err", err)
+		returnErrorResponse(ctx, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	dryRun := ctx.Query("dryRun") == "true"
+	if !report.Valid {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"status": "error",
+			"msg":    "import contains invalid rows",
+			"data":   gin.H{"dryRun": dryRun, "report": report},
+		})
+		return
+	}
+
+	if dryRun {
+		ctx.JSON(http.StatusOK, gin.H{
+			"status": "success",
+			"data":   gin.H{"dryRun": true, "report": report},
+		})
+		return
+	}
+
+	created, err := userimport.Apply(records)
+	if err != nil {
+		log.Logger.Errorw("Failed to import users", "err", err)
 		returnErrorResponse(ctx, err.Error(), http.StatusBadRequest)
 		return
 	}
 
 	ctx.JSON(http.StatusOK, gin.H{
 		"status": "success",
+		"data":   gin.H{"dryRun": false, "report": report, "created": created},
 	})
 }
+
+// Export returns all users with their share permissions as JSON or CSV
+func (ctrl *userController) Export(ctx *gin.Context) {
+	requester := context.GetRequesterFromContext(ctx)
+	if requester == nil {
+		returnErrorResponse(ctx, "unauthorized request", http.StatusUnauthorized)
+		return
+	} else if !isAdmin(requester) {
+		returnErrorResponse(ctx, "permission denied", http.StatusUnauthorized)
+		return
+	}
+
+	users, err := userimport.Export()
+	if err != nil {
+		log.Logger.Errorw("Failed to export users", "err", err)
+		returnErrorResponse(ctx, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if requestFormat(ctx, "json") != "csv" {
+		ctx.JSON(http.StatusOK, gin.H{
+			"status": "success",
+			"data":   users,
+		})
+		return
+	}
+
+	ctx.Header("Content-Type", "text/csv; charset=utf-8")
+	ctx.Header("Content-Disposition", `attachment; filename="users.csv"`)
+	ctx.Status(http.StatusOK)
+	if err = userimport.WriteCSV(ctx.Writer, users); err != nil {
+		log.Logger.Errorw("Failed to write user export", "err", err)
+	}
+}
+
+// requestFormat returns "csv" or "json" from the format query parameter,
+// falling back to the Content-Type of the request and then to the default
+func requestFormat(ctx *gin.Context, fallback string) string {
+	switch strings.ToLower(ctx.Query("format")) {
+	case "csv":
+		return "csv"
+	case "json":
+		return "json"
+	}
+	if strings.Contains(ctx.ContentType(), "csv") {
+		return "csv"
+	}
+	return fallback
+}
+
+// isLastAdmin reports whether the user is the only enabled admin
+func isLastAdmin(user *model.User) bool {
+	var count int64
+	db.GetDb().Client().Model(&model.User{}).
+		Where("role = ? AND disabled = ? AND id <> ?", model.RoleAdmin, false, user.ID).
+		Count(&count)
+	return count == 0
+}
+
+// updatePassword validates and stores a new password. Returns false if an
+// error response has been written.
+func updatePassword(ctx *gin.Context, user *model.User, input dto.UpdateUserPasswordInputDTO, mustChange bool) bool {
+	if input.Password != input.ConfirmPassword {
+		returnErrorResponse(ctx, "password and confirm password do not match", http.StatusBadRequest)
+		return false
+	}
+
+	if err := password.Validate(input.Password); err != nil {
+		returnErrorResponse(ctx, err.Error(), http.StatusBadRequest)
+		return false
+	}
+
+	if err := password.CheckReuse(user, input.Password); err != nil {
+		returnErrorResponse(ctx, err.Error(), http.StatusBadRequest)
+		return false
+	}
+
+	if err := password.Set(user, input.Password, mustChange); err != nil {
+		log.Logger.Errorw("Failed to update password", "err", err)
+		returnErrorResponse(ctx, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,183 @@
+package apitoken
+
+import (
+	"errors"
+	"fmt"
+	"github.com/whyxn/easynas/backend/pkg/db"
+	"github.com/whyxn/easynas/backend/pkg/db/model"
+	"github.com/whyxn/easynas/backend/pkg/enum"
+	"github.com/whyxn/easynas/backend/pkg/util"
+	"strings"
+	"time"
+)
+
+// TokenPrefix marks a bearer credential as a personal API token rather than a JWT.
+const TokenPrefix = "enas_"
+
+// lastUsedResolution limits how often last used time of a token is written to the DB.
+const lastUsedResolution = time.Minute
+
+var (
+	ErrTokenNotFound = errors.New("api token not found")
+	ErrTokenExpired  = errors.New("api token has expired")
+	ErrUserDisabled  = errors.New("user is disabled")
+)
+
+// IsApiToken reports whether the credential looks like a personal API token.
+func IsApiToken(token string) bool {
+	return strings.HasPrefix(token, TokenPrefix)
+}
+
+// Create generates a new API token for the user. The plain token is returned
+// only here, the DB keeps its hash.
+func Create(user *model.User, name string, scopes []enum.TokenScope, expiresAt *time.Time) (*model.ApiToken, string, error) {
+	secret, err := util.GenerateRandomToken(32)
+	if err != nil {
+		return nil, "", err
+	}
+	plain := TokenPrefix + secret
+
+	var scopeList []string
+	for _, s := range scopes {
+		scopeList = append(scopeList, string(s))
+	}
+
+	token := &model.ApiToken{
+		UserId:    user.ID,
+		Name:      name,
+		Prefix:    plain[:len(TokenPrefix)+6],
+		TokenHash: util.HashToken(plain),
+		Scopes:    strings.Join(scopeList, ","),
+		ExpiresAt: expiresAt,
+		CreatedAt: time.Now(),
+	}
+	if err = db.GetDb().Insert(token); err != nil {
+		return nil, "", err
+	}
+	return token, plain, nil
+}
+
+// Authenticate looks up a plain API token and returns it with its user
+// preloaded. The token's last used time is recorded.
+func Authenticate(plain string) (*model.ApiToken, error) {
+	token, err := db.Get[model.ApiToken](db.GetDb(), map[string]interface{}{"token_hash": util.HashToken(plain)}, "User")
+	if err != nil || token.User.ID == 0 {
+		return nil, ErrTokenNotFound
+	}
+
+	now := time.Now()
+	if token.ExpiresAt != nil && now.After(*token.ExpiresAt) {
+		return nil, ErrTokenExpired
+	}
+	if token.User.Disabled {
+		return nil, ErrUserDisabled
+	}
+
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) > lastUsedResolution {
+		if err = db.GetDb().Update(token, map[string]interface{}{"last_used_at": now}); err != nil {
+			return nil, err
+		}
+	}
+	return token, nil
+}
+
+// Validate returns the token with the given id along with its user if it
+// can still be used. Long running requests use it to notice revocation.
+func Validate(id uint) (*model.ApiToken, error) {
+	token, err := db.Get[model.ApiToken](db.GetDb(), map[string]interface{}{"id": id}, "User")
+	if err != nil || token.User.ID == 0 {
+		return nil, ErrTokenNotFound
+	}
+	if token.ExpiresAt != nil && time.Now().After(*token.ExpiresAt) {
+		return nil, ErrTokenExpired
+	}
+	if token.User.Disabled {
+		return nil, ErrUserDisabled
+	}
+	return token, nil
+}
+
+// RevokeAllForUser deletes every API token of a user. A password change or
+// reset does, as a token minted by whoever knew the old password would
+// otherwise outlive it.
+func RevokeAllForUser(userId uint) error {
+	return db.GetDb().Delete(&model.ApiToken{}, map[string]interface{}{"user_id": userId})
+}
+
+// ParseScopes validates a list of scope names.
+func ParseScopes(scopes []string) ([]enum.TokenScope, error) {
+	var result []enum.TokenScope
+	for _, s := range scopes {
+		valid := false
+		for _, known := range enum.TokenScopes {
+			if s == string(known) {
+				valid = true
+				result = append(result, known)
+				break
+			}
+		}
+		if !valid {
+			return nil, fmt.Errorf("unknown scope '%s'", s)
+		}
+	}
+	return result, nil
+}
+
+// Scopes returns the scopes granted to a token.
+func Scopes(token *model.ApiToken) []string {
+	if token.Scopes == "" {
+		return []string{}
+	}
+	return strings.Split(token.Scopes, ",")
+}
+
+// HasScope reports whether the token was granted the scope.
+func HasScope(token *model.ApiToken, scope enum.TokenScope) bool {
+	for _, s := range Scopes(token) {
+		if s == string(scope) {
+			return true
+		}
+	}
+	return false
+}
+
+// RequiredScope returns the scope an API token needs to call the endpoint.
+// ok is false for endpoints API tokens must never reach, like token management.
+func RequiredScope(method, path string) (scope enum.TokenScope, ok bool) {
+	path = strings.TrimPrefix(path, "/")
+	read := method == "GET" || method == "HEAD" || method == "OPTIONS"
+
+	switch {
+	case strings.HasPrefix(path, "health"):
+		return "", true
+	case strings.HasPrefix(path, "api/v1/auth/"),
+		strings.HasPrefix(path, "api/v1/users/me/tokens"),
+		strings.HasPrefix(path, "api/v1/users/me/sessions"),
+		strings.HasPrefix(path, "api/v1/users/me/2fa"),
+		strings.HasPrefix(path, "api/v1/users/me/password"):
+		return "", false
+	case strings.HasPrefix(path, "api/v1/nas/"),
+		// jobs run the long NAS operations
+		strings.HasPrefix(path, "api/v1/jobs"),
+		strings.HasPrefix(path, "api/v1/events"),
+		strings.HasPrefix(path, "api/v1/disks"):
+		if read {
+			return enum.ScopeNasRead, true
+		}
+		return enum.ScopeNasWrite, true
+	case strings.HasPrefix(path, "api/v1/users"):
+		if read {
+			return enum.ScopeUsersRead, true
+		}
+		return enum.ScopeUsersWrite, true
+	case strings.HasPrefix(path, "api/v1/metrics"), strings.HasPrefix(path, "api/v1/alerts"):
+		if read {
+			return enum.ScopeMetricsRead, true
+		}
+		return enum.ScopeMetricsWrite, true
+	case strings.HasPrefix(path, "api/v1/audit"):
+		// the audit log can only be read
+		return enum.ScopeAuditRead, read
+	}
+	return "", false
+}
@@ -0,0 +1,127 @@
+package apitoken
+
+import (
+	"errors"
+	"github.com/whyxn/easynas/backend/pkg/db"
+	"github.com/whyxn/easynas/backend/pkg/db/model"
+	"github.com/whyxn/easynas/backend/pkg/enum"
+	"github.com/whyxn/easynas/backend/pkg/log"
+	"go.uber.org/zap"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// useTestDb connects the db package to a new, migrated database with a user
+// for the test
+func useTestDb(t *testing.T) *model.User {
+	t.Helper()
+	log.Logger = zap.NewNop().Sugar()
+	if err := db.Connect(filepath.Join(t.TempDir(), "easynas.db")); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := db.GetDb().RunMigrations(); err != nil {
+		t.Fatal(err)
+	}
+
+	user := &model.User{Name: "Alice", Email: "alice@example.com", Role: model.RoleUser}
+	if err := db.GetDb().Insert(user); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func TestRequiredScope(t *testing.T) {
+	tests := []struct {
+		method string
+		path   string
+		scope  enum.TokenScope
+		ok     bool
+	}{
+		{"GET", "/health", "", true},
+		{"GET", "/api/v1/nas/pools/naspool/datasets", enum.ScopeNasRead, true},
+		{"POST", "/api/v1/nas/pools/naspool/datasets", enum.ScopeNasWrite, true},
+		{"DELETE", "/api/v1/jobs/7", enum.ScopeNasWrite, true},
+		{"GET", "/api/v1/events", enum.ScopeNasRead, true},
+		{"GET", "/api/v1/disks", enum.ScopeNasRead, true},
+		{"GET", "/api/v1/users", enum.ScopeUsersRead, true},
+		{"PUT", "/api/v1/users/3", enum.ScopeUsersWrite, true},
+		{"GET", "/api/v1/metrics/history", enum.ScopeMetricsRead, true},
+		{"POST", "/api/v1/alerts/rules", enum.ScopeMetricsWrite, true},
+		{"GET", "/api/v1/audit", enum.ScopeAuditRead, true},
+		// never with an API token
+		{"DELETE", "/api/v1/audit", enum.ScopeAuditRead, false},
+		{"POST", "/api/v1/auth/refresh", "", false},
+		{"POST", "/api/v1/users/me/tokens", "", false},
+		{"GET", "/api/v1/users/me/sessions", "", false},
+		{"POST", "/api/v1/users/me/2fa/disable", "", false},
+		{"PUT", "/api/v1/users/me/password", "", false},
+		// paths no scope covers are denied
+		{"GET", "/api/v1/settings/security", "", false},
+		{"GET", "/api/v1/unknown", "", false},
+		{"GET", "/", "", false},
+	}
+	for _, tt := range tests {
+		scope, ok := RequiredScope(tt.method, tt.path)
+		if scope != tt.scope || ok != tt.ok {
+			t.Errorf("RequiredScope(%s %s) = %q, %v, want %q, %v", tt.method, tt.path, scope, ok, tt.scope, tt.ok)
+		}
+	}
+}
+
+func TestParseScopes(t *testing.T) {
+	scopes, err := ParseScopes([]string{"nas:read", "audit:read"})
+	if err != nil || len(scopes) != 2 || scopes[0] != enum.ScopeNasRead || scopes[1] != enum.ScopeAuditRead {
+		t.Errorf("ParseScopes() = %v, %v", scopes, err)
+	}
+	if _, err = ParseScopes([]string{"nas:read", "nas:admin"}); err == nil {
+		t.Error("ParseScopes() of an unknown scope error = nil")
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	user := useTestDb(t)
+	token, plain, err := Create(user, "backup script", []enum.TokenScope{enum.ScopeNasRead}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsApiToken(plain) || token.TokenHash == plain {
+		t.Errorf("Create() = %q, want a prefixed token stored as a hash", plain)
+	}
+
+	authenticated, err := Authenticate(plain)
+	if err != nil {
+		t.Fatalf("Authenticate() error = %v", err)
+	}
+	if authenticated.User.ID != user.ID || authenticated.LastUsedAt == nil {
+		t.Errorf("Authenticate() = %+v, want the token of the user with its use recorded", authenticated)
+	}
+	if !HasScope(authenticated, enum.ScopeNasRead) || HasScope(authenticated, enum.ScopeNasWrite) {
+		t.Errorf("scopes = %v, want only nas:read", Scopes(authenticated))
+	}
+
+	if _, err = Authenticate(plain + "x"); !errors.Is(err, ErrTokenNotFound) {
+		t.Errorf("Authenticate() of a wrong token error = %v, want ErrTokenNotFound", err)
+	}
+
+	expired := time.Now().Add(-time.Minute)
+	_, expiredPlain, _ := Create(user, "old", nil, &expired)
+	if _, err = Authenticate(expiredPlain); !errors.Is(err, ErrTokenExpired) {
+		t.Errorf("Authenticate() of an expired token error = %v, want ErrTokenExpired", err)
+	}
+
+	if err = db.GetDb().Update(user, map[string]interface{}{"disabled": true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Authenticate(plain); !errors.Is(err, ErrUserDisabled) {
+		t.Errorf("Authenticate() of a disabled user's token error = %v, want ErrUserDisabled", err)
+	}
+
+	if err = RevokeAllForUser(user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Validate(token.ID); !errors.Is(err, ErrTokenNotFound) {
+		t.Errorf("Validate() of a revoked token error = %v, want ErrTokenNotFound", err)
+	}
+}
@@ -0,0 +1,160 @@
+package audit
+
+import (
+	"encoding/json"
+	"github.com/whyxn/easynas/backend/pkg/db"
+	"github.com/whyxn/easynas/backend/pkg/db/model"
+	"gorm.io/gorm"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 500
+
+	exportBatchSize = 500
+	redacted        = "[redacted]"
+)
+
+// sensitiveKeys are redacted from recorded parameters. A key is sensitive
+// if its lower-cased name contains one of them.
+var sensitiveKeys = []string{"password", "token", "secret", "code", "privatekey"}
+
+// Filter narrows down audit events. Zero values do not filter.
+type Filter struct {
+	ActorId      uint
+	Actor        string
+	Action       string
+	Pool         string
+	Dataset      string
+	TargetUserId uint
+	JobId        uint
+	Result       string
+	From         time.Time
+	To           time.Time
+}
+
+// Record stores an audit event.
+func Record(event *model.AuditEvent) error {
+	return db.GetDb().Insert(event)
+}
+
+// Query returns one page of the events matching the filter, newest first,
+// along with the total number of matching events. Pages start at 1.
+func Query(filter Filter, page, pageSize int) ([]model.AuditEvent, int64, error) {
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 {
+		pageSize = DefaultPageSize
+	} else if pageSize > MaxPageSize {
+		pageSize = MaxPageSize
+	}
+
+	var total int64
+	if err := filtered(filter).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var events []model.AuditEvent
+	err := filtered(filter).Order("id desc").Offset((page - 1) * pageSize).Limit(pageSize).Find(&events).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return events, total, nil
+}
+
+// Export writes the events matching the filter as JSON lines, oldest first.
+func Export(w io.Writer, filter Filter) error {
+	encoder := json.NewEncoder(w)
+
+	var events []model.AuditEvent
+	var writeErr error
+	err := filtered(filter).FindInBatches(&events, exportBatchSize, func(tx *gorm.DB, batch int) error {
+		for _, e := range events {
+			if writeErr = encoder.Encode(e); writeErr != nil {
+				return writeErr
+			}
+		}
+		return nil
+	}).Error
+	if writeErr != nil {
+		return writeErr
+	}
+	return err
+}
+
+func filtered(filter Filter) *gorm.DB {
+	query := db.GetDb().Client().Model(&model.AuditEvent{})
+
+	if filter.ActorId != 0 {
+		query = query.Where("actor_id = ?", filter.ActorId)
+	}
+	if filter.Actor != "" {
+		query = query.Where("actor_email LIKE ?", "%"+filter.Actor+"%")
+	}
+	if filter.Action != "" {
+		query = query.Where("action LIKE ?", "%"+filter.Action+"%")
+	}
+	if filter.Pool != "" {
+		query = query.Where("pool = ?", filter.Pool)
+	}
+	if filter.Dataset != "" {
+		query = query.Where("dataset = ?", filter.Dataset)
+	}
+	if filter.TargetUserId != 0 {
+		query = query.Where("target_user_id = ?", filter.TargetUserId)
+	}
+	if filter.JobId != 0 {
+		query = query.Where("job_id = ?", filter.JobId)
+	}
+	if filter.Result != "" {
+		query = query.Where("result = ?", filter.Result)
+	}
+	if !filter.From.IsZero() {
+		query = query.Where("created_at >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		query = query.Where("created_at < ?", filter.To)
+	}
+	return query
+}
+
+// Redact replaces the values of sensitive keys in decoded JSON.
+func Redact(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, inner := range v {
+			if isSensitive(key) {
+				v[key] = redacted
+			} else {
+				v[key] = Redact(inner)
+			}
+		}
+	case []interface{}:
+		for i, inner := range v {
+			v[i] = Redact(inner)
+		}
+	}
+	return value
+}
+
+// RedactField replaces the whole value of a key in decoded JSON, for values
+// that are sensitive whatever their keys are.
+func RedactField(fields map[string]interface{}, key string) {
+	if _, ok := fields[key]; ok {
+		fields[key] = redacted
+	}
+}
+
+func isSensitive(key string) bool {
+	key = strings.ToLower(key)
+	for _, s := range sensitiveKeys {
+		if strings.Contains(key, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,226 @@
+package auth
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/go-ldap/ldap/v3"
+	"github.com/whyxn/easynas/backend/pkg/config"
+	"github.com/whyxn/easynas/backend/pkg/db/model"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// LdapConfig describes how to find and authenticate users in an LDAP
+// directory or Active Directory.
+type LdapConfig = config.LdapConfig
+
+// LdapConn is the part of an LDAP connection the provider uses. It is
+// satisfied by *ldap.Conn and allows an in-process directory in its place.
+type LdapConn interface {
+	Bind(username, password string) error
+	Search(request *ldap.SearchRequest) (*ldap.SearchResult, error)
+	Close() error
+}
+
+// LdapDialer opens a connection to the directory.
+type LdapDialer func(cfg LdapConfig) (LdapConn, error)
+
+// LdapProvider authenticates users by binding to the directory with their
+// credentials and provisions them in easynas on first login.
+type LdapProvider struct {
+	cfg  LdapConfig
+	dial LdapDialer
+}
+
+func NewLdapProvider(cfg LdapConfig) *LdapProvider {
+	return NewLdapProviderWithDialer(cfg, dialLdap)
+}
+
+// NewLdapProviderWithDialer creates a provider that connects through dial.
+func NewLdapProviderWithDialer(cfg LdapConfig, dial LdapDialer) *LdapProvider {
+	if cfg.UserFilter == "" {
+		cfg.UserFilter = "(|(uid={username})(mail={username})(sAMAccountName={username}))"
+	}
+	if cfg.EmailAttribute == "" {
+		cfg.EmailAttribute = "mail"
+	}
+	if cfg.NameAttribute == "" {
+		cfg.NameAttribute = "cn"
+	}
+	if cfg.GroupAttribute == "" {
+		cfg.GroupAttribute = "memberOf"
+	}
+	if cfg.GroupSearchFilter == "" {
+		cfg.GroupSearchFilter = "(|(member={dn})(uniqueMember={dn}))"
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = config.Duration(10 * time.Second)
+	}
+	return &LdapProvider{cfg: cfg, dial: dial}
+}
+
+func (p *LdapProvider) Name() string {
+	return model.AuthSourceLdap
+}
+
+func (p *LdapProvider) Authenticate(username, password string) (*model.User, error) {
+	// An empty password would turn the user bind into an unauthenticated bind
+	if username == "" || password == "" {
+		return nil, ErrUnknownUser
+	}
+
+	conn, err := p.dial(p.cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to ldap server: %w", err)
+	}
+	defer conn.Close()
+
+	if p.cfg.BindDN != "" {
+		if err = conn.Bind(p.cfg.BindDN, p.cfg.BindPassword); err != nil {
+			return nil, fmt.Errorf("failed to bind with ldap service account: %w", err)
+		}
+	}
+
+	attributes := []string{"dn", p.cfg.EmailAttribute, p.cfg.NameAttribute, p.cfg.GroupAttribute}
+	if p.cfg.NasClientIPAttr != "" {
+		attributes = append(attributes, p.cfg.NasClientIPAttr)
+	}
+
+	result, err := conn.Search(ldap.NewSearchRequest(
+		p.cfg.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, int(time.Duration(p.cfg.Timeout).Seconds()), false,
+		strings.ReplaceAll(p.cfg.UserFilter, "{username}", ldap.EscapeFilter(username)),
+		attributes, nil,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to search ldap user: %w", err)
+	}
+	if len(result.Entries) != 1 {
+		return nil, ErrUnknownUser
+	}
+	entry := result.Entries[0]
+
+	if err = conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidPassword
+		}
+		return nil, fmt.Errorf("failed to bind as ldap user: %w", err)
+	}
+
+	groups := entry.GetAttributeValues(p.cfg.GroupAttribute)
+	if p.cfg.GroupSearchBaseDN != "" {
+		// Rebind as the service account, the user may not be allowed to read groups
+		if p.cfg.BindDN != "" {
+			if err = conn.Bind(p.cfg.BindDN, p.cfg.BindPassword); err != nil {
+				return nil, fmt.Errorf("failed to bind with ldap service account: %w", err)
+			}
+		}
+		groups, err = p.searchGroups(conn, entry.DN)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	role := p.mapRole(groups)
+	if role == "" {
+		return nil, errors.New("ldap user is not a member of any allowed group")
+	}
+
+	email := entry.GetAttributeValue(p.cfg.EmailAttribute)
+	if email == "" {
+		email = username
+	}
+	name := entry.GetAttributeValue(p.cfg.NameAttribute)
+	if name == "" {
+		name = username
+	}
+
+	identity := ExternalIdentity{
+		Source:     model.AuthSourceLdap,
+		ExternalId: strings.ToLower(entry.DN),
+		Email:      email,
+		Name:       name,
+		Role:       role,
+	}
+	if p.cfg.NasClientIPAttr != "" {
+		identity.NasClientIP = entry.GetAttributeValue(p.cfg.NasClientIPAttr)
+	}
+	return Provision(identity)
+}
+
+func (p *LdapProvider) searchGroups(conn LdapConn, userDN string) ([]string, error) {
+	result, err := conn.Search(ldap.NewSearchRequest(
+		p.cfg.GroupSearchBaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, int(time.Duration(p.cfg.Timeout).Seconds()), false,
+		strings.ReplaceAll(p.cfg.GroupSearchFilter, "{dn}", ldap.EscapeFilter(userDN)),
+		[]string{"dn"}, nil,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to search ldap groups: %w", err)
+	}
+
+	var groups []string
+	for _, e := range result.Entries {
+		groups = append(groups, e.DN)
+	}
+	return groups, nil
+}
+
+// mapRole returns the easynas role for a set of group DNs, or an empty string
+// if the user is not allowed to log in.
+func (p *LdapProvider) mapRole(groups []string) string {
+	if matchGroup(groups, p.cfg.AdminGroups) {
+		return model.RoleAdmin
+	}
+	if len(p.cfg.UserGroups) == 0 || matchGroup(groups, p.cfg.UserGroups) {
+		return model.RoleUser
+	}
+	return ""
+}
+
+func matchGroup(groups []string, wanted []string) bool {
+	for _, g := range groups {
+		cn := groupCN(g)
+		for _, w := range wanted {
+			if strings.EqualFold(g, w) || strings.EqualFold(cn, w) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// groupCN returns the value of the leading CN of a DN
+func groupCN(dn string) string {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil || len(parsed.RDNs) == 0 {
+		return ""
+	}
+	for _, attr := range parsed.RDNs[0].Attributes {
+		if strings.EqualFold(attr.Type, "cn") {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+func dialLdap(cfg LdapConfig) (LdapConn, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: cfg.InsecureSkipVerify}
+	if u, err := url.Parse(cfg.URL); err == nil {
+		tlsConfig.ServerName = u.Hostname()
+	}
+
+	conn, err := ldap.DialURL(cfg.URL, ldap.DialWithTLSConfig(tlsConfig))
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(time.Duration(cfg.Timeout))
+
+	if cfg.StartTLS {
+		if err = conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
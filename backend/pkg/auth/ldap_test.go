@@ -0,0 +1,331 @@
+package auth
+
+import (
+	"errors"
+	"github.com/go-ldap/ldap/v3"
+	"github.com/whyxn/easynas/backend/pkg/db"
+	"github.com/whyxn/easynas/backend/pkg/db/model"
+	"github.com/whyxn/easynas/backend/pkg/log"
+	"go.uber.org/zap"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// useTestDb connects the db package to a new, migrated database for the test
+func useTestDb(t *testing.T) {
+	t.Helper()
+	log.Logger = zap.NewNop().Sugar()
+	if err := db.Connect(filepath.Join(t.TempDir(), "easynas.db")); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := db.GetDb().RunMigrations(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+const (
+	testBindDN   = "cn=easynas,ou=services,dc=example,dc=com"
+	testBindPass = "service-secret"
+	aliceDN      = "uid=alice,ou=people,dc=example,dc=com"
+	adminsDN     = "cn=nas-admins,ou=groups,dc=example,dc=com"
+	usersDN      = "cn=nas-users,ou=groups,dc=example,dc=com"
+)
+
+// fakeDirectory is an in-process LDAP directory. Its connections record
+// the binds made on them.
+type fakeDirectory struct {
+	entries   []*ldap.Entry
+	passwords map[string]string
+	// groups lists the member DNs of group DNs, for group searches
+	groups   map[string][]string
+	binds    []string
+	searches []*ldap.SearchRequest
+}
+
+func aliceEntry(mail, name string, memberOf ...string) *ldap.Entry {
+	return ldap.NewEntry(aliceDN, map[string][]string{
+		"uid":      {"alice"},
+		"mail":     {mail},
+		"cn":       {name},
+		"memberOf": memberOf,
+	})
+}
+
+func newFakeDirectory() *fakeDirectory {
+	return &fakeDirectory{
+		entries: []*ldap.Entry{aliceEntry("alice@example.com", "Alice Liddell", usersDN)},
+		passwords: map[string]string{
+			testBindDN: testBindPass,
+			aliceDN:    "alice-secret",
+		},
+		groups: map[string][]string{},
+	}
+}
+
+func (d *fakeDirectory) dial(cfg LdapConfig) (LdapConn, error) {
+	return &fakeLdapConn{d}, nil
+}
+
+type fakeLdapConn struct {
+	d *fakeDirectory
+}
+
+func (c *fakeLdapConn) Bind(username, password string) error {
+	c.d.binds = append(c.d.binds, username)
+	// like a real server, an empty password is an unauthenticated bind
+	if password == "" {
+		return nil
+	}
+	if want, ok := c.d.passwords[username]; ok && want == password {
+		return nil
+	}
+	return ldap.NewError(ldap.LDAPResultInvalidCredentials, errors.New("invalid credentials"))
+}
+
+func (c *fakeLdapConn) Search(request *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	c.d.searches = append(c.d.searches, request)
+	result := &ldap.SearchResult{}
+
+	if request.BaseDN == "ou=groups,dc=example,dc=com" {
+		for group, members := range c.d.groups {
+			for _, member := range members {
+				if strings.Contains(request.Filter, "(member="+ldap.EscapeFilter(member)+")") {
+					result.Entries = append(result.Entries, ldap.NewEntry(group, nil))
+				}
+			}
+		}
+		return result, nil
+	}
+
+	// matches the default user filter on uid and mail
+	for _, e := range c.d.entries {
+		for _, attr := range []string{"uid", "mail", "sAMAccountName"} {
+			value := e.GetAttributeValue(attr)
+			if value != "" && strings.Contains(request.Filter, "("+attr+"="+ldap.EscapeFilter(value)+")") {
+				result.Entries = append(result.Entries, e)
+				break
+			}
+		}
+	}
+	return result, nil
+}
+
+func (c *fakeLdapConn) Close() error {
+	return nil
+}
+
+func testLdapConfig() LdapConfig {
+	return LdapConfig{
+		URL:          "ldap://directory.example.com",
+		BindDN:       testBindDN,
+		BindPassword: testBindPass,
+		BaseDN:       "ou=people,dc=example,dc=com",
+		AdminGroups:  []string{adminsDN},
+		UserGroups:   []string{"nas-users"},
+	}
+}
+
+func TestLdapAuthenticate(t *testing.T) {
+	useTestDb(t)
+	d := newFakeDirectory()
+	p := NewLdapProviderWithDialer(testLdapConfig(), d.dial)
+
+	user, err := p.Authenticate("alice", "alice-secret")
+	if err != nil {
+		t.Fatalf("Authenticate() error = %v", err)
+	}
+
+	if want := []string{testBindDN, aliceDN}; strings.Join(d.binds, "|") != strings.Join(want, "|") {
+		t.Errorf("binds = %v, want the service account then the user", d.binds)
+	}
+	if len(d.searches) != 1 {
+		t.Fatalf("searches = %d, want 1", len(d.searches))
+	}
+	if filter := d.searches[0].Filter; filter != "(|(uid=alice)(mail=alice)(sAMAccountName=alice))" {
+		t.Errorf("user filter = %q", filter)
+	}
+	if user.Email != "alice@example.com" || user.Name != "Alice Liddell" || user.Role != model.RoleUser {
+		t.Errorf("user = %s %q %s, want alice@example.com \"Alice Liddell\" user", user.Email, user.Name, user.Role)
+	}
+	if user.AuthSource != model.AuthSourceLdap || user.ExternalId != aliceDN {
+		t.Errorf("user source = %s %s, want ldap %s", user.AuthSource, user.ExternalId, aliceDN)
+	}
+}
+
+func TestLdapAuthenticateInvalidCredentials(t *testing.T) {
+	useTestDb(t)
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		err      error
+	}{
+		{"wrong password", "alice", "wrong", ErrInvalidPassword},
+		{"unknown user", "bob", "alice-secret", ErrUnknownUser},
+		{"empty password", "alice", "", ErrUnknownUser},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newFakeDirectory()
+			p := NewLdapProviderWithDialer(testLdapConfig(), d.dial)
+
+			user, err := p.Authenticate(tt.username, tt.password)
+			if user != nil || !errors.Is(err, tt.err) {
+				t.Fatalf("Authenticate() = %v, %v, want %v", user, err, tt.err)
+			}
+			// the login answers both alike, and nothing about the directory leaks
+			if !IsCredentialError(err) || err.Error() != tt.err.Error() {
+				t.Errorf("error %q is not a plain credential error", err)
+			}
+			for _, dn := range d.binds {
+				if dn == aliceDN && tt.password == "" {
+					t.Error("bound as the user without a password")
+				}
+			}
+		})
+	}
+
+	users, _ := db.GetList[model.User](db.GetDb(), map[string]interface{}{"auth_source": model.AuthSourceLdap})
+	if len(users) != 0 {
+		t.Errorf("failed logins provisioned %d users", len(users))
+	}
+}
+
+func TestLdapAuthenticateWrongServicePassword(t *testing.T) {
+	useTestDb(t)
+	d := newFakeDirectory()
+	cfg := testLdapConfig()
+	cfg.BindPassword = "outdated"
+	p := NewLdapProviderWithDialer(cfg, d.dial)
+
+	_, err := p.Authenticate("alice", "alice-secret")
+	if err == nil || IsCredentialError(err) {
+		t.Errorf("Authenticate() error = %v, want a service account failure", err)
+	}
+	if len(d.searches) != 0 {
+		t.Error("searched the directory after the service bind failed")
+	}
+}
+
+func TestLdapGroupRoles(t *testing.T) {
+	tests := []struct {
+		name        string
+		memberOf    []string
+		adminGroups []string
+		userGroups  []string
+		role        string
+	}{
+		{"admin group by DN", []string{adminsDN, usersDN}, []string{adminsDN}, []string{"nas-users"}, model.RoleAdmin},
+		{"user group by CN", []string{usersDN}, []string{adminsDN}, []string{"NAS-Users"}, model.RoleUser},
+		{"no user groups allows everyone", []string{"cn=staff,ou=groups,dc=example,dc=com"}, []string{adminsDN}, nil, model.RoleUser},
+		{"no matching group", []string{"cn=staff,ou=groups,dc=example,dc=com"}, []string{adminsDN}, []string{"nas-users"}, ""},
+		{"no groups", nil, []string{adminsDN}, []string{"nas-users"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTestDb(t)
+			d := newFakeDirectory()
+			d.entries[0] = aliceEntry("alice@example.com", "Alice Liddell", tt.memberOf...)
+			cfg := testLdapConfig()
+			cfg.AdminGroups = tt.adminGroups
+			cfg.UserGroups = tt.userGroups
+			p := NewLdapProviderWithDialer(cfg, d.dial)
+
+			user, err := p.Authenticate("alice", "alice-secret")
+			if tt.role == "" {
+				if err == nil || !strings.Contains(err.Error(), "not a member of any allowed group") {
+					t.Errorf("Authenticate() = %v, %v, want a denial", user, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Authenticate() error = %v", err)
+			}
+			if user.Role != tt.role {
+				t.Errorf("role = %s, want %s", user.Role, tt.role)
+			}
+		})
+	}
+}
+
+func TestLdapGroupSearch(t *testing.T) {
+	useTestDb(t)
+	d := newFakeDirectory()
+	// the user entry lacks memberOf, the groups list their members
+	d.entries[0] = aliceEntry("alice@example.com", "Alice Liddell")
+	d.groups[adminsDN] = []string{aliceDN}
+	cfg := testLdapConfig()
+	cfg.GroupSearchBaseDN = "ou=groups,dc=example,dc=com"
+	p := NewLdapProviderWithDialer(cfg, d.dial)
+
+	user, err := p.Authenticate("alice", "alice-secret")
+	if err != nil {
+		t.Fatalf("Authenticate() error = %v", err)
+	}
+	if user.Role != model.RoleAdmin {
+		t.Errorf("role = %s, want admin from the group search", user.Role)
+	}
+	// groups are read as the service account, not as the user
+	if want := []string{testBindDN, aliceDN, testBindDN}; strings.Join(d.binds, "|") != strings.Join(want, "|") {
+		t.Errorf("binds = %v, want %v", d.binds, want)
+	}
+}
+
+func TestLdapProvisioning(t *testing.T) {
+	useTestDb(t)
+	d := newFakeDirectory()
+	p := NewLdapProviderWithDialer(testLdapConfig(), d.dial)
+
+	first, err := p.Authenticate("alice", "alice-secret")
+	if err != nil {
+		t.Fatalf("first Authenticate() error = %v", err)
+	}
+
+	// the directory changed between the logins
+	d.entries[0] = aliceEntry("alice.liddell@example.com", "Alice Liddell-Hargreaves", usersDN, adminsDN)
+	second, err := p.Authenticate("alice", "alice-secret")
+	if err != nil {
+		t.Fatalf("second Authenticate() error = %v", err)
+	}
+	if second.ID != first.ID {
+		t.Errorf("second login created user %d, want %d updated", second.ID, first.ID)
+	}
+
+	stored, err := db.Get[model.User](db.GetDb(), map[string]interface{}{"id": first.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Email != "alice.liddell@example.com" || stored.Name != "Alice Liddell-Hargreaves" || stored.Role != model.RoleAdmin {
+		t.Errorf("stored user = %s %q %s, want the directory values", stored.Email, stored.Name, stored.Role)
+	}
+	users, _ := db.GetList[model.User](db.GetDb(), map[string]interface{}{"auth_source": model.AuthSourceLdap})
+	if len(users) != 1 {
+		t.Errorf("ldap users = %d, want 1", len(users))
+	}
+}
+
+func TestLdapProvisioningEmailConflict(t *testing.T) {
+	useTestDb(t)
+	local := &model.User{Name: "Alice", Email: "alice@example.com", Role: model.RoleUser, AuthSource: model.AuthSourceLocal}
+	if err := db.GetDb().Insert(local); err != nil {
+		t.Fatal(err)
+	}
+	d := newFakeDirectory()
+	p := NewLdapProviderWithDialer(testLdapConfig(), d.dial)
+
+	user, err := p.Authenticate("alice", "alice-secret")
+	if user != nil || err == nil || !strings.Contains(err.Error(), "already belongs to a local user") {
+		t.Fatalf("Authenticate() = %v, %v, want a refusal", user, err)
+	}
+
+	stored, err := db.Get[model.User](db.GetDb(), map[string]interface{}{"id": local.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.AuthSource != model.AuthSourceLocal || stored.ExternalId != "" {
+		t.Errorf("local user was taken over: %s %s", stored.AuthSource, stored.ExternalId)
+	}
+}
@@ -0,0 +1,43 @@
+package auth
+
+import (
+	"github.com/whyxn/easynas/backend/pkg/db"
+	"github.com/whyxn/easynas/backend/pkg/db/model"
+	"github.com/whyxn/easynas/backend/pkg/util"
+	"sync"
+)
+
+// LocalProvider checks passwords against the bcrypt hashes stored in the DB.
+type LocalProvider struct {
+	dummyHash     string
+	dummyHashOnce sync.Once
+}
+
+func NewLocalProvider() *LocalProvider {
+	return &LocalProvider{}
+}
+
+func (p *LocalProvider) Name() string {
+	return model.AuthSourceLocal
+}
+
+func (p *LocalProvider) Authenticate(username, password string) (*model.User, error) {
+	user, _ := db.Get[model.User](db.GetDb(), map[string]interface{}{"email": username, "auth_source": model.AuthSourceLocal})
+	if user == nil {
+		// Spend the same time as for a wrong password
+		util.CheckPasswordHash(password, p.getDummyHash())
+		return nil, ErrUnknownUser
+	}
+
+	if !util.CheckPasswordHash(password, user.Password) {
+		return nil, ErrInvalidPassword
+	}
+	return user, nil
+}
+
+func (p *LocalProvider) getDummyHash() string {
+	p.dummyHashOnce.Do(func() {
+		p.dummyHash, _ = util.HashPassword("easynas-dummy-password")
+	})
+	return p.dummyHash
+}
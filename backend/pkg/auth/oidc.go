@@ -0,0 +1,470 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/whyxn/easynas/backend/pkg/config"
+	"github.com/whyxn/easynas/backend/pkg/db/model"
+	"github.com/whyxn/easynas/backend/pkg/util"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// oidcLoginTimeout is how long a user has to complete the login at the identity provider
+	oidcLoginTimeout = 10 * time.Minute
+	// oidcMetadataTTL is how long discovery documents and signing keys are cached
+	oidcMetadataTTL = time.Hour
+)
+
+// OidcConfig describes the OpenID Connect identity provider and how its
+// claims map to easynas users.
+type OidcConfig = config.OidcConfig
+
+type oidcMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksURI               string `json:"jwks_uri"`
+}
+
+type oidcPendingLogin struct {
+	nonce        string
+	codeVerifier string
+	expiresAt    time.Time
+}
+
+// OidcProvider implements the authorization code flow with PKCE.
+type OidcProvider struct {
+	cfg    OidcConfig
+	client *http.Client
+
+	mu          sync.Mutex
+	metadata    *oidcMetadata
+	metadataAt  time.Time
+	keys        map[string]interface{}
+	keysAt      time.Time
+	pendingAuth map[string]oidcPendingLogin
+}
+
+func NewOidcProvider(cfg OidcConfig) *OidcProvider {
+	return NewOidcProviderWithClient(cfg, &http.Client{Timeout: 10 * time.Second})
+}
+
+// NewOidcProviderWithClient creates a provider talking to the identity provider through client.
+func NewOidcProviderWithClient(cfg OidcConfig, client *http.Client) *OidcProvider {
+	cfg.IssuerURL = strings.TrimSuffix(cfg.IssuerURL, "/")
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "profile", "email"}
+	}
+	if cfg.EmailClaim == "" {
+		cfg.EmailClaim = "email"
+	}
+	if cfg.NameClaim == "" {
+		cfg.NameClaim = "name"
+	}
+	if cfg.RoleClaim == "" {
+		cfg.RoleClaim = "groups"
+	}
+	if cfg.DisplayName == "" {
+		cfg.DisplayName = "Single Sign-On"
+	}
+	return &OidcProvider{cfg: cfg, client: client, pendingAuth: map[string]oidcPendingLogin{}}
+}
+
+func (p *OidcProvider) Name() string {
+	return model.AuthSourceOidc
+}
+
+func (p *OidcProvider) DisplayName() string {
+	return p.cfg.DisplayName
+}
+
+func (p *OidcProvider) PostLoginRedirectURL() string {
+	return p.cfg.PostLoginRedirectURL
+}
+
+// BeginLogin returns the URL of the identity provider the user has to be
+// sent to, and the state that identifies the login when it comes back.
+func (p *OidcProvider) BeginLogin() (authURL string, state string, err error) {
+	metadata, err := p.getMetadata()
+	if err != nil {
+		return "", "", err
+	}
+
+	state, err = util.GenerateRandomToken(24)
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := util.GenerateRandomToken(24)
+	if err != nil {
+		return "", "", err
+	}
+	codeVerifier, err := util.GenerateRandomToken(48)
+	if err != nil {
+		return "", "", err
+	}
+	challenge := sha256.Sum256([]byte(codeVerifier))
+
+	p.mu.Lock()
+	now := time.Now()
+	for s, pending := range p.pendingAuth {
+		if now.After(pending.expiresAt) {
+			delete(p.pendingAuth, s)
+		}
+	}
+	p.pendingAuth[state] = oidcPendingLogin{nonce: nonce, codeVerifier: codeVerifier, expiresAt: now.Add(oidcLoginTimeout)}
+	p.mu.Unlock()
+
+	v := url.Values{}
+	v.Set("response_type", "code")
+	v.Set("client_id", p.cfg.ClientID)
+	v.Set("redirect_uri", p.cfg.RedirectURL)
+	v.Set("scope", strings.Join(p.cfg.Scopes, " "))
+	v.Set("state", state)
+	v.Set("nonce", nonce)
+	v.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	v.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(metadata.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return metadata.AuthorizationEndpoint + separator + v.Encode(), state, nil
+}
+
+// CompleteLogin exchanges the authorization code, validates the ID token and
+// returns the provisioned easynas user.
+func (p *OidcProvider) CompleteLogin(code, state string) (*model.User, error) {
+	p.mu.Lock()
+	pending, ok := p.pendingAuth[state]
+	delete(p.pendingAuth, state)
+	p.mu.Unlock()
+
+	if !ok || time.Now().After(pending.expiresAt) {
+		return nil, errors.New("unknown or expired login state")
+	}
+
+	metadata, err := p.getMetadata()
+	if err != nil {
+		return nil, err
+	}
+
+	rawIDToken, err := p.exchangeCode(metadata, code, pending.codeVerifier)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(rawIDToken, claims, p.keyFunc,
+		jwt.WithIssuer(metadata.Issuer),
+		jwt.WithAudience(p.cfg.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid id token: %w", err)
+	}
+
+	if nonce, _ := claims["nonce"].(string); nonce != pending.nonce {
+		return nil, errors.New("invalid id token: nonce mismatch")
+	}
+
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		return nil, errors.New("invalid id token: missing subject")
+	}
+
+	role := p.mapRole(claimValues(claims[p.cfg.RoleClaim]))
+	if role == "" {
+		return nil, errors.New("user is not allowed to log in to easynas")
+	}
+
+	identity := ExternalIdentity{
+		Source:     model.AuthSourceOidc,
+		ExternalId: subject,
+		Email:      claimString(claims, p.cfg.EmailClaim),
+		Name:       claimString(claims, p.cfg.NameClaim),
+		Role:       role,
+	}
+	if identity.Name == "" {
+		identity.Name = identity.Email
+	}
+	if p.cfg.NasClientIPClaim != "" {
+		identity.NasClientIP = claimString(claims, p.cfg.NasClientIPClaim)
+	}
+	user, err := Provision(identity)
+	if err == nil && user.Disabled {
+		return nil, ErrUserDisabled
+	}
+	return user, err
+}
+
+func (p *OidcProvider) exchangeCode(metadata *oidcMetadata, code, codeVerifier string) (string, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.cfg.RedirectURL)
+	form.Set("client_id", p.cfg.ClientID)
+	form.Set("code_verifier", codeVerifier)
+
+	req, err := http.NewRequest(http.MethodPost, metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.cfg.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to exchange authorization code: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("failed to decode token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token endpoint returned %d: %s %s", resp.StatusCode, body.Error, body.ErrorDescription)
+	}
+	if body.IDToken == "" {
+		return "", errors.New("token response contains no id token")
+	}
+	return body.IDToken, nil
+}
+
+func (p *OidcProvider) getMetadata() (*oidcMetadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.metadata != nil && time.Since(p.metadataAt) < oidcMetadataTTL {
+		return p.metadata, nil
+	}
+
+	var metadata oidcMetadata
+	if err := p.getJSON(p.cfg.IssuerURL+"/.well-known/openid-configuration", &metadata); err != nil {
+		return nil, fmt.Errorf("failed to discover openid configuration: %w", err)
+	}
+	if strings.TrimSuffix(metadata.Issuer, "/") != p.cfg.IssuerURL {
+		return nil, fmt.Errorf("issuer '%s' of discovery document does not match '%s'", metadata.Issuer, p.cfg.IssuerURL)
+	}
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JwksURI == "" {
+		return nil, errors.New("discovery document is missing endpoints")
+	}
+
+	p.metadata = &metadata
+	p.metadataAt = time.Now()
+	return p.metadata, nil
+}
+
+// keyFunc returns the signing key of a token. The key set is fetched again
+// when a token refers to an unknown key, as the provider may have rotated keys.
+func (p *OidcProvider) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	metadata, err := p.getMetadata()
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key := p.findKey(kid); key != nil && time.Since(p.keysAt) < oidcMetadataTTL {
+		return key, nil
+	}
+
+	keys, err := p.fetchKeys(metadata.JwksURI)
+	if err != nil {
+		return nil, err
+	}
+	p.keys = keys
+	p.keysAt = time.Now()
+
+	if key := p.findKey(kid); key != nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key '%s'", kid)
+}
+
+func (p *OidcProvider) findKey(kid string) interface{} {
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key
+		}
+	}
+	return p.keys[kid]
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (p *OidcProvider) fetchKeys(jwksURI string) (map[string]interface{}, error) {
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := p.getJSON(jwksURI, &set); err != nil {
+		return nil, fmt.Errorf("failed to fetch signing keys: %w", err)
+	}
+
+	keys := map[string]interface{}{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := parseJSONWebKey(k)
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	return keys, nil
+}
+
+func parseJSONWebKey(k jsonWebKey) (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve '%s'", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type '%s'", k.Kty)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+func (p *OidcProvider) getJSON(u string, v interface{}) error {
+	resp, err := p.client.Get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned %d", u, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (p *OidcProvider) mapRole(values []string) string {
+	if containsFold(values, p.cfg.AdminValues) {
+		return model.RoleAdmin
+	}
+	if len(p.cfg.UserValues) == 0 || containsFold(values, p.cfg.UserValues) {
+		return model.RoleUser
+	}
+	return ""
+}
+
+func containsFold(values []string, wanted []string) bool {
+	for _, v := range values {
+		for _, w := range wanted {
+			if strings.EqualFold(v, w) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// claimValues returns a claim that is either a string or a list of strings as a list
+func claimValues(claim interface{}) []string {
+	switch v := claim.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var values []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+func claimString(claims jwt.MapClaims, name string) string {
+	s, _ := claims[name].(string)
+	return s
+}
+
+var (
+	oidcProvider   *OidcProvider
+	oidcProviderMu sync.RWMutex
+)
+
+// ConfigureOidc enables single sign-on through the provider. Pass nil to disable it.
+func ConfigureOidc(p *OidcProvider) {
+	oidcProviderMu.Lock()
+	defer oidcProviderMu.Unlock()
+	oidcProvider = p
+}
+
+// Oidc returns the configured OpenID Connect provider or nil.
+func Oidc() *OidcProvider {
+	oidcProviderMu.RLock()
+	defer oidcProviderMu.RUnlock()
+	return oidcProvider
+}
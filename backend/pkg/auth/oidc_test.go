@@ -0,0 +1,411 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/whyxn/easynas/backend/pkg/db/model"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testClientID     = "easynas"
+	testClientSecret = "client-secret"
+	testRedirectURL  = "https://nas.example.com/api/v1/auth/oidc/callback"
+)
+
+// authorization is a code the mock issuer handed out
+type authorization struct {
+	challenge string
+	nonce     string
+	claims    jwt.MapClaims
+}
+
+// mockIssuer is an OpenID Connect identity provider serving discovery, the
+// key set and the token endpoint. ID tokens are signed with the key of
+// signingKid, the key set only publishes the keys in published.
+type mockIssuer struct {
+	t   *testing.T
+	srv *httptest.Server
+
+	mu          sync.Mutex
+	keys        map[string]*rsa.PrivateKey
+	published   []string
+	signingKid  string
+	codes       map[string]authorization
+	issuedCodes int
+	jwksFetches int
+	// modify changes the claims of the next ID tokens
+	modify func(claims jwt.MapClaims)
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	m := &mockIssuer{t: t, keys: map[string]*rsa.PrivateKey{}, codes: map[string]authorization{}}
+	m.addKey("key-1", true)
+	m.signingKid = "key-1"
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 m.srv.URL,
+			"authorization_endpoint": m.srv.URL + "/authorize",
+			"token_endpoint":         m.srv.URL + "/token",
+			"jwks_uri":               m.srv.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", m.serveKeys)
+	mux.HandleFunc("/token", m.serveToken)
+	m.srv = httptest.NewServer(mux)
+	t.Cleanup(m.srv.Close)
+	return m
+}
+
+func (m *mockIssuer) addKey(kid string, publish bool) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.keys[kid] = key
+	if publish {
+		m.published = append(m.published, kid)
+	}
+}
+
+func (m *mockIssuer) signWith(kid string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.signingKid = kid
+}
+
+func (m *mockIssuer) serveKeys(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.jwksFetches++
+
+	var keys []map[string]string
+	for _, kid := range m.published {
+		public := m.keys[kid].PublicKey
+		keys = append(keys, map[string]string{
+			"kty": "RSA",
+			"kid": kid,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+		})
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+}
+
+func (m *mockIssuer) serveToken(w http.ResponseWriter, r *http.Request) {
+	tokenError := func(code string) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": code})
+	}
+
+	clientID, secret, _ := r.BasicAuth()
+	if clientID != testClientID || secret != testClientSecret {
+		tokenError("invalid_client")
+		return
+	}
+	if r.PostFormValue("grant_type") != "authorization_code" || r.PostFormValue("redirect_uri") != testRedirectURL {
+		tokenError("invalid_request")
+		return
+	}
+
+	m.mu.Lock()
+	auth, ok := m.codes[r.PostFormValue("code")]
+	delete(m.codes, r.PostFormValue("code"))
+	m.mu.Unlock()
+	if !ok {
+		tokenError("invalid_grant")
+		return
+	}
+	// PKCE, the verifier must hash to the challenge of the authorization
+	verifier := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(verifier[:]) != auth.challenge {
+		tokenError("invalid_grant")
+		return
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":   m.srv.URL,
+		"aud":   testClientID,
+		"sub":   "248289761001",
+		"iat":   now.Unix(),
+		"exp":   now.Add(5 * time.Minute).Unix(),
+		"nonce": auth.nonce,
+	}
+	for name, value := range auth.claims {
+		claims[name] = value
+	}
+	m.mu.Lock()
+	if m.modify != nil {
+		m.modify(claims)
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = m.signingKid
+	signed, err := token.SignedString(m.keys[m.signingKid])
+	m.mu.Unlock()
+	if err != nil {
+		m.t.Error(err)
+		tokenError("server_error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token": "access",
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     signed,
+	})
+}
+
+// authorize plays the user logging in at the identity provider and returns
+// the code the browser brings back to easynas
+func (m *mockIssuer) authorize(authURL string, claims jwt.MapClaims) string {
+	m.t.Helper()
+	u, err := url.Parse(authURL)
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	query := u.Query()
+	if query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		m.t.Fatalf("authorization request without PKCE: %s", authURL)
+	}
+
+	m.mu.Lock()
+	m.issuedCodes++
+	code := fmt.Sprintf("code-%d", m.issuedCodes)
+	m.codes[code] = authorization{challenge: query.Get("code_challenge"), nonce: query.Get("nonce"), claims: claims}
+	m.mu.Unlock()
+	return code
+}
+
+func (m *mockIssuer) keyFetches() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.jwksFetches
+}
+
+func (m *mockIssuer) provider(adminValues, userValues []string) *OidcProvider {
+	return NewOidcProviderWithClient(OidcConfig{
+		IssuerURL:    m.srv.URL + "/",
+		ClientID:     testClientID,
+		ClientSecret: testClientSecret,
+		RedirectURL:  testRedirectURL,
+		AdminValues:  adminValues,
+		UserValues:   userValues,
+	}, m.srv.Client())
+}
+
+func aliceClaims(groups ...interface{}) jwt.MapClaims {
+	return jwt.MapClaims{
+		"email":  "alice@example.com",
+		"name":   "Alice Liddell",
+		"groups": groups,
+	}
+}
+
+// login runs a whole login and returns the outcome of CompleteLogin
+func login(t *testing.T, m *mockIssuer, p *OidcProvider, claims jwt.MapClaims) (*model.User, error) {
+	t.Helper()
+	authURL, state, err := p.BeginLogin()
+	if err != nil {
+		t.Fatalf("BeginLogin() error = %v", err)
+	}
+	return p.CompleteLogin(m.authorize(authURL, claims), state)
+}
+
+func TestOidcLogin(t *testing.T) {
+	useTestDb(t)
+	m := newMockIssuer(t)
+	p := m.provider([]string{"nas-admins"}, []string{"nas-users"})
+
+	authURL, state, err := p.BeginLogin()
+	if err != nil {
+		t.Fatalf("BeginLogin() error = %v", err)
+	}
+	if !strings.HasPrefix(authURL, m.srv.URL+"/authorize?") {
+		t.Errorf("authorization URL = %s", authURL)
+	}
+	u, _ := url.Parse(authURL)
+	query := u.Query()
+	for name, want := range map[string]string{
+		"response_type": "code",
+		"client_id":     testClientID,
+		"redirect_uri":  testRedirectURL,
+		"scope":         "openid profile email",
+		"state":         state,
+	} {
+		if query.Get(name) != want {
+			t.Errorf("authorization parameter %s = %q, want %q", name, query.Get(name), want)
+		}
+	}
+	if query.Get("nonce") == "" {
+		t.Error("authorization request without nonce")
+	}
+
+	code := m.authorize(authURL, aliceClaims("nas-users"))
+	user, err := p.CompleteLogin(code, state)
+	if err != nil {
+		t.Fatalf("CompleteLogin() error = %v", err)
+	}
+	if user.Email != "alice@example.com" || user.Name != "Alice Liddell" || user.Role != model.RoleUser {
+		t.Errorf("user = %s %q %s", user.Email, user.Name, user.Role)
+	}
+	if user.AuthSource != model.AuthSourceOidc || user.ExternalId != "248289761001" {
+		t.Errorf("user source = %s %s, want oidc and the subject", user.AuthSource, user.ExternalId)
+	}
+
+	// a state is only good for one login
+	if _, err = p.CompleteLogin(m.authorize(authURL, aliceClaims("nas-users")), state); err == nil {
+		t.Error("CompleteLogin() accepted a used state")
+	}
+}
+
+func TestOidcCodeVerifier(t *testing.T) {
+	useTestDb(t)
+	m := newMockIssuer(t)
+	p := m.provider(nil, nil)
+
+	firstURL, _, err := p.BeginLogin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, secondState, err := p.BeginLogin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the code of the first login brought back with the state of the second
+	// one is sent with the wrong verifier
+	_, err = p.CompleteLogin(m.authorize(firstURL, aliceClaims()), secondState)
+	if err == nil || !strings.Contains(err.Error(), "invalid_grant") {
+		t.Errorf("CompleteLogin() error = %v, want the token endpoint to refuse the verifier", err)
+	}
+}
+
+func TestOidcInvalidIDToken(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(claims jwt.MapClaims)
+		err    string
+	}{
+		{"nonce mismatch", func(c jwt.MapClaims) { c["nonce"] = "replayed" }, "nonce mismatch"},
+		{"missing nonce", func(c jwt.MapClaims) { delete(c, "nonce") }, "nonce mismatch"},
+		{"wrong audience", func(c jwt.MapClaims) { c["aud"] = "other-client" }, "invalid id token"},
+		{"wrong issuer", func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" }, "invalid id token"},
+		{"expired", func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Minute).Unix() }, "invalid id token"},
+		{"missing subject", func(c jwt.MapClaims) { delete(c, "sub") }, "missing subject"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTestDb(t)
+			m := newMockIssuer(t)
+			m.modify = tt.modify
+			p := m.provider(nil, nil)
+
+			user, err := login(t, m, p, aliceClaims())
+			if user != nil || err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("CompleteLogin() = %v, %v, want %q", user, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestOidcKeyRotation(t *testing.T) {
+	useTestDb(t)
+	m := newMockIssuer(t)
+	p := m.provider(nil, nil)
+
+	if _, err := login(t, m, p, aliceClaims()); err != nil {
+		t.Fatalf("first login error = %v", err)
+	}
+	if _, err := login(t, m, p, aliceClaims()); err != nil {
+		t.Fatalf("second login error = %v", err)
+	}
+	if m.keyFetches() != 1 {
+		t.Errorf("key set fetched %d times, want once while the key is known", m.keyFetches())
+	}
+
+	// the issuer rotates to a new key, the cached key set lacks it
+	m.addKey("key-2", true)
+	m.signWith("key-2")
+	if _, err := login(t, m, p, aliceClaims()); err != nil {
+		t.Fatalf("login after key rotation error = %v", err)
+	}
+	if m.keyFetches() != 2 {
+		t.Errorf("key set fetched %d times, want a refetch for the unknown key", m.keyFetches())
+	}
+
+	// a key the issuer never published is refused after looking again
+	m.addKey("key-forged", false)
+	m.signWith("key-forged")
+	_, err := login(t, m, p, aliceClaims())
+	if err == nil || !strings.Contains(err.Error(), "unknown signing key 'key-forged'") {
+		t.Errorf("CompleteLogin() error = %v, want an unknown signing key", err)
+	}
+	if m.keyFetches() != 3 {
+		t.Errorf("key set fetched %d times, want 3", m.keyFetches())
+	}
+}
+
+func TestOidcRoleMapping(t *testing.T) {
+	tests := []struct {
+		name        string
+		groups      interface{}
+		adminValues []string
+		userValues  []string
+		role        string
+	}{
+		{"admin value", []interface{}{"staff", "NAS-Admins"}, []string{"nas-admins"}, []string{"nas-users"}, model.RoleAdmin},
+		{"user value", []interface{}{"nas-users"}, []string{"nas-admins"}, []string{"nas-users"}, model.RoleUser},
+		{"string claim", "nas-admins", []string{"nas-admins"}, []string{"nas-users"}, model.RoleAdmin},
+		{"no user values allows everyone", []interface{}{"staff"}, []string{"nas-admins"}, nil, model.RoleUser},
+		{"no matching value", []interface{}{"staff"}, []string{"nas-admins"}, []string{"nas-users"}, ""},
+		{"no claim", nil, []string{"nas-admins"}, []string{"nas-users"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTestDb(t)
+			m := newMockIssuer(t)
+			p := m.provider(tt.adminValues, tt.userValues)
+			claims := aliceClaims()
+			if tt.groups == nil {
+				delete(claims, "groups")
+			} else {
+				claims["groups"] = tt.groups
+			}
+
+			user, err := login(t, m, p, claims)
+			if tt.role == "" {
+				if user != nil || err == nil || !strings.Contains(err.Error(), "not allowed to log in") {
+					t.Errorf("CompleteLogin() = %v, %v, want a refusal", user, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("CompleteLogin() error = %v", err)
+			}
+			if user.Role != tt.role {
+				t.Errorf("role = %s, want %s", user.Role, tt.role)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package auth
+
+import (
+	"errors"
+	"github.com/whyxn/easynas/backend/pkg/db/model"
+	"sync"
+)
+
+var (
+	ErrUnknownUser     = errors.New("unknown user")
+	ErrInvalidPassword = errors.New("invalid password")
+	ErrUserDisabled    = errors.New("user is disabled")
+)
+
+// IsCredentialError tells whether a login failed for an unknown user, a
+// wrong password or a disabled user. Logins answer all of them alike, so
+// clients can not tell which users exist.
+func IsCredentialError(err error) bool {
+	return errors.Is(err, ErrUnknownUser) || errors.Is(err, ErrInvalidPassword) || errors.Is(err, ErrUserDisabled)
+}
+
+// Provider authenticates users with a username and password against an identity source.
+type Provider interface {
+	// Name identifies the provider and is stored as the auth source of the users it manages.
+	Name() string
+	// Authenticate returns the easynas user behind the credentials. It returns
+	// ErrUnknownUser if the provider does not know the user, so the next
+	// provider can be tried.
+	Authenticate(username, password string) (*model.User, error)
+}
+
+var (
+	providers []Provider
+	mu        sync.RWMutex
+)
+
+// Configure sets the providers that are tried, in order, on login.
+func Configure(p ...Provider) {
+	mu.Lock()
+	defer mu.Unlock()
+	providers = p
+}
+
+// Providers returns the configured providers.
+func Providers() []Provider {
+	mu.RLock()
+	defer mu.RUnlock()
+	return providers
+}
+
+// Authenticate tries every configured provider until one of them knows the
+// user. Any error other than ErrUnknownUser stops the search.
+func Authenticate(username, password string) (*model.User, error) {
+	for _, p := range Providers() {
+		user, err := p.Authenticate(username, password)
+		if errors.Is(err, ErrUnknownUser) {
+			continue
+		}
+		if err == nil && user.Disabled {
+			return nil, ErrUserDisabled
+		}
+		return user, err
+	}
+	return nil, ErrUnknownUser
+}
@@ -0,0 +1,61 @@
+package auth
+
+import (
+	"fmt"
+	"github.com/whyxn/easynas/backend/pkg/db"
+	"github.com/whyxn/easynas/backend/pkg/db/model"
+)
+
+// ExternalIdentity is a user as reported by an external identity source.
+type ExternalIdentity struct {
+	Source      string
+	ExternalId  string
+	Email       string
+	Name        string
+	Role        string
+	NasClientIP string
+}
+
+// Provision returns the easynas user of an external identity, creating it on
+// first login. Name, email and role are kept in sync with the identity source
+// on every login. The NAS client IP is only taken over when the source
+// provides one, otherwise admins keep managing it in easynas.
+func Provision(identity ExternalIdentity) (*model.User, error) {
+	if identity.Email == "" {
+		return nil, fmt.Errorf("identity from %s has no email address", identity.Source)
+	}
+
+	user, _ := db.Get[model.User](db.GetDb(), map[string]interface{}{"auth_source": identity.Source, "external_id": identity.ExternalId})
+	if user == nil {
+		existing, _ := db.Get[model.User](db.GetDb(), map[string]interface{}{"email": identity.Email})
+		if existing != nil {
+			return nil, fmt.Errorf("email '%s' already belongs to a %s user", identity.Email, existing.AuthSource)
+		}
+
+		user = &model.User{
+			Name:        identity.Name,
+			Email:       identity.Email,
+			NasClientIP: identity.NasClientIP,
+			Role:        identity.Role,
+			AuthSource:  identity.Source,
+			ExternalId:  identity.ExternalId,
+		}
+		if err := db.GetDb().Insert(user); err != nil {
+			return nil, err
+		}
+		return user, nil
+	}
+
+	updates := map[string]interface{}{
+		"name":  identity.Name,
+		"email": identity.Email,
+		"role":  identity.Role,
+	}
+	if identity.NasClientIP != "" {
+		updates["nas_client_ip"] = identity.NasClientIP
+	}
+	if err := db.GetDb().Update(user, updates); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
@@ -0,0 +1,358 @@
+package config
+
+import (
+	"sync"
+	"time"
+)
+
+// Config is the static configuration of easynas. It is read once at
+// startup; settings that can change at runtime live in the settings package.
+//
+// Every value can be set in the config file, most of them through an
+// EASYNAS_* environment variable and the common ones through a command line
+// flag. Flags take precedence over environment variables, which take
+// precedence over the file.
+type Config struct {
+	Server   ServerConfig   `yaml:"server" toml:"server"`
+	Database DatabaseConfig `yaml:"database" toml:"database"`
+	Log      LogConfig      `yaml:"log" toml:"log"`
+	Nas      NasConfig      `yaml:"nas" toml:"nas"`
+	Jwt      JwtConfig      `yaml:"jwt" toml:"jwt"`
+	Commands CommandsConfig `yaml:"commands" toml:"commands"`
+	Jobs     JobsConfig     `yaml:"jobs" toml:"jobs"`
+	Events   EventsConfig   `yaml:"events" toml:"events"`
+	Metrics  MetricsConfig  `yaml:"metrics" toml:"metrics"`
+	History  HistoryConfig  `yaml:"history" toml:"history"`
+	Forecast ForecastConfig `yaml:"forecast" toml:"forecast"`
+	Alerts   AlertsConfig   `yaml:"alerts" toml:"alerts"`
+	Disks    DisksConfig    `yaml:"disks" toml:"disks"`
+	Ldap     LdapConfig     `yaml:"ldap" toml:"ldap"`
+	Oidc     OidcConfig     `yaml:"oidc" toml:"oidc"`
+
+	// File is the config file the configuration was read from, if any
+	File string `yaml:"-" toml:"-"`
+}
+
+type ServerConfig struct {
+	Listen string `yaml:"listen" toml:"listen" env:"EASYNAS_LISTEN" flag:"listen" usage:"address the web server listens on"`
+	// CorsOrigins are the origins allowed to call the API from a browser.
+	// Empty or "*" allows every origin.
+	CorsOrigins []string  `yaml:"corsOrigins" toml:"corsOrigins" env:"EASYNAS_CORS_ORIGINS" flag:"cors-origins" usage:"comma separated origins allowed by CORS"`
+	TLS         TLSConfig `yaml:"tls" toml:"tls"`
+	// ShutdownTimeout is how long in-flight requests and background jobs may
+	// take to finish on shutdown before they are cancelled
+	ShutdownTimeout Duration `yaml:"shutdownTimeout" toml:"shutdownTimeout" env:"EASYNAS_SHUTDOWN_TIMEOUT" flag:"shutdown-timeout" usage:"time given to in-flight work on shutdown"`
+	// ShutdownDelay keeps accepting connections after shutdown starts while
+	// /health/ready fails, so load balancers stop sending traffic first
+	ShutdownDelay Duration `yaml:"shutdownDelay" toml:"shutdownDelay" env:"EASYNAS_SHUTDOWN_DELAY"`
+}
+
+// TLSConfig configures HTTPS. When enabled and the certificate files do not
+// exist, a self-signed certificate is generated and written to them.
+type TLSConfig struct {
+	Enabled  bool   `yaml:"enabled" toml:"enabled" env:"EASYNAS_TLS_ENABLED" flag:"tls" usage:"serve HTTPS"`
+	CertFile string `yaml:"certFile" toml:"certFile" env:"EASYNAS_TLS_CERT_FILE" flag:"tls-cert" usage:"path of the PEM certificate chain"`
+	KeyFile  string `yaml:"keyFile" toml:"keyFile" env:"EASYNAS_TLS_KEY_FILE" flag:"tls-key" usage:"path of the PEM private key"`
+	// Hosts are the DNS names and addresses of the generated self-signed
+	// certificate. The hostname and localhost are used if empty.
+	Hosts []string `yaml:"hosts" toml:"hosts" env:"EASYNAS_TLS_HOSTS"`
+	// RedirectListen is a plain HTTP address that redirects to HTTPS, like ":80".
+	// Nothing listens for plain HTTP if empty.
+	RedirectListen string `yaml:"redirectListen" toml:"redirectListen" env:"EASYNAS_TLS_REDIRECT_LISTEN" flag:"tls-redirect-listen" usage:"plain HTTP address redirecting to HTTPS"`
+	// ReloadInterval is how often the certificate files are checked for changes
+	ReloadInterval Duration `yaml:"reloadInterval" toml:"reloadInterval" env:"EASYNAS_TLS_RELOAD_INTERVAL"`
+	// HstsMaxAge is announced in the Strict-Transport-Security header. Zero disables HSTS.
+	HstsMaxAge            Duration `yaml:"hstsMaxAge" toml:"hstsMaxAge" env:"EASYNAS_TLS_HSTS_MAX_AGE"`
+	HstsIncludeSubdomains bool     `yaml:"hstsIncludeSubdomains" toml:"hstsIncludeSubdomains" env:"EASYNAS_TLS_HSTS_INCLUDE_SUBDOMAINS"`
+}
+
+type DatabaseConfig struct {
+	Path string `yaml:"path" toml:"path" env:"EASYNAS_DB_PATH" flag:"db" usage:"path of the SQLite database"`
+}
+
+type LogConfig struct {
+	// Level is one of debug, info, warn or error
+	Level string `yaml:"level" toml:"level" env:"EASYNAS_LOG_LEVEL" flag:"log-level" usage:"log level (debug, info, warn, error)"`
+}
+
+type NasConfig struct {
+	// Pools are the ZFS pools easynas manages
+	Pools []string `yaml:"pools" toml:"pools" env:"EASYNAS_POOLS" flag:"pools" usage:"comma separated ZFS pools to manage"`
+	// DefaultPool is used when a request does not name a pool. Defaults to the first of Pools.
+	DefaultPool string `yaml:"defaultPool" toml:"defaultPool" env:"EASYNAS_DEFAULT_POOL" flag:"default-pool" usage:"pool used when a request names none"`
+	// DefaultClientIP is always granted read-write access to NFS shares
+	DefaultClientIP string `yaml:"defaultClientIP" toml:"defaultClientIP" env:"EASYNAS_DEFAULT_CLIENT_IP" flag:"default-client-ip" usage:"address granted read-write access to every NFS share"`
+	// ArcStats is the kstat file OpenZFS exposes the ARC counters in
+	ArcStats string `yaml:"arcStats" toml:"arcStats" env:"EASYNAS_ARC_STATS_PATH" flag:"arc-stats" usage:"path of the ZFS arcstats file"`
+}
+
+type JwtConfig struct {
+	// Secret signs access tokens. A random one is generated at startup if
+	// empty, which signs everybody out of the web UI on restart.
+	Secret         string   `yaml:"secret" toml:"secret" env:"EASYNAS_JWT_SECRET" secret:"true"`
+	AccessTokenTTL Duration `yaml:"accessTokenTtl" toml:"accessTokenTtl" env:"EASYNAS_JWT_ACCESS_TOKEN_TTL"`
+	MfaTokenTTL    Duration `yaml:"mfaTokenTtl" toml:"mfaTokenTtl" env:"EASYNAS_JWT_MFA_TOKEN_TTL"`
+}
+
+// JobsConfig configures the background job workers
+type JobsConfig struct {
+	// Workers is the number of jobs running at the same time
+	Workers int `yaml:"workers" toml:"workers" env:"EASYNAS_JOB_WORKERS" flag:"job-workers" usage:"number of background jobs running at the same time"`
+	// Retention is how long finished jobs are kept
+	Retention Duration `yaml:"retention" toml:"retention" env:"EASYNAS_JOB_RETENTION"`
+}
+
+// EventsConfig configures the event stream
+type EventsConfig struct {
+	// History is how many events are kept for clients resuming a stream
+	History int `yaml:"history" toml:"history" env:"EASYNAS_EVENTS_HISTORY"`
+	// PoolInterval is how often pool health and scrub progress are checked
+	PoolInterval Duration `yaml:"poolInterval" toml:"poolInterval" env:"EASYNAS_EVENTS_POOL_INTERVAL"`
+	// MetricsInterval is how often system metrics are sent to subscribed clients
+	MetricsInterval Duration `yaml:"metricsInterval" toml:"metricsInterval" env:"EASYNAS_EVENTS_METRICS_INTERVAL"`
+}
+
+// MetricsConfig configures the Prometheus endpoint at /metrics
+type MetricsConfig struct {
+	Enabled bool `yaml:"enabled" toml:"enabled" env:"EASYNAS_METRICS_ENABLED" flag:"metrics" usage:"serve Prometheus metrics at /metrics"`
+	// Token must be sent as a bearer token by the scraper when set
+	Token string `yaml:"token" toml:"token" env:"EASYNAS_METRICS_TOKEN" secret:"true"`
+	// AllowedIPs are the addresses and CIDR ranges allowed to scrape, matched
+	// against the connecting address. Every address is allowed if empty.
+	AllowedIPs []string `yaml:"allowedIPs" toml:"allowedIPs" env:"EASYNAS_METRICS_ALLOWED_IPS"`
+}
+
+// HistoryConfig configures the recorded history of host, pool and dataset
+// metrics. Samples are rolled up into 5 minute and hourly averages, each kept
+// for its own retention.
+type HistoryConfig struct {
+	Enabled bool `yaml:"enabled" toml:"enabled" env:"EASYNAS_HISTORY_ENABLED"`
+	// Interval is how often samples are taken
+	Interval            Duration `yaml:"interval" toml:"interval" env:"EASYNAS_HISTORY_INTERVAL"`
+	RawRetention        Duration `yaml:"rawRetention" toml:"rawRetention" env:"EASYNAS_HISTORY_RAW_RETENTION"`
+	FiveMinuteRetention Duration `yaml:"fiveMinuteRetention" toml:"fiveMinuteRetention" env:"EASYNAS_HISTORY_FIVE_MINUTE_RETENTION"`
+	HourlyRetention     Duration `yaml:"hourlyRetention" toml:"hourlyRetention" env:"EASYNAS_HISTORY_HOURLY_RETENTION"`
+}
+
+// ForecastConfig configures the capacity forecasts, which are fitted to the
+// recorded history
+type ForecastConfig struct {
+	// Window is how much history the growth trend is fitted to
+	Window Duration `yaml:"window" toml:"window" env:"EASYNAS_FORECAST_WINDOW"`
+	// Thresholds are the usage percentages of the capacity, or the quota of
+	// a dataset, that days until reached are projected for
+	Thresholds []int `yaml:"thresholds" toml:"thresholds" env:"EASYNAS_FORECAST_THRESHOLDS"`
+}
+
+// AlertsConfig configures the alert rules engine. Rules and notification
+// channels are managed through the API.
+type AlertsConfig struct {
+	Enabled bool `yaml:"enabled" toml:"enabled" env:"EASYNAS_ALERTS_ENABLED"`
+	// Interval is how often the rules are checked
+	Interval Duration `yaml:"interval" toml:"interval" env:"EASYNAS_ALERTS_INTERVAL"`
+	// SendTimeout limits the time a notification channel may take
+	SendTimeout Duration `yaml:"sendTimeout" toml:"sendTimeout" env:"EASYNAS_ALERTS_SEND_TIMEOUT"`
+	// Retention is how long resolved alerts are kept
+	Retention Duration `yaml:"retention" toml:"retention" env:"EASYNAS_ALERTS_RETENTION"`
+}
+
+// DisksConfig configures SMART monitoring of the physical disks. A self-test
+// interval of 0 disables that test.
+type DisksConfig struct {
+	Enabled bool `yaml:"enabled" toml:"enabled" env:"EASYNAS_DISKS_ENABLED"`
+	// Interval is how often the SMART data of the disks is read
+	Interval Duration `yaml:"interval" toml:"interval" env:"EASYNAS_DISKS_INTERVAL"`
+	// ShortTestInterval and LongTestInterval are how often each disk runs
+	// a short and a long self-test
+	ShortTestInterval Duration `yaml:"shortTestInterval" toml:"shortTestInterval" env:"EASYNAS_DISKS_SHORT_TEST_INTERVAL"`
+	LongTestInterval  Duration `yaml:"longTestInterval" toml:"longTestInterval" env:"EASYNAS_DISKS_LONG_TEST_INTERVAL"`
+}
+
+// CommandsConfig holds the paths of the external commands easynas runs.
+// Plain names are looked up in PATH.
+type CommandsConfig struct {
+	Zfs   string `yaml:"zfs" toml:"zfs" env:"EASYNAS_ZFS_PATH" flag:"zfs" usage:"path of the zfs command"`
+	Zpool string `yaml:"zpool" toml:"zpool" env:"EASYNAS_ZPOOL_PATH" flag:"zpool" usage:"path of the zpool command"`
+	Sudo  string `yaml:"sudo" toml:"sudo" env:"EASYNAS_SUDO_PATH"`
+	Chown string `yaml:"chown" toml:"chown" env:"EASYNAS_CHOWN_PATH"`
+	Lsblk string `yaml:"lsblk" toml:"lsblk" env:"EASYNAS_LSBLK_PATH"`
+	// Smartctl is run through sudo, it needs access to the devices
+	Smartctl string `yaml:"smartctl" toml:"smartctl" env:"EASYNAS_SMARTCTL_PATH"`
+}
+
+// LdapConfig configures the LDAP authentication provider, which is enabled
+// when URL is set.
+type LdapConfig struct {
+	URL                string   `yaml:"url" toml:"url" env:"EASYNAS_LDAP_URL"`
+	StartTLS           bool     `yaml:"startTLS" toml:"startTLS" env:"EASYNAS_LDAP_START_TLS"`
+	InsecureSkipVerify bool     `yaml:"insecureSkipVerify" toml:"insecureSkipVerify" env:"EASYNAS_LDAP_INSECURE_SKIP_VERIFY"`
+	Timeout            Duration `yaml:"timeout" toml:"timeout" env:"EASYNAS_LDAP_TIMEOUT"`
+
+	// Service account used to search the directory. Leave empty for anonymous search.
+	BindDN       string `yaml:"bindDN" toml:"bindDN" env:"EASYNAS_LDAP_BIND_DN"`
+	BindPassword string `yaml:"bindPassword" toml:"bindPassword" env:"EASYNAS_LDAP_BIND_PASSWORD" secret:"true"`
+
+	BaseDN string `yaml:"baseDN" toml:"baseDN" env:"EASYNAS_LDAP_BASE_DN"`
+	// UserFilter finds the user entry, {username} is replaced by the escaped login name
+	UserFilter        string `yaml:"userFilter" toml:"userFilter" env:"EASYNAS_LDAP_USER_FILTER"`
+	EmailAttribute    string `yaml:"emailAttribute" toml:"emailAttribute" env:"EASYNAS_LDAP_EMAIL_ATTRIBUTE"`
+	NameAttribute     string `yaml:"nameAttribute" toml:"nameAttribute" env:"EASYNAS_LDAP_NAME_ATTRIBUTE"`
+	NasClientIPAttr   string `yaml:"nasClientIPAttribute" toml:"nasClientIPAttribute" env:"EASYNAS_LDAP_NAS_CLIENT_IP_ATTRIBUTE"`
+	GroupAttribute    string `yaml:"groupAttribute" toml:"groupAttribute" env:"EASYNAS_LDAP_GROUP_ATTRIBUTE"`
+	GroupSearchBaseDN string `yaml:"groupSearchBaseDN" toml:"groupSearchBaseDN" env:"EASYNAS_LDAP_GROUP_SEARCH_BASE_DN"`
+	// GroupSearchFilter finds the groups of a user, {dn} is replaced by the escaped user DN
+	GroupSearchFilter string `yaml:"groupSearchFilter" toml:"groupSearchFilter" env:"EASYNAS_LDAP_GROUP_SEARCH_FILTER"`
+
+	// Members of AdminGroups get the admin role, members of UserGroups the user
+	// role. Groups match by full DN or by CN. If UserGroups is empty every
+	// directory user may log in. DNs contain commas, so the environment
+	// variables separate groups with ';'.
+	AdminGroups []string `yaml:"adminGroups" toml:"adminGroups" env:"EASYNAS_LDAP_ADMIN_GROUPS" sep:";"`
+	UserGroups  []string `yaml:"userGroups" toml:"userGroups" env:"EASYNAS_LDAP_USER_GROUPS" sep:";"`
+}
+
+// OidcConfig configures OpenID Connect single sign-on, which is enabled
+// when IssuerURL is set.
+type OidcConfig struct {
+	DisplayName  string `yaml:"displayName" toml:"displayName" env:"EASYNAS_OIDC_DISPLAY_NAME"`
+	IssuerURL    string `yaml:"issuerURL" toml:"issuerURL" env:"EASYNAS_OIDC_ISSUER_URL"`
+	ClientID     string `yaml:"clientID" toml:"clientID" env:"EASYNAS_OIDC_CLIENT_ID"`
+	ClientSecret string `yaml:"clientSecret" toml:"clientSecret" env:"EASYNAS_OIDC_CLIENT_SECRET" secret:"true"`
+	// RedirectURL is the callback URL of easynas registered at the identity provider
+	RedirectURL string   `yaml:"redirectURL" toml:"redirectURL" env:"EASYNAS_OIDC_REDIRECT_URL"`
+	Scopes      []string `yaml:"scopes" toml:"scopes" env:"EASYNAS_OIDC_SCOPES" sep:" "`
+
+	EmailClaim       string `yaml:"emailClaim" toml:"emailClaim" env:"EASYNAS_OIDC_EMAIL_CLAIM"`
+	NameClaim        string `yaml:"nameClaim" toml:"nameClaim" env:"EASYNAS_OIDC_NAME_CLAIM"`
+	NasClientIPClaim string `yaml:"nasClientIPClaim" toml:"nasClientIPClaim" env:"EASYNAS_OIDC_NAS_CLIENT_IP_CLAIM"`
+	// RoleClaim holds a string or a list of strings, usually groups. Users
+	// having one of AdminValues get the admin role, users having one of
+	// UserValues the user role. If UserValues is empty everybody may log in.
+	RoleClaim   string   `yaml:"roleClaim" toml:"roleClaim" env:"EASYNAS_OIDC_ROLE_CLAIM"`
+	AdminValues []string `yaml:"adminValues" toml:"adminValues" env:"EASYNAS_OIDC_ADMIN_VALUES" sep:";"`
+	UserValues  []string `yaml:"userValues" toml:"userValues" env:"EASYNAS_OIDC_USER_VALUES" sep:";"`
+
+	// PostLoginRedirectURL is the frontend page the tokens are handed to
+	// after a successful login. Tokens are returned as JSON if empty.
+	PostLoginRedirectURL string `yaml:"postLoginRedirectURL" toml:"postLoginRedirectURL" env:"EASYNAS_OIDC_POST_LOGIN_REDIRECT_URL"`
+}
+
+// Duration is a time.Duration written as a string like "2h" or "15m" in
+// config files and environment variables.
+type Duration time.Duration
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Default returns the configuration used when nothing is configured.
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Listen:          ":8080",
+			ShutdownTimeout: Duration(30 * time.Second),
+			TLS: TLSConfig{
+				CertFile:       "easynas.crt",
+				KeyFile:        "easynas.key",
+				ReloadInterval: Duration(time.Minute),
+				HstsMaxAge:     Duration(365 * 24 * time.Hour),
+			},
+		},
+		Database: DatabaseConfig{
+			Path: "easynas.db",
+		},
+		Log: LogConfig{
+			Level: "info",
+		},
+		Nas: NasConfig{
+			Pools:           []string{"naspool"},
+			DefaultClientIP: "10.0.0.1",
+			ArcStats:        "/proc/spl/kstat/zfs/arcstats",
+		},
+		Jwt: JwtConfig{
+			AccessTokenTTL: Duration(2 * time.Hour),
+			MfaTokenTTL:    Duration(5 * time.Minute),
+		},
+		Jobs: JobsConfig{
+			Workers:   2,
+			Retention: Duration(30 * 24 * time.Hour),
+		},
+		Events: EventsConfig{
+			History:         1000,
+			PoolInterval:    Duration(30 * time.Second),
+			MetricsInterval: Duration(5 * time.Second),
+		},
+		Metrics: MetricsConfig{
+			Enabled:    true,
+			AllowedIPs: []string{"127.0.0.1", "::1"},
+		},
+		History: HistoryConfig{
+			Enabled:             true,
+			Interval:            Duration(time.Minute),
+			RawRetention:        Duration(48 * time.Hour),
+			FiveMinuteRetention: Duration(30 * 24 * time.Hour),
+			HourlyRetention:     Duration(2 * 365 * 24 * time.Hour),
+		},
+		Forecast: ForecastConfig{
+			Window:     Duration(30 * 24 * time.Hour),
+			Thresholds: []int{80, 90, 100},
+		},
+		Alerts: AlertsConfig{
+			Enabled:     true,
+			Interval:    Duration(time.Minute),
+			SendTimeout: Duration(10 * time.Second),
+			Retention:   Duration(90 * 24 * time.Hour),
+		},
+		Disks: DisksConfig{
+			Enabled:           true,
+			Interval:          Duration(30 * time.Minute),
+			ShortTestInterval: Duration(7 * 24 * time.Hour),
+			LongTestInterval:  Duration(30 * 24 * time.Hour),
+		},
+		Commands: CommandsConfig{
+			Zfs:      "zfs",
+			Zpool:    "zpool",
+			Sudo:     "sudo",
+			Chown:    "chown",
+			Lsblk:    "lsblk",
+			Smartctl: "smartctl",
+		},
+	}
+}
+
+var (
+	current = resolved(Default())
+	mu      sync.RWMutex
+)
+
+func resolved(cfg *Config) *Config {
+	cfg.resolve()
+	return cfg
+}
+
+// Get returns the active configuration. It must not be modified.
+func Get() *Config {
+	mu.RLock()
+	defer mu.RUnlock()
+	return current
+}
+
+// Set makes cfg the active configuration.
+func Set(cfg *Config) {
+	mu.Lock()
+	defer mu.Unlock()
+	current = cfg
+}
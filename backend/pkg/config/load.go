@@ -0,0 +1,421 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EnvConfigFile names the config file when the -config flag is not given
+const EnvConfigFile = "EASYNAS_CONFIG"
+
+const redacted = "[redacted]"
+
+var durationType = reflect.TypeOf(Duration(0))
+
+// Load builds the configuration from the defaults, the config file, the
+// environment and the command line arguments, in increasing precedence,
+// and validates it.
+func Load(args []string) (*Config, error) {
+	cfg := Default()
+
+	fs := flag.NewFlagSet("easynas", flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv(EnvConfigFile), "path of a YAML or TOML config file")
+	flagFields := map[string]reflect.Value{}
+	flagSeparators := map[string]string{}
+	walk(reflect.ValueOf(cfg).Elem(), func(field reflect.StructField, value reflect.Value) {
+		if name := field.Tag.Get("flag"); name != "" {
+			if value.Kind() == reflect.Bool {
+				// lets -name work without a value
+				fs.Bool(name, false, field.Tag.Get("usage"))
+			} else {
+				fs.String(name, "", field.Tag.Get("usage"))
+			}
+			flagFields[name] = value
+			flagSeparators[name] = field.Tag.Get("sep")
+		}
+	})
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *configFile != "" {
+		if err := loadFile(cfg, *configFile); err != nil {
+			return nil, fmt.Errorf("config file %s: %w", *configFile, err)
+		}
+		cfg.File = *configFile
+	}
+
+	var errs []string
+	walk(reflect.ValueOf(cfg).Elem(), func(field reflect.StructField, value reflect.Value) {
+		name := field.Tag.Get("env")
+		if name == "" {
+			return
+		}
+		if raw, ok := os.LookupEnv(name); ok {
+			if err := setFromString(value, raw, field.Tag.Get("sep")); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %s", name, err))
+			}
+		}
+	})
+
+	fs.Visit(func(f *flag.Flag) {
+		if value, ok := flagFields[f.Name]; ok {
+			if err := setFromString(value, f.Value.String(), flagSeparators[f.Name]); err != nil {
+				errs = append(errs, fmt.Sprintf("-%s: %s", f.Name, err))
+			}
+		}
+	})
+	if len(errs) > 0 {
+		return nil, errors.New(strings.Join(errs, "; "))
+	}
+
+	cfg.resolve()
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// loadFile reads a YAML or TOML file, picked by extension. Unknown keys are
+// rejected so typos do not go unnoticed.
+func loadFile(cfg *Config, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		// an empty file decodes to io.EOF
+		if err = decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+	case ".toml":
+		decoder := toml.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err = decoder.Decode(cfg); err != nil {
+			var strictErr *toml.StrictMissingError
+			if errors.As(err, &strictErr) {
+				// the plain error does not name the unknown keys
+				return errors.New(strictErr.String())
+			}
+			return err
+		}
+	default:
+		return errors.New("unsupported format, use .yaml, .yml or .toml")
+	}
+	return nil
+}
+
+// resolve fills in values derived from others
+func (cfg *Config) resolve() {
+	if cfg.Nas.DefaultPool == "" && len(cfg.Nas.Pools) > 0 {
+		cfg.Nas.DefaultPool = cfg.Nas.Pools[0]
+	}
+}
+
+// Validate reports every invalid value at once.
+func (cfg *Config) Validate() error {
+	var errs []string
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Sprintf(format, args...))
+	}
+
+	if _, _, err := net.SplitHostPort(cfg.Server.Listen); err != nil {
+		fail("server.listen: %s", err)
+	}
+	for _, origin := range cfg.Server.CorsOrigins {
+		if origin == "*" {
+			continue
+		}
+		if u, err := url.Parse(origin); err != nil || u.Scheme == "" || u.Host == "" || u.Path != "" {
+			fail("server.corsOrigins: %q is not an origin like https://nas.example.com", origin)
+		}
+	}
+
+	if cfg.Server.ShutdownTimeout <= 0 {
+		fail("server.shutdownTimeout must be positive")
+	}
+	if cfg.Server.ShutdownDelay < 0 {
+		fail("server.shutdownDelay must not be negative")
+	}
+	if tls := cfg.Server.TLS; tls.Enabled {
+		if tls.CertFile == "" || tls.KeyFile == "" {
+			fail("server.tls.certFile and server.tls.keyFile are required")
+		}
+		if tls.RedirectListen != "" {
+			if _, _, err := net.SplitHostPort(tls.RedirectListen); err != nil {
+				fail("server.tls.redirectListen: %s", err)
+			} else if tls.RedirectListen == cfg.Server.Listen {
+				fail("server.tls.redirectListen must differ from server.listen")
+			}
+		}
+		if tls.ReloadInterval < 0 {
+			fail("server.tls.reloadInterval must not be negative")
+		}
+		if tls.HstsMaxAge < 0 {
+			fail("server.tls.hstsMaxAge must not be negative")
+		}
+	}
+
+	if cfg.Database.Path == "" {
+		fail("database.path is required")
+	}
+
+	switch cfg.Log.Level {
+	case "debug", "info", "warn", "error":
+	default:
+		fail("log.level: %q is not one of debug, info, warn, error", cfg.Log.Level)
+	}
+
+	if len(cfg.Nas.Pools) == 0 {
+		fail("nas.pools: at least one pool is required")
+	}
+	knownPool := false
+	for _, pool := range cfg.Nas.Pools {
+		if pool == "" || strings.ContainsAny(pool, "/@ ") {
+			fail("nas.pools: %q is not a valid pool name", pool)
+		}
+		knownPool = knownPool || pool == cfg.Nas.DefaultPool
+	}
+	if !knownPool && len(cfg.Nas.Pools) > 0 {
+		fail("nas.defaultPool: %q is not one of nas.pools", cfg.Nas.DefaultPool)
+	}
+	if cfg.Nas.DefaultClientIP != "" && net.ParseIP(cfg.Nas.DefaultClientIP) == nil {
+		if _, _, err := net.ParseCIDR(cfg.Nas.DefaultClientIP); err != nil {
+			fail("nas.defaultClientIP: %q is not an address or CIDR range", cfg.Nas.DefaultClientIP)
+		}
+	}
+
+	if cfg.Jwt.Secret != "" && len(cfg.Jwt.Secret) < 32 {
+		fail("jwt.secret must be at least 32 characters")
+	}
+	if cfg.Jwt.AccessTokenTTL <= 0 {
+		fail("jwt.accessTokenTtl must be positive")
+	}
+	if cfg.Jwt.MfaTokenTTL <= 0 {
+		fail("jwt.mfaTokenTtl must be positive")
+	}
+
+	if cfg.Jobs.Workers < 1 {
+		fail("jobs.workers must be at least 1")
+	}
+	if cfg.Jobs.Retention <= 0 {
+		fail("jobs.retention must be positive")
+	}
+
+	if cfg.Events.History < 1 {
+		fail("events.history must be at least 1")
+	}
+	if cfg.Events.PoolInterval < Duration(time.Second) {
+		fail("events.poolInterval must be at least 1s")
+	}
+	if cfg.Events.MetricsInterval < Duration(time.Second) {
+		fail("events.metricsInterval must be at least 1s")
+	}
+
+	for _, ip := range cfg.Metrics.AllowedIPs {
+		if net.ParseIP(ip) == nil {
+			if _, _, err := net.ParseCIDR(ip); err != nil {
+				fail("metrics.allowedIPs: %q is not an address or CIDR range", ip)
+			}
+		}
+	}
+
+	if cfg.Forecast.Window < Duration(time.Hour) {
+		fail("forecast.window must be at least 1h")
+	}
+	if len(cfg.Forecast.Thresholds) == 0 {
+		fail("forecast.thresholds must not be empty")
+	}
+	for _, threshold := range cfg.Forecast.Thresholds {
+		if threshold < 1 || threshold > 100 {
+			fail("forecast.thresholds: %d is not a percentage between 1 and 100", threshold)
+		}
+	}
+
+	if cfg.Alerts.Interval < Duration(10*time.Second) {
+		fail("alerts.interval must be at least 10s")
+	}
+	if cfg.Alerts.SendTimeout <= 0 {
+		fail("alerts.sendTimeout must be positive")
+	}
+	if cfg.Alerts.Retention <= 0 {
+		fail("alerts.retention must be positive")
+	}
+
+	if cfg.Disks.Interval < Duration(time.Minute) {
+		fail("disks.interval must be at least 1m")
+	}
+	if cfg.Disks.ShortTestInterval < 0 || cfg.Disks.LongTestInterval < 0 {
+		fail("disks self-test intervals must not be negative")
+	}
+
+	if cfg.History.Interval < Duration(10*time.Second) {
+		fail("history.interval must be at least 10s")
+	}
+	if cfg.History.RawRetention < cfg.History.Interval {
+		fail("history.rawRetention must be at least history.interval")
+	}
+	if cfg.History.FiveMinuteRetention < Duration(5*time.Minute) {
+		fail("history.fiveMinuteRetention must be at least 5m")
+	}
+	if cfg.History.HourlyRetention < Duration(time.Hour) {
+		fail("history.hourlyRetention must be at least 1h")
+	}
+
+	walk(reflect.ValueOf(&cfg.Commands).Elem(), func(field reflect.StructField, value reflect.Value) {
+		if value.String() == "" {
+			fail("commands.%s is required", field.Tag.Get("yaml"))
+		}
+	})
+
+	if cfg.Ldap.URL != "" {
+		if u, err := url.Parse(cfg.Ldap.URL); err != nil || (u.Scheme != "ldap" && u.Scheme != "ldaps") {
+			fail("ldap.url must be an ldap:// or ldaps:// URL")
+		}
+		if cfg.Ldap.BaseDN == "" {
+			fail("ldap.baseDN is required")
+		}
+	}
+
+	if cfg.Oidc.IssuerURL != "" {
+		if cfg.Oidc.ClientID == "" {
+			fail("oidc.clientID is required")
+		}
+		if cfg.Oidc.RedirectURL == "" {
+			fail("oidc.redirectURL is required")
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.New("invalid configuration: " + strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Redacted returns the configuration keyed like the config file, with
+// secrets replaced.
+func (cfg *Config) Redacted() map[string]interface{} {
+	return redact(reflect.ValueOf(cfg).Elem())
+}
+
+func redact(v reflect.Value) map[string]interface{} {
+	result := map[string]interface{}{}
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		name := field.Tag.Get("yaml")
+		if name == "" || name == "-" {
+			continue
+		}
+
+		value := v.Field(i)
+		switch {
+		case field.Tag.Get("secret") == "true":
+			if value.String() != "" {
+				result[name] = redacted
+			} else {
+				result[name] = ""
+			}
+		case value.Type() == durationType:
+			result[name] = Duration(value.Int()).String()
+		case value.Kind() == reflect.Struct:
+			result[name] = redact(value)
+		case value.Kind() == reflect.Slice && value.IsNil():
+			result[name] = []string{}
+		default:
+			result[name] = value.Interface()
+		}
+	}
+	return result
+}
+
+// walk calls fn for every leaf field of a config struct
+func walk(v reflect.Value, fn func(field reflect.StructField, value reflect.Value)) {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		value := v.Field(i)
+		if value.Kind() == reflect.Struct && value.Type() != durationType {
+			walk(value, fn)
+			continue
+		}
+		fn(field, value)
+	}
+}
+
+// setFromString parses an environment variable or flag into a field. Lists
+// are split on sep, which defaults to ','. A space splits on any whitespace.
+func setFromString(value reflect.Value, raw string, sep string) error {
+	if value.Type() == durationType {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		value.SetInt(int64(d))
+		return nil
+	}
+
+	switch value.Kind() {
+	case reflect.String:
+		value.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		value.SetBool(b)
+	case reflect.Int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return err
+		}
+		value.SetInt(int64(n))
+	case reflect.Slice:
+		var items []string
+		if sep == "" {
+			sep = ","
+		}
+		var parts []string
+		if sep == " " {
+			parts = strings.Fields(raw)
+		} else {
+			parts = strings.Split(raw, sep)
+		}
+		for _, item := range parts {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		if value.Type().Elem().Kind() == reflect.Int {
+			numbers := make([]int, len(items))
+			for i, item := range items {
+				n, err := strconv.Atoi(item)
+				if err != nil {
+					return err
+				}
+				numbers[i] = n
+			}
+			value.Set(reflect.ValueOf(numbers))
+			return nil
+		}
+		value.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported type %s", value.Type())
+	}
+	return nil
+}
@@ -0,0 +1,255 @@
+package config
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadPrecedence(t *testing.T) {
+	for _, file := range []string{"easynas.yaml", "easynas.toml"} {
+		t.Run(file, func(t *testing.T) {
+			path := filepath.Join("testdata", file)
+			t.Setenv(EnvConfigFile, path)
+			t.Setenv("EASYNAS_LOG_LEVEL", "warn")
+			t.Setenv("EASYNAS_JOB_WORKERS", "6")
+
+			cfg, err := Load([]string{"-job-workers", "8", "-metrics=false", "-tls", "-tls-cert", "nas.crt"})
+			if err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+
+			if cfg.File != path {
+				t.Errorf("File = %q, want %q", cfg.File, path)
+			}
+			// the file overrides the defaults
+			if cfg.Server.Listen != ":9000" {
+				t.Errorf("Server.Listen = %q, want the file's :9000", cfg.Server.Listen)
+			}
+			if cfg.Server.ShutdownTimeout != Duration(45*time.Second) {
+				t.Errorf("Server.ShutdownTimeout = %s, want the file's 45s", cfg.Server.ShutdownTimeout)
+			}
+			if !reflect.DeepEqual(cfg.Nas.Pools, []string{"tank", "backup"}) || cfg.Nas.DefaultPool != "tank" {
+				t.Errorf("Nas.Pools = %v, DefaultPool = %q, want the file's pools and the first as default", cfg.Nas.Pools, cfg.Nas.DefaultPool)
+			}
+			if !reflect.DeepEqual(cfg.Forecast.Thresholds, []int{75, 95}) {
+				t.Errorf("Forecast.Thresholds = %v, want the file's [75 95]", cfg.Forecast.Thresholds)
+			}
+			// the environment overrides the file
+			if cfg.Log.Level != "warn" {
+				t.Errorf("Log.Level = %q, want the environment's warn", cfg.Log.Level)
+			}
+			// flags override the environment
+			if cfg.Jobs.Workers != 8 {
+				t.Errorf("Jobs.Workers = %d, want the flag's 8", cfg.Jobs.Workers)
+			}
+			if cfg.Metrics.Enabled || !cfg.Server.TLS.Enabled || cfg.Server.TLS.CertFile != "nas.crt" {
+				t.Errorf("Metrics.Enabled = %v, TLS = %+v, want the flags' values", cfg.Metrics.Enabled, cfg.Server.TLS)
+			}
+			// untouched values keep their defaults
+			if cfg.Database.Path != "easynas.db" {
+				t.Errorf("Database.Path = %q, want the default", cfg.Database.Path)
+			}
+		})
+	}
+}
+
+func TestLoadRejectsUnknownKeys(t *testing.T) {
+	for _, file := range []string{"unknown.yaml", "unknown.toml"} {
+		_, err := Load([]string{"-config", filepath.Join("testdata", file)})
+		if err == nil || !strings.Contains(err.Error(), "listne") {
+			t.Errorf("Load() of %s error = %v, want the unknown key named", file, err)
+		}
+	}
+}
+
+func TestLoadInvalidValues(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		args []string
+		want []string
+	}{
+		{
+			name: "unparsable environment",
+			env:  map[string]string{"EASYNAS_JOB_WORKERS": "many", "EASYNAS_JOB_RETENTION": "30 days"},
+			want: []string{"EASYNAS_JOB_WORKERS", "EASYNAS_JOB_RETENTION"},
+		},
+		{
+			name: "unparsable flag",
+			args: []string{"-shutdown-timeout", "soon"},
+			want: []string{"-shutdown-timeout"},
+		},
+		{
+			name: "invalid after merging",
+			env:  map[string]string{"EASYNAS_LOG_LEVEL": "verbose"},
+			args: []string{"-default-pool", "other"},
+			want: []string{"log.level", "nas.defaultPool"},
+		},
+		{
+			name: "unknown flag",
+			args: []string{"-no-such-flag"},
+			want: []string{"no-such-flag"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(EnvConfigFile, "")
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			_, err := Load(tt.args)
+			if err == nil {
+				t.Fatal("Load() error = nil")
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("Load() error = %v, want it to name %s", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSetFromString(t *testing.T) {
+	var target struct {
+		Duration Duration
+		Bool     bool
+		Int      int
+		Strings  []string
+		Ints     []int
+		Float    float64
+	}
+	field := func(name string) reflect.Value {
+		return reflect.ValueOf(&target).Elem().FieldByName(name)
+	}
+
+	tests := []struct {
+		field   string
+		raw     string
+		sep     string
+		want    interface{}
+		wantErr bool
+	}{
+		{field: "Duration", raw: "1h30m", want: Duration(90 * time.Minute)},
+		{field: "Duration", raw: "90", wantErr: true},
+		{field: "Bool", raw: "true", want: true},
+		{field: "Bool", raw: "0", want: false},
+		{field: "Bool", raw: "yes", wantErr: true},
+		{field: "Int", raw: "42", want: 42},
+		{field: "Int", raw: "4.2", wantErr: true},
+		{field: "Strings", raw: " a, b ,,c ", want: []string{"a", "b", "c"}},
+		{field: "Strings", raw: "cn=admins,dc=example;cn=ops,dc=example", sep: ";", want: []string{"cn=admins,dc=example", "cn=ops,dc=example"}},
+		{field: "Strings", raw: "openid  profile\temail", sep: " ", want: []string{"openid", "profile", "email"}},
+		{field: "Strings", raw: "", want: []string(nil)},
+		{field: "Ints", raw: "80, 95", want: []int{80, 95}},
+		{field: "Ints", raw: "80,high", wantErr: true},
+		{field: "Float", raw: "1.5", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		value := field(tt.field)
+		value.Set(reflect.Zero(value.Type()))
+		err := setFromString(value, tt.raw, tt.sep)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("setFromString(%s, %q) error = nil", tt.field, tt.raw)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("setFromString(%s, %q) error = %v", tt.field, tt.raw, err)
+			continue
+		}
+		if got := value.Interface(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("setFromString(%s, %q) = %#v, want %#v", tt.field, tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(cfg *Config)
+		want   string
+	}{
+		{"listen without port", func(cfg *Config) { cfg.Server.Listen = "localhost" }, "server.listen"},
+		{"origin with path", func(cfg *Config) { cfg.Server.CorsOrigins = []string{"https://nas.example.com/app"} }, "server.corsOrigins"},
+		{"tls without key", func(cfg *Config) { cfg.Server.TLS.Enabled = true; cfg.Server.TLS.KeyFile = "" }, "server.tls.keyFile"},
+		{"redirect on the listen address", func(cfg *Config) {
+			cfg.Server.TLS.Enabled = true
+			cfg.Server.TLS.RedirectListen = cfg.Server.Listen
+		}, "server.tls.redirectListen"},
+		{"no pools", func(cfg *Config) { cfg.Nas.Pools = nil }, "nas.pools"},
+		{"dataset as pool", func(cfg *Config) { cfg.Nas.Pools = []string{"naspool/data"} }, "nas.pools"},
+		{"unknown default pool", func(cfg *Config) { cfg.Nas.DefaultPool = "tank" }, "nas.defaultPool"},
+		{"invalid client address", func(cfg *Config) { cfg.Nas.DefaultClientIP = "10.0.0" }, "nas.defaultClientIP"},
+		{"short jwt secret", func(cfg *Config) { cfg.Jwt.Secret = "short" }, "jwt.secret"},
+		{"no workers", func(cfg *Config) { cfg.Jobs.Workers = 0 }, "jobs.workers"},
+		{"threshold above 100", func(cfg *Config) { cfg.Forecast.Thresholds = []int{80, 120} }, "forecast.thresholds"},
+		{"raw retention below interval", func(cfg *Config) { cfg.History.RawRetention = Duration(time.Second) }, "history.rawRetention"},
+		{"missing command", func(cfg *Config) { cfg.Commands.Zpool = "" }, "commands.zpool"},
+		{"ldap url scheme", func(cfg *Config) {
+			cfg.Ldap.URL = "https://ldap.example.com"
+			cfg.Ldap.BaseDN = "dc=example"
+		}, "ldap.url"},
+		{"oidc without client", func(cfg *Config) {
+			cfg.Oidc.IssuerURL = "https://id.example.com"
+			cfg.Oidc.RedirectURL = "https://nas.example.com/callback"
+		}, "oidc.clientID"},
+	}
+
+	defaults := Default()
+	defaults.resolve()
+	if err := defaults.Validate(); err != nil {
+		t.Fatalf("Validate() of the defaults error = %v", err)
+	}
+	for _, tt := range tests {
+		cfg := Default()
+		cfg.resolve()
+		tt.change(cfg)
+		if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: Validate() error = %v, want it to name %s", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	cfg := Default()
+	var secrets []string
+	walk(reflect.ValueOf(cfg).Elem(), func(field reflect.StructField, value reflect.Value) {
+		if field.Tag.Get("secret") == "true" {
+			value.SetString("s3cret-" + field.Name)
+			secrets = append(secrets, field.Name)
+		}
+	})
+	if len(secrets) == 0 {
+		t.Fatal("no field is tagged as secret")
+	}
+
+	encoded, err := json.Marshal(cfg.Redacted())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(encoded), "s3cret") {
+		t.Errorf("Redacted() shows a secret: %s", encoded)
+	}
+
+	redactedCfg := cfg.Redacted()
+	if got := redactedCfg["jwt"].(map[string]interface{})["secret"]; got != redacted {
+		t.Errorf("jwt.secret = %v, want %q", got, redacted)
+	}
+	// unset secrets show that they are unset
+	if got := Default().Redacted()["oidc"].(map[string]interface{})["clientSecret"]; got != "" {
+		t.Errorf("unset oidc.clientSecret = %v, want empty", got)
+	}
+	// other values are shown as in the config file
+	server := redactedCfg["server"].(map[string]interface{})
+	if server["listen"] != ":8080" || server["shutdownTimeout"] != "30s" {
+		t.Errorf("server = %v, want the listen address and the timeout as a duration", server)
+	}
+}
@@ -26,3 +26,33 @@ func GetRequesterFromContext(c *gin.Context) *model.User {
 	}
 	return nil
 }
+
+func AddSessionToContext(c *gin.Context, session *model.Session) {
+	c.Set("Session", session)
+}
+
+func GetSessionFromContext(c *gin.Context) *model.Session {
+	if val, ok := c.Get("Session"); ok {
+		session, ok := val.(*model.Session)
+		if !ok {
+			return nil
+		}
+		return session
+	}
+	return nil
+}
+
+func AddApiTokenToContext(c *gin.Context, token *model.ApiToken) {
+	c.Set("ApiToken", token)
+}
+
+func GetApiTokenFromContext(c *gin.Context) *model.ApiToken {
+	if val, ok := c.Get("ApiToken"); ok {
+		token, ok := val.(*model.ApiToken)
+		if !ok {
+			return nil
+		}
+		return token
+	}
+	return nil
+}
@@ -46,6 +46,18 @@ func Connect(dbPath string) error {
 	return nil
 }
 
+// Close closes the connection to the database
+func Close() error {
+	if db.client == nil {
+		return nil
+	}
+	sqlDB, err := db.client.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
+}
+
 func GetDb() *Database {
 	return &db
 }
@@ -75,9 +87,79 @@ func (db *Database) RunMigrations() error {
 		return err
 	}
 
+	err = db.Client().AutoMigrate(&model.Session{})
+	if err != nil {
+		return err
+	}
+
+	err = db.Client().AutoMigrate(&model.RetiredRefreshToken{})
+	if err != nil {
+		return err
+	}
+
+	err = db.Client().AutoMigrate(&model.ApiToken{})
+	if err != nil {
+		return err
+	}
+
+	err = db.Client().AutoMigrate(&model.RecoveryCode{})
+	if err != nil {
+		return err
+	}
+
+	err = db.Client().AutoMigrate(&model.Setting{})
+	if err != nil {
+		return err
+	}
+
+	err = db.Client().AutoMigrate(&model.LoginLockout{})
+	if err != nil {
+		return err
+	}
+
+	err = db.Client().AutoMigrate(&model.LoginAttempt{})
+	if err != nil {
+		return err
+	}
+
+	err = db.Client().AutoMigrate(&model.PasswordHistory{})
+	if err != nil {
+		return err
+	}
+
+	err = db.Client().AutoMigrate(&model.AuditEvent{})
+	if err != nil {
+		return err
+	}
+
+	err = db.Client().AutoMigrate(&model.PoolSettings{})
+	if err != nil {
+		return err
+	}
+
+	err = db.Client().AutoMigrate(&model.Job{})
+	if err != nil {
+		return err
+	}
+
+	err = db.Client().AutoMigrate(&model.MetricSample{})
+	if err != nil {
+		return err
+	}
+
+	err = db.Client().AutoMigrate(&model.AlertChannel{}, &model.AlertRule{}, &model.Alert{})
+	if err != nil {
+		return err
+	}
+
+	err = db.Client().AutoMigrate(&model.DiskHealth{})
+	if err != nil {
+		return err
+	}
+
 	// Create Initial Admin User
 	// Check if admin user already exists in the DB
-	_, err = Get[model.User](db, map[string]interface{}{"email": "admin@easy.nas"})
+	admin, err := Get[model.User](db, map[string]interface{}{"email": "admin@easy.nas"})
 	if err != nil && err.Error() == RecordNotFound {
 		// Create and insert initial admin user in DB
 		hashPassword, err := util.HashPassword("admin")
@@ -90,10 +172,17 @@ func (db *Database) RunMigrations() error {
 			Password:    hashPassword,
 			NasClientIP: "10.0.0.1",
 			Role:        model.RoleAdmin,
+			// The default password is public knowledge
+			MustChangePassword: true,
 		}
 		if err = db.Insert(user); err != nil {
 			log.Logger.Fatalw("Failed to create initial admin user", "err", err.Error())
 		}
+	} else if admin != nil && !admin.MustChangePassword && util.CheckPasswordHash("admin", admin.Password) {
+		// Installations from before password changes were enforced may still use the default password
+		if err = db.Update(admin, map[string]interface{}{"must_change_password": true}); err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -145,6 +234,12 @@ func (db *Database) Update(record interface{}, updates map[string]interface{}) e
 	return result.Error
 }
 
+// UpdateWhere modifies fields in all records matching the specified conditions
+func (db *Database) UpdateWhere(record interface{}, conditions map[string]interface{}, updates map[string]interface{}) error {
+	result := db.client.Model(record).Where(conditions).Updates(updates)
+	return result.Error
+}
+
 // Delete removes a record matching the specified conditions from the table
 func (db *Database) Delete(record interface{}, conditions map[string]interface{}) error {
 	result := db.client.Where(conditions).Delete(record)
@@ -0,0 +1,72 @@
+package model
+
+import "time"
+
+const (
+	AlertFiring   = "firing"
+	AlertResolved = "resolved"
+)
+
+const (
+	SeverityInfo     = "info"
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+// AlertChannel is a destination alert notifications are sent to.
+type AlertChannel struct {
+	ID        uint      `json:"id" gorm:"primarykey"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	Name      string    `json:"name"`
+	// Type is smtp, webhook, slack or syslog
+	Type string `json:"type"`
+	// Config holds the JSON settings of the type, including secrets
+	Config  string `json:"-"`
+	Enabled bool   `json:"enabled"`
+	// MinSeverity leaves out notifications of alerts below it
+	MinSeverity string     `json:"minSeverity"`
+	LastSentAt  *time.Time `json:"lastSentAt"`
+	LastError   string     `json:"lastError,omitempty"`
+}
+
+// AlertRule is checked periodically and fires an alert for every subject,
+// like a pool or dataset, it finds a problem with.
+type AlertRule struct {
+	ID        uint      `json:"id" gorm:"primarykey"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	// Key names built-in rules, which can be changed but not deleted
+	Key      string `json:"key,omitempty" gorm:"index"`
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Params   string `json:"-"`
+	Severity string `json:"severity"`
+	Enabled  bool   `json:"enabled"`
+	// Channels is a JSON list of channel IDs, every enabled channel if empty
+	Channels string `json:"-"`
+	// RenotifyMinutes repeats the notification of unacknowledged alerts,
+	// 0 notifies once
+	RenotifyMinutes int  `json:"renotifyMinutes"`
+	NotifyResolved  bool `json:"notifyResolved"`
+}
+
+// Alert is a problem found by a rule. Fingerprint identifies the rule and
+// subject so a problem that persists stays one alert.
+type Alert struct {
+	ID             uint       `json:"id" gorm:"primarykey"`
+	RuleId         uint       `json:"ruleId" gorm:"index"`
+	RuleName       string     `json:"ruleName"`
+	Fingerprint    string     `json:"-" gorm:"index"`
+	Subject        string     `json:"subject"`
+	Severity       string     `json:"severity"`
+	State          string     `json:"state" gorm:"index"`
+	Message        string     `json:"message"`
+	FiredAt        time.Time  `json:"firedAt"`
+	LastSeenAt     time.Time  `json:"lastSeenAt"`
+	ResolvedAt     *time.Time `json:"resolvedAt"`
+	LastNotifiedAt *time.Time `json:"lastNotifiedAt"`
+	Notifications  int        `json:"notifications"`
+	AcknowledgedAt *time.Time `json:"acknowledgedAt"`
+	AcknowledgedBy uint       `json:"acknowledgedBy,omitempty"`
+}
@@ -0,0 +1,16 @@
+package model
+
+import "time"
+
+type ApiToken struct {
+	ID         uint       `json:"id" gorm:"primarykey"`
+	UserId     uint       `json:"-" gorm:"index"`
+	User       User       `json:"-" gorm:"foreignKey:UserId"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	TokenHash  string     `json:"-" gorm:"uniqueIndex"`
+	Scopes     string     `json:"-"`
+	ExpiresAt  *time.Time `json:"expiresAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
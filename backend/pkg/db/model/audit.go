@@ -0,0 +1,38 @@
+package model
+
+import "time"
+
+const (
+	AuditResultSuccess = "success"
+	AuditResultFailure = "failure"
+	// AuditResultAccepted is the result of a request whose work was queued
+	// as a background job, the job tells how it ended
+	AuditResultAccepted = "accepted"
+)
+
+// AuditEvent records a mutating API call.
+type AuditEvent struct {
+	ID         uint      `json:"id" gorm:"primarykey"`
+	CreatedAt  time.Time `json:"createdAt" gorm:"index"`
+	ActorId    uint      `json:"actorId" gorm:"index"`
+	ActorEmail string    `json:"actorEmail"`
+	ApiTokenId uint      `json:"apiTokenId,omitempty"`
+	SourceIP   string    `json:"sourceIP"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	// Action is the route template, e.g. "DELETE /api/v1/users/:id"
+	Action       string `json:"action" gorm:"index"`
+	Pool         string `json:"pool,omitempty" gorm:"index"`
+	Dataset      string `json:"dataset,omitempty" gorm:"index"`
+	Share        string `json:"share,omitempty"`
+	Snapshot     string `json:"snapshot,omitempty"`
+	TargetUserId uint   `json:"targetUserId,omitempty" gorm:"index"`
+	// JobId is the background job an accepted request queued
+	JobId uint `json:"jobId,omitempty" gorm:"index"`
+	// Parameters holds the path, query and redacted body parameters as JSON
+	Parameters string `json:"parameters"`
+	Result     string `json:"result" gorm:"index"`
+	Status     int    `json:"status"`
+	Error      string `json:"error,omitempty"`
+	DurationMs int64  `json:"durationMs"`
+}
@@ -0,0 +1,46 @@
+package model
+
+import "time"
+
+// DiskHealth is the last SMART data read from a physical disk, with the
+// error counters of when the disk was first seen. Counters growing past
+// their baseline mean the disk is deteriorating.
+type DiskHealth struct {
+	ID        uint      `json:"id" gorm:"primarykey"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	// Key is the serial number of the disk, or its device path if it
+	// reports none, so the record follows the disk across device renames
+	Key    string `json:"key" gorm:"uniqueIndex"`
+	Device string `json:"device"`
+	Model  string `json:"model"`
+
+	Healthy            *bool  `json:"healthy"`
+	Temperature        *int   `json:"temperature"`
+	PowerOnHours       *int64 `json:"powerOnHours"`
+	ReallocatedSectors *int64 `json:"reallocatedSectors"`
+	PendingSectors     *int64 `json:"pendingSectors"`
+	Uncorrectable      *int64 `json:"uncorrectable"`
+	MediaErrors        *int64 `json:"mediaErrors"`
+	PercentageUsed     *int   `json:"percentageUsed"`
+	// FailingAttributes are the comma separated names of the attributes at
+	// or below their threshold
+	FailingAttributes string `json:"failingAttributes,omitempty"`
+
+	BaselineReallocated   *int64 `json:"baselineReallocated"`
+	BaselinePending       *int64 `json:"baselinePending"`
+	BaselineUncorrectable *int64 `json:"baselineUncorrectable"`
+	BaselineMediaErrors   *int64 `json:"baselineMediaErrors"`
+
+	SelfTestInProgress bool   `json:"selfTestInProgress"`
+	LastSelfTest       string `json:"lastSelfTest,omitempty"`
+	LastSelfTestResult string `json:"lastSelfTestResult,omitempty"`
+	LastSelfTestPassed *bool  `json:"lastSelfTestPassed"`
+
+	// LastShortTestAt and LastLongTestAt are when easynas last started a
+	// self-test of the disk
+	LastShortTestAt time.Time  `json:"lastShortTestAt"`
+	LastLongTestAt  time.Time  `json:"lastLongTestAt"`
+	LastCheckedAt   *time.Time `json:"lastCheckedAt"`
+	LastError       string     `json:"lastError,omitempty"`
+}
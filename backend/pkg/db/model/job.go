@@ -0,0 +1,41 @@
+package model
+
+import "time"
+
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+)
+
+// Job is a long running operation executed in the background.
+type Job struct {
+	ID        uint      `json:"id" gorm:"primarykey"`
+	CreatedAt time.Time `json:"createdAt" gorm:"index"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	// Type names the handler, e.g. "dataset.destroy"
+	Type  string `json:"type" gorm:"index"`
+	State string `json:"state" gorm:"index"`
+	// OwnerId is the user who started the job, 0 for jobs started by easynas
+	OwnerId uint `json:"ownerId" gorm:"index"`
+	// Target is the dataset the job works on. Jobs with the same target run
+	// one after another.
+	Target string `json:"target,omitempty"`
+	// Params and Result are JSON
+	Params          string     `json:"params"`
+	Result          string     `json:"result,omitempty"`
+	Error           string     `json:"error,omitempty"`
+	Progress        int        `json:"progress"`
+	ProgressMessage string     `json:"progressMessage,omitempty"`
+	Logs            string     `json:"logs,omitempty"`
+	Attempts        int        `json:"attempts"`
+	StartedAt       *time.Time `json:"startedAt,omitempty"`
+	FinishedAt      *time.Time `json:"finishedAt,omitempty"`
+}
+
+// Finished tells whether the job reached a final state.
+func (j *Job) Finished() bool {
+	return j.State == JobSucceeded || j.State == JobFailed || j.State == JobCancelled
+}
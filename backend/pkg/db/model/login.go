@@ -0,0 +1,29 @@
+package model
+
+import "time"
+
+const (
+	LockoutKindAccount = "account"
+	LockoutKindIP      = "ip"
+	// LockoutKindTwoFactor counts failed second factors, keyed by user ID
+	LockoutKindTwoFactor = "2fa"
+)
+
+type LoginLockout struct {
+	ID            uint      `json:"id" gorm:"primarykey"`
+	Kind          string    `json:"kind" gorm:"uniqueIndex:idx_login_lockout_kind_key"`
+	Key           string    `json:"key" gorm:"uniqueIndex:idx_login_lockout_kind_key"`
+	Failures      int       `json:"failures"`
+	BlockedUntil  time.Time `json:"blockedUntil"`
+	LastFailureAt time.Time `json:"lastFailureAt"`
+}
+
+type LoginAttempt struct {
+	ID        uint      `json:"id" gorm:"primarykey"`
+	Username  string    `json:"username" gorm:"index"`
+	ClientIP  string    `json:"clientIP" gorm:"index"`
+	UserAgent string    `json:"userAgent"`
+	Success   bool      `json:"success"`
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"createdAt" gorm:"index"`
+}
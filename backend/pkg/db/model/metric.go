@@ -0,0 +1,19 @@
+package model
+
+// MetricSample is a recorded metric value. Raw samples have a resolution of
+// 0; rollups summarize Count raw samples of the Resolution seconds starting
+// at Timestamp.
+type MetricSample struct {
+	ID uint `gorm:"primarykey"`
+	// Metric is a name like "pool.free_bytes"
+	Metric string `gorm:"index:idx_metric_samples_lookup,priority:1"`
+	// Series is the pool or dataset the value belongs to, empty for the host
+	Series     string `gorm:"index:idx_metric_samples_lookup,priority:3"`
+	Resolution int64  `gorm:"index:idx_metric_samples_lookup,priority:2"`
+	// Timestamp is in unix seconds
+	Timestamp int64 `gorm:"index:idx_metric_samples_lookup,priority:4"`
+	Average   float64
+	Minimum   float64
+	Maximum   float64
+	Count     int64
+}
@@ -0,0 +1,13 @@
+package model
+
+import "time"
+
+// PoolSettings holds the defaults applied to new datasets of a pool. Empty
+// values leave the zfs default in place.
+type PoolSettings struct {
+	Pool         string    `json:"pool" gorm:"primarykey"`
+	DefaultQuota string    `json:"defaultQuota"`
+	Compression  string    `json:"compression"`
+	RecordSize   string    `json:"recordSize"`
+	UpdatedAt    time.Time `json:"updatedAt"`
+}
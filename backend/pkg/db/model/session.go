@@ -0,0 +1,26 @@
+package model
+
+import "time"
+
+type Session struct {
+	ID               uint       `json:"id" gorm:"primarykey"`
+	UserId           uint       `json:"userId" gorm:"index"`
+	User             User       `json:"-" gorm:"foreignKey:UserId"`
+	RefreshTokenHash string     `json:"-" gorm:"uniqueIndex"`
+	Device           string     `json:"device"`
+	UserAgent        string     `json:"userAgent"`
+	ClientIP         string     `json:"clientIP"`
+	CreatedAt        time.Time  `json:"createdAt"`
+	LastUsedAt       time.Time  `json:"lastUsedAt"`
+	ExpiresAt        time.Time  `json:"expiresAt"`
+	RevokedAt        *time.Time `json:"revokedAt"`
+}
+
+// RetiredRefreshToken is a refresh token that was exchanged for a new one.
+// Presenting it again means it was stolen, or raced with its owner.
+type RetiredRefreshToken struct {
+	ID        uint      `gorm:"primarykey"`
+	Hash      string    `gorm:"uniqueIndex"`
+	SessionId uint      `gorm:"index"`
+	RetiredAt time.Time `gorm:"index"`
+}
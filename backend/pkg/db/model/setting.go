@@ -0,0 +1,6 @@
+package model
+
+type Setting struct {
+	Key   string `json:"key" gorm:"primarykey"`
+	Value string `json:"value"`
+}
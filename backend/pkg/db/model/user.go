@@ -1,15 +1,46 @@
 package model
 
+import "time"
+
 const (
 	RoleAdmin = "ROLE_ADMIN"
 	RoleUser  = "ROLE_USER"
 )
 
+const (
+	AuthSourceLocal = "local"
+	AuthSourceLdap  = "ldap"
+	AuthSourceOidc  = "oidc"
+)
+
 type User struct {
-	ID          uint   `json:"id" gorm:"primarykey"`
-	Name        string `json:"name"`
-	Email       string `json:"email" gorm:"unique"`
-	Password    string `json:"-"`
-	NasClientIP string `json:"nasClientIP" gorm:"unique"`
-	Role        string `json:"role"`
+	ID                 uint   `json:"id" gorm:"primarykey"`
+	Name               string `json:"name"`
+	Email              string `json:"email" gorm:"unique"`
+	Password           string `json:"-"`
+	NasClientIP        string `json:"nasClientIP" gorm:"index:idx_users_nas_client_ip,unique,where:nas_client_ip <> ''"`
+	Role               string `json:"role"`
+	AuthSource         string `json:"authSource" gorm:"default:local"`
+	ExternalId         string `json:"-" gorm:"index"`
+	MustChangePassword bool   `json:"mustChangePassword"`
+	Disabled           bool   `json:"disabled"`
+	TotpEnabled        bool   `json:"totpEnabled"`
+	TotpSecret         string `json:"-"`
+	TotpPendingSecret  string `json:"-"`
+	TotpLastCounter    int64  `json:"-"`
+}
+
+type RecoveryCode struct {
+	ID        uint       `json:"id" gorm:"primarykey"`
+	UserId    uint       `json:"-" gorm:"index"`
+	CodeHash  string     `json:"-"`
+	UsedAt    *time.Time `json:"usedAt"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+type PasswordHistory struct {
+	ID           uint      `json:"id" gorm:"primarykey"`
+	UserId       uint      `json:"-" gorm:"index"`
+	PasswordHash string    `json:"-"`
+	CreatedAt    time.Time `json:"createdAt"`
 }
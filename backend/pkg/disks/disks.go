@@ -0,0 +1,169 @@
+package disks
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/whyxn/easynas/backend/pkg/config"
+	"github.com/whyxn/easynas/backend/pkg/nas"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var ErrNotFound = errors.New("disk not found")
+
+// ignoredDisks are block devices of type disk that are not physical, zvols
+// and network block devices
+var ignoredDisks = []string{"zd", "nbd", "zram", "ram"}
+
+// Disk is a physical disk with the pool it is used in, if any.
+type Disk struct {
+	Name       string `json:"name"`
+	Path       string `json:"path"`
+	Model      string `json:"model"`
+	Serial     string `json:"serial"`
+	Wwn        string `json:"wwn,omitempty"`
+	Size       uint64 `json:"size"`
+	Rotational bool   `json:"rotational"`
+	Transport  string `json:"transport,omitempty"`
+	// Partitions are the paths of the partitions of the disk
+	Partitions []string `json:"partitions"`
+	// Pools lists where the disk or its partitions are used, usually once
+	Pools []PoolMember `json:"pools"`
+}
+
+// PoolMember is a device of a disk used in a pool.
+type PoolMember struct {
+	Device string `json:"device"`
+	nas.PoolDevice
+}
+
+// Key identifies the disk across device renames, by its serial number if
+// it reports one.
+func (d *Disk) Key() string {
+	if d.Serial != "" {
+		return d.Serial
+	}
+	return d.Path
+}
+
+// lsblkDevice is a device of lsblk --json. Older versions of lsblk print
+// every value as a string, so sizes and flags are decoded by hand.
+type lsblkDevice struct {
+	Name     string          `json:"name"`
+	Path     string          `json:"path"`
+	Type     string          `json:"type"`
+	Size     json.RawMessage `json:"size"`
+	Model    string          `json:"model"`
+	Serial   string          `json:"serial"`
+	Wwn      string          `json:"wwn"`
+	Rota     json.RawMessage `json:"rota"`
+	Tran     string          `json:"tran"`
+	Children []lsblkDevice   `json:"children"`
+}
+
+// List enumerates the physical disks.
+func List() ([]Disk, error) {
+	output, err := exec.Command(config.Get().Commands.Lsblk, "--json", "--bytes",
+		"-o", "NAME,PATH,TYPE,SIZE,MODEL,SERIAL,WWN,ROTA,TRAN").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list block devices: %w", err)
+	}
+	disks, err := parseLsblk(output)
+	if err != nil {
+		return nil, err
+	}
+
+	devices, err := nas.ListPoolDevices()
+	if err != nil {
+		return nil, err
+	}
+	for i := range disks {
+		for _, path := range append([]string{disks[i].Path}, disks[i].Partitions...) {
+			if device, ok := devices[path]; ok {
+				disks[i].Pools = append(disks[i].Pools, PoolMember{Device: path, PoolDevice: device})
+			}
+		}
+	}
+	return disks, nil
+}
+
+// Get finds a disk by its name, like sda.
+func Get(name string) (*Disk, error) {
+	disks, err := List()
+	if err != nil {
+		return nil, err
+	}
+	for i := range disks {
+		if disks[i].Name == name {
+			return &disks[i], nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func parseLsblk(output []byte) ([]Disk, error) {
+	var result struct {
+		BlockDevices []lsblkDevice `json:"blockdevices"`
+	}
+	if err := json.Unmarshal(output, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse lsblk output: %w", err)
+	}
+
+	disks := []Disk{}
+	for _, device := range result.BlockDevices {
+		if device.Type != "disk" || ignoredDisk(device.Name) {
+			continue
+		}
+		disk := Disk{
+			Name:       device.Name,
+			Path:       device.Path,
+			Model:      strings.TrimSpace(device.Model),
+			Serial:     strings.TrimSpace(device.Serial),
+			Wwn:        device.Wwn,
+			Size:       rawUint(device.Size),
+			Rotational: rawBool(device.Rota),
+			Transport:  device.Tran,
+			Partitions: []string{},
+			Pools:      []PoolMember{},
+		}
+		if disk.Path == "" {
+			disk.Path = "/dev/" + device.Name
+		}
+		for _, child := range device.Children {
+			if child.Type == "part" {
+				path := child.Path
+				if path == "" {
+					path = "/dev/" + child.Name
+				}
+				disk.Partitions = append(disk.Partitions, path)
+			}
+		}
+		disks = append(disks, disk)
+	}
+	sort.Slice(disks, func(i, j int) bool { return disks[i].Name < disks[j].Name })
+	return disks, nil
+}
+
+func ignoredDisk(name string) bool {
+	for _, prefix := range ignoredDisks {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// rawUint decodes a number printed as a number or a string
+func rawUint(raw json.RawMessage) uint64 {
+	n, _ := strconv.ParseUint(strings.Trim(string(raw), `"`), 10, 64)
+	return n
+}
+
+// rawBool decodes a flag printed as a boolean or as "0" and "1"
+func rawBool(raw json.RawMessage) bool {
+	value := strings.Trim(string(raw), `"`)
+	return value == "true" || value == "1"
+}
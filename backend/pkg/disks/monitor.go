@@ -0,0 +1,207 @@
+package disks
+
+import (
+	"context"
+	"errors"
+	"github.com/whyxn/easynas/backend/pkg/config"
+	"github.com/whyxn/easynas/backend/pkg/db"
+	"github.com/whyxn/easynas/backend/pkg/db/model"
+	"github.com/whyxn/easynas/backend/pkg/lifecycle"
+	"github.com/whyxn/easynas/backend/pkg/log"
+	"gorm.io/gorm"
+	"strings"
+	"sync"
+	"time"
+)
+
+// mu keeps the periodic check and requests of the API from writing the same
+// health record at once
+var mu sync.Mutex
+
+// Start reads the SMART data of all disks and starts the scheduled
+// self-tests at the configured interval.
+func Start(cfg config.DisksConfig) {
+	lifecycle.Go(func(ctx context.Context) {
+		check(cfg)
+
+		ticker := time.NewTicker(time.Duration(cfg.Interval))
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				check(cfg)
+			case <-lifecycle.Done():
+				return
+			}
+		}
+	})
+}
+
+func check(cfg config.DisksConfig) {
+	disks, err := List()
+	if err != nil {
+		log.Logger.Warnw("Failed to list disks", "err", err)
+		return
+	}
+
+	for i := range disks {
+		disk := &disks[i]
+		// disks in standby are checked once they spin up again
+		_, health, err := Refresh(disk, false)
+		if errors.Is(err, ErrStandby) || errors.Is(err, ErrUnsupported) {
+			continue
+		} else if err != nil {
+			log.Logger.Warnw("Failed to read SMART data", "disk", disk.Path, "err", err)
+			continue
+		}
+		scheduleSelfTest(cfg, disk, health)
+	}
+}
+
+// scheduleSelfTest starts the self-test that is due, if the disk is not
+// running one already. A long test also counts as a short one.
+func scheduleSelfTest(cfg config.DisksConfig, disk *Disk, health *model.DiskHealth) {
+	if health.SelfTestInProgress {
+		return
+	}
+
+	now := time.Now()
+	testType := ""
+	updates := map[string]interface{}{}
+	if cfg.LongTestInterval > 0 && now.Sub(health.LastLongTestAt) >= time.Duration(cfg.LongTestInterval) {
+		testType = SelfTestLong
+		updates["last_long_test_at"] = now
+		updates["last_short_test_at"] = now
+	} else if cfg.ShortTestInterval > 0 && now.Sub(health.LastShortTestAt) >= time.Duration(cfg.ShortTestInterval) {
+		testType = SelfTestShort
+		updates["last_short_test_at"] = now
+	} else {
+		return
+	}
+
+	if err := StartSelfTest(disk.Path, testType); err != nil {
+		log.Logger.Warnw("Failed to start self-test", "disk", disk.Path, "type", testType, "err", err)
+		return
+	}
+	log.Logger.Infow("Started scheduled self-test", "disk", disk.Path, "type", testType)
+	if err := db.GetDb().Update(health, updates); err != nil {
+		log.Logger.Warnw("Failed to save self-test time", "disk", disk.Path, "err", err)
+	}
+}
+
+// Refresh reads the SMART data of a disk and stores it as the health of the
+// disk. Failures other than a disk in standby are recorded as well.
+func Refresh(disk *Disk, wake bool) (*SmartInfo, *model.DiskHealth, error) {
+	info, smartErr := ReadSmart(disk.Path, wake)
+	if errors.Is(smartErr, ErrStandby) || errors.Is(smartErr, ErrUnsupported) {
+		return nil, nil, smartErr
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	health, err := loadHealth(disk)
+	if err != nil {
+		return nil, nil, err
+	}
+	health.Device = disk.Path
+	if disk.Model != "" {
+		health.Model = disk.Model
+	}
+	if smartErr != nil {
+		health.LastError = smartErr.Error()
+		if err = db.GetDb().Client().Save(health).Error; err != nil {
+			log.Logger.Warnw("Failed to save disk health", "disk", disk.Path, "err", err)
+		}
+		return nil, nil, smartErr
+	}
+
+	now := time.Now()
+	health.Healthy = info.Healthy
+	health.Temperature = info.Temperature
+	health.PowerOnHours = info.PowerOnHours
+	health.ReallocatedSectors = info.ReallocatedSectors
+	health.PendingSectors = info.PendingSectors
+	health.Uncorrectable = info.Uncorrectable
+	health.MediaErrors = info.MediaErrors
+	health.PercentageUsed = info.PercentageUsed
+	health.FailingAttributes = strings.Join(info.FailingAttributes(), ",")
+	health.SelfTestInProgress = info.SelfTest.InProgress
+	health.LastSelfTest = info.SelfTest.LastType
+	health.LastSelfTestResult = info.SelfTest.LastResult
+	health.LastSelfTestPassed = info.SelfTest.LastPassed
+	health.LastCheckedAt = &now
+	health.LastError = ""
+
+	// counters the disk did not report before start from their first value
+	if health.BaselineReallocated == nil {
+		health.BaselineReallocated = info.ReallocatedSectors
+	}
+	if health.BaselinePending == nil {
+		health.BaselinePending = info.PendingSectors
+	}
+	if health.BaselineUncorrectable == nil {
+		health.BaselineUncorrectable = info.Uncorrectable
+	}
+	if health.BaselineMediaErrors == nil {
+		health.BaselineMediaErrors = info.MediaErrors
+	}
+
+	if err = db.GetDb().Client().Save(health).Error; err != nil {
+		return nil, nil, err
+	}
+	return info, health, nil
+}
+
+// loadHealth returns the health record of a disk, or a new one. The
+// self-tests of a new disk are scheduled from when it was first seen.
+func loadHealth(disk *Disk) (*model.DiskHealth, error) {
+	health, err := db.Get[model.DiskHealth](db.GetDb(), map[string]interface{}{"key": disk.Key()})
+	if err == nil {
+		return health, nil
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	now := time.Now()
+	return &model.DiskHealth{
+		Key:             disk.Key(),
+		LastShortTestAt: now,
+		LastLongTestAt:  now,
+	}, nil
+}
+
+// ResetBaseline accepts the current error counters of a disk, after a
+// replacement of its data or a review of the damage, so only new errors
+// alert again.
+func ResetBaseline(disk *Disk) (*model.DiskHealth, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	health, err := db.Get[model.DiskHealth](db.GetDb(), map[string]interface{}{"key": disk.Key()})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	health.BaselineReallocated = health.ReallocatedSectors
+	health.BaselinePending = health.PendingSectors
+	health.BaselineUncorrectable = health.Uncorrectable
+	health.BaselineMediaErrors = health.MediaErrors
+	if err = db.GetDb().Client().Save(health).Error; err != nil {
+		return nil, err
+	}
+	return health, nil
+}
+
+// ListHealth returns the stored health of all disks ever seen, by key.
+func ListHealth() (map[string]model.DiskHealth, error) {
+	records, err := db.GetList[model.DiskHealth](db.GetDb(), map[string]interface{}{})
+	if err != nil {
+		return nil, err
+	}
+	health := map[string]model.DiskHealth{}
+	for _, record := range records {
+		health[record.Key] = record
+	}
+	return health, nil
+}
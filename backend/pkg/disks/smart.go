@@ -0,0 +1,385 @@
+package disks
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/whyxn/easynas/backend/pkg/config"
+	"os/exec"
+	"strings"
+)
+
+// Self-test types
+const (
+	SelfTestShort = "short"
+	SelfTestLong  = "long"
+)
+
+// smartctl exit status bits, the higher ones report problems of the disk
+// and still come with the data
+const (
+	smartctlCommandLineError = 1 << 0
+	smartctlOpenFailed       = 1 << 1
+	smartctlCommandFailed    = 1 << 2
+)
+
+// ATA attributes that count bad sectors
+const (
+	ataReallocatedSectors = 5
+	ataPendingSectors     = 197
+	ataUncorrectable      = 198
+)
+
+var (
+	// ErrStandby is returned instead of waking a disk that spun down
+	ErrStandby = errors.New("disk is in standby")
+	// ErrUnsupported is returned for devices without SMART, like virtual
+	// disks
+	ErrUnsupported = errors.New("disk does not support SMART")
+)
+
+// SmartInfo is the SMART data of a disk. Values a disk does not report are
+// nil.
+type SmartInfo struct {
+	Device       string `json:"device"`
+	Protocol     string `json:"protocol"` // ATA, NVMe or SCSI
+	Model        string `json:"model"`
+	Serial       string `json:"serial"`
+	Firmware     string `json:"firmware"`
+	Capacity     uint64 `json:"capacity"`
+	RotationRate int    `json:"rotationRate,omitempty"` // RPM, 0 for solid state disks
+
+	Healthy      *bool  `json:"healthy"` // Overall health self-assessment
+	Temperature  *int   `json:"temperature"`
+	PowerOnHours *int64 `json:"powerOnHours"`
+	PowerCycles  *int64 `json:"powerCycles"`
+
+	// ATA
+	ReallocatedSectors *int64 `json:"reallocatedSectors"`
+	PendingSectors     *int64 `json:"pendingSectors"`
+	Uncorrectable      *int64 `json:"uncorrectable"`
+
+	// NVMe
+	MediaErrors     *int64 `json:"mediaErrors"`
+	PercentageUsed  *int   `json:"percentageUsed"` // Estimated wear
+	AvailableSpare  *int   `json:"availableSpare"`
+	CriticalWarning *int   `json:"criticalWarning"`
+
+	SelfTest   SelfTestStatus   `json:"selfTest"`
+	Attributes []SmartAttribute `json:"attributes"`
+	// Messages are the warnings smartctl printed
+	Messages []string `json:"messages"`
+}
+
+// SelfTestStatus is the running self-test, if any, and the result of the
+// last one.
+type SelfTestStatus struct {
+	InProgress       bool   `json:"inProgress"`
+	RemainingPercent int    `json:"remainingPercent,omitempty"`
+	LastType         string `json:"lastType,omitempty"`
+	LastResult       string `json:"lastResult,omitempty"`
+	LastPassed       *bool  `json:"lastPassed"`
+	// LastPowerOnHours is the age of the disk when the last test ran
+	LastPowerOnHours int64 `json:"lastPowerOnHours,omitempty"`
+}
+
+// SmartAttribute is an ATA SMART attribute. The normalized Value failing
+// to Threshold or below predicts a failure of the disk.
+type SmartAttribute struct {
+	ID         int    `json:"id"`
+	Name       string `json:"name"`
+	Value      int    `json:"value"`
+	Worst      int    `json:"worst"`
+	Threshold  int    `json:"threshold"`
+	Raw        int64  `json:"raw"`
+	RawString  string `json:"rawString"`
+	Prefailure bool   `json:"prefailure"`
+	// WhenFailed is "now" or "past" if Value reached Threshold
+	WhenFailed string `json:"whenFailed,omitempty"`
+}
+
+// smartctlOutput is the part of smartctl --json easynas reads
+type smartctlOutput struct {
+	Smartctl struct {
+		ExitStatus int `json:"exit_status"`
+		Messages   []struct {
+			String   string `json:"string"`
+			Severity string `json:"severity"`
+		} `json:"messages"`
+	} `json:"smartctl"`
+	Device struct {
+		Name     string `json:"name"`
+		Protocol string `json:"protocol"`
+	} `json:"device"`
+	ModelName       string `json:"model_name"`
+	SerialNumber    string `json:"serial_number"`
+	FirmwareVersion string `json:"firmware_version"`
+	UserCapacity    struct {
+		Bytes uint64 `json:"bytes"`
+	} `json:"user_capacity"`
+	RotationRate *int `json:"rotation_rate"`
+	SmartSupport *struct {
+		Available bool `json:"available"`
+		Enabled   bool `json:"enabled"`
+	} `json:"smart_support"`
+	SmartStatus *struct {
+		Passed bool `json:"passed"`
+	} `json:"smart_status"`
+	Temperature *struct {
+		Current *int `json:"current"`
+	} `json:"temperature"`
+	PowerOnTime *struct {
+		Hours int64 `json:"hours"`
+	} `json:"power_on_time"`
+	PowerCycleCount *int64 `json:"power_cycle_count"`
+
+	AtaSmartAttributes struct {
+		Table []struct {
+			ID         int    `json:"id"`
+			Name       string `json:"name"`
+			Value      int    `json:"value"`
+			Worst      int    `json:"worst"`
+			Thresh     int    `json:"thresh"`
+			WhenFailed string `json:"when_failed"`
+			Flags      struct {
+				Prefailure bool `json:"prefailure"`
+			} `json:"flags"`
+			Raw struct {
+				Value  int64  `json:"value"`
+				String string `json:"string"`
+			} `json:"raw"`
+		} `json:"table"`
+	} `json:"ata_smart_attributes"`
+	AtaSmartData struct {
+		SelfTest struct {
+			Status struct {
+				Value            int    `json:"value"`
+				String           string `json:"string"`
+				RemainingPercent *int   `json:"remaining_percent"`
+			} `json:"status"`
+		} `json:"self_test"`
+	} `json:"ata_smart_data"`
+	AtaSmartSelfTestLog struct {
+		Standard struct {
+			Table []struct {
+				Type struct {
+					String string `json:"string"`
+				} `json:"type"`
+				Status struct {
+					String string `json:"string"`
+					Passed *bool  `json:"passed"`
+				} `json:"status"`
+				LifetimeHours int64 `json:"lifetime_hours"`
+			} `json:"table"`
+		} `json:"standard"`
+	} `json:"ata_smart_self_test_log"`
+
+	NvmeSmartHealthInformationLog *struct {
+		CriticalWarning int   `json:"critical_warning"`
+		Temperature     int   `json:"temperature"`
+		AvailableSpare  int   `json:"available_spare"`
+		PercentageUsed  int   `json:"percentage_used"`
+		PowerCycles     int64 `json:"power_cycles"`
+		PowerOnHours    int64 `json:"power_on_hours"`
+		MediaErrors     int64 `json:"media_errors"`
+	} `json:"nvme_smart_health_information_log"`
+	NvmeSelfTestLog struct {
+		CurrentSelfTestOperation struct {
+			Value int `json:"value"`
+		} `json:"current_self_test_operation"`
+		CurrentSelfTestCompletionPercent *int `json:"current_self_test_completion_percent"`
+		Table                            []struct {
+			SelfTestCode struct {
+				String string `json:"string"`
+			} `json:"self_test_code"`
+			SelfTestResult struct {
+				Value  int    `json:"value"`
+				String string `json:"string"`
+			} `json:"self_test_result"`
+			PowerOnHours int64 `json:"power_on_hours"`
+		} `json:"table"`
+	} `json:"nvme_self_test_log"`
+}
+
+// ReadSmart reads the SMART data of a device. Unless wake is set, a disk in
+// standby is left alone and ErrStandby returned.
+func ReadSmart(device string, wake bool) (*SmartInfo, error) {
+	args := []string{config.Get().Commands.Smartctl, "--json", "-a"}
+	if !wake {
+		args = append(args, "-n", "standby")
+	}
+	args = append(args, device)
+	output, err := exec.Command(config.Get().Commands.Sudo, args...).Output()
+
+	var exitError *exec.ExitError
+	if err != nil && !errors.As(err, &exitError) {
+		return nil, fmt.Errorf("failed to run smartctl: %w", err)
+	}
+	return parseSmart(output)
+}
+
+func parseSmart(output []byte) (*SmartInfo, error) {
+	var out smartctlOutput
+	if err := json.Unmarshal(output, &out); err != nil {
+		return nil, fmt.Errorf("failed to parse smartctl output: %w", err)
+	}
+
+	var messages []string
+	for _, message := range out.Smartctl.Messages {
+		messages = append(messages, message.String)
+	}
+	if out.Smartctl.ExitStatus&(smartctlCommandLineError|smartctlOpenFailed) != 0 {
+		text := strings.Join(messages, "; ")
+		if strings.Contains(text, "STANDBY") || strings.Contains(text, "SLEEP") {
+			return nil, ErrStandby
+		}
+		if text == "" {
+			text = fmt.Sprintf("exit status %d", out.Smartctl.ExitStatus)
+		}
+		return nil, fmt.Errorf("smartctl failed: %s", text)
+	}
+	if out.SmartSupport != nil && !out.SmartSupport.Available {
+		return nil, ErrUnsupported
+	}
+
+	info := &SmartInfo{
+		Device:      out.Device.Name,
+		Protocol:    out.Device.Protocol,
+		Model:       out.ModelName,
+		Serial:      out.SerialNumber,
+		Firmware:    out.FirmwareVersion,
+		Capacity:    out.UserCapacity.Bytes,
+		PowerCycles: out.PowerCycleCount,
+		Attributes:  []SmartAttribute{},
+		Messages:    messages,
+	}
+	if out.RotationRate != nil {
+		info.RotationRate = *out.RotationRate
+	}
+	if out.SmartStatus != nil {
+		info.Healthy = &out.SmartStatus.Passed
+	}
+	if out.Temperature != nil {
+		info.Temperature = out.Temperature.Current
+	}
+	if out.PowerOnTime != nil {
+		info.PowerOnHours = &out.PowerOnTime.Hours
+	}
+
+	for _, a := range out.AtaSmartAttributes.Table {
+		attribute := SmartAttribute{
+			ID:         a.ID,
+			Name:       a.Name,
+			Value:      a.Value,
+			Worst:      a.Worst,
+			Threshold:  a.Thresh,
+			Raw:        a.Raw.Value,
+			RawString:  a.Raw.String,
+			Prefailure: a.Flags.Prefailure,
+			WhenFailed: a.WhenFailed,
+		}
+		info.Attributes = append(info.Attributes, attribute)
+
+		raw := a.Raw.Value
+		switch a.ID {
+		case ataReallocatedSectors:
+			info.ReallocatedSectors = &raw
+		case ataPendingSectors:
+			info.PendingSectors = &raw
+		case ataUncorrectable:
+			info.Uncorrectable = &raw
+		}
+	}
+
+	status := out.AtaSmartData.SelfTest.Status
+	// ATA reports a running test with status values 0xf0 to 0xff
+	if status.Value>>4 == 0xf {
+		info.SelfTest.InProgress = true
+		if status.RemainingPercent != nil {
+			info.SelfTest.RemainingPercent = *status.RemainingPercent
+		}
+	}
+	if entries := out.AtaSmartSelfTestLog.Standard.Table; len(entries) > 0 {
+		info.SelfTest.LastType = entries[0].Type.String
+		info.SelfTest.LastResult = entries[0].Status.String
+		info.SelfTest.LastPassed = entries[0].Status.Passed
+		info.SelfTest.LastPowerOnHours = entries[0].LifetimeHours
+	}
+
+	if nvme := out.NvmeSmartHealthInformationLog; nvme != nil {
+		info.MediaErrors = &nvme.MediaErrors
+		info.PercentageUsed = &nvme.PercentageUsed
+		info.AvailableSpare = &nvme.AvailableSpare
+		info.CriticalWarning = &nvme.CriticalWarning
+		if info.Temperature == nil {
+			info.Temperature = &nvme.Temperature
+		}
+		if info.PowerOnHours == nil {
+			info.PowerOnHours = &nvme.PowerOnHours
+		}
+		if info.PowerCycles == nil {
+			info.PowerCycles = &nvme.PowerCycles
+		}
+	}
+	selfTestLog := out.NvmeSelfTestLog
+	if selfTestLog.CurrentSelfTestOperation.Value != 0 {
+		info.SelfTest.InProgress = true
+		if done := selfTestLog.CurrentSelfTestCompletionPercent; done != nil {
+			info.SelfTest.RemainingPercent = 100 - *done
+		}
+	}
+	if entries := selfTestLog.Table; len(entries) > 0 {
+		// result 0 is completed without error, 15 an unused entry
+		if entries[0].SelfTestResult.Value != 15 {
+			passed := entries[0].SelfTestResult.Value == 0
+			info.SelfTest.LastType = entries[0].SelfTestCode.String
+			info.SelfTest.LastResult = entries[0].SelfTestResult.String
+			info.SelfTest.LastPassed = &passed
+			info.SelfTest.LastPowerOnHours = entries[0].PowerOnHours
+		}
+	}
+	return info, nil
+}
+
+// FailingAttributes returns the names of the attributes at or below their
+// threshold.
+func (info *SmartInfo) FailingAttributes() []string {
+	var failing []string
+	for _, a := range info.Attributes {
+		if a.WhenFailed != "" {
+			failing = append(failing, a.Name)
+		}
+	}
+	return failing
+}
+
+// StartSelfTest starts a short or long self-test, which the disk runs in
+// the background.
+func StartSelfTest(device, testType string) error {
+	if testType != SelfTestShort && testType != SelfTestLong {
+		return fmt.Errorf("unknown self-test type '%s'", testType)
+	}
+	output, err := exec.Command(config.Get().Commands.Sudo, config.Get().Commands.Smartctl,
+		"--json", "-t", testType, device).Output()
+
+	var exitError *exec.ExitError
+	if err != nil && !errors.As(err, &exitError) {
+		return fmt.Errorf("failed to run smartctl: %w", err)
+	}
+	var out smartctlOutput
+	if jsonErr := json.Unmarshal(output, &out); jsonErr != nil {
+		if err != nil {
+			return fmt.Errorf("failed to start self-test: %w", err)
+		}
+		return fmt.Errorf("failed to parse smartctl output: %w", jsonErr)
+	}
+	// a test that could not start is a failed SMART command
+	if out.Smartctl.ExitStatus&(smartctlCommandLineError|smartctlOpenFailed|smartctlCommandFailed) != 0 {
+		var messages []string
+		for _, message := range out.Smartctl.Messages {
+			messages = append(messages, message.String)
+		}
+		return fmt.Errorf("failed to start self-test: %s", strings.Join(messages, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package disks
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseSmart(t *testing.T) {
+	tests := []struct {
+		fixture     string
+		err         error
+		protocol    string
+		healthy     bool
+		temperature int
+		powerOn     int64
+		reallocated *int64
+		mediaErrors *int64
+		failing     []string
+		inProgress  bool
+		lastPassed  bool
+	}{
+		{
+			fixture:     "smartctl_sata_healthy.json",
+			protocol:    "ATA",
+			healthy:     true,
+			temperature: 34,
+			powerOn:     21437,
+			reallocated: int64Ptr(0),
+			lastPassed:  true,
+		},
+		{
+			fixture:     "smartctl_nvme.json",
+			protocol:    "NVMe",
+			healthy:     true,
+			temperature: 41,
+			powerOn:     9876,
+			mediaErrors: int64Ptr(0),
+			lastPassed:  true,
+		},
+		{
+			fixture:     "smartctl_sata_failing.json",
+			protocol:    "ATA",
+			healthy:     false,
+			temperature: 42,
+			powerOn:     43871,
+			reallocated: int64Ptr(1832),
+			failing:     []string{"Reallocated_Sector_Ct"},
+			inProgress:  true,
+			lastPassed:  false,
+		},
+		{
+			fixture: "smartctl_standby.json",
+			err:     ErrStandby,
+		},
+		{
+			fixture: "smartctl_unsupported.json",
+			err:     ErrUnsupported,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			output, err := os.ReadFile(filepath.Join("testdata", tt.fixture))
+			if err != nil {
+				t.Fatal(err)
+			}
+			info, err := parseSmart(output)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("parseSmart() error = %v, want %v", err, tt.err)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("parseSmart() error = %v", err)
+			}
+
+			if info.Protocol != tt.protocol {
+				t.Errorf("Protocol = %q, want %q", info.Protocol, tt.protocol)
+			}
+			if info.Healthy == nil || *info.Healthy != tt.healthy {
+				t.Errorf("Healthy = %v, want %v", deref(info.Healthy), tt.healthy)
+			}
+			if info.Temperature == nil || *info.Temperature != tt.temperature {
+				t.Errorf("Temperature = %v, want %d", deref(info.Temperature), tt.temperature)
+			}
+			if info.PowerOnHours == nil || *info.PowerOnHours != tt.powerOn {
+				t.Errorf("PowerOnHours = %v, want %d", deref(info.PowerOnHours), tt.powerOn)
+			}
+			if !reflect.DeepEqual(info.ReallocatedSectors, tt.reallocated) {
+				t.Errorf("ReallocatedSectors = %v, want %v", deref(info.ReallocatedSectors), deref(tt.reallocated))
+			}
+			if !reflect.DeepEqual(info.MediaErrors, tt.mediaErrors) {
+				t.Errorf("MediaErrors = %v, want %v", deref(info.MediaErrors), deref(tt.mediaErrors))
+			}
+			if failing := info.FailingAttributes(); !reflect.DeepEqual(failing, tt.failing) {
+				t.Errorf("FailingAttributes() = %v, want %v", failing, tt.failing)
+			}
+			if info.SelfTest.InProgress != tt.inProgress {
+				t.Errorf("SelfTest.InProgress = %v, want %v", info.SelfTest.InProgress, tt.inProgress)
+			}
+			if info.SelfTest.LastPassed == nil || *info.SelfTest.LastPassed != tt.lastPassed {
+				t.Errorf("SelfTest.LastPassed = %v, want %v", deref(info.SelfTest.LastPassed), tt.lastPassed)
+			}
+		})
+	}
+}
+
+func TestParseSmartFailingCounters(t *testing.T) {
+	output, err := os.ReadFile(filepath.Join("testdata", "smartctl_sata_failing.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := parseSmart(output)
+	if err != nil {
+		t.Fatalf("parseSmart() error = %v", err)
+	}
+	if deref(info.PendingSectors) != int64(24) {
+		t.Errorf("PendingSectors = %v, want 24", deref(info.PendingSectors))
+	}
+	if deref(info.Uncorrectable) != int64(8) {
+		t.Errorf("Uncorrectable = %v, want 8", deref(info.Uncorrectable))
+	}
+	if info.SelfTest.RemainingPercent != 90 {
+		t.Errorf("SelfTest.RemainingPercent = %d, want 90", info.SelfTest.RemainingPercent)
+	}
+	if info.SelfTest.LastResult != "Completed: read failure" {
+		t.Errorf("SelfTest.LastResult = %q", info.SelfTest.LastResult)
+	}
+	if len(info.Messages) != 1 {
+		t.Errorf("Messages = %v, want the smartctl warning", info.Messages)
+	}
+}
+
+func int64Ptr(v int64) *int64 {
+	return &v
+}
+
+// deref returns the value a pointer points to, or nil, for error messages
+func deref[T any](p *T) interface{} {
+	if p == nil {
+		return nil
+	}
+	return *p
+}
@@ -1,10 +1,57 @@
 package dto
 
-import "github.com/whyxn/easynas/backend/pkg/enum"
+import (
+	"encoding/json"
+	"github.com/whyxn/easynas/backend/pkg/enum"
+)
 
 type LoginInputDTO struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
+	Username   string `json:"username"`
+	Password   string `json:"password"`
+	DeviceName string `json:"deviceName"`
+}
+
+type LoginTwoFactorInputDTO struct {
+	MfaToken     string `json:"mfaToken"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recoveryCode"`
+	DeviceName   string `json:"deviceName"`
+}
+
+type TwoFactorCodeInputDTO struct {
+	Code string `json:"code"`
+}
+
+type DisableTwoFactorInputDTO struct {
+	Password     string `json:"password"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recoveryCode"`
+}
+
+type SecuritySettingsDTO struct {
+	RequireTwoFactorForAdmins *bool `json:"requireTwoFactorForAdmins"`
+	LoginFreeAttempts         *int  `json:"loginFreeAttempts"`
+	LoginBackoffBaseSeconds   *int  `json:"loginBackoffBaseSeconds"`
+	LoginBackoffMaxSeconds    *int  `json:"loginBackoffMaxSeconds"`
+	LoginMaxFailures          *int  `json:"loginMaxFailures"`
+	LoginMaxFailuresPerIP     *int  `json:"loginMaxFailuresPerIP"`
+	LoginLockoutMinutes       *int  `json:"loginLockoutMinutes"`
+	PasswordMinLength         *int  `json:"passwordMinLength"`
+	PasswordRequireUpper      *bool `json:"passwordRequireUpper"`
+	PasswordRequireLower      *bool `json:"passwordRequireLower"`
+	PasswordRequireDigit      *bool `json:"passwordRequireDigit"`
+	PasswordRequireSymbol     *bool `json:"passwordRequireSymbol"`
+	PasswordHistoryCount      *int  `json:"passwordHistoryCount"`
+}
+
+type RefreshTokenInputDTO struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+type CreateApiTokenInputDTO struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int      `json:"expiresInDays"`
 }
 
 type CreateUserInputDTO struct {
@@ -16,8 +63,17 @@ type CreateUserInputDTO struct {
 	Role            string `json:"role"`
 }
 
+type UpdateUserInputDTO struct {
+	Name        *string `json:"name"`
+	Email       *string `json:"email"`
+	NasClientIP *string `json:"nasClientIP"`
+	Role        *string `json:"role"`
+	Disabled    *bool   `json:"disabled"`
+}
+
 type UpdateUserPasswordInputDTO struct {
 	Id              uint   `json:"id"`
+	CurrentPassword string `json:"currentPassword"`
 	Password        string `json:"password"`
 	ConfirmPassword string `json:"confirmPassword"`
 }
@@ -32,6 +88,12 @@ type CreateZfsDatasetInputDTO struct {
 	Quota       string `json:"quota"`
 }
 
+type PoolSettingsInputDTO struct {
+	DefaultQuota string `json:"defaultQuota"`
+	Compression  string `json:"compression"`
+	RecordSize   string `json:"recordSize"`
+}
+
 type DeleteZfsDatasetInputDTO struct {
 	Pool        string `json:"pool"`
 	DatasetName string `json:"datasetName"`
@@ -64,3 +126,36 @@ type RemoveUserPermissionFromNfsShareInputDTO struct {
 type RestoreFromSnapshotInputDTO struct {
 	SnapshotName string `json:"snapshotName"`
 }
+
+type CertificateInputDTO struct {
+	// Certificate is the PEM encoded chain, leaf first
+	Certificate string `json:"certificate"`
+	PrivateKey  string `json:"privateKey"`
+}
+
+type AlertRuleInputDTO struct {
+	Name     string          `json:"name"`
+	Type     string          `json:"type"`
+	Params   json.RawMessage `json:"params"`
+	Severity string          `json:"severity"`
+	Enabled  *bool           `json:"enabled"`
+	// Channels are the IDs of the channels to notify, every enabled channel if empty
+	Channels        []uint `json:"channels"`
+	RenotifyMinutes *int   `json:"renotifyMinutes"`
+	NotifyResolved  *bool  `json:"notifyResolved"`
+}
+
+type AlertChannelInputDTO struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	// Config holds the settings of the type, secrets may be left as returned
+	// by the API to keep them
+	Config      json.RawMessage `json:"config"`
+	Enabled     *bool           `json:"enabled"`
+	MinSeverity string          `json:"minSeverity"`
+}
+
+type DiskSelfTestInputDTO struct {
+	// Type is short or long
+	Type string `json:"type"`
+}
@@ -6,3 +6,17 @@ const (
 	ReadOnly  PermissionType = "r"
 	ReadWrite PermissionType = "rw"
 )
+
+type TokenScope string
+
+const (
+	ScopeNasRead      TokenScope = "nas:read"
+	ScopeNasWrite     TokenScope = "nas:write"
+	ScopeUsersRead    TokenScope = "users:read"
+	ScopeUsersWrite   TokenScope = "users:write"
+	ScopeMetricsRead  TokenScope = "metrics:read"
+	ScopeMetricsWrite TokenScope = "metrics:write"
+	ScopeAuditRead    TokenScope = "audit:read"
+)
+
+var TokenScopes = []TokenScope{ScopeNasRead, ScopeNasWrite, ScopeUsersRead, ScopeUsersWrite, ScopeMetricsRead, ScopeMetricsWrite, ScopeAuditRead}
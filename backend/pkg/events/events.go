@@ -0,0 +1,186 @@
+package events
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// Topics clients subscribe to
+const (
+	TopicDataset  = "dataset"
+	TopicShare    = "share"
+	TopicSnapshot = "snapshot"
+	TopicJob      = "job"
+	TopicPool     = "pool"
+	TopicMetrics  = "metrics"
+)
+
+var Topics = []string{TopicDataset, TopicShare, TopicSnapshot, TopicJob, TopicPool, TopicMetrics}
+
+// Event types, prefixed by their topic
+const (
+	DatasetCreated   = "dataset.created"
+	DatasetDeleted   = "dataset.deleted"
+	ShareChanged     = "share.changed"
+	SnapshotCreated  = "snapshot.created"
+	SnapshotDeleted  = "snapshot.deleted"
+	SnapshotRestored = "snapshot.restored"
+	JobChanged       = "job.changed"
+	PoolHealth       = "pool.health"
+	PoolScrub        = "pool.scrub"
+	MetricsSample    = "metrics.system"
+)
+
+// subscriberBuffer is how many events a slow client may fall behind before
+// it is disconnected. It resumes from the history when it reconnects.
+const subscriberBuffer = 256
+
+// Event is published on the bus. Dataset and OwnerId decide who may see it.
+type Event struct {
+	ID    uint64      `json:"id"`
+	Type  string      `json:"type"`
+	Topic string      `json:"topic"`
+	Time  time.Time   `json:"time"`
+	Pool  string      `json:"pool,omitempty"`
+	Data  interface{} `json:"data,omitempty"`
+	// Dataset limits the event to admins and users with a permission on it
+	Dataset string `json:"dataset,omitempty"`
+	// OwnerId limits the event to admins and this user
+	OwnerId uint `json:"-"`
+	// Volatile events like metrics samples are not kept in the history, a
+	// resuming client does not need the samples it missed
+	Volatile bool `json:"-"`
+}
+
+// Subscription receives the events published after it was created. C is
+// closed when the subscriber falls too far behind or unsubscribes.
+type Subscription struct {
+	C chan Event
+	// Head is the ID of the latest event when subscribing
+	Head   uint64
+	topics map[string]bool
+	closed bool
+}
+
+var (
+	mu          sync.Mutex
+	subscribers = map[*Subscription]struct{}{}
+	// history keeps the latest events for clients resuming a stream
+	history     []Event
+	historySize = 1000
+	// IDs start at the boot time so they keep growing across restarts
+	lastID = uint64(time.Now().UnixMilli()) * 1000
+	// dropped is the ID of the latest event no longer in the history
+	dropped = lastID
+)
+
+// SetHistorySize sets how many events are kept for resuming streams.
+func SetHistorySize(size int) {
+	mu.Lock()
+	defer mu.Unlock()
+	historySize = size
+	if len(history) > size {
+		dropped = history[len(history)-size-1].ID
+		history = append([]Event{}, history[len(history)-size:]...)
+	}
+}
+
+// Publish sends an event to every subscriber.
+func Publish(event Event) {
+	if event.Topic == "" {
+		event.Topic = topicOf(event.Type)
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	lastID++
+	event.ID = lastID
+	if !event.Volatile {
+		history = append(history, event)
+		if len(history) > historySize {
+			dropped = history[len(history)-historySize-1].ID
+			history = history[len(history)-historySize:]
+		}
+	}
+
+	for s := range subscribers {
+		if !s.topics[event.Topic] {
+			continue
+		}
+		select {
+		case s.C <- event:
+		default:
+			closeSubscription(s)
+		}
+	}
+}
+
+// Subscribe returns a subscription to the topics and their events published
+// after resumeID that are still in the history. complete is false when events after
+// resumeID were already dropped from the history, or resumeID is unknown,
+// so the client has to reload its state.
+func Subscribe(topics []string, resumeID uint64) (s *Subscription, missed []Event, complete bool) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	s = &Subscription{C: make(chan Event, subscriberBuffer), Head: lastID, topics: map[string]bool{}}
+	for _, topic := range topics {
+		s.topics[topic] = true
+	}
+	subscribers[s] = struct{}{}
+
+	if resumeID == 0 || resumeID == lastID {
+		return s, nil, true
+	}
+	complete = resumeID >= dropped && resumeID <= lastID
+	if !complete {
+		return s, nil, false
+	}
+	for _, event := range history {
+		if event.ID > resumeID && s.topics[event.Topic] {
+			missed = append(missed, event)
+		}
+	}
+	return s, missed, true
+}
+
+// Unsubscribe stops the subscription.
+func Unsubscribe(s *Subscription) {
+	mu.Lock()
+	defer mu.Unlock()
+	closeSubscription(s)
+}
+
+// HasSubscribers tells whether anybody listens to the topic, so costly
+// events like metrics samples are only collected when needed.
+func HasSubscribers(topic string) bool {
+	mu.Lock()
+	defer mu.Unlock()
+	for s := range subscribers {
+		if s.topics[topic] {
+			return true
+		}
+	}
+	return false
+}
+
+// closeSubscription must be called with mu held
+func closeSubscription(s *Subscription) {
+	if !s.closed {
+		s.closed = true
+		close(s.C)
+		delete(subscribers, s)
+	}
+}
+
+func topicOf(eventType string) string {
+	if i := strings.IndexByte(eventType, '.'); i >= 0 {
+		return eventType[:i]
+	}
+	return eventType
+}
@@ -0,0 +1,266 @@
+package forecast
+
+import (
+	"github.com/whyxn/easynas/backend/pkg/config"
+	"github.com/whyxn/easynas/backend/pkg/history"
+	"github.com/whyxn/easynas/backend/pkg/nas"
+	"github.com/whyxn/easynas/backend/pkg/util"
+	"math"
+	"time"
+)
+
+// Kinds of forecast targets
+const (
+	KindPool    = "pool"
+	KindDataset = "dataset"
+)
+
+// Sources of the capacity of a target
+const (
+	CapacityPoolSize  = "size"
+	CapacityQuota     = "quota"
+	CapacityAvailable = "available"
+)
+
+// minPoints is the number of history points needed to fit a trend
+const minPoints = 3
+
+// minStep is the distance of the history points the trend is fitted to,
+// larger for long windows
+const minStep = time.Hour
+
+// Forecast projects when a pool or dataset reaches its thresholds.
+type Forecast struct {
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+	Pool string `json:"pool"`
+	// UsedBytes and CapacityBytes are the current values
+	UsedBytes     uint64 `json:"usedBytes"`
+	CapacityBytes uint64 `json:"capacityBytes"`
+	// CapacitySource tells what the capacity is: the pool size, the quota of
+	// a dataset, or used plus available space of a dataset without quota
+	CapacitySource string `json:"capacitySource"`
+	// Trend is nil when there is not enough history yet
+	Trend      *Trend      `json:"trend"`
+	Thresholds []Threshold `json:"thresholds"`
+}
+
+// Trend is the growth fitted to the history, with its 95% confidence bounds.
+type Trend struct {
+	BytesPerDay     float64   `json:"bytesPerDay"`
+	BytesPerDayLow  float64   `json:"bytesPerDayLow"`
+	BytesPerDayHigh float64   `json:"bytesPerDayHigh"`
+	Points          int       `json:"points"`
+	Since           time.Time `json:"since"`
+}
+
+// Threshold is the projection for one usage percentage. Days are nil when
+// the threshold is not reached at the current trend or its bound.
+type Threshold struct {
+	Percent int    `json:"percent"`
+	Bytes   uint64 `json:"bytes"`
+	Reached bool   `json:"reached"`
+	// Days is the projection, DaysEarliest and DaysLatest its bounds
+	Days         *float64   `json:"days"`
+	DaysEarliest *float64   `json:"daysEarliest"`
+	DaysLatest   *float64   `json:"daysLatest"`
+	Date         *time.Time `json:"date,omitempty"`
+}
+
+// Options select the targets and thresholds. Empty fields use the
+// configured thresholds and every managed pool.
+type Options struct {
+	Thresholds []int
+	Pools      []string
+	// Datasets limits the dataset forecasts, all datasets if nil
+	Datasets []string
+	// SkipPools leaves out the pool forecasts, e.g. for users
+	SkipPools bool
+}
+
+// Compute fits the growth trends and projects the thresholds.
+func Compute(options Options) ([]Forecast, error) {
+	cfg := config.Get().Forecast
+	thresholds := options.Thresholds
+	if len(thresholds) == 0 {
+		thresholds = cfg.Thresholds
+	}
+	pools := options.Pools
+	if len(pools) == 0 {
+		pools = config.Get().Nas.Pools
+	}
+
+	step := minStep
+	if perPoint := time.Duration(cfg.Window) / (history.MaxPoints / 2); perPoint > step {
+		step = perPoint
+	}
+
+	now := time.Now()
+	result, err := history.Query{
+		Metrics: []string{history.PoolAllocatedBytes, history.DatasetUsedBytes},
+		From:    now.Add(-time.Duration(cfg.Window)),
+		To:      now,
+		Step:    step,
+	}.Run()
+	if err != nil && err != history.ErrDisabled {
+		return nil, err
+	}
+	trends := map[string]*Trend{}
+	if result != nil {
+		for _, series := range result.Series {
+			trends[series.Metric+"/"+series.Series] = fit(series.Points, now)
+		}
+	}
+
+	stats, err := nas.ListPoolStats()
+	if err != nil {
+		return nil, err
+	}
+	forecasts := []Forecast{}
+	for _, pool := range stats {
+		if !util.Contains(pools, pool.Name) {
+			continue
+		}
+		if !options.SkipPools {
+			f := Forecast{
+				Kind:           KindPool,
+				Name:           pool.Name,
+				Pool:           pool.Name,
+				UsedBytes:      pool.Allocated,
+				CapacityBytes:  pool.Size,
+				CapacitySource: CapacityPoolSize,
+				Trend:          trends[history.PoolAllocatedBytes+"/"+pool.Name],
+			}
+			f.project(thresholds, now)
+			forecasts = append(forecasts, f)
+		}
+
+		datasets, err := nas.ListDatasetStats(pool.Name)
+		if err != nil {
+			return nil, err
+		}
+		for _, dataset := range datasets {
+			if options.Datasets != nil && !util.Contains(options.Datasets, dataset.Name) {
+				continue
+			}
+			f := Forecast{
+				Kind:           KindDataset,
+				Name:           dataset.Name,
+				Pool:           pool.Name,
+				UsedBytes:      dataset.Used,
+				CapacityBytes:  dataset.Used + dataset.Available,
+				CapacitySource: CapacityAvailable,
+				Trend:          trends[history.DatasetUsedBytes+"/"+dataset.Name],
+			}
+			if dataset.Quota > 0 {
+				f.CapacityBytes = dataset.Quota
+				f.CapacitySource = CapacityQuota
+			}
+			f.project(thresholds, now)
+			forecasts = append(forecasts, f)
+		}
+	}
+	return forecasts, nil
+}
+
+func (f *Forecast) project(percents []int, now time.Time) {
+	f.Thresholds = []Threshold{}
+	for _, percent := range percents {
+		t := Threshold{Percent: percent, Bytes: uint64(float64(f.CapacityBytes) * float64(percent) / 100)}
+		if f.UsedBytes >= t.Bytes {
+			t.Reached = true
+			zero := 0.0
+			t.Days, t.DaysEarliest, t.DaysLatest = &zero, &zero, &zero
+		} else if f.Trend != nil {
+			remaining := float64(t.Bytes - f.UsedBytes)
+			t.Days = daysUntil(remaining, f.Trend.BytesPerDay)
+			// the faster bound reaches it first
+			t.DaysEarliest = daysUntil(remaining, f.Trend.BytesPerDayHigh)
+			t.DaysLatest = daysUntil(remaining, f.Trend.BytesPerDayLow)
+			if t.Days != nil {
+				date := now.Add(time.Duration(*t.Days * float64(24*time.Hour)))
+				t.Date = &date
+			}
+		}
+		f.Thresholds = append(f.Thresholds, t)
+	}
+}
+
+// daysUntil returns nil if the growth never covers the remaining bytes
+func daysUntil(remaining, bytesPerDay float64) *float64 {
+	if bytesPerDay <= 0 {
+		return nil
+	}
+	days := remaining / bytesPerDay
+	// beyond about a century the projection means nothing
+	if days > 36500 {
+		return nil
+	}
+	days = math.Round(days*10) / 10
+	return &days
+}
+
+// fit returns the least squares line through the points with the 95%
+// confidence interval of its slope, nil with too few points
+func fit(points []history.Point, now time.Time) *Trend {
+	n := len(points)
+	if n < minPoints {
+		return nil
+	}
+
+	var sumX, sumY float64
+	xs := make([]float64, n)
+	for i, p := range points {
+		// days relative to now keep the numbers small
+		xs[i] = p.Time.Sub(now).Hours() / 24
+		sumX += xs[i]
+		sumY += p.Average
+	}
+	meanX, meanY := sumX/float64(n), sumY/float64(n)
+
+	var sxx, sxy float64
+	for i, p := range points {
+		dx := xs[i] - meanX
+		sxx += dx * dx
+		sxy += dx * (p.Average - meanY)
+	}
+	if sxx == 0 {
+		return nil
+	}
+	slope := sxy / sxx
+	intercept := meanY - slope*meanX
+
+	var sse float64
+	for i, p := range points {
+		residual := p.Average - (intercept + slope*xs[i])
+		sse += residual * residual
+	}
+	margin := 0.0
+	if n > 2 {
+		standardError := math.Sqrt(sse / float64(n-2) / sxx)
+		margin = tQuantile(n-2) * standardError
+	}
+
+	return &Trend{
+		BytesPerDay:     slope,
+		BytesPerDayLow:  slope - margin,
+		BytesPerDayHigh: slope + margin,
+		Points:          n,
+		Since:           points[0].Time,
+	}
+}
+
+// tTable holds the two-sided 95% quantiles of Student's t distribution for
+// 1 to 30 degrees of freedom
+var tTable = []float64{
+	12.706, 4.303, 3.182, 2.776, 2.571, 2.447, 2.365, 2.306, 2.262, 2.228,
+	2.201, 2.179, 2.160, 2.145, 2.131, 2.120, 2.110, 2.101, 2.093, 2.086,
+	2.080, 2.074, 2.069, 2.064, 2.060, 2.056, 2.052, 2.048, 2.045, 2.042,
+}
+
+func tQuantile(degrees int) float64 {
+	if degrees <= len(tTable) {
+		return tTable[degrees-1]
+	}
+	return 1.96
+}
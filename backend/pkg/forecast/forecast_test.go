@@ -0,0 +1,117 @@
+package forecast
+
+import (
+	"github.com/whyxn/easynas/backend/pkg/history"
+	"math"
+	"testing"
+	"time"
+)
+
+// points returns history points one day apart, the last one at now
+func points(now time.Time, values ...float64) []history.Point {
+	result := make([]history.Point, len(values))
+	for i, v := range values {
+		result[i] = history.Point{Time: now.AddDate(0, 0, i-len(values)+1), Average: v}
+	}
+	return result
+}
+
+func TestFit(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name         string
+		points       []history.Point
+		slope        float64
+		low, high    float64
+		wantNoTrend  bool
+		wantNoMargin bool
+	}{
+		{
+			name:   "noisy growth",
+			points: points(now, 600, 710, 790, 910, 1000),
+			slope:  100,
+			// t(3) = 3.182 times the standard error sqrt(280 / 3 / 10)
+			low:  90.2788,
+			high: 109.7212,
+		},
+		{
+			name:         "exact line",
+			points:       points(now, 1000, 950, 900, 850),
+			slope:        -50,
+			low:          -50,
+			high:         -50,
+			wantNoMargin: true,
+		},
+		{
+			name:        "too few points",
+			points:      points(now, 100, 200),
+			wantNoTrend: true,
+		},
+		{
+			name: "all at the same time",
+			points: []history.Point{
+				{Time: now, Average: 1}, {Time: now, Average: 2}, {Time: now, Average: 3},
+			},
+			wantNoTrend: true,
+		},
+	}
+
+	for _, tt := range tests {
+		trend := fit(tt.points, now)
+		if tt.wantNoTrend {
+			if trend != nil {
+				t.Errorf("%s: fit() = %+v, want nil", tt.name, trend)
+			}
+			continue
+		}
+		if trend == nil {
+			t.Errorf("%s: fit() = nil", tt.name)
+			continue
+		}
+		if !near(trend.BytesPerDay, tt.slope) || !near(trend.BytesPerDayLow, tt.low) || !near(trend.BytesPerDayHigh, tt.high) {
+			t.Errorf("%s: fit() = %v [%v, %v], want %v [%v, %v]", tt.name,
+				trend.BytesPerDay, trend.BytesPerDayLow, trend.BytesPerDayHigh, tt.slope, tt.low, tt.high)
+		}
+		if tt.wantNoMargin && trend.BytesPerDayLow != trend.BytesPerDayHigh {
+			t.Errorf("%s: bounds differ for points on a line", tt.name)
+		}
+		if trend.Points != len(tt.points) || !trend.Since.Equal(tt.points[0].Time) {
+			t.Errorf("%s: Points = %d, Since = %s", tt.name, trend.Points, trend.Since)
+		}
+	}
+}
+
+func TestProject(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	f := Forecast{
+		UsedBytes:     850,
+		CapacityBytes: 1000,
+		Trend:         &Trend{BytesPerDay: 10, BytesPerDayLow: 5, BytesPerDayHigh: 20},
+	}
+	f.project([]int{80, 90, 100}, now)
+
+	reached := f.Thresholds[0]
+	if !reached.Reached || *reached.Days != 0 {
+		t.Errorf("80%% = %+v, want reached", reached)
+	}
+	ninety := f.Thresholds[1]
+	if ninety.Bytes != 900 || *ninety.Days != 5 || *ninety.DaysEarliest != 2.5 || *ninety.DaysLatest != 10 {
+		t.Errorf("90%% = %d bytes in %v [%v, %v] days, want 900 in 5 [2.5, 10]",
+			ninety.Bytes, *ninety.Days, *ninety.DaysEarliest, *ninety.DaysLatest)
+	}
+	if ninety.Date == nil || !ninety.Date.Equal(now.AddDate(0, 0, 5)) {
+		t.Errorf("90%% date = %v, want in 5 days", ninety.Date)
+	}
+
+	// a shrinking trend never reaches the threshold
+	f.Trend = &Trend{BytesPerDay: -10, BytesPerDayLow: -20, BytesPerDayHigh: 1}
+	f.project([]int{100}, now)
+	full := f.Thresholds[0]
+	if full.Days != nil || full.DaysLatest != nil || full.DaysEarliest == nil || *full.DaysEarliest != 150 {
+		t.Errorf("100%% = %+v, want only the upper bound to reach it, in 150 days", full)
+	}
+}
+
+func near(got, want float64) bool {
+	return math.Abs(got-want) < 1e-3
+}
@@ -0,0 +1,248 @@
+package history
+
+import (
+	"context"
+	"database/sql"
+	"github.com/whyxn/easynas/backend/pkg/config"
+	"github.com/whyxn/easynas/backend/pkg/db"
+	"github.com/whyxn/easynas/backend/pkg/db/model"
+	"github.com/whyxn/easynas/backend/pkg/lifecycle"
+	"github.com/whyxn/easynas/backend/pkg/log"
+	"github.com/whyxn/easynas/backend/pkg/metrics"
+	"github.com/whyxn/easynas/backend/pkg/nas"
+	"github.com/whyxn/easynas/backend/pkg/util"
+	"time"
+)
+
+// Recorded metrics. Pool metrics are recorded per pool, dataset metrics per
+// dataset of the managed pools.
+const (
+	SystemCPUPercent      = "system.cpu_percent"
+	SystemMemoryUsedBytes = "system.memory_used_bytes"
+	SystemMemoryPercent   = "system.memory_percent"
+	SystemRootDiskUsed    = "system.root_disk_used_bytes"
+	SystemAppMemoryBytes  = "system.memory_application_bytes"
+	SystemArcSizeBytes    = "system.arc_size_bytes"
+	ArcTargetBytes        = "arc.target_bytes"
+	ArcHitPercent         = "arc.hit_percent"
+	L2ArcSizeBytes        = "arc.l2_size_bytes"
+	L2ArcHitPercent       = "arc.l2_hit_percent"
+	PoolSizeBytes         = "pool.size_bytes"
+	PoolAllocatedBytes    = "pool.allocated_bytes"
+	PoolFreeBytes         = "pool.free_bytes"
+	DatasetUsedBytes      = "dataset.used_bytes"
+	DatasetAvailableBytes = "dataset.available_bytes"
+)
+
+// Resolutions of the stored samples, in seconds. Raw samples are stored
+// with resolution 0.
+const (
+	resolutionRaw         = 0
+	resolutionFiveMinutes = 300
+	resolutionHour        = 3600
+)
+
+const (
+	rollupInterval  = 5 * time.Minute
+	pruneInterval   = time.Hour
+	sampleBatchSize = 500
+)
+
+// Metrics are the names of all recorded metrics
+var Metrics = []string{
+	SystemCPUPercent, SystemMemoryUsedBytes, SystemMemoryPercent, SystemRootDiskUsed,
+	SystemAppMemoryBytes, SystemArcSizeBytes,
+	ArcTargetBytes, ArcHitPercent, L2ArcSizeBytes, L2ArcHitPercent,
+	PoolSizeBytes, PoolAllocatedBytes, PoolFreeBytes,
+	DatasetUsedBytes, DatasetAvailableBytes,
+}
+
+// lastArc holds the ARC counters of the previous sample, hit ratios are
+// recorded over the interval between samples
+var lastArc *metrics.ArcStats
+
+// level is a resolution samples are stored at
+type level struct {
+	resolution int64
+	retention  time.Duration
+}
+
+// levels go from the finest to the coarsest resolution
+var levels []level
+
+// Start records samples at the configured interval and rolls them up in the
+// background.
+func Start(cfg config.HistoryConfig) {
+	levels = []level{
+		{resolution: resolutionRaw, retention: time.Duration(cfg.RawRetention)},
+		{resolution: resolutionFiveMinutes, retention: time.Duration(cfg.FiveMinuteRetention)},
+		{resolution: resolutionHour, retention: time.Duration(cfg.HourlyRetention)},
+	}
+
+	lifecycle.Go(func(ctx context.Context) {
+		record()
+		var lastRollup, lastPrune time.Time
+		ticker := time.NewTicker(time.Duration(cfg.Interval))
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				record()
+				if time.Since(lastRollup) >= rollupInterval {
+					rollupAll()
+					lastRollup = time.Now()
+				}
+				if time.Since(lastPrune) >= pruneInterval {
+					prune()
+					lastPrune = time.Now()
+				}
+			case <-lifecycle.Done():
+				return
+			}
+		}
+	})
+}
+
+// record takes a raw sample of every metric
+func record() {
+	now := time.Now().Unix()
+	var samples []model.MetricSample
+	add := func(metric, series string, value float64) {
+		samples = append(samples, model.MetricSample{
+			Metric:    metric,
+			Series:    series,
+			Timestamp: now,
+			Average:   value,
+			Minimum:   value,
+			Maximum:   value,
+			Count:     1,
+		})
+	}
+
+	if m, err := metrics.GetSystemMetrics(); err != nil {
+		log.Logger.Debugw("Failed to sample system metrics", "err", err)
+	} else {
+		add(SystemCPUPercent, "", m.CPUUsagePercent)
+		add(SystemMemoryUsedBytes, "", float64(m.MemoryUsage))
+		add(SystemMemoryPercent, "", m.MemoryPercent)
+		add(SystemRootDiskUsed, "", float64(m.DiskUsage))
+		add(SystemAppMemoryBytes, "", float64(m.ApplicationMemory))
+	}
+
+	if arc, err := metrics.GetArcStats(); err != nil {
+		log.Logger.Debugw("Failed to sample ARC metrics", "err", err)
+	} else {
+		add(SystemArcSizeBytes, "", float64(arc.Size))
+		add(ArcTargetBytes, "", float64(arc.TargetSize))
+		if arc.L2 != nil {
+			add(L2ArcSizeBytes, "", float64(arc.L2.Size))
+		}
+		if lastArc != nil {
+			if percent, ok := hitPercent(lastArc.Hits, lastArc.Misses, arc.Hits, arc.Misses); ok {
+				add(ArcHitPercent, "", percent)
+			}
+			if arc.L2 != nil && lastArc.L2 != nil {
+				if percent, ok := hitPercent(lastArc.L2.Hits, lastArc.L2.Misses, arc.L2.Hits, arc.L2.Misses); ok {
+					add(L2ArcHitPercent, "", percent)
+				}
+			}
+		}
+		lastArc = arc
+	}
+
+	if pools, err := nas.ListPoolStats(); err != nil {
+		log.Logger.Debugw("Failed to sample pool metrics", "err", err)
+	} else {
+		managed := config.Get().Nas.Pools
+		for _, pool := range pools {
+			if !util.Contains(managed, pool.Name) {
+				continue
+			}
+			add(PoolSizeBytes, pool.Name, float64(pool.Size))
+			add(PoolAllocatedBytes, pool.Name, float64(pool.Allocated))
+			add(PoolFreeBytes, pool.Name, float64(pool.Free))
+
+			datasets, err := nas.ListDatasetStats(pool.Name)
+			if err != nil {
+				log.Logger.Debugw("Failed to sample dataset metrics", "pool", pool.Name, "err", err)
+				continue
+			}
+			for _, dataset := range datasets {
+				add(DatasetUsedBytes, dataset.Name, float64(dataset.Used))
+				add(DatasetAvailableBytes, dataset.Name, float64(dataset.Available))
+			}
+		}
+	}
+
+	if len(samples) == 0 {
+		return
+	}
+	if err := db.GetDb().Client().CreateInBatches(samples, sampleBatchSize).Error; err != nil {
+		log.Logger.Warnw("Failed to store metric samples", "err", err)
+	}
+}
+
+// hitPercent is the hit ratio between two samples of the counters, if
+// there were accesses in between
+func hitPercent(lastHits, lastMisses, hits, misses uint64) (float64, bool) {
+	// the counters start over when the module is reloaded
+	if hits < lastHits || misses < lastMisses {
+		return 0, false
+	}
+	accesses := (hits - lastHits) + (misses - lastMisses)
+	if accesses == 0 {
+		return 0, false
+	}
+	return 100 * float64(hits-lastHits) / float64(accesses), true
+}
+
+func rollupAll() {
+	for i := 1; i < len(levels); i++ {
+		if err := rollup(levels[i-1], levels[i]); err != nil {
+			log.Logger.Warnw("Failed to roll up metric samples", "resolution", levels[i].resolution, "err", err)
+		}
+	}
+}
+
+// rollup summarizes the complete buckets of the finer level that were not
+// rolled up yet
+func rollup(from, to level) error {
+	client := db.GetDb().Client()
+
+	var last sql.NullInt64
+	err := client.Model(&model.MetricSample{}).
+		Where("resolution = ?", to.resolution).
+		Select("MAX(timestamp)").
+		Scan(&last).Error
+	if err != nil {
+		return err
+	}
+	var start int64
+	if last.Valid {
+		start = last.Int64 + to.resolution
+	}
+	end := time.Now().Unix() / to.resolution * to.resolution
+
+	return client.Exec(`INSERT INTO metric_samples (metric, series, resolution, timestamp, average, minimum, maximum, count)
+		SELECT metric, series, ?, timestamp / ? * ?, SUM(average * count) / SUM(count), MIN(minimum), MAX(maximum), SUM(count)
+		FROM metric_samples
+		WHERE resolution = ? AND timestamp >= ? AND timestamp < ?
+		GROUP BY metric, series, timestamp / ?`,
+		to.resolution, to.resolution, to.resolution,
+		from.resolution, start, end,
+		to.resolution).Error
+}
+
+// prune drops the samples older than the retention of their level
+func prune() {
+	for _, l := range levels {
+		result := db.GetDb().Client().
+			Where("resolution = ? AND timestamp < ?", l.resolution, time.Now().Add(-l.retention).Unix()).
+			Delete(&model.MetricSample{})
+		if result.Error != nil {
+			log.Logger.Warnw("Failed to prune metric samples", "resolution", l.resolution, "err", result.Error)
+		} else if result.RowsAffected > 0 {
+			log.Logger.Debugw("Pruned metric samples", "resolution", l.resolution, "count", result.RowsAffected)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package history
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/whyxn/easynas/backend/pkg/db"
+	"github.com/whyxn/easynas/backend/pkg/db/model"
+	"github.com/whyxn/easynas/backend/pkg/util"
+	"time"
+)
+
+// MaxPoints limits the points of a series returned by one query
+const MaxPoints = 5000
+
+// ErrDisabled is returned when no history is recorded
+var ErrDisabled = errors.New("metrics history is disabled")
+
+// ErrInvalidQuery is wrapped by the errors about the query itself
+var ErrInvalidQuery = errors.New("invalid query")
+
+// Query selects the recorded values of metrics.
+type Query struct {
+	Metrics []string
+	// Series limits pool and dataset metrics to these pools or datasets, all if nil
+	Series []string
+	From   time.Time
+	To     time.Time
+	// Step is the distance between points. It is raised to the resolution
+	// of the stored samples that still cover From.
+	Step time.Duration
+}
+
+// Point is the average, minimum and maximum of the samples of a step.
+type Point struct {
+	Time    time.Time `json:"time"`
+	Average float64   `json:"avg"`
+	Minimum float64   `json:"min"`
+	Maximum float64   `json:"max"`
+}
+
+// Series holds the points of a metric for one pool or dataset, or the host.
+type Series struct {
+	Metric string  `json:"metric"`
+	Series string  `json:"series,omitempty"`
+	Points []Point `json:"points"`
+}
+
+// Result is the answer to a query. Step is the step actually used.
+type Result struct {
+	From   time.Time `json:"from"`
+	To     time.Time `json:"to"`
+	Step   string    `json:"step"`
+	Series []Series  `json:"series"`
+}
+
+// Run executes the query.
+func (q Query) Run() (*Result, error) {
+	if len(q.Metrics) == 0 {
+		return nil, fmt.Errorf("%w: no metric given", ErrInvalidQuery)
+	}
+	for _, metric := range q.Metrics {
+		if !util.Contains(Metrics, metric) {
+			return nil, fmt.Errorf("%w: unknown metric '%s'", ErrInvalidQuery, metric)
+		}
+	}
+	if !q.To.After(q.From) {
+		return nil, fmt.Errorf("%w: the range must end after it starts", ErrInvalidQuery)
+	}
+	if len(levels) == 0 {
+		return nil, ErrDisabled
+	}
+
+	src := pickLevel(q.From, q.Step)
+	step := int64(q.Step.Seconds())
+	if step < src.resolution {
+		step = src.resolution
+	}
+	if step < 1 {
+		step = 1
+	}
+	if (q.To.Unix()-q.From.Unix())/step > MaxPoints {
+		return nil, fmt.Errorf("%w: the range holds more than %d points, use a larger step", ErrInvalidQuery, MaxPoints)
+	}
+
+	// rollups only exist for complete buckets, the raw samples after the
+	// last one fill in the tail of the range
+	client := db.GetDb().Client()
+	var last sql.NullInt64
+	err := client.Model(&model.MetricSample{}).Where("resolution = ?", src.resolution).Select("MAX(timestamp)").Scan(&last).Error
+	if err != nil {
+		return nil, err
+	}
+	rolledUpTo := q.To.Unix()
+	if src.resolution != resolutionRaw {
+		rolledUpTo = 0
+		if last.Valid {
+			rolledUpTo = last.Int64 + src.resolution
+		}
+	}
+
+	query := client.Table("metric_samples").
+		Select("metric, series, timestamp / ? * ? AS bucket, SUM(average * count) / SUM(count) AS average, MIN(minimum) AS minimum, MAX(maximum) AS maximum", step, step).
+		Where("metric IN ? AND timestamp >= ? AND timestamp < ?", q.Metrics, q.From.Unix(), q.To.Unix()).
+		Where("(resolution = ? AND timestamp < ?) OR (resolution = ? AND timestamp >= ?)", src.resolution, rolledUpTo, resolutionRaw, rolledUpTo)
+	if q.Series != nil {
+		// host metrics have no series
+		query = query.Where("series IN ? OR series = ''", q.Series)
+	}
+
+	var rows []struct {
+		Metric  string
+		Series  string
+		Bucket  int64
+		Average float64
+		Minimum float64
+		Maximum float64
+	}
+	err = query.Group("metric, series, bucket").Order("metric, series, bucket").Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{From: q.From, To: q.To, Step: (time.Duration(step) * time.Second).String(), Series: []Series{}}
+	for _, row := range rows {
+		n := len(result.Series)
+		if n == 0 || result.Series[n-1].Metric != row.Metric || result.Series[n-1].Series != row.Series {
+			result.Series = append(result.Series, Series{Metric: row.Metric, Series: row.Series})
+			n++
+		}
+		result.Series[n-1].Points = append(result.Series[n-1].Points, Point{
+			Time:    time.Unix(row.Bucket, 0).UTC(),
+			Average: row.Average,
+			Minimum: row.Minimum,
+			Maximum: row.Maximum,
+		})
+	}
+	return result, nil
+}
+
+// pickLevel returns the coarsest level not coarser than step that still
+// holds samples from the start of the range. If every such level has
+// already dropped them, the finest level that holds them is used.
+func pickLevel(from time.Time, step time.Duration) level {
+	var covering []level
+	for _, l := range levels {
+		if time.Since(from) <= l.retention {
+			covering = append(covering, l)
+		}
+	}
+	if len(covering) == 0 {
+		return levels[len(levels)-1]
+	}
+
+	picked := covering[0]
+	for _, l := range covering {
+		if time.Duration(l.resolution)*time.Second <= step {
+			picked = l
+		}
+	}
+	return picked
+}
@@ -0,0 +1,375 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/whyxn/easynas/backend/pkg/db"
+	"github.com/whyxn/easynas/backend/pkg/db/model"
+	"github.com/whyxn/easynas/backend/pkg/events"
+	"github.com/whyxn/easynas/backend/pkg/lifecycle"
+	"github.com/whyxn/easynas/backend/pkg/log"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 500
+
+	pruneInterval = time.Hour
+)
+
+var (
+	ErrUnknownType = errors.New("unknown job type")
+	ErrFinished    = errors.New("job has already finished")
+)
+
+// Handler runs a job and returns its result, which is stored as JSON. It
+// must return soon after ctx is cancelled, leaving things in a safe state.
+type Handler func(ctx context.Context, run *Run) (interface{}, error)
+
+type definition struct {
+	handler Handler
+	// resumable jobs are run again when interrupted by a restart
+	resumable bool
+}
+
+var (
+	definitions = map[string]definition{}
+
+	mu sync.Mutex
+	// queue holds the queued jobs, oldest first
+	queue []*model.Job
+	// busy holds the targets of running jobs
+	busy = map[string]bool{}
+	// running holds the cancel functions of running jobs
+	running = map[uint]context.CancelFunc{}
+	// cancelRequested holds running jobs cancelled by a user
+	cancelRequested = map[uint]bool{}
+	// changed is closed and replaced whenever a worker may find new work
+	changed = make(chan struct{})
+)
+
+// Register adds a job type. Resumable jobs must be safe to run again from
+// the start.
+func Register(jobType string, resumable bool, handler Handler) {
+	definitions[jobType] = definition{handler: handler, resumable: resumable}
+}
+
+// Enqueue stores a new job and hands it to the workers. Jobs with the same
+// target run one after another.
+func Enqueue(jobType string, ownerId uint, target string, params interface{}) (*model.Job, error) {
+	if _, ok := definitions[jobType]; !ok {
+		return nil, ErrUnknownType
+	}
+
+	encoded, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+
+	job := &model.Job{
+		Type:    jobType,
+		State:   model.JobQueued,
+		OwnerId: ownerId,
+		Target:  target,
+		Params:  string(encoded),
+	}
+	if err = db.GetDb().Insert(job); err != nil {
+		return nil, err
+	}
+
+	mu.Lock()
+	queue = append(queue, job)
+	notify()
+	mu.Unlock()
+
+	log.Logger.Infow("Queued job", "id", job.ID, "type", jobType, "target", target)
+	publish(job)
+	return job, nil
+}
+
+// Cancel stops a queued or running job. A running job is marked cancelled
+// once its handler has returned.
+func Cancel(id uint) (*model.Job, error) {
+	mu.Lock()
+	if cancel, ok := running[id]; ok {
+		cancelRequested[id] = true
+		cancel()
+		mu.Unlock()
+		return db.Get[model.Job](db.GetDb(), map[string]interface{}{"id": id})
+	}
+
+	var queued *model.Job
+	for i, job := range queue {
+		if job.ID == id {
+			queued = job
+			queue = append(queue[:i], queue[i+1:]...)
+			break
+		}
+	}
+	mu.Unlock()
+
+	if queued == nil {
+		job, err := db.Get[model.Job](db.GetDb(), map[string]interface{}{"id": id})
+		if err != nil {
+			return nil, err
+		}
+		if job.Finished() {
+			return job, ErrFinished
+		}
+		// queued before a restart that has not been picked up yet
+		queued = job
+	}
+
+	now := time.Now()
+	queued.State = model.JobCancelled
+	queued.FinishedAt = &now
+	if err := db.GetDb().Update(queued, map[string]interface{}{"state": queued.State, "finished_at": now}); err != nil {
+		return nil, err
+	}
+	publish(queued)
+	return queued, nil
+}
+
+// Start recovers the jobs interrupted by the last shutdown and starts the
+// workers. Workers stop taking jobs when shutdown starts; running jobs are
+// cancelled when the drain timeout is over.
+func Start(workers int, retention time.Duration) error {
+	if err := recoverInterrupted(); err != nil {
+		return err
+	}
+
+	var queued []model.Job
+	err := db.GetDb().Client().Where("state = ?", model.JobQueued).Order("id").Find(&queued).Error
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	for i := range queued {
+		queue = append(queue, &queued[i])
+	}
+	mu.Unlock()
+	if len(queued) > 0 {
+		log.Logger.Infow("Resuming queued jobs", "count", len(queued))
+	}
+
+	for i := 0; i < workers; i++ {
+		lifecycle.Go(work)
+	}
+	lifecycle.Go(func(ctx context.Context) {
+		prune(retention)
+		ticker := time.NewTicker(pruneInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				prune(retention)
+			case <-lifecycle.Done():
+				return
+			}
+		}
+	})
+	return nil
+}
+
+// recoverInterrupted handles jobs that were running when easynas stopped
+// without draining, e.g. after a crash.
+func recoverInterrupted() error {
+	var interrupted []model.Job
+	err := db.GetDb().Client().Where("state = ?", model.JobRunning).Find(&interrupted).Error
+	if err != nil {
+		return err
+	}
+
+	for i := range interrupted {
+		job := &interrupted[i]
+		updates := map[string]interface{}{}
+		if def, ok := definitions[job.Type]; ok && def.resumable {
+			updates["state"] = model.JobQueued
+			updates["logs"] = appendLog(job.Logs, "interrupted by a restart, resuming")
+		} else {
+			updates["state"] = model.JobFailed
+			updates["error"] = "interrupted by a restart"
+			updates["finished_at"] = time.Now()
+		}
+		if err = db.GetDb().Update(job, updates); err != nil {
+			return err
+		}
+		log.Logger.Warnw("Recovered interrupted job", "id", job.ID, "type", job.Type, "state", updates["state"])
+	}
+	return nil
+}
+
+func prune(retention time.Duration) {
+	result := db.GetDb().Client().
+		Where("state IN ? AND finished_at < ?", []string{model.JobSucceeded, model.JobFailed, model.JobCancelled}, time.Now().Add(-retention)).
+		Delete(&model.Job{})
+	if result.Error != nil {
+		log.Logger.Warnw("Failed to prune finished jobs", "err", result.Error)
+	} else if result.RowsAffected > 0 {
+		log.Logger.Infow("Pruned finished jobs", "count", result.RowsAffected)
+	}
+}
+
+// notify wakes up idle workers. mu must be held.
+func notify() {
+	close(changed)
+	changed = make(chan struct{})
+}
+
+func work(parent context.Context) {
+	for {
+		job, ctx, cancel := next(parent)
+		if job == nil {
+			return
+		}
+		execute(parent, ctx, cancel, job)
+	}
+}
+
+// next waits for a queued job whose target is not busy and registers it as
+// running, so that Cancel always finds it in the queue or running. It
+// returns nil when shutdown starts.
+func next(parent context.Context) (*model.Job, context.Context, context.CancelFunc) {
+	for {
+		mu.Lock()
+		if lifecycle.ShuttingDown() {
+			mu.Unlock()
+			return nil, nil, nil
+		}
+		for i, job := range queue {
+			if job.Target != "" && busy[job.Target] {
+				continue
+			}
+			queue = append(queue[:i], queue[i+1:]...)
+			if job.Target != "" {
+				busy[job.Target] = true
+			}
+			ctx, cancel := context.WithCancel(parent)
+			running[job.ID] = cancel
+			mu.Unlock()
+			return job, ctx, cancel
+		}
+		wait := changed
+		mu.Unlock()
+
+		select {
+		case <-wait:
+		case <-lifecycle.Done():
+		}
+	}
+}
+
+func execute(parent, ctx context.Context, cancel context.CancelFunc, job *model.Job) {
+	defer func() {
+		cancel()
+		mu.Lock()
+		delete(running, job.ID)
+		delete(cancelRequested, job.ID)
+		delete(busy, job.Target)
+		notify()
+		mu.Unlock()
+	}()
+
+	def := definitions[job.Type]
+	run := &Run{job: job}
+
+	// cancelled after it was taken from the queue
+	mu.Lock()
+	cancelledEarly := cancelRequested[job.ID]
+	mu.Unlock()
+	if cancelledEarly {
+		now := time.Now()
+		if err := db.GetDb().Update(job, map[string]interface{}{"state": model.JobCancelled, "finished_at": now}); err != nil {
+			log.Logger.Errorw("Failed to cancel job", "id", job.ID, "err", err)
+		}
+		log.Logger.Infow("Cancelled job before it started", "id", job.ID, "type", job.Type)
+		publish(job)
+		return
+	}
+
+	now := time.Now()
+	job.State = model.JobRunning
+	job.StartedAt = &now
+	job.Attempts++
+	if err := db.GetDb().Update(job, map[string]interface{}{"state": job.State, "started_at": now, "attempts": job.Attempts}); err != nil {
+		log.Logger.Errorw("Failed to start job", "id", job.ID, "err", err)
+		return
+	}
+	log.Logger.Infow("Running job", "id", job.ID, "type", job.Type, "target", job.Target)
+	publish(job)
+
+	result, err := invoke(ctx, def.handler, run)
+
+	mu.Lock()
+	userCancelled := cancelRequested[job.ID]
+	mu.Unlock()
+
+	updates := map[string]interface{}{}
+	switch {
+	case err == nil:
+		updates["state"] = model.JobSucceeded
+		updates["progress"] = 100
+		if result != nil {
+			if encoded, encodeErr := json.Marshal(result); encodeErr == nil {
+				updates["result"] = string(encoded)
+			}
+		}
+	case userCancelled:
+		updates["state"] = model.JobCancelled
+		updates["error"] = err.Error()
+	case parent.Err() != nil && def.resumable:
+		// picked up again on the next start
+		updates["state"] = model.JobQueued
+		updates["logs"] = run.log("interrupted by shutdown, will resume on restart")
+	case parent.Err() != nil:
+		updates["state"] = model.JobFailed
+		updates["error"] = "interrupted by shutdown: " + err.Error()
+	default:
+		updates["state"] = model.JobFailed
+		updates["error"] = err.Error()
+	}
+	if updates["state"] != model.JobQueued {
+		updates["finished_at"] = time.Now()
+	}
+
+	if updateErr := db.GetDb().Update(job, updates); updateErr != nil {
+		log.Logger.Errorw("Failed to store job result", "id", job.ID, "err", updateErr)
+	}
+	publish(job)
+	if err != nil {
+		log.Logger.Warnw("Job did not succeed", "id", job.ID, "type", job.Type, "state", updates["state"], "err", err)
+	} else {
+		log.Logger.Infow("Job succeeded", "id", job.ID, "type", job.Type)
+	}
+}
+
+// publish tells event stream clients about the job. Its log is left out.
+func publish(job *model.Job) {
+	events.Publish(events.Event{
+		Type:    events.JobChanged,
+		Dataset: job.Target,
+		OwnerId: job.OwnerId,
+		Data: map[string]interface{}{
+			"id":              job.ID,
+			"type":            job.Type,
+			"state":           job.State,
+			"progress":        job.Progress,
+			"progressMessage": job.ProgressMessage,
+			"error":           job.Error,
+		},
+	})
+}
+
+// invoke runs the handler, turning a panic into an error
+func invoke(ctx context.Context, handler Handler, run *Run) (result interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+	return handler(ctx, run)
+}
@@ -0,0 +1,59 @@
+package jobs
+
+import (
+	"context"
+	"github.com/whyxn/easynas/backend/pkg/db"
+	"github.com/whyxn/easynas/backend/pkg/db/model"
+	"github.com/whyxn/easynas/backend/pkg/log"
+	"go.uber.org/zap"
+	"path/filepath"
+	"testing"
+)
+
+// useTestDb connects the db package to a new, migrated database for the test
+func useTestDb(t *testing.T) {
+	t.Helper()
+	log.Logger = zap.NewNop().Sugar()
+	if err := db.Connect(filepath.Join(t.TempDir(), "easynas.db")); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := db.GetDb().RunMigrations(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCancelTakenFromQueue(t *testing.T) {
+	useTestDb(t)
+	called := false
+	Register("test", false, func(ctx context.Context, run *Run) (interface{}, error) {
+		called = true
+		return nil, nil
+	})
+
+	queued, err := Enqueue("test", 1, "naspool/data", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a worker took the job but did not start it yet
+	job, ctx, cancel := next(context.Background())
+	if job == nil || job.ID != queued.ID {
+		t.Fatalf("next() = %v, want the queued job", job)
+	}
+	if _, err = Cancel(job.ID); err != nil {
+		t.Fatalf("Cancel() error = %v", err)
+	}
+	execute(context.Background(), ctx, cancel, job)
+
+	if called {
+		t.Error("handler of a cancelled job was called")
+	}
+	stored, _ := db.Get[model.Job](db.GetDb(), map[string]interface{}{"id": job.ID})
+	if stored.State != model.JobCancelled || stored.FinishedAt == nil {
+		t.Errorf("job state = %s, finished %v, want cancelled", stored.State, stored.FinishedAt)
+	}
+	if len(running) != 0 || busy["naspool/data"] {
+		t.Errorf("job still registered: running %v, busy %v", running, busy)
+	}
+}
@@ -0,0 +1,116 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"github.com/whyxn/easynas/backend/pkg/db"
+	"github.com/whyxn/easynas/backend/pkg/db/model"
+	"github.com/whyxn/easynas/backend/pkg/events"
+	"github.com/whyxn/easynas/backend/pkg/nas"
+	"gorm.io/gorm"
+	"strings"
+)
+
+// Types of the NAS jobs
+const (
+	TypeSetOwnership     = "dataset.set-ownership"
+	TypeDestroyDataset   = "dataset.destroy"
+	TypeRollbackSnapshot = "snapshot.rollback"
+)
+
+type DatasetParams struct {
+	Dataset string `json:"dataset"`
+}
+
+type SnapshotParams struct {
+	Dataset  string `json:"dataset"`
+	Snapshot string `json:"snapshot"`
+}
+
+func init() {
+	// chown can be repeated, destroy and rollback must not run twice unasked
+	Register(TypeSetOwnership, true, setOwnership)
+	Register(TypeDestroyDataset, false, destroyDataset)
+	Register(TypeRollbackSnapshot, false, rollbackSnapshot)
+}
+
+func poolOf(dataset string) string {
+	return strings.SplitN(dataset, "/", 2)[0]
+}
+
+func setOwnership(ctx context.Context, run *Run) (interface{}, error) {
+	var params DatasetParams
+	if err := run.Params(&params); err != nil {
+		return nil, err
+	}
+
+	path := "/" + params.Dataset
+	run.Progress(0, "changing ownership of "+path)
+	if err := nas.SetPathPermissions(ctx, path); err != nil {
+		return nil, err
+	}
+	run.Logf("changed ownership of %s to nobody:nogroup", path)
+	return nil, nil
+}
+
+// destroyDataset destroys the dataset and forgets its NFS share. zfs destroy
+// cannot be interrupted safely, so cancelling only works before it starts.
+func destroyDataset(ctx context.Context, run *Run) (interface{}, error) {
+	var params DatasetParams
+	if err := run.Params(&params); err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	run.Progress(0, "destroying "+params.Dataset)
+	if err := nas.DeleteZFSVolume(params.Dataset); err != nil {
+		return nil, err
+	}
+	run.Logf("destroyed dataset %s", params.Dataset)
+	events.Publish(events.Event{Type: events.DatasetDeleted, Pool: poolOf(params.Dataset), Dataset: params.Dataset})
+	run.Progress(90, "removing nfs share")
+
+	err := db.GetDb().Client().Transaction(func(tx *gorm.DB) error {
+		var share model.NfsShare
+		if err := tx.Where("dataset = ?", params.Dataset).First(&share).Error; err == gorm.ErrRecordNotFound {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := tx.Where("nfs_share_id = ?", share.ID).Delete(&model.NfsSharePermission{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&share).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("dataset was destroyed but its nfs share records were not removed: %w", err)
+	}
+	return nil, nil
+}
+
+// rollbackSnapshot rolls the dataset back, destroying later snapshots.
+// Like destroy it can only be cancelled before it starts.
+func rollbackSnapshot(ctx context.Context, run *Run) (interface{}, error) {
+	var params SnapshotParams
+	if err := run.Params(&params); err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	run.Progress(0, "rolling back to "+params.Snapshot)
+	if err := nas.RestoreFromSnapshot(params.Snapshot); err != nil {
+		return nil, err
+	}
+	run.Logf("rolled back %s to %s", params.Dataset, params.Snapshot)
+	events.Publish(events.Event{
+		Type:    events.SnapshotRestored,
+		Pool:    poolOf(params.Dataset),
+		Dataset: params.Dataset,
+		Data:    map[string]interface{}{"snapshot": params.Snapshot},
+	})
+	return nil, nil
+}
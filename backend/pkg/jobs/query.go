@@ -0,0 +1,60 @@
+package jobs
+
+import (
+	"github.com/whyxn/easynas/backend/pkg/db"
+	"github.com/whyxn/easynas/backend/pkg/db/model"
+)
+
+// Filter narrows down jobs. Zero values do not filter.
+type Filter struct {
+	OwnerId uint
+	Type    string
+	State   string
+	Target  string
+}
+
+// Get returns a job.
+func Get(id uint) (*model.Job, error) {
+	return db.Get[model.Job](db.GetDb(), map[string]interface{}{"id": id})
+}
+
+// List returns one page of the jobs matching the filter, newest first, along
+// with the total number of matching jobs. Logs are left out. Pages start at 1.
+func List(filter Filter, page, pageSize int) ([]model.Job, int64, error) {
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 {
+		pageSize = DefaultPageSize
+	} else if pageSize > MaxPageSize {
+		pageSize = MaxPageSize
+	}
+
+	conditions := map[string]interface{}{}
+	if filter.OwnerId != 0 {
+		conditions["owner_id"] = filter.OwnerId
+	}
+	if filter.Type != "" {
+		conditions["type"] = filter.Type
+	}
+	if filter.State != "" {
+		conditions["state"] = filter.State
+	}
+	if filter.Target != "" {
+		conditions["target"] = filter.Target
+	}
+
+	query := db.GetDb().Client().Model(&model.Job{}).Where(conditions)
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var list []model.Job
+	err := query.Omit("logs").Order("id desc").Offset((page - 1) * pageSize).Limit(pageSize).Find(&list).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return list, total, nil
+}
@@ -0,0 +1,80 @@
+package jobs
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/whyxn/easynas/backend/pkg/db"
+	"github.com/whyxn/easynas/backend/pkg/db/model"
+	"github.com/whyxn/easynas/backend/pkg/log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxLogSize is the size of the log kept per job, older lines are dropped
+const maxLogSize = 64 << 10
+
+// Run is handed to a job handler to read its parameters and report
+// progress.
+type Run struct {
+	job *model.Job
+	mu  sync.Mutex
+}
+
+// ID returns the id of the job.
+func (r *Run) ID() uint {
+	return r.job.ID
+}
+
+// Params decodes the parameters the job was queued with into v.
+func (r *Run) Params(v interface{}) error {
+	return json.Unmarshal([]byte(r.job.Params), v)
+}
+
+// Progress stores how far the job got, in percent.
+func (r *Run) Progress(percent int, message string) {
+	if percent < 0 {
+		percent = 0
+	} else if percent > 100 {
+		percent = 100
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if percent == r.job.Progress && message == r.job.ProgressMessage {
+		return
+	}
+	r.store(map[string]interface{}{"progress": percent, "progress_message": message})
+	publish(r.job)
+}
+
+// Logf appends a line to the job log.
+func (r *Run) Logf(format string, args ...interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.store(map[string]interface{}{"logs": appendLog(r.job.Logs, fmt.Sprintf(format, args...))})
+}
+
+// log returns the job log with a line appended, for the final update
+func (r *Run) log(line string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return appendLog(r.job.Logs, line)
+}
+
+func (r *Run) store(updates map[string]interface{}) {
+	if err := db.GetDb().Update(r.job, updates); err != nil {
+		log.Logger.Warnw("Failed to update job", "id", r.job.ID, "err", err)
+	}
+}
+
+func appendLog(logs, line string) string {
+	logs += time.Now().UTC().Format(time.RFC3339) + " " + strings.TrimRight(line, "\n") + "\n"
+	if len(logs) > maxLogSize {
+		logs = logs[len(logs)-maxLogSize:]
+		if i := strings.IndexByte(logs, '\n'); i >= 0 {
+			logs = logs[i+1:]
+		}
+	}
+	return logs
+}
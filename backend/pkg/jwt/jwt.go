@@ -2,27 +2,36 @@ package jwt
 
 import (
 	"fmt"
+	"github.com/whyxn/easynas/backend/pkg/config"
 	"github.com/whyxn/easynas/backend/pkg/db/model"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
-var jwtKey = []byte("your_secret_key")
+// jwtKey returns the configured signing key
+func jwtKey() []byte {
+	return []byte(config.Get().Jwt.Secret)
+}
+
+// mfaAudience marks tokens that only prove the first login factor
+const mfaAudience = "easynas-mfa"
 
 type Claims struct {
-	User model.User
+	User      model.User
+	SessionId uint
 	jwt.RegisteredClaims
 }
 
-// GenerateJWT creates a new JWT token for a given username
-func GenerateJWT(user model.User) (string, error) {
+// GenerateJWT creates a new JWT token for a given user bound to a login session
+func GenerateJWT(user model.User, sessionId uint) (string, error) {
 	// Set expiration time for token
-	expirationTime := time.Now().Add(2 * time.Hour)
+	expirationTime := time.Now().Add(time.Duration(config.Get().Jwt.AccessTokenTTL))
 
 	// Create claims with username and expiry
 	claims := &Claims{
-		User: user,
+		User:      user,
+		SessionId: sessionId,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
 		},
@@ -32,7 +41,7 @@ func GenerateJWT(user model.User) (string, error) {
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 
 	// Sign the token with the secret key
-	return token.SignedString(jwtKey)
+	return token.SignedString(jwtKey())
 }
 
 // ValidateJWT parses and validates a JWT token from the request header
@@ -45,7 +54,7 @@ func ValidateJWT(tokenStr string) (*Claims, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("unexpected signing method")
 		}
-		return jwtKey, nil
+		return jwtKey(), nil
 	})
 
 	if err != nil {
@@ -56,5 +65,51 @@ func ValidateJWT(tokenStr string) (*Claims, error) {
 		return nil, fmt.Errorf("invalid token")
 	}
 
+	if len(claims.Audience) > 0 {
+		return nil, fmt.Errorf("token is not an access token")
+	}
+
+	return claims, nil
+}
+
+type MfaClaims struct {
+	UserId uint
+	jwt.RegisteredClaims
+}
+
+// GenerateMfaJWT creates a short-lived token for a user that passed the password
+// check and still has to present a second factor
+func GenerateMfaJWT(userId uint) (string, error) {
+	claims := &MfaClaims{
+		UserId: userId,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Audience:  jwt.ClaimStrings{mfaAudience},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Duration(config.Get().Jwt.MfaTokenTTL))),
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(jwtKey())
+}
+
+// ValidateMfaJWT parses and validates a token issued by GenerateMfaJWT
+func ValidateMfaJWT(tokenStr string) (*MfaClaims, error) {
+	claims := &MfaClaims{}
+
+	token, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method")
+		}
+		return jwtKey(), nil
+	}, jwt.WithAudience(mfaAudience))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !token.Valid {
+		return nil, fmt.Errorf("invalid token")
+	}
+
 	return claims, nil
 }
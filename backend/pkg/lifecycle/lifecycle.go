@@ -0,0 +1,70 @@
+package lifecycle
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	shuttingDown int32
+	stopping     = make(chan struct{})
+	stopOnce     sync.Once
+
+	// work is cancelled once the drain timeout is over
+	work, cancelWork = context.WithCancel(context.Background())
+	running          sync.WaitGroup
+)
+
+// ShuttingDown tells whether shutdown has started.
+func ShuttingDown() bool {
+	return atomic.LoadInt32(&shuttingDown) == 1
+}
+
+// BeginShutdown marks the server as shutting down: it reports not ready and
+// refuses new mutating requests from now on.
+func BeginShutdown() {
+	atomic.StoreInt32(&shuttingDown, 1)
+	stopOnce.Do(func() { close(stopping) })
+}
+
+// Done is closed when shutdown starts. Idle background loops return on it.
+func Done() <-chan struct{} {
+	return stopping
+}
+
+// Go runs background work that shutdown waits for. The context is cancelled
+// when the drain timeout is over, fn must then stop at a safe point.
+func Go(fn func(ctx context.Context)) {
+	running.Add(1)
+	go func() {
+		defer running.Done()
+		fn(work)
+	}()
+}
+
+// Drain waits for background work started with Go. When ctx is done first
+// the work is cancelled and given grace to stop. It returns false if some
+// work did not stop in time.
+func Drain(ctx context.Context, grace time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		running.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+	}
+
+	cancelWork()
+	select {
+	case <-done:
+		return true
+	case <-time.After(grace):
+		return false
+	}
+}
@@ -10,7 +10,14 @@ import (
 var ZLogger *zap.Logger
 var Logger *zap.SugaredLogger
 
-func InitializeLogger() {
+// InitializeLogger sets up the loggers to print messages of the given level
+// (debug, info, warn or error) and above. Unknown levels fall back to info.
+func InitializeLogger(level string) {
+	logLevel, err := zapcore.ParseLevel(level)
+	if err != nil {
+		logLevel = zapcore.InfoLevel
+	}
+
 	config := zap.NewProductionEncoderConfig()
 	// Setting time encoder
 	config.EncodeTime = zapcore.ISO8601TimeEncoder
@@ -20,7 +27,7 @@ func InitializeLogger() {
 
 	var cores []zapcore.Core
 
-	cores = append(cores, zapcore.NewCore(consoleEncoder, zapcore.AddSync(os.Stdout), zapcore.InfoLevel))
+	cores = append(cores, zapcore.NewCore(consoleEncoder, zapcore.AddSync(os.Stdout), logLevel))
 
 	core := zapcore.NewTee(cores...)
 
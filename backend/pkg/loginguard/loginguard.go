@@ -0,0 +1,293 @@
+package loginguard
+
+import (
+	"github.com/whyxn/easynas/backend/pkg/db"
+	"github.com/whyxn/easynas/backend/pkg/db/model"
+	"github.com/whyxn/easynas/backend/pkg/log"
+	"github.com/whyxn/easynas/backend/pkg/settings"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults of the throttling policy, each one can be changed through settings
+const (
+	DefaultFreeAttempts       = 3
+	DefaultBackoffBaseSeconds = 1
+	DefaultBackoffMaxSeconds  = 300
+	DefaultMaxFailures        = 10
+	DefaultMaxFailuresPerIP   = 50
+	DefaultLockoutMinutes     = 15
+)
+
+// Policy controls how failed logins are throttled. After FreeAttempts
+// consecutive failures every further failure blocks the account or address
+// for an exponentially growing delay, and after MaxFailures it is locked out
+// for LockoutDuration. Failure counters are forgotten once no failure has
+// happened for LockoutDuration.
+type Policy struct {
+	FreeAttempts     int
+	BackoffBase      time.Duration
+	BackoffMax       time.Duration
+	MaxFailures      int
+	MaxFailuresPerIP int
+	LockoutDuration  time.Duration
+}
+
+// CurrentPolicy returns the policy configured in settings.
+func CurrentPolicy() Policy {
+	return Policy{
+		FreeAttempts:     settings.GetInt(settings.LoginFreeAttempts, DefaultFreeAttempts),
+		BackoffBase:      time.Duration(settings.GetInt(settings.LoginBackoffBaseSeconds, DefaultBackoffBaseSeconds)) * time.Second,
+		BackoffMax:       time.Duration(settings.GetInt(settings.LoginBackoffMaxSeconds, DefaultBackoffMaxSeconds)) * time.Second,
+		MaxFailures:      settings.GetInt(settings.LoginMaxFailures, DefaultMaxFailures),
+		MaxFailuresPerIP: settings.GetInt(settings.LoginMaxFailuresPerIP, DefaultMaxFailuresPerIP),
+		LockoutDuration:  time.Duration(settings.GetInt(settings.LoginLockoutMinutes, DefaultLockoutMinutes)) * time.Minute,
+	}
+}
+
+// mu serializes read-modify-write cycles on lockout records
+var mu sync.Mutex
+
+// Reservation is a login attempt that was let through and counted as a
+// failure before the credentials are checked, so attempts running in
+// parallel can not all pass before the first failure is stored. A
+// successful attempt gives its count back.
+type Reservation struct {
+	username string
+	ip       string
+	slots    []reservedSlot
+}
+
+// reservedSlot is the failure an attempt counted on one lockout record and
+// the block that was in place before
+type reservedSlot struct {
+	kind         string
+	key          string
+	blockedUntil time.Time
+	prevBlocked  time.Time
+}
+
+// Reserve returns how long logins for the username from the ip are still
+// blocked, or, if they are not, counts the attempt as a failure of both the
+// account and the source address until it succeeds.
+func Reserve(username, ip string) (*Reservation, time.Duration) {
+	return reserve(username, ip, model.LockoutKindAccount, normalize(username))
+}
+
+// ReserveTwoFactor is Reserve for the second factor of a user. Its failures
+// count on a lockout of their own, which only a verified second factor or
+// an admin clears, so logging in with the password again does not give
+// new guesses.
+func ReserveTwoFactor(userId uint, username, ip string) (*Reservation, time.Duration) {
+	return reserve(username, ip, model.LockoutKindTwoFactor, strconv.FormatUint(uint64(userId), 10))
+}
+
+func reserve(username, ip, kind, key string) (*Reservation, time.Duration) {
+	policy := CurrentPolicy()
+	now := time.Now()
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	var wait time.Duration
+	for _, l := range findLockouts(kind, key, ip) {
+		if l.BlockedUntil.After(now) && l.BlockedUntil.Sub(now) > wait {
+			wait = l.BlockedUntil.Sub(now)
+		}
+	}
+	if wait > 0 {
+		return nil, wait
+	}
+
+	r := &Reservation{username: username, ip: ip}
+	if slot, ok := registerFailure(kind, key, policy.MaxFailures, policy); ok {
+		r.slots = append(r.slots, slot)
+	}
+	if slot, ok := registerFailure(model.LockoutKindIP, ip, policy.MaxFailuresPerIP, policy); ok {
+		r.slots = append(r.slots, slot)
+	}
+	return r, 0
+}
+
+// Fail stores the attempt as failed, it was already counted.
+func (r *Reservation) Fail(userAgent, reason string) {
+	recordAttempt(r.username, r.ip, userAgent, false, reason)
+}
+
+// Succeed stores the attempt as successful and releases it.
+func (r *Reservation) Succeed(userAgent string) {
+	recordAttempt(r.username, r.ip, userAgent, true, "")
+	r.Release()
+}
+
+// Release clears the failures of the account, or of the second factor of a
+// two-factor reservation, as the right credentials were given, and gives
+// back the failure counted on the source address. On its own it is used
+// when the password was right but the login still needs the second factor,
+// which is only stored once it is checked. The failures of the second
+// factor are left alone then.
+func (r *Reservation) Release() {
+	mu.Lock()
+	defer mu.Unlock()
+
+	for _, slot := range r.slots {
+		if slot.kind == model.LockoutKindIP {
+			release(slot)
+		} else {
+			clearLockout(slot.kind, slot.key)
+		}
+	}
+	r.slots = nil
+}
+
+// RecordFailure stores a failed attempt that was not reserved and extends
+// the blocks of both the account and the source address.
+func RecordFailure(username, ip, userAgent, reason string) {
+	recordAttempt(username, ip, userAgent, false, reason)
+
+	policy := CurrentPolicy()
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	registerFailure(model.LockoutKindAccount, normalize(username), policy.MaxFailures, policy)
+	registerFailure(model.LockoutKindIP, ip, policy.MaxFailuresPerIP, policy)
+}
+
+// RecordBlocked stores an attempt that was refused without checking credentials.
+func RecordBlocked(username, ip, userAgent string) {
+	recordAttempt(username, ip, userAgent, false, "blocked")
+}
+
+// RecordSuccess stores a successful attempt that was not reserved and
+// clears the account's failures.
+func RecordSuccess(username, ip, userAgent string) {
+	recordAttempt(username, ip, userAgent, true, "")
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	clearLockout(model.LockoutKindAccount, normalize(username))
+}
+
+// ListActive returns the lockout records that still count failures or block logins.
+func ListActive() ([]model.LoginLockout, error) {
+	var lockouts []model.LoginLockout
+	since := time.Now().Add(-CurrentPolicy().LockoutDuration)
+	err := db.GetDb().Client().
+		Where("blocked_until > ? OR last_failure_at > ?", time.Now(), since).
+		Order("last_failure_at desc").
+		Find(&lockouts).Error
+	return lockouts, err
+}
+
+// Clear removes a lockout record.
+func Clear(id uint) error {
+	return db.GetDb().Delete(&model.LoginLockout{}, map[string]interface{}{"id": id})
+}
+
+// registerFailure counts a failure on a lockout record and returns what it
+// changed
+func registerFailure(kind, key string, maxFailures int, policy Policy) (reservedSlot, bool) {
+	if key == "" {
+		return reservedSlot{}, false
+	}
+
+	now := time.Now()
+	lockout, _ := db.Get[model.LoginLockout](db.GetDb(), map[string]interface{}{"kind": kind, "key": key})
+	if lockout == nil {
+		lockout = &model.LoginLockout{Kind: kind, Key: key}
+	} else if now.Sub(lockout.LastFailureAt) > policy.LockoutDuration {
+		lockout.Failures = 0
+	}
+
+	slot := reservedSlot{kind: kind, key: key, prevBlocked: lockout.BlockedUntil}
+	lockout.Failures++
+	lockout.LastFailureAt = now
+
+	if block := policy.blockFor(lockout.Failures, maxFailures); block > 0 {
+		lockout.BlockedUntil = now.Add(block)
+	}
+	slot.blockedUntil = lockout.BlockedUntil
+
+	if err := db.GetDb().Client().Save(lockout).Error; err != nil {
+		log.Logger.Errorw("Failed to store login lockout", "kind", kind, "err", err.Error())
+		return reservedSlot{}, false
+	}
+	return slot, true
+}
+
+// release takes back a counted failure, and the block it caused unless a
+// later failure extended it
+func release(slot reservedSlot) {
+	lockout, _ := db.Get[model.LoginLockout](db.GetDb(), map[string]interface{}{"kind": slot.kind, "key": slot.key})
+	if lockout == nil {
+		return
+	}
+	if lockout.Failures > 0 {
+		lockout.Failures--
+	}
+	if lockout.BlockedUntil.Equal(slot.blockedUntil) {
+		lockout.BlockedUntil = slot.prevBlocked
+	}
+	if err := db.GetDb().Client().Save(lockout).Error; err != nil {
+		log.Logger.Errorw("Failed to store login lockout", "kind", slot.kind, "err", err.Error())
+	}
+}
+
+func clearLockout(kind, key string) {
+	err := db.GetDb().Delete(&model.LoginLockout{}, map[string]interface{}{"kind": kind, "key": key})
+	if err != nil {
+		log.Logger.Errorw("Failed to clear login lockout", "err", err.Error())
+	}
+}
+
+// blockFor returns how long to block after the given number of consecutive failures.
+func (p Policy) blockFor(failures, maxFailures int) time.Duration {
+	if maxFailures > 0 && failures >= maxFailures {
+		return p.LockoutDuration
+	}
+	if failures <= p.FreeAttempts {
+		return 0
+	}
+
+	block := p.BackoffBase
+	for i := p.FreeAttempts + 1; i < failures && block < p.BackoffMax; i++ {
+		block *= 2
+	}
+	if block > p.BackoffMax {
+		block = p.BackoffMax
+	}
+	return block
+}
+
+func findLockouts(kind, key, ip string) []model.LoginLockout {
+	var lockouts []model.LoginLockout
+	err := db.GetDb().Client().
+		Where("(kind = ? AND \"key\" = ?) OR (kind = ? AND \"key\" = ?)", kind, key, model.LockoutKindIP, ip).
+		Find(&lockouts).Error
+	if err != nil {
+		log.Logger.Errorw("Failed to fetch login lockouts", "err", err.Error())
+	}
+	return lockouts
+}
+
+func recordAttempt(username, ip, userAgent string, success bool, reason string) {
+	attempt := &model.LoginAttempt{
+		Username:  normalize(username),
+		ClientIP:  ip,
+		UserAgent: userAgent,
+		Success:   success,
+		Reason:    reason,
+		CreatedAt: time.Now(),
+	}
+	if err := db.GetDb().Insert(attempt); err != nil {
+		log.Logger.Errorw("Failed to record login attempt", "err", err.Error())
+	}
+}
+
+func normalize(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
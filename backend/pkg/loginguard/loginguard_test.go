@@ -0,0 +1,198 @@
+package loginguard
+
+import (
+	"github.com/whyxn/easynas/backend/pkg/db"
+	"github.com/whyxn/easynas/backend/pkg/db/model"
+	"github.com/whyxn/easynas/backend/pkg/log"
+	"github.com/whyxn/easynas/backend/pkg/settings"
+	"go.uber.org/zap"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// useTestDb connects the db package to a new, migrated database for the
+// test, with the settings of the policy in it
+func useTestDb(t *testing.T, policy map[string]int) {
+	t.Helper()
+	log.Logger = zap.NewNop().Sugar()
+	if err := db.Connect(filepath.Join(t.TempDir(), "easynas.db")); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := db.GetDb().RunMigrations(); err != nil {
+		t.Fatal(err)
+	}
+	if err := settings.Load(); err != nil {
+		t.Fatal(err)
+	}
+	for key, value := range policy {
+		if err := settings.SetInt(key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// noBackoff locks out after five failures without blocking before
+var noBackoff = map[string]int{
+	settings.LoginFreeAttempts:   100,
+	settings.LoginMaxFailures:    5,
+	settings.LoginLockoutMinutes: 15,
+}
+
+func TestBlockFor(t *testing.T) {
+	policy := Policy{
+		FreeAttempts:    3,
+		BackoffBase:     time.Second,
+		BackoffMax:      8 * time.Second,
+		MaxFailures:     10,
+		LockoutDuration: 15 * time.Minute,
+	}
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{1, 0},
+		{3, 0},
+		{4, time.Second},
+		{5, 2 * time.Second},
+		{6, 4 * time.Second},
+		{7, 8 * time.Second},
+		{9, 8 * time.Second},
+		{10, 15 * time.Minute},
+		{11, 15 * time.Minute},
+	}
+	for _, tt := range tests {
+		if got := policy.blockFor(tt.failures, policy.MaxFailures); got != tt.want {
+			t.Errorf("blockFor(%d) = %s, want %s", tt.failures, got, tt.want)
+		}
+	}
+	// without a maximum the backoff goes on
+	if got := policy.blockFor(50, 0); got != 8*time.Second {
+		t.Errorf("blockFor(50) without maximum = %s, want the backoff maximum", got)
+	}
+}
+
+func TestLockoutAtMaxFailures(t *testing.T) {
+	useTestDb(t, noBackoff)
+
+	for i := 1; i <= 5; i++ {
+		r, wait := Reserve("Alice@Example.com", "192.0.2.1")
+		if wait > 0 {
+			t.Fatalf("attempt %d blocked for %s before the maximum", i, wait)
+		}
+		r.Fail("test", "invalid password")
+	}
+
+	// the username is keyed case-insensitively
+	if _, wait := Reserve(" alice@example.com", "198.51.100.7"); wait < 14*time.Minute {
+		t.Errorf("account blocked for %s after the maximum, want the lockout duration", wait)
+	}
+	// the address is not locked out yet, other accounts may log in from it
+	if _, wait := Reserve("bob@example.com", "192.0.2.1"); wait > 0 {
+		t.Errorf("address blocked for %s, want only the account locked", wait)
+	}
+}
+
+func TestReserveCountsBeforeVerifying(t *testing.T) {
+	useTestDb(t, noBackoff)
+
+	// parallel attempts are counted before any of them failed
+	for i := 0; i < 5; i++ {
+		if _, wait := Reserve("alice@example.com", "192.0.2.1"); wait > 0 {
+			t.Fatalf("reservation %d blocked", i+1)
+		}
+	}
+	if _, wait := Reserve("alice@example.com", "192.0.2.1"); wait == 0 {
+		t.Error("reservation past the maximum was let through")
+	}
+}
+
+func TestSucceedClearsAccount(t *testing.T) {
+	useTestDb(t, noBackoff)
+
+	for i := 0; i < 3; i++ {
+		r, _ := Reserve("alice@example.com", "192.0.2.1")
+		r.Fail("test", "invalid password")
+	}
+	r, wait := Reserve("alice@example.com", "192.0.2.1")
+	if wait > 0 {
+		t.Fatal("login blocked")
+	}
+	r.Succeed("test")
+
+	if lockout, _ := db.Get[model.LoginLockout](db.GetDb(), map[string]interface{}{"kind": model.LockoutKindAccount}); lockout != nil {
+		t.Errorf("account still has %d failures after a successful login", lockout.Failures)
+	}
+	// the failures of the address stay, only the successful attempt is given back
+	lockout, err := db.Get[model.LoginLockout](db.GetDb(), map[string]interface{}{"kind": model.LockoutKindIP, "key": "192.0.2.1"})
+	if err != nil || lockout.Failures != 3 {
+		t.Errorf("address failures = %v, %v, want 3", lockout, err)
+	}
+
+	attempts, _ := db.GetList[model.LoginAttempt](db.GetDb(), map[string]interface{}{"success": true})
+	if len(attempts) != 1 {
+		t.Errorf("successful attempts = %d, want 1", len(attempts))
+	}
+}
+
+func TestTwoFactorLockoutSurvivesPasswordLogin(t *testing.T) {
+	useTestDb(t, noBackoff)
+
+	for i := 1; i <= 5; i++ {
+		// the password is right, which gets a new MFA token
+		password, wait := Reserve("alice@example.com", "192.0.2.1")
+		if wait > 0 {
+			t.Fatalf("password step %d blocked for %s", i, wait)
+		}
+		password.Release()
+
+		// and the second factor is wrong
+		second, wait := ReserveTwoFactor(7, "alice@example.com", "192.0.2.1")
+		if wait > 0 {
+			t.Fatalf("second factor %d blocked for %s before the maximum", i, wait)
+		}
+		second.Fail("test", "invalid second factor")
+	}
+
+	if _, wait := ReserveTwoFactor(7, "alice@example.com", "192.0.2.1"); wait < 14*time.Minute {
+		t.Errorf("second factor blocked for %s, want the lockout duration", wait)
+	}
+	password, _ := Reserve("alice@example.com", "192.0.2.1")
+	password.Release()
+	if _, wait := ReserveTwoFactor(7, "alice@example.com", "192.0.2.1"); wait == 0 {
+		t.Error("a password login lifted the lockout of the second factor")
+	}
+
+	// an admin unlocks it
+	lockouts, err := ListActive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range lockouts {
+		if l.Kind == model.LockoutKindTwoFactor {
+			if err = Clear(l.ID); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if _, wait := ReserveTwoFactor(7, "alice@example.com", "192.0.2.1"); wait > 0 {
+		t.Errorf("second factor blocked for %s after an admin cleared it", wait)
+	}
+}
+
+func TestTwoFactorSucceedClearsFailures(t *testing.T) {
+	useTestDb(t, noBackoff)
+
+	for i := 0; i < 3; i++ {
+		r, _ := ReserveTwoFactor(7, "alice@example.com", "192.0.2.1")
+		r.Fail("test", "invalid second factor")
+	}
+	r, _ := ReserveTwoFactor(7, "alice@example.com", "192.0.2.1")
+	r.Succeed("test")
+
+	lockout, _ := db.Get[model.LoginLockout](db.GetDb(), map[string]interface{}{"kind": model.LockoutKindTwoFactor, "key": "7"})
+	if lockout != nil {
+		t.Errorf("second factor still has %d failures after it was verified", lockout.Failures)
+	}
+}
@@ -0,0 +1,151 @@
+package metrics
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/whyxn/easynas/backend/pkg/config"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ErrArcUnavailable is returned when the ZFS module is not loaded or the
+// arcstats path is not set
+var ErrArcUnavailable = errors.New("ARC statistics are not available")
+
+// ArcStats represents the state of the ZFS adaptive replacement cache.
+// Sizes are in bytes, counters count since the module was loaded and ratios
+// go from 0 to 1.
+type ArcStats struct {
+	Size       uint64 `json:"size"`       // Current size
+	TargetSize uint64 `json:"targetSize"` // Size the ARC is adapting to
+	MinSize    uint64 `json:"minSize"`    // zfs_arc_min
+	MaxSize    uint64 `json:"maxSize"`    // zfs_arc_max
+
+	Hits           uint64  `json:"hits"`
+	Misses         uint64  `json:"misses"`
+	HitRatio       float64 `json:"hitRatio"`
+	DemandHits     uint64  `json:"demandHits"`     // Hits of reads applications asked for
+	DemandMisses   uint64  `json:"demandMisses"`   // Misses of reads applications asked for
+	DemandHitRatio float64 `json:"demandHitRatio"` // Hit ratio without prefetching
+	PrefetchHits   uint64  `json:"prefetchHits"`
+	PrefetchMisses uint64  `json:"prefetchMisses"`
+
+	// Recently and frequently used lists, the ghost hits are misses the
+	// list would have hit if it had been larger
+	MruSize      uint64 `json:"mruSize"`
+	MfuSize      uint64 `json:"mfuSize"`
+	MruHits      uint64 `json:"mruHits"`
+	MfuHits      uint64 `json:"mfuHits"`
+	MruGhostHits uint64 `json:"mruGhostHits"`
+	MfuGhostHits uint64 `json:"mfuGhostHits"`
+
+	DataSize     uint64 `json:"dataSize"`
+	MetadataSize uint64 `json:"metadataSize"`
+	HeaderSize   uint64 `json:"headerSize"`
+
+	// MemoryThrottles counts the times the ARC had to shrink for memory
+	MemoryThrottles uint64 `json:"memoryThrottles"`
+
+	// L2 is nil without a cache device
+	L2 *L2ArcStats `json:"l2arc"`
+}
+
+// L2ArcStats represents the state of the second level cache on cache
+// devices.
+type L2ArcStats struct {
+	Size          uint64  `json:"size"`          // Data cached, uncompressed
+	AllocatedSize uint64  `json:"allocatedSize"` // Space used on the cache devices
+	HeaderSize    uint64  `json:"headerSize"`    // ARC memory used to track the L2ARC
+	Hits          uint64  `json:"hits"`
+	Misses        uint64  `json:"misses"`
+	HitRatio      float64 `json:"hitRatio"`
+	ReadBytes     uint64  `json:"readBytes"`
+	WriteBytes    uint64  `json:"writeBytes"`
+}
+
+// GetArcStats reads the ARC counters from the configured arcstats file.
+func GetArcStats() (*ArcStats, error) {
+	path := config.Get().Nas.ArcStats
+	if path == "" {
+		return nil, ErrArcUnavailable
+	}
+	raw, err := readArcStats(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrArcUnavailable
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read ARC statistics: %w", err)
+	}
+
+	stats := &ArcStats{
+		Size:            raw["size"],
+		TargetSize:      raw["c"],
+		MinSize:         raw["c_min"],
+		MaxSize:         raw["c_max"],
+		Hits:            raw["hits"],
+		Misses:          raw["misses"],
+		DemandHits:      raw["demand_data_hits"] + raw["demand_metadata_hits"],
+		DemandMisses:    raw["demand_data_misses"] + raw["demand_metadata_misses"],
+		PrefetchHits:    raw["prefetch_data_hits"] + raw["prefetch_metadata_hits"],
+		PrefetchMisses:  raw["prefetch_data_misses"] + raw["prefetch_metadata_misses"],
+		MruSize:         raw["mru_size"],
+		MfuSize:         raw["mfu_size"],
+		MruHits:         raw["mru_hits"],
+		MfuHits:         raw["mfu_hits"],
+		MruGhostHits:    raw["mru_ghost_hits"],
+		MfuGhostHits:    raw["mfu_ghost_hits"],
+		DataSize:        raw["data_size"],
+		MetadataSize:    raw["metadata_size"],
+		HeaderSize:      raw["hdr_size"],
+		MemoryThrottles: raw["memory_throttle_count"],
+	}
+	stats.HitRatio = ratio(stats.Hits, stats.Misses)
+	stats.DemandHitRatio = ratio(stats.DemandHits, stats.DemandMisses)
+
+	if raw["l2_size"] > 0 || raw["l2_hits"] > 0 || raw["l2_misses"] > 0 {
+		stats.L2 = &L2ArcStats{
+			Size:          raw["l2_size"],
+			AllocatedSize: raw["l2_asize"],
+			HeaderSize:    raw["l2_hdr_size"],
+			Hits:          raw["l2_hits"],
+			Misses:        raw["l2_misses"],
+			HitRatio:      ratio(raw["l2_hits"], raw["l2_misses"]),
+			ReadBytes:     raw["l2_read_bytes"],
+			WriteBytes:    raw["l2_write_bytes"],
+		}
+	}
+	return stats, nil
+}
+
+// readArcStats reads the counters of a kstat file like
+// /proc/spl/kstat/zfs/arcstats.
+func readArcStats(path string) (map[string]uint64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	stats := map[string]uint64{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// name type data, after a kstat header and a column header
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 3 {
+			continue
+		}
+		if value, err := strconv.ParseUint(fields[2], 10, 64); err == nil {
+			stats[fields[0]] = value
+		}
+	}
+	return stats, scanner.Err()
+}
+
+func ratio(hits, misses uint64) float64 {
+	if hits+misses == 0 {
+		return 0
+	}
+	return float64(hits) / float64(hits+misses)
+}
@@ -0,0 +1,114 @@
+package metrics
+
+import (
+	"errors"
+	"github.com/whyxn/easynas/backend/pkg/config"
+	"path/filepath"
+	"testing"
+)
+
+// useArcStats points the configuration at an arcstats file for the test
+func useArcStats(t *testing.T, path string) {
+	t.Helper()
+	previous := config.Get()
+	cfg := config.Default()
+	cfg.Nas.ArcStats = path
+	config.Set(cfg)
+	t.Cleanup(func() { config.Set(previous) })
+}
+
+func TestGetArcStats(t *testing.T) {
+	useArcStats(t, filepath.Join("testdata", "arcstats"))
+
+	stats, err := GetArcStats()
+	if err != nil {
+		t.Fatalf("GetArcStats() error = %v", err)
+	}
+
+	sizes := []struct {
+		name      string
+		got, want uint64
+	}{
+		{"Size", stats.Size, 4294967296},
+		{"TargetSize", stats.TargetSize, 5368709120},
+		{"MinSize", stats.MinSize, 1073741824},
+		{"MaxSize", stats.MaxSize, 8589934592},
+		{"Hits", stats.Hits, 900},
+		{"Misses", stats.Misses, 300},
+		{"DemandHits", stats.DemandHits, 800},
+		{"DemandMisses", stats.DemandMisses, 200},
+		{"PrefetchHits", stats.PrefetchHits, 100},
+		{"PrefetchMisses", stats.PrefetchMisses, 100},
+		{"MruSize", stats.MruSize, 1073741824},
+		{"MfuSize", stats.MfuSize, 2147483648},
+		{"MruHits", stats.MruHits, 300},
+		{"MfuHits", stats.MfuHits, 500},
+		{"MruGhostHits", stats.MruGhostHits, 12},
+		{"MfuGhostHits", stats.MfuGhostHits, 7},
+		{"DataSize", stats.DataSize, 3758096384},
+		{"MetadataSize", stats.MetadataSize, 520093696},
+		{"HeaderSize", stats.HeaderSize, 16777216},
+		{"MemoryThrottles", stats.MemoryThrottles, 3},
+	}
+	for _, s := range sizes {
+		if s.got != s.want {
+			t.Errorf("%s = %d, want %d", s.name, s.got, s.want)
+		}
+	}
+	if stats.HitRatio != 0.75 {
+		t.Errorf("HitRatio = %v, want 0.75", stats.HitRatio)
+	}
+	if stats.DemandHitRatio != 0.8 {
+		t.Errorf("DemandHitRatio = %v, want 0.8", stats.DemandHitRatio)
+	}
+	if stats.L2 != nil {
+		t.Errorf("L2 = %+v, want nil without a cache device", stats.L2)
+	}
+}
+
+func TestGetArcStatsL2Arc(t *testing.T) {
+	useArcStats(t, filepath.Join("testdata", "arcstats_l2arc"))
+
+	stats, err := GetArcStats()
+	if err != nil {
+		t.Fatalf("GetArcStats() error = %v", err)
+	}
+	if stats.L2 == nil {
+		t.Fatal("L2 = nil, want the L2ARC counters")
+	}
+	want := L2ArcStats{
+		Size:          42949672960,
+		AllocatedSize: 32212254720,
+		HeaderSize:    41943040,
+		Hits:          250,
+		Misses:        750,
+		HitRatio:      0.25,
+		ReadBytes:     1048576000,
+		WriteBytes:    21474836480,
+	}
+	if *stats.L2 != want {
+		t.Errorf("L2 = %+v, want %+v", *stats.L2, want)
+	}
+}
+
+func TestGetArcStatsUnavailable(t *testing.T) {
+	for _, path := range []string{"", filepath.Join("testdata", "missing")} {
+		useArcStats(t, path)
+		if _, err := GetArcStats(); !errors.Is(err, ErrArcUnavailable) {
+			t.Errorf("GetArcStats() with path %q error = %v, want ErrArcUnavailable", path, err)
+		}
+	}
+}
+
+func TestReadArcStatsSkipsHeaders(t *testing.T) {
+	raw, err := readArcStats(filepath.Join("testdata", "arcstats"))
+	if err != nil {
+		t.Fatalf("readArcStats() error = %v", err)
+	}
+	if _, ok := raw["name"]; ok {
+		t.Error("column header was read as a counter")
+	}
+	if raw["hits"] != 900 {
+		t.Errorf("hits = %d, want 900", raw["hits"])
+	}
+}
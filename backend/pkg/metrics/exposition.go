@@ -0,0 +1,104 @@
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Metric types of the Prometheus text format
+const (
+	Gauge     = "gauge"
+	Counter   = "counter"
+	Histogram = "histogram"
+)
+
+// Family is a metric with all its label combinations.
+type Family struct {
+	Name    string
+	Help    string
+	Type    string
+	Samples []Sample
+}
+
+// Sample is one value of a family. Suffix is appended to the family name,
+// like "_bucket" for histograms.
+type Sample struct {
+	Suffix string
+	Labels Labels
+	Value  float64
+}
+
+// Labels are written sorted by name.
+type Labels map[string]string
+
+// Add appends a sample to the family.
+func (f *Family) Add(value float64, labels Labels) {
+	f.Samples = append(f.Samples, Sample{Labels: labels, Value: value})
+}
+
+// WriteText writes the families in the Prometheus text exposition format.
+func WriteText(w io.Writer, families []*Family) error {
+	out := bufio.NewWriter(w)
+	for _, f := range families {
+		if len(f.Samples) == 0 {
+			continue
+		}
+		out.WriteString("# HELP " + f.Name + " " + escapeHelp(f.Help) + "\n")
+		out.WriteString("# TYPE " + f.Name + " " + f.Type + "\n")
+		for _, s := range f.Samples {
+			out.WriteString(f.Name + s.Suffix)
+			writeLabels(out, s.Labels)
+			out.WriteString(" " + formatValue(s.Value) + "\n")
+		}
+	}
+	return out.Flush()
+}
+
+func writeLabels(out *bufio.Writer, labels Labels) {
+	if len(labels) == 0 {
+		return
+	}
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	out.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			out.WriteByte(',')
+		}
+		out.WriteString(name + `="` + escapeLabel(labels[name]) + `"`)
+	}
+	out.WriteByte('}')
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
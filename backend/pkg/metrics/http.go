@@ -0,0 +1,106 @@
+package metrics
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// durationBuckets are the upper bounds of the request duration histogram,
+// in seconds
+var durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type requestKey struct {
+	method string
+	route  string
+	code   string
+}
+
+type routeKey struct {
+	method string
+	route  string
+}
+
+type histogram struct {
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+var (
+	httpMu        sync.Mutex
+	httpRequests  = map[requestKey]uint64{}
+	httpDurations = map[routeKey]*histogram{}
+)
+
+// ObserveRequest counts a served HTTP request. route is the route pattern,
+// not the requested path, to keep the number of series bounded.
+func ObserveRequest(method, route string, status int, duration time.Duration) {
+	httpMu.Lock()
+	defer httpMu.Unlock()
+
+	httpRequests[requestKey{method: method, route: route, code: strconv.Itoa(status)}]++
+
+	key := routeKey{method: method, route: route}
+	h := httpDurations[key]
+	if h == nil {
+		h = &histogram{buckets: make([]uint64, len(durationBuckets))}
+		httpDurations[key] = h
+	}
+	seconds := duration.Seconds()
+	for i, bound := range durationBuckets {
+		if seconds <= bound {
+			h.buckets[i]++
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+func httpFamilies() []*Family {
+	httpMu.Lock()
+	defer httpMu.Unlock()
+
+	requests := &Family{Name: "easynas_http_requests_total", Help: "HTTP requests served, by route and status code.", Type: Counter}
+	for key, count := range httpRequests {
+		requests.Add(float64(count), Labels{"method": key.method, "route": key.route, "code": key.code})
+	}
+
+	durations := &Family{Name: "easynas_http_request_duration_seconds", Help: "Time taken to serve HTTP requests.", Type: Histogram}
+	for key, h := range httpDurations {
+		for i, bound := range durationBuckets {
+			durations.Samples = append(durations.Samples, Sample{
+				Suffix: "_bucket",
+				Labels: Labels{"method": key.method, "route": key.route, "le": formatValue(bound)},
+				Value:  float64(h.buckets[i]),
+			})
+		}
+		labels := Labels{"method": key.method, "route": key.route}
+		durations.Samples = append(durations.Samples,
+			Sample{Suffix: "_bucket", Labels: Labels{"method": key.method, "route": key.route, "le": formatValue(math.Inf(1))}, Value: float64(h.count)},
+			Sample{Suffix: "_sum", Labels: labels, Value: h.sum},
+			Sample{Suffix: "_count", Labels: labels, Value: float64(h.count)},
+		)
+	}
+
+	sortSamples(requests)
+	sortSamples(durations)
+	return []*Family{requests, durations}
+}
+
+// sortSamples keeps the series of a histogram together and the output
+// stable between scrapes
+func sortSamples(f *Family) {
+	sort.SliceStable(f.Samples, func(i, j int) bool {
+		a, b := f.Samples[i].Labels, f.Samples[j].Labels
+		if a["route"] != b["route"] {
+			return a["route"] < b["route"]
+		}
+		if a["method"] != b["method"] {
+			return a["method"] < b["method"]
+		}
+		return a["code"] < b["code"]
+	})
+}
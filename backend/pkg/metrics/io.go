@@ -0,0 +1,240 @@
+package metrics
+
+import (
+	"fmt"
+	"github.com/shirou/gopsutil/v3/cpu"
+	"github.com/shirou/gopsutil/v3/disk"
+	"github.com/shirou/gopsutil/v3/net"
+	"github.com/whyxn/easynas/backend/pkg/config"
+	"github.com/whyxn/easynas/backend/pkg/nas"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ignoredDevices are virtual block devices without interesting I/O
+var ignoredDevices = []string{"loop", "ram", "zram"}
+
+// IOMetrics is the activity of the host over a sampled interval, to tell
+// whether slowness comes from the disks, the network or the CPU.
+type IOMetrics struct {
+	Interval   float64          `json:"interval"` // Sampled interval in seconds
+	CPU        CPUIO            `json:"cpu"`
+	Disks      []DiskIO         `json:"disks"`
+	Vdevs      []nas.VdevIOStat `json:"vdevs"`
+	Interfaces []InterfaceIO    `json:"interfaces"`
+}
+
+// CPUIO is the CPU usage over the interval, IowaitPercent being the time
+// spent idle waiting for I/O.
+type CPUIO struct {
+	UsagePercent  float64 `json:"usagePercent"`
+	IowaitPercent float64 `json:"iowaitPercent"`
+}
+
+// DiskIO is the activity of a block device. Rates are per second, the
+// latencies are the average time of a request in milliseconds, nil without
+// requests.
+type DiskIO struct {
+	Name            string   `json:"name"`
+	ReadOps         float64  `json:"readOps"`
+	WriteOps        float64  `json:"writeOps"`
+	ReadBytes       float64  `json:"readBytes"`
+	WriteBytes      float64  `json:"writeBytes"`
+	ReadLatency     *float64 `json:"readLatency"`
+	WriteLatency    *float64 `json:"writeLatency"`
+	BusyPercent     float64  `json:"busyPercent"`     // Time the device had requests in flight
+	ReadBytesTotal  uint64   `json:"readBytesTotal"`  // Read since boot
+	WriteBytesTotal uint64   `json:"writeBytesTotal"` // Written since boot
+}
+
+// InterfaceIO is the traffic of a network interface. Rates are per second,
+// errors and drops are counted over the interval and since boot.
+type InterfaceIO struct {
+	Name                string  `json:"name"`
+	SpeedMbps           int     `json:"speedMbps,omitempty"` // Link speed, if known
+	ReceiveBytes        float64 `json:"receiveBytes"`
+	TransmitBytes       float64 `json:"transmitBytes"`
+	ReceivePackets      float64 `json:"receivePackets"`
+	TransmitPackets     float64 `json:"transmitPackets"`
+	UtilizationPercent  float64 `json:"utilizationPercent,omitempty"` // Busier direction against the link speed
+	ReceiveErrors       uint64  `json:"receiveErrors"`
+	TransmitErrors      uint64  `json:"transmitErrors"`
+	ReceiveDrops        uint64  `json:"receiveDrops"`
+	TransmitDrops       uint64  `json:"transmitDrops"`
+	ReceiveErrorsTotal  uint64  `json:"receiveErrorsTotal"`
+	TransmitErrorsTotal uint64  `json:"transmitErrorsTotal"`
+	ReceiveDropsTotal   uint64  `json:"receiveDropsTotal"`
+	TransmitDropsTotal  uint64  `json:"transmitDropsTotal"`
+}
+
+type ioCounters struct {
+	time       time.Time
+	cpu        cpu.TimesStat
+	disks      map[string]disk.IOCountersStat
+	interfaces []net.IOCountersStat
+}
+
+func readIOCounters() (*ioCounters, error) {
+	times, err := cpu.Times(false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get CPU times: %w", err)
+	} else if len(times) == 0 {
+		return nil, fmt.Errorf("failed to get CPU times")
+	}
+	disks, err := disk.IOCounters()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get disk counters: %w", err)
+	}
+	interfaces, err := net.IOCounters(true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get network counters: %w", err)
+	}
+	return &ioCounters{time: time.Now(), cpu: times[0], disks: disks, interfaces: interfaces}, nil
+}
+
+// GetIOMetrics samples disk, pool, network and CPU activity over interval,
+// which is rounded to whole seconds for zpool iostat.
+func GetIOMetrics(interval time.Duration) (*IOMetrics, error) {
+	before, err := readIOCounters()
+	if err != nil {
+		return nil, err
+	}
+
+	// zpool iostat takes the interval to sample, so the counters are read
+	// around it
+	vdevs := []nas.VdevIOStat{}
+	if pools := config.Get().Nas.Pools; len(pools) > 0 {
+		if vdevs, err = nas.PoolIOStats(pools, interval); err != nil {
+			return nil, err
+		}
+	} else {
+		time.Sleep(interval)
+	}
+
+	after, err := readIOCounters()
+	if err != nil {
+		return nil, err
+	}
+	elapsed := after.time.Sub(before.time).Seconds()
+
+	return &IOMetrics{
+		Interval:   elapsed,
+		CPU:        cpuIO(before.cpu, after.cpu),
+		Disks:      diskIO(before.disks, after.disks, elapsed),
+		Vdevs:      vdevs,
+		Interfaces: interfaceIO(before.interfaces, after.interfaces, elapsed),
+	}, nil
+}
+
+func cpuIO(before, after cpu.TimesStat) CPUIO {
+	total := after.Total() - before.Total()
+	if total <= 0 {
+		return CPUIO{}
+	}
+	idle := (after.Idle - before.Idle) + (after.Iowait - before.Iowait)
+	return CPUIO{
+		UsagePercent:  100 * (total - idle) / total,
+		IowaitPercent: 100 * (after.Iowait - before.Iowait) / total,
+	}
+}
+
+func diskIO(before, after map[string]disk.IOCountersStat, elapsed float64) []DiskIO {
+	disks := []DiskIO{}
+	for name, a := range after {
+		b, ok := before[name]
+		if !ok || ignoredDevice(name) {
+			continue
+		}
+		reads, writes := float64(a.ReadCount-b.ReadCount), float64(a.WriteCount-b.WriteCount)
+		d := DiskIO{
+			Name:            name,
+			ReadOps:         reads / elapsed,
+			WriteOps:        writes / elapsed,
+			ReadBytes:       float64(a.ReadBytes-b.ReadBytes) / elapsed,
+			WriteBytes:      float64(a.WriteBytes-b.WriteBytes) / elapsed,
+			BusyPercent:     100 * float64(a.IoTime-b.IoTime) / (elapsed * 1000),
+			ReadBytesTotal:  a.ReadBytes,
+			WriteBytesTotal: a.WriteBytes,
+		}
+		if reads > 0 {
+			latency := float64(a.ReadTime-b.ReadTime) / reads
+			d.ReadLatency = &latency
+		}
+		if writes > 0 {
+			latency := float64(a.WriteTime-b.WriteTime) / writes
+			d.WriteLatency = &latency
+		}
+		if d.BusyPercent > 100 {
+			d.BusyPercent = 100
+		}
+		disks = append(disks, d)
+	}
+	sort.Slice(disks, func(i, j int) bool { return disks[i].Name < disks[j].Name })
+	return disks
+}
+
+func ignoredDevice(name string) bool {
+	for _, prefix := range ignoredDevices {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func interfaceIO(before, after []net.IOCountersStat, elapsed float64) []InterfaceIO {
+	previous := map[string]net.IOCountersStat{}
+	for _, b := range before {
+		previous[b.Name] = b
+	}
+
+	interfaces := []InterfaceIO{}
+	for _, a := range after {
+		b, ok := previous[a.Name]
+		if !ok || a.Name == "lo" {
+			continue
+		}
+		i := InterfaceIO{
+			Name:                a.Name,
+			SpeedMbps:           linkSpeed(a.Name),
+			ReceiveBytes:        float64(a.BytesRecv-b.BytesRecv) / elapsed,
+			TransmitBytes:       float64(a.BytesSent-b.BytesSent) / elapsed,
+			ReceivePackets:      float64(a.PacketsRecv-b.PacketsRecv) / elapsed,
+			TransmitPackets:     float64(a.PacketsSent-b.PacketsSent) / elapsed,
+			ReceiveErrors:       a.Errin - b.Errin,
+			TransmitErrors:      a.Errout - b.Errout,
+			ReceiveDrops:        a.Dropin - b.Dropin,
+			TransmitDrops:       a.Dropout - b.Dropout,
+			ReceiveErrorsTotal:  a.Errin,
+			TransmitErrorsTotal: a.Errout,
+			ReceiveDropsTotal:   a.Dropin,
+			TransmitDropsTotal:  a.Dropout,
+		}
+		if i.SpeedMbps > 0 {
+			busiest := i.ReceiveBytes
+			if i.TransmitBytes > busiest {
+				busiest = i.TransmitBytes
+			}
+			i.UtilizationPercent = 100 * busiest * 8 / (float64(i.SpeedMbps) * 1e6)
+		}
+		interfaces = append(interfaces, i)
+	}
+	return interfaces
+}
+
+// linkSpeed reads the negotiated speed of an interface in Mbit/s, 0 if the
+// driver does not tell
+func linkSpeed(name string) int {
+	data, err := os.ReadFile("/sys/class/net/" + name + "/speed")
+	if err != nil {
+		return 0
+	}
+	speed, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || speed < 0 {
+		return 0
+	}
+	return speed
+}
@@ -10,15 +10,17 @@ import (
 
 // SystemMetrics represents detailed system metrics.
 type SystemMetrics struct {
-	TotalCPUs       int     `json:"totalCpus"`       // Total number of CPUs
-	CPUUsagePercent float64 `json:"cpuUsagePercent"` // CPU usage percentage
-	TotalMemory     uint64  `json:"totalMemory"`     // Total memory in bytes
-	MemoryUsage     uint64  `json:"memoryUsed"`      // Used memory in bytes
-	MemoryPercent   float64 `json:"memoryPercent"`   // Memory usage percentage
-	TotalDisk       uint64  `json:"totalDisk"`       // Total disk space in bytes
-	DiskUsage       uint64  `json:"diskUsed"`        // Used disk space in bytes
-	DiskPercent     float64 `json:"diskPercent"`     // Disk usage percentage
-	Uptime          uint64  `json:"uptime"`          // Uptime in seconds
+	TotalCPUs         int     `json:"totalCpus"`         // Total number of CPUs
+	CPUUsagePercent   float64 `json:"cpuUsagePercent"`   // CPU usage percentage
+	TotalMemory       uint64  `json:"totalMemory"`       // Total memory in bytes
+	MemoryUsage       uint64  `json:"memoryUsed"`        // Used memory in bytes
+	MemoryPercent     float64 `json:"memoryPercent"`     // Memory usage percentage
+	ArcSize           uint64  `json:"arcSize"`           // Memory used by the ZFS ARC in bytes, part of memoryUsed
+	ApplicationMemory uint64  `json:"applicationMemory"` // Used memory without the ARC in bytes
+	TotalDisk         uint64  `json:"totalDisk"`         // Total disk space in bytes
+	DiskUsage         uint64  `json:"diskUsed"`          // Used disk space in bytes
+	DiskPercent       float64 `json:"diskPercent"`       // Disk usage percentage
+	Uptime            uint64  `json:"uptime"`            // Uptime in seconds
 }
 
 // GetSystemMetrics fetches and returns detailed system metrics.
@@ -47,6 +49,19 @@ func GetSystemMetrics() (*SystemMetrics, error) {
 		return nil, fmt.Errorf("failed to get disk stats: %w", err)
 	}
 
+	// The ARC counts as used memory, though it shrinks when applications
+	// need the memory
+	var arcSize uint64
+	if arc, err := GetArcStats(); err == nil {
+		arcSize = arc.Size
+	}
+	applicationMemory := memoryStats.Used
+	if applicationMemory > arcSize {
+		applicationMemory -= arcSize
+	} else {
+		applicationMemory = 0
+	}
+
 	// Get system uptime
 	uptime, err := host.Uptime()
 	if err != nil {
@@ -55,15 +70,17 @@ func GetSystemMetrics() (*SystemMetrics, error) {
 
 	// Assemble metrics into the struct
 	metrics := &SystemMetrics{
-		TotalCPUs:       totalCPUs,
-		CPUUsagePercent: cpuUsage[0], // Overall CPU usage percentage
-		TotalMemory:     memoryStats.Total,
-		MemoryUsage:     memoryStats.Used,
-		MemoryPercent:   memoryStats.UsedPercent,
-		TotalDisk:       diskStats.Total,
-		DiskUsage:       diskStats.Used,
-		DiskPercent:     diskStats.UsedPercent,
-		Uptime:          uptime,
+		TotalCPUs:         totalCPUs,
+		CPUUsagePercent:   cpuUsage[0], // Overall CPU usage percentage
+		TotalMemory:       memoryStats.Total,
+		MemoryUsage:       memoryStats.Used,
+		MemoryPercent:     memoryStats.UsedPercent,
+		ArcSize:           arcSize,
+		ApplicationMemory: applicationMemory,
+		TotalDisk:         diskStats.Total,
+		DiskUsage:         diskStats.Used,
+		DiskPercent:       diskStats.UsedPercent,
+		Uptime:            uptime,
 	}
 
 	return metrics, nil
@@ -0,0 +1,262 @@
+package metrics
+
+import (
+	"github.com/whyxn/easynas/backend/pkg/config"
+	"github.com/whyxn/easynas/backend/pkg/log"
+	"github.com/whyxn/easynas/backend/pkg/nas"
+	"github.com/whyxn/easynas/backend/pkg/util"
+	"io"
+	"sort"
+	"time"
+)
+
+// poolHealthStates are exported as one series each, 1 for the current state
+var poolHealthStates = []string{"ONLINE", "DEGRADED", "FAULTED", "OFFLINE", "UNAVAIL", "REMOVED", "SUSPENDED"}
+
+// WritePrometheus collects host, ZFS and HTTP metrics and writes them in
+// the Prometheus text format. A collector that fails is reported through
+// easynas_collector_success instead of failing the scrape.
+func WritePrometheus(w io.Writer) error {
+	success := &Family{Name: "easynas_collector_success", Help: "Whether a collector succeeded during this scrape.", Type: Gauge}
+	duration := &Family{Name: "easynas_collector_duration_seconds", Help: "Time taken by a collector during this scrape.", Type: Gauge}
+
+	var families []*Family
+	for _, c := range []struct {
+		name    string
+		collect func() ([]*Family, error)
+	}{
+		{"host", collectHost},
+		{"zfs", collectZfs},
+		{"arc", collectArc},
+		{"io", collectIO},
+	} {
+		start := time.Now()
+		collected, err := c.collect()
+		duration.Add(time.Since(start).Seconds(), Labels{"collector": c.name})
+		if err != nil {
+			log.Logger.Debugw("Metrics collector failed", "collector", c.name, "err", err)
+			success.Add(0, Labels{"collector": c.name})
+			continue
+		}
+		success.Add(1, Labels{"collector": c.name})
+		families = append(families, collected...)
+	}
+
+	families = append(families, success, duration)
+	families = append(families, httpFamilies()...)
+	return WriteText(w, families)
+}
+
+func collectHost() ([]*Family, error) {
+	m, err := GetSystemMetrics()
+	if err != nil {
+		return nil, err
+	}
+	gauge := func(name, help string, value float64) *Family {
+		f := &Family{Name: name, Help: help, Type: Gauge}
+		f.Add(value, nil)
+		return f
+	}
+	return []*Family{
+		gauge("easynas_host_cpus", "Number of logical CPUs.", float64(m.TotalCPUs)),
+		gauge("easynas_host_cpu_usage_percent", "CPU usage since the previous sample.", m.CPUUsagePercent),
+		gauge("easynas_host_memory_total_bytes", "Total memory.", float64(m.TotalMemory)),
+		gauge("easynas_host_memory_used_bytes", "Used memory.", float64(m.MemoryUsage)),
+		gauge("easynas_host_root_disk_total_bytes", "Size of the root filesystem.", float64(m.TotalDisk)),
+		gauge("easynas_host_root_disk_used_bytes", "Used space of the root filesystem.", float64(m.DiskUsage)),
+		gauge("easynas_host_uptime_seconds", "Time since the host booted.", float64(m.Uptime)),
+	}, nil
+}
+
+func collectZfs() ([]*Family, error) {
+	pools, err := nas.ListPoolStats()
+	if err != nil {
+		return nil, err
+	}
+
+	poolSize := &Family{Name: "easynas_zfs_pool_size_bytes", Help: "Size of the pool.", Type: Gauge}
+	poolAlloc := &Family{Name: "easynas_zfs_pool_allocated_bytes", Help: "Allocated space of the pool.", Type: Gauge}
+	poolFree := &Family{Name: "easynas_zfs_pool_free_bytes", Help: "Free space of the pool.", Type: Gauge}
+	poolFrag := &Family{Name: "easynas_zfs_pool_fragmentation_percent", Help: "Fragmentation of the free space of the pool.", Type: Gauge}
+	poolHealth := &Family{Name: "easynas_zfs_pool_health", Help: "Health of the pool, 1 for the current state.", Type: Gauge}
+	scrubRunning := &Family{Name: "easynas_zfs_pool_scrub_in_progress", Help: "Whether a scrub or resilver is running.", Type: Gauge}
+	scrubAge := &Family{Name: "easynas_zfs_pool_last_scrub_age_seconds", Help: "Time since the last scrub or resilver finished.", Type: Gauge}
+	used := &Family{Name: "easynas_zfs_dataset_used_bytes", Help: "Space used by the dataset and its children.", Type: Gauge}
+	available := &Family{Name: "easynas_zfs_dataset_available_bytes", Help: "Space available to the dataset.", Type: Gauge}
+	quota := &Family{Name: "easynas_zfs_dataset_quota_bytes", Help: "Quota of the dataset, 0 if not set.", Type: Gauge}
+	snapshots := &Family{Name: "easynas_zfs_dataset_snapshots", Help: "Number of snapshots of the dataset.", Type: Gauge}
+
+	managed := config.Get().Nas.Pools
+	for _, pool := range pools {
+		if !util.Contains(managed, pool.Name) {
+			continue
+		}
+		labels := Labels{"pool": pool.Name}
+		poolSize.Add(float64(pool.Size), labels)
+		poolAlloc.Add(float64(pool.Allocated), labels)
+		poolFree.Add(float64(pool.Free), labels)
+		if pool.Fragmentation >= 0 {
+			poolFrag.Add(pool.Fragmentation, labels)
+		}
+		for _, state := range poolHealthStates {
+			poolHealth.Add(boolValue(pool.Health == state), Labels{"pool": pool.Name, "state": state})
+		}
+
+		scan, err := nas.PoolScanStatus(pool.Name)
+		if err != nil {
+			return nil, err
+		}
+		scrubRunning.Add(boolValue(scan.State == "in progress"), labels)
+		if scan.EndTime != nil {
+			scrubAge.Add(time.Since(*scan.EndTime).Seconds(), labels)
+		}
+
+		datasets, err := nas.ListDatasetStats(pool.Name)
+		if err != nil {
+			return nil, err
+		}
+		counts, err := nas.CountSnapshots(pool.Name)
+		if err != nil {
+			return nil, err
+		}
+		for _, dataset := range datasets {
+			labels := Labels{"pool": pool.Name, "dataset": dataset.Name}
+			used.Add(float64(dataset.Used), labels)
+			available.Add(float64(dataset.Available), labels)
+			quota.Add(float64(dataset.Quota), labels)
+			snapshots.Add(float64(counts[dataset.Name]), labels)
+		}
+	}
+
+	return []*Family{poolSize, poolAlloc, poolFree, poolFrag, poolHealth, scrubRunning, scrubAge, used, available, quota, snapshots}, nil
+}
+
+func collectArc() ([]*Family, error) {
+	stats, err := GetArcStats()
+	if err != nil {
+		return nil, err
+	}
+
+	counter := func(name, help string, value uint64) *Family {
+		f := &Family{Name: name, Help: help, Type: Counter}
+		f.Add(float64(value), nil)
+		return f
+	}
+	gauge := func(name, help string, value float64) *Family {
+		f := &Family{Name: name, Help: help, Type: Gauge}
+		f.Add(value, nil)
+		return f
+	}
+
+	listSize := &Family{Name: "easynas_zfs_arc_list_size_bytes", Help: "Size of the most recently and most frequently used lists.", Type: Gauge}
+	listSize.Add(float64(stats.MruSize), Labels{"list": "mru"})
+	listSize.Add(float64(stats.MfuSize), Labels{"list": "mfu"})
+	listHits := &Family{Name: "easynas_zfs_arc_list_hits_total", Help: "Hits of the most recently and most frequently used lists and their ghost lists.", Type: Counter}
+	listHits.Add(float64(stats.MruHits), Labels{"list": "mru"})
+	listHits.Add(float64(stats.MfuHits), Labels{"list": "mfu"})
+	listHits.Add(float64(stats.MruGhostHits), Labels{"list": "mru_ghost"})
+	listHits.Add(float64(stats.MfuGhostHits), Labels{"list": "mfu_ghost"})
+
+	families := []*Family{
+		counter("easynas_zfs_arc_hits_total", "ARC hits.", stats.Hits),
+		counter("easynas_zfs_arc_misses_total", "ARC misses.", stats.Misses),
+		counter("easynas_zfs_arc_demand_hits_total", "ARC hits of reads applications asked for.", stats.DemandHits),
+		counter("easynas_zfs_arc_demand_misses_total", "ARC misses of reads applications asked for.", stats.DemandMisses),
+		counter("easynas_zfs_arc_prefetch_hits_total", "ARC hits of prefetched reads.", stats.PrefetchHits),
+		counter("easynas_zfs_arc_prefetch_misses_total", "ARC misses of prefetched reads.", stats.PrefetchMisses),
+		counter("easynas_zfs_arc_memory_throttles_total", "Times the ARC had to shrink for memory.", stats.MemoryThrottles),
+		gauge("easynas_zfs_arc_size_bytes", "Current size of the ARC.", float64(stats.Size)),
+		gauge("easynas_zfs_arc_target_size_bytes", "Size the ARC is adapting to.", float64(stats.TargetSize)),
+		gauge("easynas_zfs_arc_min_size_bytes", "Minimum size of the ARC.", float64(stats.MinSize)),
+		gauge("easynas_zfs_arc_max_size_bytes", "Maximum size of the ARC.", float64(stats.MaxSize)),
+		gauge("easynas_zfs_arc_data_size_bytes", "Data cached in the ARC.", float64(stats.DataSize)),
+		gauge("easynas_zfs_arc_metadata_size_bytes", "Metadata cached in the ARC.", float64(stats.MetadataSize)),
+		gauge("easynas_zfs_arc_hit_ratio", "ARC hits per access since the module was loaded.", stats.HitRatio),
+		listSize,
+		listHits,
+	}
+	if l2 := stats.L2; l2 != nil {
+		families = append(families,
+			counter("easynas_zfs_l2arc_hits_total", "L2ARC hits.", l2.Hits),
+			counter("easynas_zfs_l2arc_misses_total", "L2ARC misses.", l2.Misses),
+			counter("easynas_zfs_l2arc_read_bytes_total", "Bytes read from the cache devices.", l2.ReadBytes),
+			counter("easynas_zfs_l2arc_written_bytes_total", "Bytes written to the cache devices.", l2.WriteBytes),
+			gauge("easynas_zfs_l2arc_size_bytes", "Data cached in the L2ARC, uncompressed.", float64(l2.Size)),
+			gauge("easynas_zfs_l2arc_allocated_bytes", "Space used on the cache devices.", float64(l2.AllocatedSize)),
+			gauge("easynas_zfs_l2arc_header_bytes", "ARC memory used to track the L2ARC.", float64(l2.HeaderSize)),
+		)
+	}
+	return families, nil
+}
+
+// collectIO exports the disk and network counters, Prometheus computes the
+// rates
+func collectIO() ([]*Family, error) {
+	counters, err := readIOCounters()
+	if err != nil {
+		return nil, err
+	}
+
+	reads := &Family{Name: "easynas_disk_reads_completed_total", Help: "Reads completed by the device.", Type: Counter}
+	writes := &Family{Name: "easynas_disk_writes_completed_total", Help: "Writes completed by the device.", Type: Counter}
+	readBytes := &Family{Name: "easynas_disk_read_bytes_total", Help: "Bytes read from the device.", Type: Counter}
+	writeBytes := &Family{Name: "easynas_disk_written_bytes_total", Help: "Bytes written to the device.", Type: Counter}
+	readTime := &Family{Name: "easynas_disk_read_time_seconds_total", Help: "Time spent on reads by the device.", Type: Counter}
+	writeTime := &Family{Name: "easynas_disk_write_time_seconds_total", Help: "Time spent on writes by the device.", Type: Counter}
+	ioTime := &Family{Name: "easynas_disk_io_time_seconds_total", Help: "Time the device had requests in flight.", Type: Counter}
+	var devices []string
+	for name := range counters.disks {
+		if !ignoredDevice(name) {
+			devices = append(devices, name)
+		}
+	}
+	sort.Strings(devices)
+	for _, name := range devices {
+		d := counters.disks[name]
+		labels := Labels{"device": name}
+		reads.Add(float64(d.ReadCount), labels)
+		writes.Add(float64(d.WriteCount), labels)
+		readBytes.Add(float64(d.ReadBytes), labels)
+		writeBytes.Add(float64(d.WriteBytes), labels)
+		readTime.Add(float64(d.ReadTime)/1000, labels)
+		writeTime.Add(float64(d.WriteTime)/1000, labels)
+		ioTime.Add(float64(d.IoTime)/1000, labels)
+	}
+
+	receiveBytes := &Family{Name: "easynas_network_receive_bytes_total", Help: "Bytes received by the interface.", Type: Counter}
+	transmitBytes := &Family{Name: "easynas_network_transmit_bytes_total", Help: "Bytes sent by the interface.", Type: Counter}
+	receivePackets := &Family{Name: "easynas_network_receive_packets_total", Help: "Packets received by the interface.", Type: Counter}
+	transmitPackets := &Family{Name: "easynas_network_transmit_packets_total", Help: "Packets sent by the interface.", Type: Counter}
+	receiveErrors := &Family{Name: "easynas_network_receive_errors_total", Help: "Receive errors of the interface.", Type: Counter}
+	transmitErrors := &Family{Name: "easynas_network_transmit_errors_total", Help: "Transmit errors of the interface.", Type: Counter}
+	receiveDrops := &Family{Name: "easynas_network_receive_drop_total", Help: "Received packets the interface dropped.", Type: Counter}
+	transmitDrops := &Family{Name: "easynas_network_transmit_drop_total", Help: "Outgoing packets the interface dropped.", Type: Counter}
+	for _, i := range counters.interfaces {
+		if i.Name == "lo" {
+			continue
+		}
+		labels := Labels{"interface": i.Name}
+		receiveBytes.Add(float64(i.BytesRecv), labels)
+		transmitBytes.Add(float64(i.BytesSent), labels)
+		receivePackets.Add(float64(i.PacketsRecv), labels)
+		transmitPackets.Add(float64(i.PacketsSent), labels)
+		receiveErrors.Add(float64(i.Errin), labels)
+		transmitErrors.Add(float64(i.Errout), labels)
+		receiveDrops.Add(float64(i.Dropin), labels)
+		transmitDrops.Add(float64(i.Dropout), labels)
+	}
+
+	iowait := &Family{Name: "easynas_cpu_iowait_seconds_total", Help: "Time the CPUs were idle waiting for I/O.", Type: Counter}
+	iowait.Add(counters.cpu.Iowait, nil)
+
+	return []*Family{reads, writes, readBytes, writeBytes, readTime, writeTime, ioTime,
+		receiveBytes, transmitBytes, receivePackets, transmitPackets, receiveErrors, transmitErrors, receiveDrops, transmitDrops,
+		iowait}, nil
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
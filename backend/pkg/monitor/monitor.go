@@ -0,0 +1,107 @@
+package monitor
+
+import (
+	"context"
+	"github.com/whyxn/easynas/backend/pkg/config"
+	"github.com/whyxn/easynas/backend/pkg/events"
+	"github.com/whyxn/easynas/backend/pkg/lifecycle"
+	"github.com/whyxn/easynas/backend/pkg/log"
+	"github.com/whyxn/easynas/backend/pkg/metrics"
+	"github.com/whyxn/easynas/backend/pkg/nas"
+	"github.com/whyxn/easynas/backend/pkg/util"
+	"time"
+)
+
+// Start polls the managed pools and publishes changes of their health and
+// the progress of scrubs and resilvers. System metrics are sampled only while
+// somebody subscribes to them.
+func Start(poolInterval, metricsInterval time.Duration) {
+	lifecycle.Go(func(ctx context.Context) {
+		every(poolInterval, newPoolWatcher().check)
+	})
+	lifecycle.Go(func(ctx context.Context) {
+		every(metricsInterval, sampleMetrics)
+	})
+}
+
+func every(interval time.Duration, fn func()) {
+	fn()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			fn()
+		case <-lifecycle.Done():
+			return
+		}
+	}
+}
+
+type poolWatcher struct {
+	health map[string]string
+	scans  map[string]*nas.ScanStatus
+	// lastErr keeps a failing zpool command from being logged on every poll
+	lastErr string
+}
+
+func newPoolWatcher() *poolWatcher {
+	return &poolWatcher{health: map[string]string{}, scans: map[string]*nas.ScanStatus{}}
+}
+
+func (w *poolWatcher) check() {
+	pools, err := nas.ListZPools()
+	if err != nil {
+		w.fail(err)
+		return
+	}
+
+	managed := config.Get().Nas.Pools
+	for _, pool := range pools {
+		if !util.Contains(managed, pool.Name) {
+			continue
+		}
+
+		// the first poll only records the state easynas started with
+		if previous, seen := w.health[pool.Name]; seen && previous != pool.Health {
+			log.Logger.Warnw("Pool health changed", "pool", pool.Name, "from", previous, "to", pool.Health)
+			events.Publish(events.Event{
+				Type: events.PoolHealth,
+				Pool: pool.Name,
+				Data: map[string]interface{}{"health": pool.Health, "previous": previous},
+			})
+		}
+		w.health[pool.Name] = pool.Health
+
+		scan, err := nas.PoolScanStatus(pool.Name)
+		if err != nil {
+			w.fail(err)
+			continue
+		}
+		previous := w.scans[pool.Name]
+		w.scans[pool.Name] = scan
+		if scan.State == "in progress" || (previous != nil && previous.State == "in progress") {
+			events.Publish(events.Event{Type: events.PoolScrub, Pool: pool.Name, Data: scan})
+		}
+	}
+	w.lastErr = ""
+}
+
+func (w *poolWatcher) fail(err error) {
+	if err.Error() != w.lastErr {
+		log.Logger.Warnw("Failed to check pool status", "err", err)
+		w.lastErr = err.Error()
+	}
+}
+
+func sampleMetrics() {
+	if !events.HasSubscribers(events.TopicMetrics) {
+		return
+	}
+	sample, err := metrics.GetSystemMetrics()
+	if err != nil {
+		log.Logger.Warnw("Failed to collect system metrics", "err", err)
+		return
+	}
+	events.Publish(events.Event{Type: events.MetricsSample, Data: sample, Volatile: true})
+}
@@ -0,0 +1,204 @@
+package nas
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Archive formats
+const (
+	ArchiveZip   = "zip"
+	ArchiveTarGz = "tar.gz"
+)
+
+// ArchiveSource is a dataset, or a snapshot of it, to archive files of.
+type ArchiveSource struct {
+	Dataset string
+	// Snapshot is the name after the @, empty for the live dataset
+	Snapshot string
+}
+
+// Root returns the directory the files of the source are in. The files of a
+// snapshot are read through the hidden .zfs directory of the dataset, which
+// does not need the snapshot to be mounted.
+func (s ArchiveSource) Root() string {
+	if s.Snapshot != "" {
+		return filepath.Join("/"+s.Dataset, ".zfs", "snapshot", s.Snapshot)
+	}
+	return "/" + s.Dataset
+}
+
+// ArchiveEntry is a selected file or folder and the name it has in the
+// archive.
+type ArchiveEntry struct {
+	Path string
+	Name string
+}
+
+// Resolve returns the entries of the paths relative to the root of the
+// source, which must exist. They are named after their last element, the
+// root after the dataset.
+func (s ArchiveSource) Resolve(relativePaths []string) ([]ArchiveEntry, error) {
+	var entries []ArchiveEntry
+	for _, relativePath := range relativePaths {
+		p, err := rootedPath(s.Root(), relativePath)
+		if err != nil {
+			return nil, err
+		}
+		name := path.Base(path.Clean("/" + filepath.ToSlash(relativePath)))
+		if name == "/" {
+			name = path.Base(s.Dataset)
+		}
+		entries = append(entries, ArchiveEntry{Path: p, Name: name})
+	}
+	return entries, nil
+}
+
+// archiveWriter adds entries to a zip or tar archive
+type archiveWriter interface {
+	addDir(name string, info os.FileInfo) error
+	addFile(name string, info os.FileInfo, src io.Reader) error
+	Close() error
+}
+
+// WriteArchive streams the selected files and folders, with everything
+// below the folders, as an archive of the format. Archiving a folder photos
+// yields photos/... Only files and folders are archived; symbolic links are
+// skipped so they can not reach outside of the dataset. Nothing is buffered
+// beyond the compressor.
+func WriteArchive(w io.Writer, format string, entries []ArchiveEntry) error {
+	var aw archiveWriter
+	switch format {
+	case ArchiveZip:
+		aw = &zipArchive{zip.NewWriter(w)}
+	case ArchiveTarGz:
+		gz := gzip.NewWriter(w)
+		aw = &tarArchive{gz: gz, tw: tar.NewWriter(gz)}
+	default:
+		return fmt.Errorf("unknown archive format '%s'", format)
+	}
+
+	for _, entry := range entries {
+		err := filepath.Walk(entry.Path, func(fullPath string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(entry.Path, fullPath)
+			if err != nil {
+				return err
+			}
+			// archives always use forward slashes
+			name := path.Join(entry.Name, filepath.ToSlash(rel))
+
+			switch {
+			case info.IsDir() && info.Name() == ".zfs" && fullPath != entry.Path:
+				// the snapshots of the dataset, visible with snapdir=visible
+				return filepath.SkipDir
+			case info.IsDir():
+				return aw.addDir(name+"/", info)
+			case info.Mode().IsRegular():
+				file, err := os.Open(fullPath)
+				if err != nil {
+					return err
+				}
+				defer file.Close()
+				return aw.addFile(name, info, file)
+			}
+			return nil
+		})
+		if err != nil {
+			aw.Close()
+			return err
+		}
+	}
+	return aw.Close()
+}
+
+type zipArchive struct {
+	zw *zip.Writer
+}
+
+func (a *zipArchive) addDir(name string, info os.FileInfo) error {
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	_, err = a.zw.CreateHeader(header)
+	return err
+}
+
+func (a *zipArchive) addFile(name string, info os.FileInfo, src io.Reader) error {
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	header.Method = zip.Deflate
+	dst, err := a.zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	return err
+}
+
+func (a *zipArchive) Close() error {
+	return a.zw.Close()
+}
+
+type tarArchive struct {
+	gz *gzip.Writer
+	tw *tar.Writer
+}
+
+func (a *tarArchive) addDir(name string, info os.FileInfo) error {
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	return a.tw.WriteHeader(header)
+}
+
+func (a *tarArchive) addFile(name string, info os.FileInfo, src io.Reader) error {
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	if err = a.tw.WriteHeader(header); err != nil {
+		return err
+	}
+	// a file that grows while it is archived must not overflow its header
+	_, err = io.Copy(a.tw, io.LimitReader(src, header.Size))
+	return err
+}
+
+func (a *tarArchive) Close() error {
+	err := a.tw.Close()
+	if gzErr := a.gz.Close(); err == nil {
+		err = gzErr
+	}
+	return err
+}
+
+// ArchiveName returns the file name of an archive of the entries, named
+// after the only entry or else after the dataset.
+func ArchiveName(source ArchiveSource, entries []ArchiveEntry, format string) string {
+	name := path.Base(source.Dataset)
+	if len(entries) == 1 {
+		name = entries[0].Name
+	}
+	if source.Snapshot != "" {
+		name += "@" + source.Snapshot
+	}
+	return strings.TrimPrefix(name, ".") + "." + format
+}
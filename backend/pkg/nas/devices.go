@@ -0,0 +1,78 @@
+package nas
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// PoolDevice is where a device is used in a pool.
+type PoolDevice struct {
+	Pool string `json:"pool"`
+	// Vdev is the top-level vdev, empty for a device striped directly in
+	// the pool
+	Vdev string `json:"vdev,omitempty"`
+	// Class is logs, cache, special, dedup or spares for the devices of
+	// those sections
+	Class string `json:"class,omitempty"`
+	State string `json:"state"`
+}
+
+// ListPoolDevices maps the paths of the devices of all imported pools, with
+// symbolic links resolved, like /dev/sda1, to where they are used.
+func ListPoolDevices() (map[string]PoolDevice, error) {
+	output, err := exec.Command(zpoolCommand(), "status", "-LP").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pool status: %w", err)
+	}
+	return parsePoolDevices(string(output)), nil
+}
+
+func parsePoolDevices(output string) map[string]PoolDevice {
+	devices := map[string]PoolDevice{}
+	inConfig := false
+	var pool, vdev, class string
+	for _, line := range strings.Split(output, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(trimmed, "config:"):
+			inConfig = true
+			continue
+		case strings.HasPrefix(trimmed, "errors:"), strings.HasPrefix(trimmed, "pool:"):
+			inConfig = false
+			continue
+		}
+		// the config lines are indented by a tab, vdevs by two spaces more
+		// per level
+		if !inConfig || !strings.HasPrefix(line, "\t") {
+			continue
+		}
+		fields := strings.Fields(trimmed)
+		if len(fields) == 0 || fields[0] == "NAME" {
+			continue
+		}
+
+		line = strings.TrimPrefix(line, "\t")
+		depth := (len(line) - len(strings.TrimLeft(line, " "))) / 2
+		name := fields[0]
+		state := ""
+		if len(fields) > 1 {
+			state = fields[1]
+		}
+		switch {
+		case depth == 0 && vdevClasses[name]:
+			class, vdev = name, ""
+		case depth == 0:
+			pool, class, vdev = name, "", ""
+		case depth == 1 && !strings.HasPrefix(name, "/"):
+			vdev = name
+		case strings.HasPrefix(name, "/"):
+			device := PoolDevice{Pool: pool, Class: class, State: state}
+			if depth > 1 {
+				device.Vdev = vdev
+			}
+			devices[name] = device
+		}
+	}
+	return devices
+}
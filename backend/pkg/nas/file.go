@@ -1,6 +1,7 @@
 package nas
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -8,6 +9,10 @@ import (
 	"strings"
 )
 
+// ErrOutsidePath is returned for relative paths that lead out of their root,
+// like ../other-dataset
+var ErrOutsidePath = errors.New("path is outside of the dataset")
+
 // FileInfo holds the path and size of a file or folder
 type FileInfo struct {
 	Name string
@@ -63,3 +68,41 @@ func ListAndSortFilesFolders(path string) ([]FileInfo, error) {
 
 	return result, nil
 }
+
+// DatasetFilePath returns the absolute path of a path relative to the mount
+// point of a dataset, with symbolic links resolved. The path must exist and
+// stay inside the dataset.
+func DatasetFilePath(dataset, relativePath string) (string, error) {
+	return rootedPath("/"+dataset, relativePath)
+}
+
+// rootedPath joins a relative path to root and resolves symbolic links,
+// refusing paths that leave root. Users write the datasets through their
+// shares, so a link may point anywhere.
+func rootedPath(root, relativePath string) (string, error) {
+	root, err := filepath.EvalSymlinks(filepath.Clean(root))
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(root, relativePath)
+	if !insidePath(root, path) {
+		return "", ErrOutsidePath
+	}
+	if path, err = filepath.EvalSymlinks(path); err != nil {
+		return "", err
+	}
+	if !insidePath(root, path) {
+		return "", ErrOutsidePath
+	}
+	return path, nil
+}
+
+func insidePath(root, path string) bool {
+	return path == root || strings.HasPrefix(path, root+string(filepath.Separator))
+}
+
+// FileETag returns a strong entity tag of a file, which changes whenever the
+// file is written or replaced.
+func FileETag(info os.FileInfo) string {
+	return fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size())
+}
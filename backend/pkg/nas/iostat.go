@@ -0,0 +1,146 @@
+package nas
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// IOWait is a read and write latency in milliseconds, nil if the vdev had
+// no such requests
+type IOWait struct {
+	Read  *float64 `json:"read"`
+	Write *float64 `json:"write"`
+}
+
+// VdevIOStat is the activity of a pool, or one of its vdevs, over the
+// sampled interval.
+type VdevIOStat struct {
+	Pool string `json:"pool"`
+	Name string `json:"name"`
+	// Parent is the vdev this one belongs to, empty for the pool itself
+	Parent string `json:"parent,omitempty"`
+	// Class is logs, cache, special, dedup or spares for the vdevs of those
+	// sections
+	Class string `json:"class,omitempty"`
+	Depth int    `json:"depth"`
+	// Operations and bytes per second
+	ReadOps    float64 `json:"readOps"`
+	WriteOps   float64 `json:"writeOps"`
+	ReadBytes  float64 `json:"readBytes"`
+	WriteBytes float64 `json:"writeBytes"`
+	// TotalWait is the whole latency of requests, DiskWait the part spent in
+	// the disk and the queue waits the part spent in the ZFS I/O queues
+	TotalWait      IOWait   `json:"totalWait"`
+	DiskWait       IOWait   `json:"diskWait"`
+	SyncQueueWait  IOWait   `json:"syncQueueWait"`
+	AsyncQueueWait IOWait   `json:"asyncQueueWait"`
+	ScrubWait      *float64 `json:"scrubWait"`
+	TrimWait       *float64 `json:"trimWait"`
+}
+
+// vdevClasses are the section headings zpool prints for special vdevs
+var vdevClasses = map[string]bool{"logs": true, "cache": true, "special": true, "dedup": true, "spares": true}
+
+// PoolIOStats samples the activity of the pools and their vdevs with
+// zpool iostat for interval, which must be whole seconds.
+func PoolIOStats(pools []string, interval time.Duration) ([]VdevIOStat, error) {
+	seconds := int(interval / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	// -y leaves out the report since boot, -l adds the latencies
+	args := append([]string{"iostat", "-vply"}, pools...)
+	args = append(args, strconv.Itoa(seconds), "1")
+	output, err := exec.Command(zpoolCommand(), args...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pool iostat: %w", err)
+	}
+	return parseIOStat(string(output)), nil
+}
+
+// parseIOStat reads the human readable output, which unlike the scripted
+// one indents vdevs below their parent
+func parseIOStat(output string) []VdevIOStat {
+	var stats []VdevIOStat
+	var pool, class string
+	var parents []string
+	inBody := false
+	for _, line := range strings.Split(output, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "---") {
+			// the header ends at the first rule, the others end a pool
+			inBody = true
+			pool = ""
+			continue
+		}
+		fields := strings.Fields(line)
+		if !inBody || len(fields) == 0 {
+			continue
+		}
+
+		depth := (len(line) - len(strings.TrimLeft(line, " "))) / 2
+		name := fields[0]
+		if depth == 0 && pool != "" && vdevClasses[name] {
+			// the vdevs of the section follow at the level of top-level vdevs
+			class = name
+			parents = parents[:1]
+			continue
+		}
+		if depth == 0 {
+			pool, class = name, ""
+			parents = []string{name}
+		} else if pool == "" {
+			continue
+		}
+		if depth < len(parents) {
+			parents = parents[:depth]
+		}
+		stat := VdevIOStat{Pool: pool, Name: name, Class: class, Depth: depth}
+		if depth > 0 {
+			stat.Parent = parents[len(parents)-1]
+		}
+		parents = append(parents, name)
+
+		values := fields[1:]
+		if len(values) < 6 {
+			// spares have no statistics
+			stats = append(stats, stat)
+			continue
+		}
+		stat.ReadOps = parseIOValue(values[2])
+		stat.WriteOps = parseIOValue(values[3])
+		stat.ReadBytes = parseIOValue(values[4])
+		stat.WriteBytes = parseIOValue(values[5])
+		if len(values) >= 16 {
+			stat.TotalWait = IOWait{parseWait(values[6]), parseWait(values[7])}
+			stat.DiskWait = IOWait{parseWait(values[8]), parseWait(values[9])}
+			stat.SyncQueueWait = IOWait{parseWait(values[10]), parseWait(values[11])}
+			stat.AsyncQueueWait = IOWait{parseWait(values[12]), parseWait(values[13])}
+			stat.ScrubWait = parseWait(values[14])
+			stat.TrimWait = parseWait(values[15])
+		}
+		stats = append(stats, stat)
+	}
+	return stats
+}
+
+func parseIOValue(value string) float64 {
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// parseWait converts the nanoseconds zpool prints with -p to milliseconds
+func parseWait(value string) *float64 {
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return nil
+	}
+	ms := n / 1e6
+	return &ms
+}
@@ -0,0 +1,134 @@
+package nas
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseIOStat(t *testing.T) {
+	// vdev is where a line of the output ends up
+	type vdev struct {
+		pool, name, parent, class string
+		depth                     int
+	}
+	tests := []struct {
+		fixture string
+		vdevs   []vdev
+	}{
+		{
+			fixture: "zpool_iostat_mirror_logs_cache.txt",
+			vdevs: []vdev{
+				{"naspool", "naspool", "", "", 0},
+				{"naspool", "mirror-0", "naspool", "", 1},
+				{"naspool", "sda", "mirror-0", "", 2},
+				{"naspool", "sdb", "mirror-0", "", 2},
+				{"naspool", "nvme0n1", "naspool", "logs", 1},
+				{"naspool", "sdd", "naspool", "cache", 1},
+			},
+		},
+		{
+			fixture: "zpool_iostat_two_pools.txt",
+			vdevs: []vdev{
+				{"naspool", "naspool", "", "", 0},
+				{"naspool", "sda", "naspool", "", 1},
+				{"tank", "tank", "", "", 0},
+				{"tank", "raidz1-0", "tank", "", 1},
+				{"tank", "sdb", "raidz1-0", "", 2},
+				{"tank", "sdc", "raidz1-0", "", 2},
+				{"tank", "sdd", "raidz1-0", "", 2},
+				{"tank", "sde", "tank", "spares", 1},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			output, err := os.ReadFile(filepath.Join("testdata", tt.fixture))
+			if err != nil {
+				t.Fatal(err)
+			}
+			stats := parseIOStat(string(output))
+			if len(stats) != len(tt.vdevs) {
+				t.Fatalf("parseIOStat() returned %d vdevs, want %d: %+v", len(stats), len(tt.vdevs), stats)
+			}
+			for i, want := range tt.vdevs {
+				s := stats[i]
+				got := vdev{s.Pool, s.Name, s.Parent, s.Class, s.Depth}
+				if got != want {
+					t.Errorf("vdev %d = %+v, want %+v", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseIOStatValues(t *testing.T) {
+	output, err := os.ReadFile(filepath.Join("testdata", "zpool_iostat_mirror_logs_cache.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats := parseIOStat(string(output))
+	byName := map[string]VdevIOStat{}
+	for _, s := range stats {
+		byName[s.Name] = s
+	}
+
+	sda := byName["sda"]
+	if sda.ReadOps != 60 || sda.WriteOps != 20 || sda.ReadBytes != 3932160 || sda.WriteBytes != 655360 {
+		t.Errorf("sda operations and bandwidth = %v %v %v %v", sda.ReadOps, sda.WriteOps, sda.ReadBytes, sda.WriteBytes)
+	}
+	// latencies are printed in nanoseconds and reported in milliseconds
+	waits := []struct {
+		name      string
+		got       *float64
+		want      float64
+		wantUnset bool
+	}{
+		{name: "total read", got: sda.TotalWait.Read, want: 2.000112},
+		{name: "total write", got: sda.TotalWait.Write, want: 9.000001},
+		{name: "disk read", got: sda.DiskWait.Read, want: 1.8},
+		{name: "sync queue write", got: sda.SyncQueueWait.Write, want: 0.0044},
+		{name: "async queue write", got: sda.AsyncQueueWait.Write, want: 3.1},
+		{name: "scrub", got: sda.ScrubWait, wantUnset: true},
+		{name: "trim", got: sda.TrimWait, wantUnset: true},
+	}
+	for _, w := range waits {
+		if w.wantUnset {
+			if w.got != nil {
+				t.Errorf("sda %s wait = %v, want nil for '-'", w.name, *w.got)
+			}
+		} else if w.got == nil || *w.got != w.want {
+			t.Errorf("sda %s wait = %v, want %v", w.name, deref(w.got), w.want)
+		}
+	}
+
+	// a log device only writes
+	logDevice := byName["nvme0n1"]
+	if logDevice.WriteBytes != 262144 || logDevice.TotalWait.Read != nil || logDevice.TotalWait.Write == nil {
+		t.Errorf("nvme0n1 = %+v, want writes with a write latency only", logDevice)
+	}
+	if pool := byName["naspool"]; pool.TrimWait == nil || *pool.TrimWait != 0.098011 {
+		t.Errorf("naspool trim wait = %v, want 0.098011", deref(pool.TrimWait))
+	}
+}
+
+func TestParseIOStatSpares(t *testing.T) {
+	output, err := os.ReadFile(filepath.Join("testdata", "zpool_iostat_two_pools.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats := parseIOStat(string(output))
+	spare := stats[len(stats)-1]
+	if spare.Name != "sde" || spare.ReadOps != 0 || spare.TotalWait.Read != nil {
+		t.Errorf("spare = %+v, want no statistics", spare)
+	}
+}
+
+// deref returns the value a pointer points to, or nil, for error messages
+func deref[T any](p *T) interface{} {
+	if p == nil {
+		return nil
+	}
+	return *p
+}
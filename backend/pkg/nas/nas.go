@@ -2,11 +2,17 @@ package nas
 
 import (
 	"bytes"
+	"context"
 	"fmt"
+	"github.com/whyxn/easynas/backend/pkg/config"
 	"github.com/whyxn/easynas/backend/pkg/log"
 	"github.com/whyxn/easynas/backend/pkg/util"
 	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // ZPool represents a ZFS zpool with relevant properties.
@@ -37,9 +43,19 @@ type Snapshot struct {
 	CreatedAt  string `json:"createdAt"`
 }
 
+// zfsCommand returns the configured path of the zfs command
+func zfsCommand() string {
+	return config.Get().Commands.Zfs
+}
+
+// zpoolCommand returns the configured path of the zpool command
+func zpoolCommand() string {
+	return config.Get().Commands.Zpool
+}
+
 // ListZPools lists all zpools on the system.
 func ListZPools() ([]ZPool, error) {
-	cmd := exec.Command("zpool", "list", "-H", "-o", "name,size,alloc,free,frag,health")
+	cmd := exec.Command(zpoolCommand(), "list", "-H", "-o", "name,size,alloc,free,frag,health")
 	output, err := cmd.Output()
 	if err != nil {
 		return nil, err
@@ -66,7 +82,17 @@ func ListZPools() ([]ZPool, error) {
 
 // ListZFSDatasets lists all ZFS volumes on the system.
 func ListZFSDatasets() ([]ZFSDataset, error) {
-	cmd := exec.Command("zfs", "list", "-H", "-o", "name,quota,used,avail", "-t", "filesystem")
+	return listDatasets()
+}
+
+// ListPoolDatasets lists the ZFS volumes of a pool, including the pool's root dataset.
+func ListPoolDatasets(pool string) ([]ZFSDataset, error) {
+	return listDatasets("-r", pool)
+}
+
+func listDatasets(extraArgs ...string) ([]ZFSDataset, error) {
+	args := append([]string{"list", "-H", "-o", "name,quota,used,avail", "-t", "filesystem"}, extraArgs...)
+	cmd := exec.Command(zfsCommand(), args...)
 	output, err := cmd.Output()
 	if err != nil {
 		return nil, err
@@ -92,7 +118,7 @@ func ListZFSDatasets() ([]ZFSDataset, error) {
 
 // ListZVOLs lists all ZFS ZVOLs.
 func ListZVOLs() ([]string, error) {
-	cmd := exec.Command("zfs", "list", "-H", "-o", "name", "-t", "volume")
+	cmd := exec.Command(zfsCommand(), "list", "-H", "-o", "name", "-t", "volume")
 	output, err := cmd.Output()
 	if err != nil {
 		return nil, err
@@ -100,19 +126,37 @@ func ListZVOLs() ([]string, error) {
 	return strings.Split(strings.TrimSpace(string(output)), "\n"), nil
 }
 
-// CreateZFSVolume creates a ZFS volume with a specified quota.
-func CreateZFSVolume(name, quota string) error {
-	cmd := exec.Command("zfs", "create", "-o", fmt.Sprintf("quota=%s", quota), name)
+// CreateZFSVolume creates a ZFS volume with the given properties, e.g.
+// quota or compression. Properties with an empty value are left at the
+// inherited value.
+func CreateZFSVolume(name string, properties map[string]string) error {
+	keys := make([]string, 0, len(properties))
+	for key, value := range properties {
+		if value != "" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	args := []string{"create"}
+	for _, key := range keys {
+		args = append(args, "-o", fmt.Sprintf("%s=%s", key, properties[key]))
+	}
+	args = append(args, name)
+
+	cmd := exec.Command(zfsCommand(), args...)
 	return cmd.Run()
 }
 
 // UpdateQuota updates the quota for an existing ZFS volume.
 func UpdateQuota(volumeName, quota string) error {
-	cmd := exec.Command("zfs", "set", fmt.Sprintf("quota=%s", quota), volumeName)
+	cmd := exec.Command(zfsCommand(), "set", fmt.Sprintf("quota=%s", quota), volumeName)
 	return cmd.Run()
 }
 
-// CreateNFSShare creates an NFS share for a given ZFS volume.
+// CreateNFSShare creates an NFS share for a given ZFS volume. Ownership of
+// the files is changed separately by SetPathPermissions, which takes long on
+// large datasets.
 func CreateNFSShare(zfsDatasetName string, rwIPs []string, roIPs []string) error {
 	var rwAccess, roAccess string
 
@@ -127,21 +171,20 @@ func CreateNFSShare(zfsDatasetName string, rwIPs []string, roIPs []string) error
 
 	log.Logger.Infow("creating nfs share", "permission", shareNfs)
 
-	cmd := exec.Command("zfs", "set", fmt.Sprintf("sharenfs=%s", shareNfs), zfsDatasetName)
-
-	err := cmd.Run()
-	if err != nil {
-		return err
-	}
-	return setPathPermissions(fmt.Sprintf("/%s", zfsDatasetName))
+	cmd := exec.Command(zfsCommand(), "set", fmt.Sprintf("sharenfs=%s", shareNfs), zfsDatasetName)
+	return cmd.Run()
 }
 
-// setPathPermissions sets ownership to nobody:nogroup and permissions to 777 on the specified ZFS path.
-func setPathPermissions(zfsPath string) error {
+// SetPathPermissions sets ownership to nobody:nogroup and permissions to 777 on the specified ZFS path.
+// Cancelling ctx stops it, leaving part of the files changed.
+func SetPathPermissions(ctx context.Context, zfsPath string) error {
 	// Change ownership to nobody:nogroup
-	chownCmd := exec.Command("sudo", "chown", "-R", "nobody:nogroup", zfsPath)
-	if err := chownCmd.Run(); err != nil {
-		return fmt.Errorf("failed to set path ownership: %v", err)
+	chownCmd := exec.CommandContext(ctx, config.Get().Commands.Sudo, config.Get().Commands.Chown, "-R", "nobody:nogroup", zfsPath)
+	if output, err := chownCmd.CombinedOutput(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("failed to set path ownership: %s (%v)", strings.TrimSpace(string(output)), err)
 	}
 
 	// Change permissions to 777
@@ -159,32 +202,36 @@ func ConfigureNFSAccess(volumeName string, rwIPs []string, roIPs []string) error
 	roAccess := fmt.Sprintf("ro=%s", strings.Join(roIPs, ":"))
 	shareNfs := fmt.Sprintf("%s,%s,insecure", rwAccess, roAccess)
 
-	cmd := exec.Command("zfs", "set", fmt.Sprintf("sharenfs=%s", shareNfs), volumeName)
+	cmd := exec.Command(zfsCommand(), "set", fmt.Sprintf("sharenfs=%s", shareNfs), volumeName)
 	return cmd.Run()
 }
 
 // RemoveNFSAccess revokes an IP's access to a specified NFS share.
 func RemoveNFSAccess(volumeName, ip string) error {
-	cmd := exec.Command("zfs", "set", "sharenfs=off", volumeName)
+	cmd := exec.Command(zfsCommand(), "set", "sharenfs=off", volumeName)
 	return cmd.Run()
 }
 
 // DeleteNFSShare disables NFS sharing on a ZFS volume.
 func DeleteNFSShare(volumeName string) error {
-	cmd := exec.Command("zfs", "set", "sharenfs=off", volumeName)
+	cmd := exec.Command(zfsCommand(), "set", "sharenfs=off", volumeName)
 	return cmd.Run()
 }
 
 // DeleteZFSVolume deletes a specified ZFS volume.
 func DeleteZFSVolume(volumeName string) error {
-	cmd := exec.Command("zfs", "destroy", volumeName)
-	return cmd.Run()
+	cmd := exec.Command(zfsCommand(), "destroy", volumeName)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to destroy dataset '%s': %s (%w)", volumeName, strings.TrimSpace(string(output)), err)
+	}
+	return nil
 }
 
 // ListSnapshots lists all snapshots for a given ZFS dataset with detailed information.
 func ListSnapshots(dataset string) ([]Snapshot, error) {
 	// Execute the zfs command to list snapshots with additional fields
-	cmd := exec.Command("zfs", "list", "-t", "snapshot", "-o", "name,used,referenced,creation", "-H", "-d", "1", dataset)
+	cmd := exec.Command(zfsCommand(), "list", "-t", "snapshot", "-o", "name,used,referenced,creation", "-H", "-d", "1", dataset)
 	var out bytes.Buffer
 	cmd.Stdout = &out
 	err := cmd.Run()
@@ -215,7 +262,7 @@ func ListSnapshots(dataset string) ([]Snapshot, error) {
 func CreateSnapshot(dataset, snapshotName string) error {
 	snapshot := fmt.Sprintf("%s@%s", dataset, snapshotName)
 	// Execute the zfs command to create the snapshot
-	cmd := exec.Command("zfs", "snapshot", snapshot)
+	cmd := exec.Command(zfsCommand(), "snapshot", snapshot)
 	err := cmd.Run()
 	if err != nil {
 		return fmt.Errorf("failed to create snapshot: %w", err)
@@ -226,7 +273,7 @@ func CreateSnapshot(dataset, snapshotName string) error {
 // RestoreFromSnapshot rolls back a dataset to a given snapshot.
 func RestoreFromSnapshot(snapshotName string) error {
 	// Execute the zfs command to rollback the dataset to the snapshot
-	cmd := exec.Command("zfs", "rollback", "-r", snapshotName)
+	cmd := exec.Command(zfsCommand(), "rollback", "-r", snapshotName)
 	output, err := cmd.CombinedOutput() // Capture both stdout and stderr
 	if err != nil {
 		return fmt.Errorf("failed to restore from snapshot '%s': %s (%w)", snapshotName, string(output), err)
@@ -237,10 +284,98 @@ func RestoreFromSnapshot(snapshotName string) error {
 // DeleteSnapshot deletes a specific ZFS snapshot.
 func DeleteSnapshot(snapshotName string) error {
 	// Execute the zfs destroy command
-	cmd := exec.Command("zfs", "destroy", snapshotName)
+	cmd := exec.Command(zfsCommand(), "destroy", snapshotName)
 	output, err := cmd.CombinedOutput() // Capture both stdout and stderr
 	if err != nil {
 		return fmt.Errorf("failed to delete snapshot '%s': %s (%w)", snapshotName, string(output), err)
 	}
 	return nil
 }
+
+// ScanStatus is the state of the running or last scrub or resilver of a pool.
+type ScanStatus struct {
+	// Function is scrub or resilver, empty if the pool was never scanned
+	Function string `json:"function"`
+	// State is one of none, in progress, finished or canceled
+	State   string  `json:"state"`
+	Percent float64 `json:"percent"`
+	Summary string  `json:"summary"`
+	// Errors is the number of errors the last finished scan found
+	Errors int `json:"errors"`
+	// EndTime is when the last scan finished or was canceled
+	EndTime *time.Time `json:"endTime,omitempty"`
+}
+
+var (
+	scanPercent = regexp.MustCompile(`([\d.]+)% done`)
+	scanErrors  = regexp.MustCompile(`with (\d+) errors`)
+)
+
+// PoolScanStatus reads the scan section of zpool status.
+func PoolScanStatus(pool string) (*ScanStatus, error) {
+	cmd := exec.Command(zpoolCommand(), "status", pool)
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pool status: %w", err)
+	}
+	return parseScanStatus(string(output)), nil
+}
+
+func parseScanStatus(output string) *ScanStatus {
+	var lines []string
+	inScan := false
+	for _, line := range strings.Split(output, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "scan:") {
+			inScan = true
+			lines = append(lines, strings.TrimSpace(strings.TrimPrefix(trimmed, "scan:")))
+			continue
+		}
+		// continuation lines are indented, the next section starts with "name:"
+		if inScan {
+			if trimmed == "" || strings.HasSuffix(strings.Fields(trimmed)[0], ":") {
+				break
+			}
+			lines = append(lines, trimmed)
+		}
+	}
+
+	status := &ScanStatus{State: "none", Summary: strings.Join(lines, ", ")}
+	if len(lines) == 0 || strings.HasPrefix(lines[0], "none requested") {
+		return status
+	}
+
+	status.Function = strings.Fields(lines[0])[0]
+	switch {
+	case strings.Contains(lines[0], "in progress"):
+		status.State = "in progress"
+		if m := scanPercent.FindStringSubmatch(status.Summary); m != nil {
+			status.Percent, _ = strconv.ParseFloat(m[1], 64)
+		}
+	case strings.Contains(lines[0], "canceled"):
+		status.State = "canceled"
+		status.EndTime = parseScanTime(lines[0])
+	default:
+		status.State = "finished"
+		status.Percent = 100
+		status.EndTime = parseScanTime(lines[0])
+		if m := scanErrors.FindStringSubmatch(lines[0]); m != nil {
+			status.Errors, _ = strconv.Atoi(m[1])
+		}
+	}
+	return status
+}
+
+// parseScanTime reads the time at the end of a scan line like "scrub
+// repaired 0B in 00:00:01 with 0 errors on Sun Jul 25 16:07:49 2021"
+func parseScanTime(line string) *time.Time {
+	i := strings.LastIndex(line, " on ")
+	if i < 0 {
+		return nil
+	}
+	t, err := time.ParseInLocation("Mon Jan _2 15:04:05 2006", strings.TrimSpace(line[i+4:]), time.Local)
+	if err != nil {
+		return nil
+	}
+	return &t
+}
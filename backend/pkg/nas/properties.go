@@ -0,0 +1,43 @@
+package nas
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	sizePattern        = regexp.MustCompile(`^(?i)[0-9]+(\.[0-9]+)?[KMGTPE]?B?$`)
+	compressionPattern = regexp.MustCompile(`^(on|off|lz4|lzjb|zle|gzip(-[1-9])?|zstd(-([1-9]|1[0-9]))?|zstd-fast(-[0-9]+)?)$`)
+	recordSizePattern  = regexp.MustCompile(`^(?i)([0-9]+)([KM]?)$`)
+)
+
+// ValidQuota reports whether s is a quota zfs accepts, a size like "10G" or "none".
+func ValidQuota(s string) bool {
+	return s == "none" || sizePattern.MatchString(s)
+}
+
+// ValidCompression reports whether s is a compression algorithm zfs accepts.
+func ValidCompression(s string) bool {
+	return compressionPattern.MatchString(s)
+}
+
+// ValidRecordSize reports whether s is a power of two between 512 bytes and
+// 16M, e.g. "128K".
+func ValidRecordSize(s string) bool {
+	match := recordSizePattern.FindStringSubmatch(s)
+	if match == nil {
+		return false
+	}
+	n, err := strconv.ParseInt(match[1], 10, 64)
+	if err != nil {
+		return false
+	}
+	switch strings.ToUpper(match[2]) {
+	case "K":
+		n <<= 10
+	case "M":
+		n <<= 20
+	}
+	return n >= 512 && n <= 16<<20 && n&(n-1) == 0
+}
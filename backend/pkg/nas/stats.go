@@ -0,0 +1,102 @@
+package nas
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// PoolStat holds the exact sizes of a pool, in bytes.
+type PoolStat struct {
+	Name      string
+	Size      uint64
+	Allocated uint64
+	Free      uint64
+	// Fragmentation in percent, -1 if unknown
+	Fragmentation float64
+	Health        string
+}
+
+// DatasetStat holds the exact sizes of a dataset, in bytes. Quota is 0 if
+// not set.
+type DatasetStat struct {
+	Name      string
+	Used      uint64
+	Available uint64
+	Quota     uint64
+}
+
+// ListPoolStats lists the pools with sizes in bytes, for monitoring.
+func ListPoolStats() ([]PoolStat, error) {
+	output, err := exec.Command(zpoolCommand(), "list", "-Hp", "-o", "name,size,alloc,free,frag,health").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pools: %w", err)
+	}
+
+	var pools []PoolStat
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 6 {
+			continue
+		}
+		frag, err := strconv.ParseFloat(strings.TrimSuffix(fields[4], "%"), 64)
+		if err != nil {
+			frag = -1
+		}
+		pools = append(pools, PoolStat{
+			Name:          fields[0],
+			Size:          parseBytes(fields[1]),
+			Allocated:     parseBytes(fields[2]),
+			Free:          parseBytes(fields[3]),
+			Fragmentation: frag,
+			Health:        fields[5],
+		})
+	}
+	return pools, nil
+}
+
+// ListDatasetStats lists the filesystems of a pool with sizes in bytes.
+func ListDatasetStats(pool string) ([]DatasetStat, error) {
+	output, err := exec.Command(zfsCommand(), "list", "-Hp", "-o", "name,used,avail,quota", "-t", "filesystem", "-r", pool).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list datasets: %w", err)
+	}
+
+	var datasets []DatasetStat
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 4 {
+			continue
+		}
+		datasets = append(datasets, DatasetStat{
+			Name:      fields[0],
+			Used:      parseBytes(fields[1]),
+			Available: parseBytes(fields[2]),
+			Quota:     parseBytes(fields[3]),
+		})
+	}
+	return datasets, nil
+}
+
+// CountSnapshots returns the number of snapshots of each dataset of a pool.
+func CountSnapshots(pool string) (map[string]int, error) {
+	output, err := exec.Command(zfsCommand(), "list", "-H", "-o", "name", "-t", "snapshot", "-r", pool).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snapshots: %w", err)
+	}
+
+	counts := map[string]int{}
+	for _, line := range strings.Split(string(output), "\n") {
+		if dataset, _, found := strings.Cut(strings.TrimSpace(line), "@"); found {
+			counts[dataset]++
+		}
+	}
+	return counts, nil
+}
+
+// parseBytes parses a value of zfs -p, "-" or "none" count as 0
+func parseBytes(value string) uint64 {
+	n, _ := strconv.ParseUint(value, 10, 64)
+	return n
+}
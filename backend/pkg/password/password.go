@@ -0,0 +1,214 @@
+package password
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"github.com/whyxn/easynas/backend/pkg/db"
+	"github.com/whyxn/easynas/backend/pkg/db/model"
+	"github.com/whyxn/easynas/backend/pkg/settings"
+	"github.com/whyxn/easynas/backend/pkg/util"
+	"gorm.io/gorm"
+	"math/big"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Defaults of the password policy, each one can be changed through settings
+const (
+	DefaultMinLength    = 8
+	DefaultHistoryCount = 5
+)
+
+var ErrPasswordReused = errors.New("password has been used recently, choose a different one")
+
+// Policy describes the requirements passwords of local users have to meet.
+type Policy struct {
+	MinLength     int
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+	// HistoryCount is the number of previous passwords that may not be reused
+	HistoryCount int
+}
+
+// CurrentPolicy returns the policy configured in settings.
+func CurrentPolicy() Policy {
+	return Policy{
+		MinLength:     settings.GetInt(settings.PasswordMinLength, DefaultMinLength),
+		RequireUpper:  settings.GetBool(settings.PasswordRequireUpper, false),
+		RequireLower:  settings.GetBool(settings.PasswordRequireLower, false),
+		RequireDigit:  settings.GetBool(settings.PasswordRequireDigit, false),
+		RequireSymbol: settings.GetBool(settings.PasswordRequireSymbol, false),
+		HistoryCount:  settings.GetInt(settings.PasswordHistoryCount, DefaultHistoryCount),
+	}
+}
+
+// Validate checks a password against the policy.
+func (p Policy) Validate(password string) error {
+	var missing []string
+
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			symbol = true
+		}
+	}
+
+	if len([]rune(password)) < p.MinLength {
+		missing = append(missing, fmt.Sprintf("at least %d characters", p.MinLength))
+	}
+	if p.RequireUpper && !upper {
+		missing = append(missing, "an uppercase letter")
+	}
+	if p.RequireLower && !lower {
+		missing = append(missing, "a lowercase letter")
+	}
+	if p.RequireDigit && !digit {
+		missing = append(missing, "a digit")
+	}
+	if p.RequireSymbol && !symbol {
+		missing = append(missing, "a symbol")
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("password must contain %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// Validate checks a password against the current policy.
+func Validate(password string) error {
+	return CurrentPolicy().Validate(password)
+}
+
+// CheckReuse returns ErrPasswordReused if the password is the user's current
+// password or one of the previous ones the policy remembers.
+func CheckReuse(user *model.User, password string) error {
+	if user.Password != "" && util.CheckPasswordHash(password, user.Password) {
+		return ErrPasswordReused
+	}
+
+	historyCount := CurrentPolicy().HistoryCount
+	if historyCount <= 0 {
+		return nil
+	}
+
+	var history []model.PasswordHistory
+	err := db.GetDb().Client().Where("user_id = ?", user.ID).Order("created_at desc").Limit(historyCount).Find(&history).Error
+	if err != nil {
+		return err
+	}
+	for _, h := range history {
+		if util.CheckPasswordHash(password, h.PasswordHash) {
+			return ErrPasswordReused
+		}
+	}
+	return nil
+}
+
+const (
+	generateLength  = 16
+	generateUpper   = "ABCDEFGHJKLMNPQRSTUVWXYZ"
+	generateLower   = "abcdefghijkmnopqrstuvwxyz"
+	generateDigits  = "23456789"
+	generateSymbols = "!#$%&*+-=?@_"
+)
+
+// Generate returns a random password that satisfies the current policy. It
+// is meant as a temporary password the user has to change on first login.
+func Generate() (string, error) {
+	length := CurrentPolicy().MinLength
+	if length < generateLength {
+		length = generateLength
+	}
+
+	// One character of every class, so any policy combination is met
+	classes := []string{generateUpper, generateLower, generateDigits, generateSymbols}
+	all := strings.Join(classes, "")
+
+	chars := make([]byte, 0, length)
+	for _, class := range classes {
+		c, err := randomChar(class)
+		if err != nil {
+			return "", err
+		}
+		chars = append(chars, c)
+	}
+	for len(chars) < length {
+		c, err := randomChar(all)
+		if err != nil {
+			return "", err
+		}
+		chars = append(chars, c)
+	}
+
+	// Shuffle so the class characters are not always in front
+	for i := len(chars) - 1; i > 0; i-- {
+		j, err := rand.Int(rand.Reader, big.NewInt(int64(i+1)))
+		if err != nil {
+			return "", err
+		}
+		chars[i], chars[j.Int64()] = chars[j.Int64()], chars[i]
+	}
+	return string(chars), nil
+}
+
+func randomChar(set string) (byte, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(len(set))))
+	if err != nil {
+		return 0, err
+	}
+	return set[n.Int64()], nil
+}
+
+// Hash returns the bcrypt hash of a password.
+func Hash(password string) (string, error) {
+	return util.HashPassword(password)
+}
+
+// Set stores a new password for the user and remembers the previous one.
+// mustChange forces the user to choose a new password on the next login.
+func Set(user *model.User, password string, mustChange bool) error {
+	hash, err := util.HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	historyCount := CurrentPolicy().HistoryCount
+
+	return db.GetDb().Client().Transaction(func(tx *gorm.DB) error {
+		if user.Password != "" {
+			if err := tx.Create(&model.PasswordHistory{UserId: user.ID, PasswordHash: user.Password, CreatedAt: time.Now()}).Error; err != nil {
+				return err
+			}
+		}
+
+		updates := map[string]interface{}{
+			"password":             hash,
+			"must_change_password": mustChange,
+		}
+		if err := tx.Model(user).Updates(updates).Error; err != nil {
+			return err
+		}
+
+		// Forget history entries the policy no longer needs
+		if historyCount <= 0 {
+			return tx.Where("user_id = ?", user.ID).Delete(&model.PasswordHistory{}).Error
+		}
+		var keep []uint
+		if err := tx.Model(&model.PasswordHistory{}).Where("user_id = ?", user.ID).Order("created_at desc").Limit(historyCount).Pluck("id", &keep).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ? AND id NOT IN ?", user.ID, keep).Delete(&model.PasswordHistory{}).Error
+	})
+}
@@ -0,0 +1,133 @@
+package password
+
+import (
+	"errors"
+	"github.com/whyxn/easynas/backend/pkg/db"
+	"github.com/whyxn/easynas/backend/pkg/db/model"
+	"github.com/whyxn/easynas/backend/pkg/log"
+	"github.com/whyxn/easynas/backend/pkg/settings"
+	"go.uber.org/zap"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// useTestDb connects the db package to a new, migrated database with the
+// settings loaded for the test
+func useTestDb(t *testing.T) {
+	t.Helper()
+	log.Logger = zap.NewNop().Sugar()
+	if err := db.Connect(filepath.Join(t.TempDir(), "easynas.db")); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := db.GetDb().RunMigrations(); err != nil {
+		t.Fatal(err)
+	}
+	if err := settings.Load(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPolicyValidate(t *testing.T) {
+	strict := Policy{MinLength: 10, RequireUpper: true, RequireLower: true, RequireDigit: true, RequireSymbol: true}
+	tests := []struct {
+		policy   Policy
+		password string
+		missing  []string
+	}{
+		{Policy{MinLength: 8}, "longenough", nil},
+		{Policy{MinLength: 8}, "short", []string{"at least 8 characters"}},
+		// characters are counted, not bytes
+		{Policy{MinLength: 8}, "äöüäöüäö", nil},
+		{strict, "Correct-Horse-7", nil},
+		{strict, "correct horse 7", []string{"an uppercase letter"}},
+		{strict, "CORRECT-HORSE-7", []string{"a lowercase letter"}},
+		{strict, "Correct-Horse", []string{"a digit"}},
+		{strict, "CorrectHorse7", []string{"a symbol"}},
+		{strict, "abc", []string{"at least 10 characters", "an uppercase letter", "a digit", "a symbol"}},
+	}
+	for _, tt := range tests {
+		err := tt.policy.Validate(tt.password)
+		if len(tt.missing) == 0 {
+			if err != nil {
+				t.Errorf("Validate(%q) error = %v", tt.password, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("Validate(%q) error = nil, want %v missing", tt.password, tt.missing)
+			continue
+		}
+		for _, m := range tt.missing {
+			if !strings.Contains(err.Error(), m) {
+				t.Errorf("Validate(%q) error = %v, want it to name %q", tt.password, err, m)
+			}
+		}
+	}
+}
+
+func TestGenerateMeetsPolicy(t *testing.T) {
+	useTestDb(t)
+	for _, key := range []string{settings.PasswordRequireUpper, settings.PasswordRequireLower, settings.PasswordRequireDigit, settings.PasswordRequireSymbol} {
+		if err := settings.SetBool(key, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := settings.SetInt(settings.PasswordMinLength, 20); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 20; i++ {
+		generated, err := Generate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = Validate(generated); err != nil {
+			t.Errorf("Generate() = %q, which fails the policy: %v", generated, err)
+		}
+	}
+}
+
+func TestCheckReuse(t *testing.T) {
+	useTestDb(t)
+	if err := settings.SetInt(settings.PasswordHistoryCount, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	user := &model.User{Name: "Alice", Email: "alice@example.com", Role: model.RoleUser}
+	if err := db.GetDb().Insert(user); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{"first-password", "second-password", "third-password", "fourth-password"} {
+		if err := Set(user, p, false); err != nil {
+			t.Fatal(err)
+		}
+		user, _ = db.Get[model.User](db.GetDb(), map[string]interface{}{"id": user.ID})
+	}
+
+	tests := []struct {
+		password string
+		reused   bool
+	}{
+		{"fourth-password", true},
+		{"third-password", true},
+		{"second-password", true},
+		// older than the history the policy keeps
+		{"first-password", false},
+		{"fifth-password", false},
+	}
+	for _, tt := range tests {
+		err := CheckReuse(user, tt.password)
+		if tt.reused && !errors.Is(err, ErrPasswordReused) {
+			t.Errorf("CheckReuse(%q) error = %v, want ErrPasswordReused", tt.password, err)
+		} else if !tt.reused && err != nil {
+			t.Errorf("CheckReuse(%q) error = %v, want nil", tt.password, err)
+		}
+	}
+
+	history, _ := db.GetList[model.PasswordHistory](db.GetDb(), map[string]interface{}{"user_id": user.ID})
+	if len(history) != 2 {
+		t.Errorf("password history has %d entries, want the 2 the policy keeps", len(history))
+	}
+}
@@ -0,0 +1,234 @@
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/whyxn/easynas/backend/pkg/audit"
+	"github.com/whyxn/easynas/backend/pkg/context"
+	"github.com/whyxn/easynas/backend/pkg/db/model"
+	"github.com/whyxn/easynas/backend/pkg/log"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// maxAuditBodySize is the largest request body recorded with an event
+	maxAuditBodySize = 64 << 10
+	// maxAuditResponseSize is the part of the response kept to find the error message
+	maxAuditResponseSize = 4 << 10
+)
+
+// auditResponseWriter keeps the beginning of the response body
+type auditResponseWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *auditResponseWriter) Write(b []byte) (int, error) {
+	w.capture(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *auditResponseWriter) WriteString(s string) (int, error) {
+	w.capture([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *auditResponseWriter) capture(b []byte) {
+	if remaining := maxAuditResponseSize - w.body.Len(); remaining > 0 {
+		if len(b) > remaining {
+			b = b[:remaining]
+		}
+		w.body.Write(b)
+	}
+}
+
+// AuditMiddleware records an audit event for every mutating request,
+// including the ones rejected by authentication.
+func AuditMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			c.Next()
+			return
+		}
+
+		start := time.Now()
+		body := captureRequestBody(c)
+
+		writer := &auditResponseWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+
+		c.Next()
+
+		event := buildAuditEvent(c, body, writer.body.Bytes())
+		event.DurationMs = time.Since(start).Milliseconds()
+		if err := audit.Record(event); err != nil {
+			log.Logger.Errorw("Failed to record audit event", "action", event.Action, "err", err)
+		}
+	}
+}
+
+// captureRequestBody reads the beginning of the request body and puts it
+// back for the handler. File uploads are not kept.
+func captureRequestBody(c *gin.Context) []byte {
+	contentType := c.ContentType()
+	if c.Request.Body == nil || strings.HasPrefix(contentType, "multipart/") || contentType == "application/octet-stream" {
+		return nil
+	}
+
+	captured, err := io.ReadAll(io.LimitReader(c.Request.Body, maxAuditBodySize+1))
+	c.Request.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(captured), c.Request.Body), c.Request.Body}
+	if err != nil || len(captured) > maxAuditBodySize {
+		return nil
+	}
+	return captured
+}
+
+func buildAuditEvent(c *gin.Context, body, response []byte) *model.AuditEvent {
+	event := &model.AuditEvent{
+		SourceIP: c.ClientIP(),
+		Method:   c.Request.Method,
+		Path:     c.Request.URL.Path,
+		Status:   c.Writer.Status(),
+		Result:   model.AuditResultSuccess,
+	}
+
+	if route := c.FullPath(); route != "" {
+		event.Action = c.Request.Method + " " + route
+	} else {
+		event.Action = c.Request.Method + " " + c.Request.URL.Path
+	}
+
+	if requester := context.GetRequesterFromContext(c); requester != nil {
+		event.ActorId = requester.ID
+		event.ActorEmail = requester.Email
+	}
+	if token := context.GetApiTokenFromContext(c); token != nil {
+		event.ApiTokenId = token.ID
+	}
+
+	var decodedBody interface{}
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &decodedBody); err != nil {
+			decodedBody = nil
+		}
+	}
+	bodyFields, _ := decodedBody.(map[string]interface{})
+
+	// Targets come from the route first and from the request body otherwise
+	event.Pool = firstNonEmpty(c.Param("pool"), stringField(bodyFields, "pool"))
+	event.Dataset = firstNonEmpty(c.Param("dataset"), stringField(bodyFields, "datasetName"), stringField(bodyFields, "dataset"))
+	event.Snapshot = firstNonEmpty(c.Param("snapshotName"), stringField(bodyFields, "snapshotName"))
+	if strings.Contains(c.FullPath(), "/nfs-share") {
+		event.Share = strings.Trim(event.Pool+"/"+event.Dataset, "/")
+	}
+	if strings.HasPrefix(c.FullPath(), "/api/v1/users/:id") {
+		if id, err := strconv.ParseUint(c.Param("id"), 10, 64); err == nil {
+			event.TargetUserId = uint(id)
+		}
+	} else if id, ok := bodyFields["userId"].(float64); ok {
+		event.TargetUserId = uint(id)
+	}
+
+	// the settings of an alert channel hold its credentials, like the URL
+	// of a Slack webhook or the headers of a webhook
+	if strings.HasPrefix(c.FullPath(), "/api/v1/alerts/channels") {
+		audit.RedactField(bodyFields, "config")
+	}
+
+	parameters := map[string]interface{}{}
+	if len(c.Params) > 0 {
+		params := map[string]string{}
+		for _, p := range c.Params {
+			params[p.Key] = p.Value
+		}
+		parameters["params"] = params
+	}
+	if query := c.Request.URL.Query(); len(query) > 0 {
+		parameters["query"] = audit.Redact(queryToMap(query))
+	}
+	if decodedBody != nil {
+		parameters["body"] = audit.Redact(decodedBody)
+	} else if c.Request.ContentLength > 0 {
+		parameters["body"] = fmt.Sprintf("%s, %d bytes", c.ContentType(), c.Request.ContentLength)
+	}
+	if encoded, err := json.Marshal(parameters); err == nil {
+		event.Parameters = string(encoded)
+	}
+
+	if event.Status == http.StatusAccepted {
+		event.Result = model.AuditResultAccepted
+		event.JobId = responseJobId(response)
+	} else if event.Status >= http.StatusBadRequest {
+		event.Result = model.AuditResultFailure
+		event.Error = responseError(response)
+		if event.Error == "" {
+			event.Error = http.StatusText(event.Status)
+		}
+	} else if len(c.Errors) > 0 {
+		event.Error = c.Errors.String()
+	}
+	return event
+}
+
+// responseError finds the error message in a JSON error response
+func responseError(response []byte) string {
+	var fields map[string]interface{}
+	if err := json.Unmarshal(response, &fields); err != nil {
+		return ""
+	}
+	for _, key := range []string{"msg", "error"} {
+		if s, ok := fields[key].(string); ok && s != "" {
+			return s
+		}
+	}
+	return ""
+}
+
+// responseJobId finds the ID of the job in the response of an accepted request
+func responseJobId(response []byte) uint {
+	var fields struct {
+		Data struct {
+			ID uint `json:"id"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(response, &fields); err != nil {
+		return 0
+	}
+	return fields.Data.ID
+}
+
+func queryToMap(query map[string][]string) map[string]interface{} {
+	m := make(map[string]interface{}, len(query))
+	for key, values := range query {
+		if len(values) == 1 {
+			m[key] = values[0]
+		} else {
+			m[key] = values
+		}
+	}
+	return m
+}
+
+func stringField(fields map[string]interface{}, key string) string {
+	s, _ := fields[key].(string)
+	return s
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -1,22 +1,67 @@
 package router
 
 import (
+	"fmt"
 	"github.com/gin-gonic/gin"
+	"github.com/whyxn/easynas/backend/pkg/apitoken"
 	"github.com/whyxn/easynas/backend/pkg/context"
 	"github.com/whyxn/easynas/backend/pkg/jwt"
 	"github.com/whyxn/easynas/backend/pkg/log"
+	"github.com/whyxn/easynas/backend/pkg/session"
+	"net/http"
+	"strings"
 )
 
+// metricsPath checks its own bearer token
+const metricsPath = "/metrics"
+
+// accessTokenParam is the query parameter of the access token
+const accessTokenParam = "access_token"
+
+// queryTokenPaths also accept the access token as a query parameter, as
+// browsers cannot set headers on an EventSource, a link or a media element.
+// Only the short-lived token of a session is accepted there, URLs end up in
+// logs and the browser history.
+var queryTokenPaths = map[string]bool{
+	"/api/v1/events": true,
+	"/api/v1/nas/pools/:pool/datasets/:dataset/files/:path/download": true,
+	"/api/v1/nas/pools/:pool/datasets/:dataset/archive":              true,
+}
+
 func TokenAuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
+		if c.FullPath() == metricsPath {
+			c.Next()
+			return
+		}
+
+		accessToken := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		fromQuery := false
+		if accessToken == "" && queryTokenPaths[c.FullPath()] {
+			accessToken = c.Query(accessTokenParam)
+			fromQuery = accessToken != ""
+		}
 
-		if accessToken := c.GetHeader("Authorization"); len(accessToken) > 0 {
-			claims, err := jwt.ValidateJWT(accessToken)
-			if err != nil {
+		if len(accessToken) > 0 {
+			if apitoken.IsApiToken(accessToken) {
+				if fromQuery {
+					c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+						"status": "error",
+						"msg":    "api tokens must be sent in the Authorization header",
+					})
+					return
+				}
+				if !authenticateApiToken(c, accessToken) {
+					return
+				}
+			} else if claims, err := jwt.ValidateJWT(accessToken); err != nil {
 				log.Logger.Warnw("Failed to validate JWT token", "err", err.Error())
+			} else if s, err := session.Validate(claims.SessionId); err != nil {
+				log.Logger.Warnw("Rejected JWT token of inactive session", "session", claims.SessionId, "err", err.Error())
 			} else {
 				context.AddAccessTokenToContext(c, accessToken)
-				context.AddRequesterToContext(c, &claims.User)
+				context.AddSessionToContext(c, s)
+				context.AddRequesterToContext(c, &s.User)
 			}
 		} else {
 			// Access Token not found in request header
@@ -27,3 +72,34 @@ func TokenAuthMiddleware() gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// authenticateApiToken resolves a personal API token and checks that it was
+// granted the scope the requested endpoint needs. Returns false if the
+// request has been aborted.
+func authenticateApiToken(c *gin.Context, plain string) bool {
+	token, err := apitoken.Authenticate(plain)
+	if err != nil {
+		log.Logger.Warnw("Failed to validate API token", "err", err.Error())
+		return true
+	}
+
+	scope, allowed := apitoken.RequiredScope(c.Request.Method, c.Request.URL.Path)
+	if !allowed {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+			"status": "error",
+			"msg":    "this endpoint can not be accessed with an api token",
+		})
+		return false
+	}
+	if scope != "" && !apitoken.HasScope(token, scope) {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+			"status": "error",
+			"msg":    fmt.Sprintf("api token lacks required scope '%s'", scope),
+		})
+		return false
+	}
+
+	context.AddApiTokenToContext(c, token)
+	context.AddRequesterToContext(c, &token.User)
+	return true
+}
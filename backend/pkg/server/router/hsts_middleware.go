@@ -0,0 +1,24 @@
+package router
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/whyxn/easynas/backend/pkg/config"
+	"strconv"
+	"time"
+)
+
+// HstsMiddleware tells browsers to use HTTPS only. The header is ignored on
+// plain HTTP, so it is only sent over TLS.
+func HstsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tlsConfig := config.Get().Server.TLS
+		if c.Request.TLS != nil && tlsConfig.HstsMaxAge > 0 {
+			value := "max-age=" + strconv.FormatInt(int64(time.Duration(tlsConfig.HstsMaxAge)/time.Second), 10)
+			if tlsConfig.HstsIncludeSubdomains {
+				value += "; includeSubDomains"
+			}
+			c.Header("Strict-Transport-Security", value)
+		}
+		c.Next()
+	}
+}
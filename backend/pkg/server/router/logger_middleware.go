@@ -0,0 +1,55 @@
+package router
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// LoggerMiddleware logs requests like gin.Logger, with the access token
+// taken out of the query string.
+func LoggerMiddleware() gin.HandlerFunc {
+	return gin.LoggerWithConfig(gin.LoggerConfig{
+		Formatter: func(param gin.LogFormatterParams) string {
+			var statusColor, methodColor, resetColor string
+			if param.IsOutputColor() {
+				statusColor = param.StatusCodeColor()
+				methodColor = param.MethodColor()
+				resetColor = param.ResetColor()
+			}
+
+			if param.Latency > time.Minute {
+				param.Latency = param.Latency.Truncate(time.Second)
+			}
+			return fmt.Sprintf("[GIN] %v |%s %3d %s| %13v | %15s |%s %-7s %s %#v\n%s",
+				param.TimeStamp.Format("2006/01/02 - 15:04:05"),
+				statusColor, param.StatusCode, resetColor,
+				param.Latency,
+				param.ClientIP,
+				methodColor, param.Method, resetColor,
+				redactQueryToken(param.Path),
+				param.ErrorMessage,
+			)
+		},
+	})
+}
+
+// redactQueryToken replaces the value of the access token in the query
+// string of a path, keeping the other parameters as they were sent
+func redactQueryToken(path string) string {
+	path, query, found := strings.Cut(path, "?")
+	if !found {
+		return path
+	}
+
+	params := strings.Split(query, "&")
+	for i, param := range params {
+		key, _, _ := strings.Cut(param, "=")
+		if name, err := url.QueryUnescape(key); err == nil && name == accessTokenParam {
+			params[i] = key + "=[redacted]"
+		}
+	}
+	return path + "?" + strings.Join(params, "&")
+}
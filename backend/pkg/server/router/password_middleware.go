@@ -0,0 +1,42 @@
+package router
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/whyxn/easynas/backend/pkg/context"
+	"net/http"
+	"strings"
+)
+
+// pathsAllowedWithoutPasswordChange can be reached by users that have to
+// change their password first
+var pathsAllowedWithoutPasswordChange = []string{
+	"/health",
+	"/api/v1/auth/login",
+	"/api/v1/auth/refresh",
+	"/api/v1/auth/logout",
+	"/api/v1/users/me/password",
+}
+
+// PasswordChangeMiddleware blocks users that have to change their password,
+// e.g. after an admin reset it, until they have done so
+func PasswordChangeMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requester := context.GetRequesterFromContext(c)
+		if requester == nil || !requester.MustChangePassword {
+			c.Next()
+			return
+		}
+
+		for _, p := range pathsAllowedWithoutPasswordChange {
+			if strings.HasPrefix(c.Request.URL.Path, p) {
+				c.Next()
+				return
+			}
+		}
+
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+			"status": "error",
+			"msg":    "password must be changed before continuing",
+		})
+	}
+}
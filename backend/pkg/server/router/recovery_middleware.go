@@ -0,0 +1,35 @@
+package router
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/whyxn/easynas/backend/pkg/log"
+	"net/http"
+	"runtime/debug"
+)
+
+// RecoveryMiddleware answers requests whose handler panicked with 500.
+// http.ErrAbortHandler is passed on to net/http instead, handlers panic with
+// it to abort a response they already started, like a streamed archive.
+// net/http then resets the stream on HTTP/2 or closes the connection on
+// HTTP/1.1, so the client does not take the response for complete. gin's
+// own recovery would end the response normally.
+func RecoveryMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+			log.Logger.Errorw("Recovered from panic", "path", c.Request.URL.Path, "err", err, "stack", string(debug.Stack()))
+			if c.Writer.Written() {
+				c.Abort()
+			} else {
+				c.AbortWithStatus(http.StatusInternalServerError)
+			}
+		}()
+		c.Next()
+	}
+}
@@ -8,16 +8,83 @@ import (
 func AddApiRoutes(httpRg *gin.RouterGroup) {
 	httpRg.GET("health", v1.HealthController().Check)
 	httpRg.GET("health/secured", v1.HealthController().SecuredCheck)
+	httpRg.GET("health/ready", v1.HealthController().Ready)
+	httpRg.GET("metrics", v1.MetricsController().Prometheus)
 
 	httpRg.POST("api/v1/auth/login", v1.AuthController().Login)
+	httpRg.POST("api/v1/auth/login/2fa", v1.AuthController().LoginTwoFactor)
+	httpRg.GET("api/v1/auth/providers", v1.AuthController().GetProviders)
+	httpRg.GET("api/v1/auth/oidc/login", v1.AuthController().OidcLogin)
+	httpRg.GET("api/v1/auth/oidc/callback", v1.AuthController().OidcCallback)
+	httpRg.POST("api/v1/auth/refresh", v1.AuthController().Refresh)
+	httpRg.POST("api/v1/auth/logout", v1.AuthController().Logout)
+
+	httpRg.GET("api/v1/auth/lockouts", v1.LockoutController().GetList)
+	httpRg.DELETE("api/v1/auth/lockouts/:id", v1.LockoutController().Clear)
+	httpRg.GET("api/v1/auth/login-attempts", v1.LockoutController().GetLoginAttempts)
+
+	httpRg.GET("api/v1/users/me", v1.UserController().GetMe)
+	httpRg.PUT("api/v1/users/me/password", v1.UserController().ChangeOwnPassword)
+	httpRg.PUT("api/v1/users/:id/password", v1.UserController().ResetPassword)
+
+	httpRg.GET("api/v1/users/me/sessions", v1.SessionController().GetList)
+	httpRg.DELETE("api/v1/users/me/sessions/:id", v1.SessionController().Revoke)
+	httpRg.DELETE("api/v1/users/:id/sessions", v1.SessionController().RevokeAllOfUser)
+
+	httpRg.POST("api/v1/users/me/tokens", v1.ApiTokenController().Create)
+	httpRg.GET("api/v1/users/me/tokens", v1.ApiTokenController().GetList)
+	httpRg.DELETE("api/v1/users/me/tokens/:id", v1.ApiTokenController().Delete)
+
+	httpRg.GET("api/v1/users/me/2fa", v1.TwoFactorController().GetStatus)
+	httpRg.POST("api/v1/users/me/2fa/enroll", v1.TwoFactorController().Enroll)
+	httpRg.POST("api/v1/users/me/2fa/confirm", v1.TwoFactorController().Confirm)
+	httpRg.POST("api/v1/users/me/2fa/disable", v1.TwoFactorController().Disable)
+	httpRg.POST("api/v1/users/me/2fa/recovery-codes", v1.TwoFactorController().RegenerateRecoveryCodes)
+	httpRg.DELETE("api/v1/users/:id/2fa", v1.TwoFactorController().ResetForUser)
+
+	httpRg.GET("api/v1/audit", v1.AuditController().GetList)
+	httpRg.GET("api/v1/audit/export", v1.AuditController().Export)
+
+	httpRg.GET("api/v1/config", v1.ConfigController().Get)
+
+	httpRg.GET("api/v1/events", v1.EventController().Stream)
+
+	httpRg.GET("api/v1/alerts", v1.AlertController().GetList)
+	httpRg.POST("api/v1/alerts/:id/acknowledge", v1.AlertController().Acknowledge)
+	httpRg.GET("api/v1/alerts/rules", v1.AlertController().GetRuleList)
+	httpRg.POST("api/v1/alerts/rules", v1.AlertController().CreateRule)
+	httpRg.PUT("api/v1/alerts/rules/:id", v1.AlertController().UpdateRule)
+	httpRg.DELETE("api/v1/alerts/rules/:id", v1.AlertController().DeleteRule)
+	httpRg.GET("api/v1/alerts/channels", v1.AlertController().GetChannelList)
+	httpRg.POST("api/v1/alerts/channels", v1.AlertController().CreateChannel)
+	httpRg.PUT("api/v1/alerts/channels/:id", v1.AlertController().UpdateChannel)
+	httpRg.DELETE("api/v1/alerts/channels/:id", v1.AlertController().DeleteChannel)
+	httpRg.POST("api/v1/alerts/channels/:id/test", v1.AlertController().TestChannel)
+
+	httpRg.GET("api/v1/jobs", v1.JobController().GetList)
+	httpRg.GET("api/v1/jobs/:id", v1.JobController().Get)
+	httpRg.POST("api/v1/jobs/:id/cancel", v1.JobController().Cancel)
+	httpRg.GET("api/v1/server/certificate", v1.CertificateController().Get)
+	httpRg.PUT("api/v1/server/certificate", v1.CertificateController().Upload)
+
+	httpRg.GET("api/v1/settings/security", v1.SettingsController().GetSecuritySettings)
+	httpRg.PUT("api/v1/settings/security", v1.SettingsController().UpdateSecuritySettings)
 
 	httpRg.POST("api/v1/users", v1.UserController().Create)
+	httpRg.POST("api/v1/users/import", v1.UserController().Import)
+	httpRg.GET("api/v1/users/export", v1.UserController().Export)
 	httpRg.GET("api/v1/users/:id", v1.UserController().Get)
 	httpRg.GET("api/v1/users", v1.UserController().GetList)
+	httpRg.PATCH("api/v1/users/:id", v1.UserController().Update)
 	httpRg.DELETE("api/v1/users/:id", v1.UserController().Delete)
 
 	httpRg.GET("api/v1/nas/pools/main", v1.NasController().GetPool)
 	httpRg.GET("api/v1/nas/pools", v1.NasController().GetPoolList)
+	httpRg.GET("api/v1/nas/pools/:pool", v1.NasController().GetPool)
+	httpRg.GET("api/v1/nas/pools/:pool/settings", v1.NasController().GetPoolSettings)
+	httpRg.PUT("api/v1/nas/pools/:pool/settings", v1.NasController().UpdatePoolSettings)
+	httpRg.GET("api/v1/nas/forecast", v1.ForecastController().GetForecasts)
+	httpRg.GET("api/v1/nas/pools/:pool/forecast", v1.ForecastController().GetForecasts)
 
 	httpRg.GET("api/v1/nas/pools/:pool/datasets/:dataset", v1.NasController().GetDataset)
 	httpRg.GET("api/v1/nas/pools/:pool/datasets", v1.NasController().GetDatasetList)
@@ -33,11 +100,22 @@ func AddApiRoutes(httpRg *gin.RouterGroup) {
 	httpRg.GET("api/v1/nas/pools/:pool/datasets/:dataset/files/:path", v1.NasController().GetDatasetFileSystem)
 	httpRg.POST("api/v1/nas/pools/:pool/datasets/:dataset/files/:path", v1.NasController().UploadFileToDataset)
 	httpRg.DELETE("api/v1/nas/pools/:pool/datasets/:dataset/files/:path", v1.NasController().DeleteFileFromDataset)
+	httpRg.GET("api/v1/nas/pools/:pool/datasets/:dataset/files/:path/download", v1.NasController().DownloadFileFromDataset)
+	httpRg.HEAD("api/v1/nas/pools/:pool/datasets/:dataset/files/:path/download", v1.NasController().DownloadFileFromDataset)
+	httpRg.GET("api/v1/nas/pools/:pool/datasets/:dataset/archive", v1.NasController().DownloadArchive)
 
 	httpRg.GET("api/v1/nas/pools/:pool/datasets/:dataset/snapshots", v1.NasController().GetSnapshotList)
 	httpRg.POST("api/v1/nas/pools/:pool/datasets/:dataset/snapshots", v1.NasController().CreateSnapshot)
 	httpRg.POST("api/v1/nas/pools/:pool/datasets/:dataset/snapshots/restore", v1.NasController().RestoreFromSnapshot)
 	httpRg.DELETE("api/v1/nas/pools/:pool/datasets/:dataset/snapshots/:snapshotName", v1.NasController().DeleteSnapshot)
 
+	httpRg.GET("api/v1/disks", v1.DiskController().GetList)
+	httpRg.GET("api/v1/disks/:name/smart", v1.DiskController().GetSmart)
+	httpRg.POST("api/v1/disks/:name/selftest", v1.DiskController().StartSelfTest)
+	httpRg.POST("api/v1/disks/:name/smart/reset-baseline", v1.DiskController().ResetBaseline)
+
 	httpRg.GET("api/v1/metrics/system", v1.MetricsController().GetSystemMetrics)
+	httpRg.GET("api/v1/metrics/io", v1.MetricsController().GetIOMetrics)
+	httpRg.GET("api/v1/metrics/arc", v1.MetricsController().GetArcStats)
+	httpRg.GET("api/v1/metrics/history", v1.MetricsController().GetHistory)
 }
@@ -0,0 +1,32 @@
+package router
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/whyxn/easynas/backend/pkg/lifecycle"
+	"net/http"
+)
+
+// ShutdownMiddleware refuses mutating requests once shutdown has started,
+// so nothing new is begun that the drain timeout could cut short. Reads
+// are still served until the listener closes.
+func ShutdownMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !lifecycle.ShuttingDown() {
+			c.Next()
+			return
+		}
+
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			c.Next()
+			return
+		}
+
+		c.Header("Connection", "close")
+		c.Header("Retry-After", "30")
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+			"status": "error",
+			"msg":    "server is shutting down",
+		})
+	}
+}
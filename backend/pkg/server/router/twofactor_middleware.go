@@ -0,0 +1,43 @@
+package router
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/whyxn/easynas/backend/pkg/context"
+	"github.com/whyxn/easynas/backend/pkg/twofactor"
+	"net/http"
+	"strings"
+)
+
+// pathsAllowedWithoutTwoFactor can be reached by users that still have to
+// enroll in two-factor authentication
+var pathsAllowedWithoutTwoFactor = []string{
+	"/health",
+	"/api/v1/auth/login",
+	"/api/v1/auth/refresh",
+	"/api/v1/auth/logout",
+	"/api/v1/users/me/2fa",
+}
+
+// TwoFactorPolicyMiddleware blocks users the policy requires to use
+// two-factor authentication until they have enrolled
+func TwoFactorPolicyMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requester := context.GetRequesterFromContext(c)
+		if requester == nil || requester.TotpEnabled || !twofactor.Required(requester) {
+			c.Next()
+			return
+		}
+
+		for _, p := range pathsAllowedWithoutTwoFactor {
+			if strings.HasPrefix(c.Request.URL.Path, p) {
+				c.Next()
+				return
+			}
+		}
+
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+			"status": "error",
+			"msg":    "two-factor authentication must be enabled for your account",
+		})
+	}
+}
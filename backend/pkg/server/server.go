@@ -1,23 +1,52 @@
 package server
 
 import (
-	"fmt"
+	"context"
+	"crypto/tls"
+	"github.com/whyxn/easynas/backend/pkg/config"
+	"github.com/whyxn/easynas/backend/pkg/lifecycle"
 	"github.com/whyxn/easynas/backend/pkg/log"
 	"github.com/whyxn/easynas/backend/pkg/server/router"
+	"github.com/whyxn/easynas/backend/pkg/tlscert"
+	"github.com/whyxn/easynas/backend/pkg/util"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 )
 
-func Start() {
-	r := gin.Default()
+// drainGrace is how long cancelled background jobs get to stop
+const drainGrace = 5 * time.Second
 
+// Start serves the API until SIGINT or SIGTERM, then drains in-flight
+// requests and background jobs. A second signal exits immediately.
+func Start() error {
+	cfg := config.Get()
+
+	r := gin.New()
+
+	r.Use(router.LoggerMiddleware())
+	r.Use(router.RecoveryMiddleware())
+	r.Use(router.MetricsMiddleware())
+	r.Use(router.HstsMiddleware())
+	r.Use(router.ShutdownMiddleware())
+	r.Use(router.AuditMiddleware())
 	r.Use(router.TokenAuthMiddleware())
+	r.Use(router.TwoFactorPolicyMiddleware())
+	r.Use(router.PasswordChangeMiddleware())
 
 	// Setup CORS Config
 	corsConfig := cors.DefaultConfig()
-	corsConfig.AllowAllOrigins = true
+	if origins := cfg.Server.CorsOrigins; len(origins) == 0 || util.Contains(origins, "*") {
+		corsConfig.AllowAllOrigins = true
+	} else {
+		corsConfig.AllowOrigins = origins
+	}
 	corsConfig.AllowCredentials = true
 	corsConfig.MaxAge = time.Second * 3600
 	corsConfig.AllowMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE"}
@@ -33,9 +62,90 @@ func Start() {
 	// Setting up all Http Routes
 	router.AddApiRoutes(httpRouter)
 
-	log.Logger.Infof("Starting Web Server in port %s", "8080")
-	err := r.Run(fmt.Sprintf(":%s", "8080")) // listen and serve on 0.0.0.0:PORT
-	if err != nil {
-		log.Logger.Errorw("Failed to start Web Server", "err", err.Error())
+	srv := &http.Server{
+		Addr:    cfg.Server.Listen,
+		Handler: r.Handler(),
+	}
+	servers := []*http.Server{srv}
+
+	serveErr := make(chan error, 1)
+	if manager := tlscert.Current(); manager != nil {
+		srv.TLSConfig = &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: manager.GetCertificate,
+		}
+		if cfg.Server.TLS.RedirectListen != "" {
+			servers = append(servers, redirectToHttps(cfg.Server.TLS.RedirectListen, cfg.Server.Listen))
+		}
+
+		log.Logger.Infof("Starting Web Server on %s with HTTPS", cfg.Server.Listen)
+		// the certificate comes from the TLS config
+		go func() { serveErr <- srv.ListenAndServeTLS("", "") }()
+	} else {
+		log.Logger.Infof("Starting Web Server on %s", cfg.Server.Listen)
+		go func() { serveErr <- srv.ListenAndServe() }()
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	select {
+	case err := <-serveErr:
+		return err
+	case sig := <-signals:
+		log.Logger.Infow("Shutting down", "signal", sig.String(), "timeout", cfg.Server.ShutdownTimeout.String())
+	}
+
+	lifecycle.BeginShutdown()
+	go func() {
+		sig := <-signals
+		log.Logger.Warnw("Forced shutdown, in-flight work is lost", "signal", sig.String())
+		os.Exit(1)
+	}()
+
+	// give load balancers time to notice the failing readiness check
+	time.Sleep(time.Duration(cfg.Server.ShutdownDelay))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.Server.ShutdownTimeout))
+	defer cancel()
+
+	for _, s := range servers {
+		if err := s.Shutdown(ctx); err != nil {
+			log.Logger.Warnw("Requests still in flight at shutdown timeout, closing connections", "addr", s.Addr, "err", err)
+			s.Close()
+		}
+	}
+	if !lifecycle.Drain(ctx, drainGrace) {
+		log.Logger.Warn("Background jobs did not stop in time")
+	}
+	log.Logger.Info("Web Server stopped")
+	return nil
+}
+
+// redirectToHttps serves plain HTTP on listen and sends every request to the
+// same host and path on the HTTPS address
+func redirectToHttps(listen, httpsListen string) *http.Server {
+	_, httpsPort, _ := net.SplitHostPort(httpsListen)
+
+	srv := &http.Server{
+		Addr: listen,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			host := r.Host
+			if h, _, err := net.SplitHostPort(r.Host); err == nil {
+				host = h
+			}
+			if httpsPort != "443" {
+				host = net.JoinHostPort(host, httpsPort)
+			}
+			http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+		}),
 	}
+
+	log.Logger.Infof("Redirecting HTTP on %s to HTTPS", listen)
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Logger.Errorw("Failed to start HTTP redirect", "err", err.Error())
+		}
+	}()
+	return srv
 }
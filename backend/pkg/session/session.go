@@ -0,0 +1,177 @@
+package session
+
+import (
+	"errors"
+	"github.com/whyxn/easynas/backend/pkg/db"
+	"github.com/whyxn/easynas/backend/pkg/db/model"
+	"github.com/whyxn/easynas/backend/pkg/util"
+	"gorm.io/gorm"
+	"time"
+)
+
+// RefreshTokenTTL is how long a refresh token stays valid without being used.
+const RefreshTokenTTL = 30 * 24 * time.Hour
+
+var (
+	ErrSessionNotFound = errors.New("session not found")
+	ErrSessionRevoked  = errors.New("session has been revoked")
+	ErrSessionExpired  = errors.New("session has expired")
+	ErrUserDisabled    = errors.New("user is disabled")
+	// ErrTokenReused is returned for a refresh token that was already
+	// exchanged. The session it belonged to is revoked.
+	ErrTokenReused = errors.New("refresh token has already been used")
+)
+
+// Create starts a new session for the user and returns it together with the
+// plain refresh token. Only the hash of the refresh token is persisted.
+func Create(user *model.User, device, userAgent, clientIP string) (*model.Session, string, error) {
+	refreshToken, err := util.GenerateRandomToken(32)
+	if err != nil {
+		return nil, "", err
+	}
+
+	now := time.Now()
+	if device == "" {
+		device = userAgent
+	}
+
+	s := &model.Session{
+		UserId:           user.ID,
+		RefreshTokenHash: util.HashToken(refreshToken),
+		Device:           device,
+		UserAgent:        userAgent,
+		ClientIP:         clientIP,
+		CreatedAt:        now,
+		LastUsedAt:       now,
+		ExpiresAt:        now.Add(RefreshTokenTTL),
+	}
+	if err = db.GetDb().Insert(s); err != nil {
+		return nil, "", err
+	}
+	s.User = *user
+	return s, refreshToken, nil
+}
+
+// Rotate exchanges a refresh token for a new one. The presented token stops
+// being valid as soon as the new one is issued. A token that was already
+// exchanged, by an earlier or a concurrent refresh, revokes its session, as
+// either the token was stolen or the session can no longer tell its owner
+// from someone else.
+func Rotate(refreshToken, userAgent, clientIP string) (*model.Session, string, error) {
+	hash := util.HashToken(refreshToken)
+	s, err := db.Get[model.Session](db.GetDb(), map[string]interface{}{"refresh_token_hash": hash}, "User")
+	if err != nil {
+		if revokeReused(hash) {
+			return nil, "", ErrTokenReused
+		}
+		return nil, "", ErrSessionNotFound
+	}
+	if err = checkActive(s); err != nil {
+		return nil, "", err
+	}
+
+	newRefreshToken, err := util.GenerateRandomToken(32)
+	if err != nil {
+		return nil, "", err
+	}
+
+	now := time.Now()
+	updates := map[string]interface{}{
+		"refresh_token_hash": util.HashToken(newRefreshToken),
+		"user_agent":         userAgent,
+		"client_ip":          clientIP,
+		"last_used_at":       now,
+		"expires_at":         now.Add(RefreshTokenTTL),
+	}
+	rotated := false
+	err = db.GetDb().Client().Transaction(func(tx *gorm.DB) error {
+		// only one of concurrent refreshes with the same token finds it
+		result := tx.Model(&model.Session{}).
+			Where("id = ? AND refresh_token_hash = ? AND revoked_at IS NULL", s.ID, hash).
+			Updates(updates)
+		if result.Error != nil || result.RowsAffected != 1 {
+			return result.Error
+		}
+		rotated = true
+		return tx.Create(&model.RetiredRefreshToken{Hash: hash, SessionId: s.ID, RetiredAt: now}).Error
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	if !rotated {
+		if err = Revoke(s.ID); err != nil {
+			return nil, "", err
+		}
+		return nil, "", ErrTokenReused
+	}
+
+	// retired tokens older than a session can live without a refresh are no
+	// longer worth recognizing
+	db.GetDb().Client().Where("retired_at < ?", now.Add(-RefreshTokenTTL)).Delete(&model.RetiredRefreshToken{})
+	return s, newRefreshToken, nil
+}
+
+// revokeReused revokes the session a retired refresh token belonged to and
+// reports whether there was one
+func revokeReused(hash string) bool {
+	retired, err := db.Get[model.RetiredRefreshToken](db.GetDb(), map[string]interface{}{"hash": hash})
+	if err != nil {
+		return false
+	}
+	if err = Revoke(retired.SessionId); err != nil {
+		return false
+	}
+	return true
+}
+
+// Validate returns the active session with the given id along with its user.
+func Validate(id uint) (*model.Session, error) {
+	s, err := db.Get[model.Session](db.GetDb(), map[string]interface{}{"id": id}, "User")
+	if err != nil {
+		return nil, ErrSessionNotFound
+	}
+	if err = checkActive(s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// ListActive returns all non revoked, non expired sessions of a user.
+func ListActive(userId uint) ([]model.Session, error) {
+	var sessions []model.Session
+	err := db.GetDb().Client().
+		Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userId, time.Now()).
+		Order("last_used_at desc").
+		Find(&sessions).Error
+	return sessions, err
+}
+
+// Revoke revokes a single session.
+func Revoke(id uint) error {
+	return db.GetDb().UpdateWhere(&model.Session{}, map[string]interface{}{"id": id, "revoked_at": nil}, map[string]interface{}{"revoked_at": time.Now()})
+}
+
+// RevokeAllForUser revokes every active session of a user except the one
+// with id exceptId. Pass 0 to revoke all of them.
+func RevokeAllForUser(userId uint, exceptId uint) error {
+	return db.GetDb().Client().Model(&model.Session{}).
+		Where("user_id = ? AND revoked_at IS NULL AND id <> ?", userId, exceptId).
+		Update("revoked_at", time.Now()).Error
+}
+
+func checkActive(s *model.Session) error {
+	if s.User.ID == 0 {
+		// owner of the session no longer exists
+		return ErrSessionNotFound
+	}
+	if s.User.Disabled {
+		return ErrUserDisabled
+	}
+	if s.RevokedAt != nil {
+		return ErrSessionRevoked
+	}
+	if time.Now().After(s.ExpiresAt) {
+		return ErrSessionExpired
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package session
+
+import (
+	"errors"
+	"github.com/whyxn/easynas/backend/pkg/db"
+	"github.com/whyxn/easynas/backend/pkg/db/model"
+	"github.com/whyxn/easynas/backend/pkg/log"
+	"go.uber.org/zap"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// useTestDb connects the db package to a new, migrated database with a user
+// for the test
+func useTestDb(t *testing.T) *model.User {
+	t.Helper()
+	log.Logger = zap.NewNop().Sugar()
+	if err := db.Connect(filepath.Join(t.TempDir(), "easynas.db")); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := db.GetDb().RunMigrations(); err != nil {
+		t.Fatal(err)
+	}
+
+	user := &model.User{Name: "Alice", Email: "alice@example.com", Role: model.RoleUser}
+	if err := db.GetDb().Insert(user); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func TestRotate(t *testing.T) {
+	user := useTestDb(t)
+	s, first, err := Create(user, "laptop", "curl/8.0", "192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotated, second, err := Rotate(first, "curl/8.1", "192.0.2.2")
+	if err != nil {
+		t.Fatalf("Rotate() error = %v", err)
+	}
+	if rotated.ID != s.ID || second == first {
+		t.Errorf("Rotate() = session %d with token %q, want session %d with a new token", rotated.ID, second, s.ID)
+	}
+	if _, third, err := Rotate(second, "curl/8.1", "192.0.2.2"); err != nil || third == second {
+		t.Errorf("Rotate() of the new token = %q, %v, want another token", third, err)
+	}
+
+	if _, _, err = Rotate("unknown", "curl/8.1", "192.0.2.2"); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Rotate() of an unknown token error = %v, want ErrSessionNotFound", err)
+	}
+}
+
+func TestRotateRetiredTokenRevokesSession(t *testing.T) {
+	user := useTestDb(t)
+	s, first, err := Create(user, "laptop", "curl/8.0", "192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, second, err := Rotate(first, "curl/8.0", "192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the old token was stolen and is used after its owner refreshed
+	if _, _, err = Rotate(first, "evil/1.0", "198.51.100.7"); !errors.Is(err, ErrTokenReused) {
+		t.Fatalf("Rotate() of a retired token error = %v, want ErrTokenReused", err)
+	}
+	if _, err = Validate(s.ID); !errors.Is(err, ErrSessionRevoked) {
+		t.Errorf("Validate() error = %v, want ErrSessionRevoked", err)
+	}
+	// the current token of the owner stops working too
+	if _, _, err = Rotate(second, "curl/8.0", "192.0.2.1"); !errors.Is(err, ErrSessionRevoked) {
+		t.Errorf("Rotate() of the current token error = %v, want ErrSessionRevoked", err)
+	}
+}
+
+func TestRotateConcurrently(t *testing.T) {
+	user := useTestDb(t)
+	s, token, err := Create(user, "laptop", "curl/8.0", "192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const refreshes = 8
+	var wg sync.WaitGroup
+	errs := make([]error, refreshes)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, _, errs[i] = Rotate(token, "curl/8.0", "192.0.2.1")
+		}(i)
+	}
+	wg.Wait()
+
+	rotated := 0
+	for _, err := range errs {
+		if err == nil {
+			rotated++
+		} else if !errors.Is(err, ErrTokenReused) {
+			t.Errorf("Rotate() error = %v, want ErrTokenReused", err)
+		}
+	}
+	if rotated != 1 {
+		t.Errorf("token rotated %d times, want once", rotated)
+	}
+	if _, err = Validate(s.ID); !errors.Is(err, ErrSessionRevoked) {
+		t.Errorf("Validate() after concurrent refreshes error = %v, want ErrSessionRevoked", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	user := useTestDb(t)
+	s, _, err := Create(user, "", "curl/8.0", "192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Device != "curl/8.0" {
+		t.Errorf("Device = %q, want the user agent", s.Device)
+	}
+	if _, err = Validate(s.ID); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+
+	if err = db.GetDb().Update(user, map[string]interface{}{"disabled": true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Validate(s.ID); !errors.Is(err, ErrUserDisabled) {
+		t.Errorf("Validate() of a disabled user error = %v, want ErrUserDisabled", err)
+	}
+	if _, err = Validate(s.ID + 1); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Validate() of an unknown session error = %v, want ErrSessionNotFound", err)
+	}
+}
@@ -0,0 +1,94 @@
+package settings
+
+import (
+	"github.com/whyxn/easynas/backend/pkg/db"
+	"github.com/whyxn/easynas/backend/pkg/db/model"
+	"gorm.io/gorm/clause"
+	"strconv"
+	"sync"
+)
+
+// Keys of runtime settings admins can change through the API
+const (
+	RequireTwoFactorForAdmins = "security.require_2fa_for_admins"
+	LoginFreeAttempts         = "security.login_free_attempts"
+	LoginBackoffBaseSeconds   = "security.login_backoff_base_seconds"
+	LoginBackoffMaxSeconds    = "security.login_backoff_max_seconds"
+	LoginMaxFailures          = "security.login_max_failures"
+	LoginMaxFailuresPerIP     = "security.login_max_failures_per_ip"
+	LoginLockoutMinutes       = "security.login_lockout_minutes"
+	PasswordMinLength         = "security.password_min_length"
+	PasswordRequireUpper      = "security.password_require_upper"
+	PasswordRequireLower      = "security.password_require_lower"
+	PasswordRequireDigit      = "security.password_require_digit"
+	PasswordRequireSymbol     = "security.password_require_symbol"
+	PasswordHistoryCount      = "security.password_history_count"
+)
+
+var (
+	cache = map[string]string{}
+	mu    sync.RWMutex
+)
+
+// Load reads all settings from the DB into memory.
+func Load() error {
+	list, err := db.GetList[model.Setting](db.GetDb(), map[string]interface{}{})
+	if err != nil {
+		return err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	cache = map[string]string{}
+	for _, s := range list {
+		cache[s.Key] = s.Value
+	}
+	return nil
+}
+
+// Set persists a setting and updates the in-memory copy.
+func Set(key, value string) error {
+	err := db.GetDb().Client().Clauses(clause.OnConflict{UpdateAll: true}).Create(&model.Setting{Key: key, Value: value}).Error
+	if err != nil {
+		return err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	cache[key] = value
+	return nil
+}
+
+// Get returns the value of a setting or def when it is not set.
+func Get(key, def string) string {
+	mu.RLock()
+	defer mu.RUnlock()
+	if v, ok := cache[key]; ok {
+		return v
+	}
+	return def
+}
+
+func GetBool(key string, def bool) bool {
+	v, err := strconv.ParseBool(Get(key, strconv.FormatBool(def)))
+	if err != nil {
+		return def
+	}
+	return v
+}
+
+func SetBool(key string, value bool) error {
+	return Set(key, strconv.FormatBool(value))
+}
+
+func GetInt(key string, def int) int {
+	v, err := strconv.Atoi(Get(key, strconv.Itoa(def)))
+	if err != nil {
+		return def
+	}
+	return v
+}
+
+func SetInt(key string, value int) error {
+	return Set(key, strconv.Itoa(value))
+}
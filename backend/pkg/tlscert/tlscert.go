@@ -0,0 +1,269 @@
+package tlscert
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/whyxn/easynas/backend/pkg/log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Manager serves a certificate from a pair of PEM files and reloads it when
+// the files change, so certificates can be renewed without a restart.
+type Manager struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// Info describes the certificate being served
+type Info struct {
+	Subject     string    `json:"subject"`
+	Issuer      string    `json:"issuer"`
+	DNSNames    []string  `json:"dnsNames"`
+	IPAddresses []string  `json:"ipAddresses"`
+	NotBefore   time.Time `json:"notBefore"`
+	NotAfter    time.Time `json:"notAfter"`
+	SelfSigned  bool      `json:"selfSigned"`
+	Fingerprint string    `json:"fingerprint"`
+	CertFile    string    `json:"certFile"`
+	KeyFile     string    `json:"keyFile"`
+}
+
+var (
+	manager   *Manager
+	managerMu sync.RWMutex
+)
+
+// Configure sets the manager used by the server.
+func Configure(m *Manager) {
+	managerMu.Lock()
+	defer managerMu.Unlock()
+	manager = m
+}
+
+// Current returns the configured manager or nil when TLS is disabled.
+func Current() *Manager {
+	managerMu.RLock()
+	defer managerMu.RUnlock()
+	return manager
+}
+
+// NewManager loads the certificate pair. If neither file exists a
+// self-signed certificate for hosts is generated and written first.
+func NewManager(certFile, keyFile string, hosts []string) (*Manager, error) {
+	m := &Manager{certFile: certFile, keyFile: keyFile}
+
+	_, certErr := os.Stat(certFile)
+	_, keyErr := os.Stat(keyFile)
+	if os.IsNotExist(certErr) && os.IsNotExist(keyErr) {
+		certPEM, keyPEM, err := GenerateSelfSigned(hosts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate self-signed certificate: %w", err)
+		}
+		if err = m.write(certPEM, keyPEM); err != nil {
+			return nil, err
+		}
+		log.Logger.Warnw("Generated a self-signed certificate, browsers will warn until a trusted one is installed",
+			"certFile", certFile, "keyFile", keyFile)
+	}
+
+	if err := m.load(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// GetCertificate is used as tls.Config.GetCertificate
+func (m *Manager) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.cert, nil
+}
+
+// Watch reloads the certificate whenever one of the files changes. A broken
+// pair is logged and the previous certificate is kept.
+func (m *Manager) Watch(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			modTime, err := m.latestModTime()
+			if err != nil {
+				log.Logger.Warnw("Failed to check certificate files", "err", err)
+				continue
+			}
+
+			m.mu.RLock()
+			changed := modTime.After(m.modTime)
+			m.mu.RUnlock()
+			if !changed {
+				continue
+			}
+
+			if err = m.load(); err != nil {
+				log.Logger.Errorw("Failed to reload certificate, keeping the current one", "err", err)
+				// do not retry until the files change again
+				m.mu.Lock()
+				m.modTime = modTime
+				m.mu.Unlock()
+				continue
+			}
+			log.Logger.Infow("Reloaded certificate", "certFile", m.certFile)
+		}
+	}()
+}
+
+// Install validates a new certificate chain and private key, writes them to
+// the certificate files and starts serving them.
+func (m *Manager) Install(certPEM, keyPEM []byte) error {
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return fmt.Errorf("invalid certificate or key: %w", err)
+	}
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("invalid certificate: %w", err)
+	}
+	if time.Now().After(leaf.NotAfter) {
+		return errors.New("certificate has expired")
+	}
+
+	if err = m.write(certPEM, keyPEM); err != nil {
+		return err
+	}
+	return m.load()
+}
+
+// Info describes the certificate being served.
+func (m *Manager) Info() *Info {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	leaf := m.cert.Leaf
+	fingerprint := sha256.Sum256(leaf.Raw)
+	info := &Info{
+		Subject:     leaf.Subject.String(),
+		Issuer:      leaf.Issuer.String(),
+		DNSNames:    append([]string{}, leaf.DNSNames...),
+		IPAddresses: []string{},
+		NotBefore:   leaf.NotBefore,
+		NotAfter:    leaf.NotAfter,
+		SelfSigned:  bytes.Equal(leaf.RawIssuer, leaf.RawSubject) && leaf.CheckSignatureFrom(leaf) == nil,
+		Fingerprint: hex.EncodeToString(fingerprint[:]),
+		CertFile:    m.certFile,
+		KeyFile:     m.keyFile,
+	}
+	for _, ip := range leaf.IPAddresses {
+		info.IPAddresses = append(info.IPAddresses, ip.String())
+	}
+	return info
+}
+
+func (m *Manager) load() error {
+	modTime, err := m.latestModTime()
+	if err != nil {
+		return err
+	}
+
+	pair, err := tls.LoadX509KeyPair(m.certFile, m.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load certificate: %w", err)
+	}
+	if pair.Leaf == nil {
+		if pair.Leaf, err = x509.ParseCertificate(pair.Certificate[0]); err != nil {
+			return fmt.Errorf("failed to parse certificate: %w", err)
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cert = &pair
+	m.modTime = modTime
+	return nil
+}
+
+func (m *Manager) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{m.certFile, m.keyFile} {
+		stat, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if stat.ModTime().After(latest) {
+			latest = stat.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// write replaces both files, the key readable by the owner only. Both are
+// written to temporary files first, and the old key is put back if the
+// certificate can not be replaced, so the files on disk always form a pair.
+func (m *Manager) write(certPEM, keyPEM []byte) error {
+	keyTmp, err := stageFile(m.keyFile, keyPEM, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to write private key: %w", err)
+	}
+	defer os.Remove(keyTmp)
+	certTmp, err := stageFile(m.certFile, certPEM, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write certificate: %w", err)
+	}
+	defer os.Remove(certTmp)
+
+	oldKey, readErr := os.ReadFile(m.keyFile)
+	if err = os.Rename(keyTmp, m.keyFile); err != nil {
+		return fmt.Errorf("failed to write private key: %w", err)
+	}
+	if err = os.Rename(certTmp, m.certFile); err != nil {
+		if readErr == nil {
+			if restoreErr := os.WriteFile(m.keyFile, oldKey, 0600); restoreErr != nil {
+				log.Logger.Errorw("Failed to restore the private key", "file", m.keyFile, "err", restoreErr)
+			}
+		} else if os.IsNotExist(readErr) {
+			os.Remove(m.keyFile)
+		}
+		return fmt.Errorf("failed to write certificate: %w", err)
+	}
+	return nil
+}
+
+// stageFile writes data to a new temporary file next to path and returns its
+// name, for the caller to rename over path
+func stageFile(path string, data []byte, perm os.FileMode) (string, error) {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*")
+	if err != nil {
+		return "", err
+	}
+
+	if err = tmp.Chmod(perm); err == nil {
+		_, err = tmp.Write(data)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
@@ -0,0 +1,60 @@
+package tlscert
+
+import (
+	"bytes"
+	"github.com/whyxn/easynas/backend/pkg/log"
+	"go.uber.org/zap"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewManagerGeneratesSelfSigned(t *testing.T) {
+	log.Logger = zap.NewNop().Sugar()
+	dir := t.TempDir()
+	m, err := NewManager(filepath.Join(dir, "easynas.crt"), filepath.Join(dir, "tls", "easynas.key"), []string{"nas.local", "192.0.2.10"})
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+
+	info := m.Info()
+	if !info.SelfSigned || len(info.DNSNames) == 0 || info.DNSNames[0] != "nas.local" {
+		t.Errorf("Info() = %+v, want a self-signed certificate for nas.local", info)
+	}
+	stat, err := os.Stat(filepath.Join(dir, "tls", "easynas.key"))
+	if err != nil || stat.Mode().Perm() != 0600 {
+		t.Errorf("key file mode = %v, %v, want 0600", stat.Mode().Perm(), err)
+	}
+	// no temporary files are left behind
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 2 {
+		t.Errorf("directory has %d entries, want the certificate and the key directory", len(entries))
+	}
+}
+
+func TestWriteKeepsPairWhenCertificateFails(t *testing.T) {
+	log.Logger = zap.NewNop().Sugar()
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "easynas.key")
+	if err := os.WriteFile(keyFile, []byte("old key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// a directory that is not empty can not be replaced by the certificate
+	certFile := filepath.Join(dir, "easynas.crt")
+	if err := os.MkdirAll(filepath.Join(certFile, "blocked"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	m := &Manager{certFile: certFile, keyFile: keyFile}
+	if err := m.write([]byte("new cert"), []byte("new key")); err == nil {
+		t.Fatal("write() error = nil")
+	}
+
+	if key, _ := os.ReadFile(keyFile); !bytes.Equal(key, []byte("old key")) {
+		t.Errorf("key file = %q, want the old key restored", key)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 2 {
+		t.Errorf("directory has %d entries, want no temporary files left", len(entries))
+	}
+}
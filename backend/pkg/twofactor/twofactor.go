@@ -0,0 +1,154 @@
+package twofactor
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"github.com/whyxn/easynas/backend/pkg/db"
+	"github.com/whyxn/easynas/backend/pkg/db/model"
+	"github.com/whyxn/easynas/backend/pkg/settings"
+	"github.com/whyxn/easynas/backend/pkg/totp"
+	"github.com/whyxn/easynas/backend/pkg/util"
+	"gorm.io/gorm"
+	"strings"
+	"time"
+)
+
+// Issuer is shown by authenticator apps next to the account name.
+const Issuer = "easyNAS"
+
+// RecoveryCodeCount is the number of recovery codes generated at a time.
+const RecoveryCodeCount = 10
+
+var ErrInvalidCode = errors.New("invalid two-factor authentication code")
+
+// Required reports whether the policy forces the user to use two-factor authentication.
+func Required(user *model.User) bool {
+	return user.Role == model.RoleAdmin && settings.GetBool(settings.RequireTwoFactorForAdmins, false)
+}
+
+// VerifyCode checks a TOTP code against the user's active secret. A code is
+// accepted only once.
+func VerifyCode(user *model.User, code string) error {
+	return verify(user, user.TotpSecret, code)
+}
+
+// VerifyPendingCode checks a TOTP code against the secret that is still being enrolled.
+func VerifyPendingCode(user *model.User, code string) error {
+	return verify(user, user.TotpPendingSecret, code)
+}
+
+func verify(user *model.User, secret, code string) error {
+	if secret == "" {
+		return ErrInvalidCode
+	}
+
+	counter, ok := totp.Validate(secret, code, time.Now())
+	if !ok || counter <= user.TotpLastCounter {
+		return ErrInvalidCode
+	}
+
+	// the counter only moves forward, so of requests using the same code at
+	// once only one updates the row
+	result := db.GetDb().Client().Model(&model.User{}).
+		Where("id = ? AND totp_last_counter < ?", user.ID, counter).
+		Update("totp_last_counter", counter)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrInvalidCode
+	}
+	user.TotpLastCounter = counter
+	return nil
+}
+
+// UseRecoveryCode consumes one of the user's unused recovery codes.
+func UseRecoveryCode(user *model.User, code string) error {
+	hash := util.HashToken(normalizeRecoveryCode(code))
+
+	result := db.GetDb().Client().Model(&model.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", user.ID, hash).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrInvalidCode
+	}
+	return nil
+}
+
+// GenerateRecoveryCodes replaces all recovery codes of the user with a fresh
+// set and returns the plain codes. They can not be retrieved later.
+func GenerateRecoveryCodes(userId uint) ([]string, error) {
+	var codes []string
+	for i := 0; i < RecoveryCodeCount; i++ {
+		code, err := newRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+
+	err := db.GetDb().Client().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userId).Delete(&model.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		for _, code := range codes {
+			rc := &model.RecoveryCode{
+				UserId:    userId,
+				CodeHash:  util.HashToken(normalizeRecoveryCode(code)),
+				CreatedAt: time.Now(),
+			}
+			if err := tx.Create(rc).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// RemainingRecoveryCodes returns how many unused recovery codes the user has left.
+func RemainingRecoveryCodes(userId uint) (int64, error) {
+	var count int64
+	err := db.GetDb().Client().Model(&model.RecoveryCode{}).
+		Where("user_id = ? AND used_at IS NULL", userId).
+		Count(&count).Error
+	return count, err
+}
+
+// Disable turns off two-factor authentication for the user and drops the recovery codes.
+func Disable(user *model.User) error {
+	return db.GetDb().Client().Transaction(func(tx *gorm.DB) error {
+		updates := map[string]interface{}{
+			"totp_enabled":        false,
+			"totp_secret":         "",
+			"totp_pending_secret": "",
+			"totp_last_counter":   0,
+		}
+		if err := tx.Model(user).Updates(updates).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", user.ID).Delete(&model.RecoveryCode{}).Error
+	})
+}
+
+func newRecoveryCode() (string, error) {
+	b := make([]byte, 10)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	s := strings.ToLower(base32.StdEncoding.EncodeToString(b))
+	return s[:5] + "-" + s[5:10], nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	code = strings.ReplaceAll(code, "-", "")
+	return strings.ReplaceAll(code, " ", "")
+}
@@ -0,0 +1,134 @@
+package twofactor
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/whyxn/easynas/backend/pkg/db"
+	"github.com/whyxn/easynas/backend/pkg/db/model"
+	"github.com/whyxn/easynas/backend/pkg/log"
+	"github.com/whyxn/easynas/backend/pkg/totp"
+	"go.uber.org/zap"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// useTestDb connects the db package to a new, migrated database with a user
+// that has two-factor authentication enabled
+func useTestDb(t *testing.T) *model.User {
+	t.Helper()
+	log.Logger = zap.NewNop().Sugar()
+	if err := db.Connect(filepath.Join(t.TempDir(), "easynas.db")); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := db.GetDb().RunMigrations(); err != nil {
+		t.Fatal(err)
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &model.User{Name: "Alice", Email: "alice@example.com", Role: model.RoleUser, TotpEnabled: true, TotpSecret: secret}
+	if err = db.GetDb().Insert(user); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// currentCode computes the TOTP code of the secret like an authenticator app
+func currentCode(t *testing.T, secret string) string {
+	t.Helper()
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(time.Now().Unix()/totp.Period))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", value%1000000)
+}
+
+func TestVerifyCodeOnlyOnce(t *testing.T) {
+	user := useTestDb(t)
+	code := currentCode(t, user.TotpSecret)
+
+	if err := VerifyCode(user, code); err != nil {
+		t.Fatalf("VerifyCode() error = %v", err)
+	}
+	// a copy of the user loaded before the code was used
+	stale, _ := db.Get[model.User](db.GetDb(), map[string]interface{}{"id": user.ID})
+	stale.TotpLastCounter = 0
+	if err := VerifyCode(stale, code); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("VerifyCode() of a used code error = %v, want ErrInvalidCode", err)
+	}
+	wrong := code[:5] + string('0'+(code[5]-'0'+1)%10)
+	if err := VerifyCode(user, wrong); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("VerifyCode() of a wrong code error = %v, want ErrInvalidCode", err)
+	}
+}
+
+func TestVerifyCodeConcurrently(t *testing.T) {
+	user := useTestDb(t)
+	code := currentCode(t, user.TotpSecret)
+
+	// requests load the user before any of them verified the code
+	const requests = 8
+	users := make([]*model.User, requests)
+	for i := range users {
+		users[i], _ = db.Get[model.User](db.GetDb(), map[string]interface{}{"id": user.ID})
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, requests)
+	for i := range users {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = VerifyCode(users[i], code)
+		}(i)
+	}
+	wg.Wait()
+
+	accepted := 0
+	for _, err := range errs {
+		if err == nil {
+			accepted++
+		} else if !errors.Is(err, ErrInvalidCode) {
+			t.Errorf("VerifyCode() error = %v", err)
+		}
+	}
+	if accepted != 1 {
+		t.Errorf("code accepted %d times, want once", accepted)
+	}
+}
+
+func TestUseRecoveryCodeOnlyOnce(t *testing.T) {
+	user := useTestDb(t)
+	codes, err := GenerateRecoveryCodes(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// codes are accepted in upper case and without the dash
+	if err = UseRecoveryCode(user, " "+strings.ToUpper(strings.ReplaceAll(codes[0], "-", ""))+" "); err != nil {
+		t.Fatalf("UseRecoveryCode() error = %v", err)
+	}
+	if err = UseRecoveryCode(user, codes[0]); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("UseRecoveryCode() of a used code error = %v, want ErrInvalidCode", err)
+	}
+	if remaining, _ := RemainingRecoveryCodes(user.ID); remaining != RecoveryCodeCount-1 {
+		t.Errorf("remaining recovery codes = %d, want %d", remaining, RecoveryCodeCount-1)
+	}
+}
@@ -0,0 +1,100 @@
+package userimport
+
+import (
+	"encoding/csv"
+	"github.com/whyxn/easynas/backend/pkg/db"
+	"github.com/whyxn/easynas/backend/pkg/db/model"
+	"github.com/whyxn/easynas/backend/pkg/enum"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ExportedPermission is a share permission of an exported user.
+type ExportedPermission struct {
+	Dataset    string              `json:"dataset"`
+	Permission enum.PermissionType `json:"permission"`
+}
+
+// ExportedUser is a user along with its share permissions.
+type ExportedUser struct {
+	ID          uint                 `json:"id"`
+	Name        string               `json:"name"`
+	Email       string               `json:"email"`
+	NasClientIP string               `json:"nasClientIP"`
+	Role        string               `json:"role"`
+	AuthSource  string               `json:"authSource"`
+	Disabled    bool                 `json:"disabled"`
+	Permissions []ExportedPermission `json:"permissions"`
+}
+
+// Export returns all users with their share permissions.
+func Export() ([]ExportedUser, error) {
+	users, err := db.GetList[model.User](db.GetDb(), map[string]interface{}{})
+	if err != nil {
+		return nil, err
+	}
+
+	permissionList, err := db.GetList[model.NfsSharePermission](db.GetDb(), map[string]interface{}{}, "NfsShare")
+	if err != nil {
+		return nil, err
+	}
+
+	permissions := map[uint][]ExportedPermission{}
+	for _, p := range permissionList {
+		permissions[p.UserId] = append(permissions[p.UserId], ExportedPermission{
+			Dataset:    p.NfsShare.Dataset,
+			Permission: p.Permission,
+		})
+	}
+
+	exported := make([]ExportedUser, 0, len(users))
+	for _, u := range users {
+		userPermissions := permissions[u.ID]
+		if userPermissions == nil {
+			userPermissions = []ExportedPermission{}
+		}
+		exported = append(exported, ExportedUser{
+			ID:          u.ID,
+			Name:        u.Name,
+			Email:       u.Email,
+			NasClientIP: u.NasClientIP,
+			Role:        u.Role,
+			AuthSource:  u.AuthSource,
+			Disabled:    u.Disabled,
+			Permissions: userPermissions,
+		})
+	}
+	return exported, nil
+}
+
+// WriteCSV writes exported users as CSV. Permissions are joined into one
+// column as "dataset:permission" pairs separated by ";".
+func WriteCSV(w io.Writer, users []ExportedUser) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(csvColumns); err != nil {
+		return err
+	}
+
+	for _, u := range users {
+		var permissions []string
+		for _, p := range u.Permissions {
+			permissions = append(permissions, p.Dataset+":"+string(p.Permission))
+		}
+		row := []string{
+			u.Name,
+			u.Email,
+			u.NasClientIP,
+			u.Role,
+			u.AuthSource,
+			strconv.FormatBool(u.Disabled),
+			strings.Join(permissions, ";"),
+		}
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,257 @@
+package userimport
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/whyxn/easynas/backend/pkg/db"
+	"github.com/whyxn/easynas/backend/pkg/db/model"
+	"github.com/whyxn/easynas/backend/pkg/password"
+	"github.com/whyxn/easynas/backend/pkg/util"
+	"gorm.io/gorm"
+	"io"
+	"net/mail"
+	"strings"
+)
+
+// MaxRecords limits the number of users in a single import.
+const MaxRecords = 1000
+
+// csvColumns are the columns written on export. Import reads the same
+// columns and ignores the ones it does not know.
+var csvColumns = []string{"name", "email", "nasClientIP", "role", "authSource", "disabled", "permissions"}
+
+var ErrTooManyRecords = fmt.Errorf("an import may contain at most %d users", MaxRecords)
+
+// Record is a user to be imported. Users without a password get a
+// generated temporary one they have to change on first login.
+type Record struct {
+	Name        string `json:"name"`
+	Email       string `json:"email"`
+	NasClientIP string `json:"nasClientIP"`
+	Role        string `json:"role"`
+	Password    string `json:"password"`
+}
+
+// RowResult holds the validation errors of one record. Rows are counted
+// from 1, not counting the CSV header.
+type RowResult struct {
+	Row    int      `json:"row"`
+	Email  string   `json:"email"`
+	Errors []string `json:"errors,omitempty"`
+}
+
+// Report is the outcome of validating an import.
+type Report struct {
+	Valid bool        `json:"valid"`
+	Rows  []RowResult `json:"rows"`
+}
+
+// Created is a user created by an import along with its temporary
+// password, if one has been generated.
+type Created struct {
+	ID                uint   `json:"id"`
+	Email             string `json:"email"`
+	TemporaryPassword string `json:"temporaryPassword,omitempty"`
+}
+
+// ParseJSON reads a JSON array of records.
+func ParseJSON(r io.Reader) ([]Record, error) {
+	var records []Record
+	if err := json.NewDecoder(r).Decode(&records); err != nil {
+		return nil, err
+	}
+	if len(records) > MaxRecords {
+		return nil, ErrTooManyRecords
+	}
+	return records, nil
+}
+
+// ParseCSV reads records from CSV with a header row. Column names are
+// matched case-insensitively.
+func ParseCSV(r io.Reader) ([]Record, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, errors.New("csv is empty")
+	} else if err != nil {
+		return nil, err
+	}
+
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["email"]; !ok {
+		return nil, errors.New("csv has no email column")
+	}
+
+	field := func(row []string, name string) string {
+		i, ok := columns[strings.ToLower(name)]
+		if !ok || i >= len(row) {
+			return ""
+		}
+		return strings.TrimSpace(row[i])
+	}
+
+	var records []Record
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if len(records) == MaxRecords {
+			return nil, ErrTooManyRecords
+		}
+		records = append(records, Record{
+			Name:        field(row, "name"),
+			Email:       field(row, "email"),
+			NasClientIP: field(row, "nasClientIP"),
+			Role:        field(row, "role"),
+			Password:    field(row, "password"),
+		})
+	}
+	return records, nil
+}
+
+// Validate checks the records against each other and against the existing
+// users. Records without a role become regular users.
+func Validate(records []Record) (*Report, error) {
+	existingEmails, existingIPs, err := existingUsers()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{Valid: true}
+	emailRows := map[string]int{}
+	ipRows := map[string]int{}
+
+	for i := range records {
+		record := &records[i]
+		if record.Role == "" {
+			record.Role = model.RoleUser
+		}
+
+		row := RowResult{Row: i + 1, Email: record.Email}
+		addError := func(format string, args ...interface{}) {
+			row.Errors = append(row.Errors, fmt.Sprintf(format, args...))
+		}
+
+		if record.Name == "" {
+			addError("name is required")
+		}
+
+		email := strings.ToLower(record.Email)
+		if record.Email == "" {
+			addError("email is required")
+		} else if addr, err := mail.ParseAddress(record.Email); err != nil || addr.Address != record.Email {
+			addError("invalid email")
+		} else if existingEmails[email] {
+			addError("email already belongs to an existing user")
+		} else if first, ok := emailRows[email]; ok {
+			addError("duplicate email, also used in row %d", first)
+		} else {
+			emailRows[email] = row.Row
+		}
+
+		if record.Role != model.RoleAdmin && record.Role != model.RoleUser {
+			addError("invalid role %q", record.Role)
+		}
+
+		if record.NasClientIP != "" {
+			if !util.IsValidNasClientIP(record.NasClientIP) {
+				addError("invalid nas client ip")
+			} else if existingIPs[record.NasClientIP] {
+				addError("nas client ip already belongs to an existing user")
+			} else if first, ok := ipRows[record.NasClientIP]; ok {
+				addError("duplicate nas client ip, also used in row %d", first)
+			} else {
+				ipRows[record.NasClientIP] = row.Row
+			}
+		}
+
+		if record.Password != "" {
+			if err := password.Validate(record.Password); err != nil {
+				addError(err.Error())
+			}
+		}
+
+		if len(row.Errors) > 0 {
+			report.Valid = false
+		}
+		report.Rows = append(report.Rows, row)
+	}
+	return report, nil
+}
+
+// Apply creates all users in a single transaction, either all of them are
+// created or none. The records have to be validated first.
+func Apply(records []Record) ([]Created, error) {
+	var created []Created
+
+	err := db.GetDb().Client().Transaction(func(tx *gorm.DB) error {
+		created = nil
+		for _, record := range records {
+			plain := record.Password
+			generated := plain == ""
+			if generated {
+				var err error
+				if plain, err = password.Generate(); err != nil {
+					return err
+				}
+			}
+
+			hash, err := password.Hash(plain)
+			if err != nil {
+				return err
+			}
+
+			user := &model.User{
+				Name:               record.Name,
+				Email:              record.Email,
+				Password:           hash,
+				NasClientIP:        record.NasClientIP,
+				Role:               record.Role,
+				AuthSource:         model.AuthSourceLocal,
+				MustChangePassword: generated,
+			}
+			if err = tx.Create(user).Error; err != nil {
+				return fmt.Errorf("failed to create %s: %w", record.Email, err)
+			}
+
+			result := Created{ID: user.ID, Email: user.Email}
+			if generated {
+				result.TemporaryPassword = plain
+			}
+			created = append(created, result)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+func existingUsers() (map[string]bool, map[string]bool, error) {
+	users, err := db.GetList[model.User](db.GetDb(), map[string]interface{}{})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	emails := map[string]bool{}
+	ips := map[string]bool{}
+	for _, u := range users {
+		emails[strings.ToLower(u.Email)] = true
+		if u.NasClientIP != "" {
+			ips[u.NasClientIP] = true
+		}
+	}
+	return emails, ips, nil
+}
@@ -1,8 +1,12 @@
 package util
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"golang.org/x/crypto/bcrypt"
+	"net"
 )
 
 // HashPassword generates a bcrypt hash of the password.
@@ -31,3 +35,38 @@ func Base64Decode(data string) string {
 	}
 	return string(decoded)
 }
+
+// GenerateRandomToken returns a url-safe random string built from n random bytes.
+func GenerateRandomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashToken returns the hex encoded SHA-256 digest of a token. Used for opaque
+// tokens that are stored in the DB and looked up by value.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// IsValidNasClientIP accepts an empty value, a single address or a CIDR range.
+func IsValidNasClientIP(value string) bool {
+	if value == "" || net.ParseIP(value) != nil {
+		return true
+	}
+	_, _, err := net.ParseCIDR(value)
+	return err == nil
+}
+
+// Contains reports whether the list contains the value.
+func Contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}